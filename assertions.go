@@ -0,0 +1,163 @@
+package shuttle
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const (
+	AssertionModeFail = "fail"
+	AssertionModeWarn = "warn"
+
+	assertionArrow       = "->"
+	assertionDefaultPort = "443"
+)
+
+type IAssertionsConfig interface {
+	GetAssertions() *config.Assertions
+}
+
+// intent of the routing, e.g. "example.com -> Proxy": the target is routed by the policy,
+// a group of the selection chain or the selected server
+type assertion struct {
+	raw    string
+	addr   string
+	expect string
+}
+
+type assertions struct {
+	mode  string
+	items []*assertion
+}
+
+// nil config or empty assert means none
+func parseAssertions(c *config.Assertions) (*assertions, error) {
+	if c == nil || len(c.Assert) == 0 {
+		return nil, nil
+	}
+	as := &assertions{mode: AssertionModeFail}
+	switch c.Mode {
+	case "":
+	case AssertionModeFail, AssertionModeWarn:
+		as.mode = c.Mode
+	default:
+		return nil, fmt.Errorf("mode [%s] must be %s or %s", c.Mode, AssertionModeFail, AssertionModeWarn)
+	}
+	for _, v := range c.Assert {
+		a, err := parseAssertion(v)
+		if err != nil {
+			return nil, err
+		}
+		as.items = append(as.items, a)
+	}
+	return as, nil
+}
+
+// "host[:port] -> policy", port is 443 by default
+func parseAssertion(v string) (*assertion, error) {
+	i := strings.Index(v, assertionArrow)
+	if i < 0 {
+		return nil, fmt.Errorf("assert [%s] must be \"target -> policy\"", v)
+	}
+	a := &assertion{
+		raw:    v,
+		addr:   strings.TrimSpace(v[:i]),
+		expect: strings.TrimSpace(v[i+len(assertionArrow):]),
+	}
+	if len(a.addr) == 0 || len(a.expect) == 0 {
+		return nil, fmt.Errorf("assert [%s] must be \"target -> policy\"", v)
+	}
+	if _, _, err := net.SplitHostPort(a.addr); err != nil {
+		a.addr = net.JoinHostPort(strings.Trim(a.addr, "[]"), assertionDefaultPort)
+	}
+	return a, nil
+}
+
+// route every assertion of the applied config, the failures are an error in fail mode,
+// only logged in warn mode
+func CheckAssertions(config IAssertionsConfig) error {
+	as, err := parseAssertions(config.GetAssertions())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Assertions] failed: %v", err)
+	}
+	return as.check(Route)
+}
+
+// route every assertion by the servers and rules of [conf] before it's applied,
+// so the running config is kept when they fail
+func CheckCandidateAssertions(conf *config.Config) error {
+	as, err := parseAssertions(conf.GetAssertions())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Assertions] failed: %v", err)
+	}
+	if as == nil {
+		return nil
+	}
+	servers, err := proxy.NewServerSet(conf)
+	if err != nil {
+		return err
+	}
+	defer servers.Destroy()
+	rules, err := rule.ParseRules(conf, func(policy string) error {
+		_, err := servers.GetServer(policy)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return as.check(func(addr, network string, _ bool) (*RouteTrace, error) {
+		return RouteCandidate(addr, network, rules, servers)
+	})
+}
+
+// nil passes
+func (as *assertions) check(route func(addr, network string, dial bool) (*RouteTrace, error)) error {
+	if as == nil {
+		return nil
+	}
+	var failed []string
+	for _, a := range as.items {
+		if err := a.check(route); err != nil {
+			assertionsLog.Errorf("[Assertions] [%s] %v", a.raw, err)
+			failed = append(failed, fmt.Sprintf("[%s] %v", a.raw, err))
+		}
+	}
+	if len(failed) == 0 {
+		assertionsLog.Infof("[Assertions] %d assertions passed", len(as.items))
+		return nil
+	}
+	if as.mode == AssertionModeWarn {
+		return nil
+	}
+	return fmt.Errorf("[Assertions] %d of %d failed: %s", len(failed), len(as.items), strings.Join(failed, "; "))
+}
+
+func (a *assertion) check(route func(addr, network string, dial bool) (*RouteTrace, error)) error {
+	trace, err := route(a.addr, connect.TCP, false)
+	if err != nil {
+		return err
+	}
+	for _, s := range trace.Steps {
+		if len(s.Error) > 0 {
+			return fmt.Errorf("%s failed: %s", s.Name, s.Error)
+		}
+	}
+	if trace.Rule == nil {
+		return fmt.Errorf("no rule matched")
+	}
+	if trace.Rule.Policy == a.expect || trace.Server == a.expect {
+		return nil
+	}
+	for _, v := range trace.Path {
+		if v == a.expect {
+			return nil
+		}
+	}
+	return fmt.Errorf("routed to %v by [%s,%s,%s]", trace.Path, trace.Rule.Type, trace.Rule.Value, trace.Rule.Policy)
+}
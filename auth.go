@@ -0,0 +1,170 @@
+package shuttle
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+)
+
+const (
+	socksMethodNoAuth       = 0x00
+	socksMethodUserPass     = 0x02
+	socksMethodNoAcceptable = 0xFF
+	socksUserPassVer        = 0x01
+
+	headerProxyAuthorization = "Proxy-Authorization"
+)
+
+var ErrorAuthFailed = errors.New("authentication failed")
+
+type IAuthConfig interface {
+	GetUsers() map[string]config.Secret
+}
+
+var (
+	users     map[string]string
+	usersLock sync.RWMutex
+)
+
+type userKey struct{}
+
+func ApplyAuthConfig(config IAuthConfig) error {
+	us := make(map[string]string, len(config.GetUsers()))
+	for k, v := range config.GetUsers() {
+		if len(k) == 0 || len(k) > 255 || len(v) > 255 {
+			return errors.New("resolve config file [Users] username and password must be 1~255 bytes")
+		}
+		us[k] = v.Reveal()
+	}
+	usersLock.Lock()
+	users = us
+	usersLock.Unlock()
+	return nil
+}
+
+// users of config, the map is replaced but never modified
+func getUsers() map[string]string {
+	usersLock.RLock()
+	defer usersLock.RUnlock()
+	return users
+}
+
+// inbound need authentication or not
+func AuthRequired() bool {
+	return len(getUsers()) > 0
+}
+
+func checkUser(user, password string) bool {
+	p, ok := getUsers()[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+}
+
+// return the authenticated user of the inbound connection
+func connUser(c connect.IConn) string {
+	user, _ := c.Context().Value(userKey{}).(string)
+	return user
+}
+
+func setConnUser(c connect.IConn, user string) {
+	c.SetContext(context.WithValue(c.Context(), userKey{}, user))
+}
+
+// RFC 1929
+//+----+------+----------+------+----------+
+//|VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+//+----+------+----------+------+----------+
+//| 1  |  1   | 1 to 255 |  1   | 1 to 255 |
+//+----+------+----------+------+----------+
+func socksUserPassAuth(conn connect.IConn) error {
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socksUserPassVer {
+		return errors.New("socks username/password version not supported")
+	}
+	n := int(buf[1])
+	if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+		return err
+	}
+	user := string(buf[:n])
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return err
+	}
+	n = int(buf[0])
+	if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+		return err
+	}
+	password := string(buf[:n])
+	user, tag, policy := splitUsername(user)
+	if AuthRequired() && !checkUser(user, password) {
+		conn.Write([]byte{socksUserPassVer, 0x01})
+		return ErrorAuthFailed
+	}
+	if _, err := conn.Write([]byte{socksUserPassVer, 0x00}); err != nil {
+		return err
+	}
+	if AuthRequired() {
+		setConnUser(conn, user)
+	}
+	setConnTag(conn, tag)
+	setConnPolicy(conn, policy)
+	return nil
+}
+
+// "alice+run-42#global" -> "alice", "run-42", "global", a username of [Users] is kept
+func splitUsername(username string) (user, tag, policy string) {
+	user = username
+	users := getUsers()
+	if _, ok := users[user]; ok {
+		return
+	}
+	if i := strings.LastIndexByte(user, '#'); i >= 0 {
+		user, policy = user[:i], user[i+1:]
+	}
+	if _, ok := users[user]; ok {
+		return
+	}
+	if i := strings.LastIndexByte(user, '+'); i >= 0 {
+		user, tag = user[:i], user[i+1:]
+	}
+	return
+}
+
+// check Proxy-Authorization: Basic xxx, and remove it
+func httpProxyAuth(hreq *http.Request) (string, bool) {
+	v := hreq.Header.Get(headerProxyAuthorization)
+	hreq.Header.Del(headerProxyAuthorization)
+	const prefix = "Basic "
+	if !strings.HasPrefix(v, prefix) {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(v[len(prefix):])
+	if err != nil {
+		return "", false
+	}
+	i := strings.IndexByte(string(data), ':')
+	if i < 0 {
+		return "", false
+	}
+	user, password := string(data[:i]), string(data[i+1:])
+	return user, checkUser(user, password)
+}
+
+func replyProxyAuthRequired(conn connect.IConn) {
+	conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n" +
+		"Proxy-Authenticate: Basic realm=\"shuttle\"\r\n" +
+		"Content-Length: 0\r\n" +
+		"Connection: close\r\n\r\n"))
+}
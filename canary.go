@@ -0,0 +1,207 @@
+package shuttle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const defaultCanaryWindow = 30 * time.Minute
+
+// blue/green deployment: a candidate config runs beside the applied one, a percentage of new connections
+// goes through its [Proxy], [Proxy-Group] and [Rule], until it is promoted or rolled back.
+// it is rolled back when the trial window ends without promotion
+type canary struct {
+	File    string        `json:"file"`
+	Percent int           `json:"percent"`
+	Window  time.Duration `json:"window"`
+	Started time.Time     `json:"started"`
+	Expires time.Time     `json:"expires"`
+	Conns   int64         `json:"conns"` // connections through the candidate
+	Total   int64         `json:"total"` // all connections since started
+
+	servers *proxy.ServerSet
+	rules   []*rule.Rule
+	timer   *time.Timer
+}
+
+var (
+	currentCanary *canary
+	canaryLock    sync.RWMutex
+)
+
+// apply the config file as candidate for [percent] of new connections in [window], replace the running one
+func StartCanary(file string, percent int, window time.Duration) error {
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("percent [%d] must be in (0, 100]", percent)
+	}
+	if window <= 0 {
+		window = defaultCanaryWindow
+	}
+	file, err := canaryFile(file)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read config file failed: %v", err)
+	}
+	if errs := ValidateConfigFileData(data, file); len(errs) > 0 {
+		return errs[0]
+	}
+	conf, err := config.ParseConfigFile(data, file)
+	if err != nil {
+		return err
+	}
+	servers, err := proxy.NewServerSet(conf)
+	if err != nil {
+		return err
+	}
+	rules, err := rule.ParseRules(conf, func(policy string) error {
+		_, err := servers.GetServer(policy)
+		return err
+	})
+	if err != nil {
+		servers.Destroy()
+		return err
+	}
+	c := &canary{
+		File:    file,
+		Percent: percent,
+		Window:  window,
+		Started: time.Now(),
+		Expires: time.Now().Add(window),
+		servers: servers,
+		rules:   rules,
+	}
+	canaryLock.Lock()
+	old := currentCanary
+	currentCanary = c
+	c.timer = time.AfterFunc(window, func() {
+		if stopCanary(c) {
+			canaryLog.Infof("[Canary] trial window of [%s] is over, rolled back", c.File)
+		}
+	})
+	canaryLock.Unlock()
+	if old != nil {
+		old.destroy()
+	}
+	canaryLog.Infof("[Canary] [%s] takes %d%% of new connections for %s", file, percent, window)
+	return nil
+}
+
+// [file] relative to the directory of the applied config file, a file out of it is refused:
+// the controller takes the path from anyone who reaches it
+func canaryFile(file string) (string, error) {
+	dir, err := filepath.Abs(filepath.Dir(config.CurrentConfigFile()))
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	file = filepath.Clean(file)
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	realFile, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", fmt.Errorf("read config file failed: %v", err)
+	}
+	rel, err := filepath.Rel(realDir, realFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("config file [%s] is out of the config directory [%s]", file, dir)
+	}
+	return file, nil
+}
+
+// roll back the candidate, false if there is none
+func RollbackCanary() bool {
+	c := getCanary()
+	if c == nil {
+		return false
+	}
+	if stopCanary(c) {
+		canaryLog.Infof("[Canary] [%s] is rolled back", c.File)
+	}
+	return true
+}
+
+// stop the candidate and return its file, which should be reloaded as the applied config
+func PromoteCanary() (string, error) {
+	c := getCanary()
+	if c == nil || !stopCanary(c) {
+		return "", fmt.Errorf("no candidate config")
+	}
+	canaryLog.Infof("[Canary] [%s] is promoted", c.File)
+	return c.File, nil
+}
+
+// status of the candidate, nil if there is none
+func CanaryStatus() *canary {
+	c := getCanary()
+	if c == nil {
+		return nil
+	}
+	return &canary{
+		File:    c.File,
+		Percent: c.Percent,
+		Window:  c.Window,
+		Started: c.Started,
+		Expires: c.Expires,
+		Conns:   atomic.LoadInt64(&c.Conns),
+		Total:   atomic.LoadInt64(&c.Total),
+	}
+}
+
+func getCanary() *canary {
+	canaryLock.RLock()
+	defer canaryLock.RUnlock()
+	return currentCanary
+}
+
+// stop [c] if it is still the running one
+func stopCanary(c *canary) bool {
+	canaryLock.Lock()
+	if currentCanary != c {
+		canaryLock.Unlock()
+		return false
+	}
+	currentCanary = nil
+	canaryLock.Unlock()
+	c.destroy()
+	return true
+}
+
+func (c *canary) destroy() {
+	c.timer.Stop()
+	c.servers.Destroy()
+}
+
+// the candidate for a new connection, spread evenly: the n-th connection goes through it
+// when it crosses a multiple of 100/percent
+func pickCanary() *canary {
+	c := getCanary()
+	if c == nil {
+		return nil
+	}
+	n := atomic.AddInt64(&c.Total, 1)
+	if n*int64(c.Percent)/100 == (n-1)*int64(c.Percent)/100 {
+		return nil
+	}
+	atomic.AddInt64(&c.Conns, 1)
+	return c
+}
+
+func (c *canary) filter(req rule.IRequest) (*rule.Rule, error) {
+	return rule.FilterRules(c.rules, req)
+}
@@ -21,3 +21,19 @@ func CipherDecorate(password, method string, conn connect.IConn) (connect.IConn,
 	}
 	return nil, fmt.Errorf("[SS Cipher] not support : %s", method)
 }
+
+type IPacketCipher interface {
+	Encrypt(b []byte) ([]byte, error)
+	Decrypt(b []byte) ([]byte, error)
+}
+
+//UDP包加密
+func PacketCipher(password, method string) (IPacketCipher, error) {
+	if c := ssstream.GetPacketCipher(method, password); c != nil {
+		return c, nil
+	}
+	if c := ssaead.GetPacketCipher(method, password); c != nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("[SS Cipher] not support : %s", method)
+}
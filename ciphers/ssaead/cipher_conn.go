@@ -9,7 +9,6 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/rand"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"golang.org/x/crypto/hkdf"
 )
@@ -78,7 +77,7 @@ func (a *aeadConn) Read(b []byte) (n int, err error) {
 		}
 		a.Decrypter, err = a.NewDecrypter(a.key, salt)
 		if err != nil {
-			log.Logger.Errorf("[AEAD Conn] init decrypter failed: %v", err)
+			ciphersLog.Errorf("[AEAD Conn] init decrypter failed: %v", err)
 			return 0, err
 		}
 	}
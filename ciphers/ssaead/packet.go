@@ -0,0 +1,48 @@
+package ssaead
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// UDP packet: [salt][sealed payload][tag], every packet has its own salt and a zero nonce
+type PacketCipher struct {
+	IAEADCipher
+	key []byte
+}
+
+func GetPacketCipher(method, password string) *PacketCipher {
+	c, ok := aeadCiphers[method]
+	if !ok {
+		return nil
+	}
+	return &PacketCipher{IAEADCipher: c, key: evpBytesToKey(password, c.KeySize())}
+}
+
+func (p *PacketCipher) Encrypt(b []byte) ([]byte, error) {
+	salt := make([]byte, p.SaltSize())
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := p.NewEncrypter(p.key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(salt, make([]byte, aead.NonceSize()), b, nil), nil
+}
+
+func (p *PacketCipher) Decrypt(b []byte) ([]byte, error) {
+	if len(b) < p.SaltSize() {
+		return nil, errors.New("packet too short")
+	}
+	aead, err := p.NewDecrypter(p.key, b[:p.SaltSize()])
+	if err != nil {
+		return nil, err
+	}
+	b = b[p.SaltSize():]
+	if len(b) < aead.Overhead() {
+		return nil, errors.New("packet too short")
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), b, nil)
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"io"
 )
@@ -61,7 +60,7 @@ func (s *streamConn) Read(b []byte) (n int, err error) {
 		}
 		s.Decrypter, err = s.NewDecrypter(s.key, iv)
 		if err != nil {
-			log.Logger.Errorf("[Stream Conn] init decrypter failed: %v", err)
+			ciphersLog.Errorf("[Stream Conn] init decrypter failed: %v", err)
 			return 0, err
 		}
 	}
@@ -0,0 +1,6 @@
+package ssstream
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var ciphersLog = log.Named("ciphers")
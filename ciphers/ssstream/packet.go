@@ -0,0 +1,48 @@
+package ssstream
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// UDP packet: [IV][encrypted payload], every packet has its own IV
+type PacketCipher struct {
+	IStreamCipher
+	key []byte
+}
+
+func GetPacketCipher(method, password string) *PacketCipher {
+	c, ok := streamCiphers[method]
+	if !ok {
+		return nil
+	}
+	return &PacketCipher{IStreamCipher: c, key: evpBytesToKey(password, c.KeyLen())}
+}
+
+func (p *PacketCipher) Encrypt(b []byte) ([]byte, error) {
+	buf := make([]byte, p.IVLen()+len(b))
+	iv := buf[:p.IVLen()]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	s, err := p.NewEncrypter(p.key, iv)
+	if err != nil {
+		return nil, err
+	}
+	s.XORKeyStream(buf[len(iv):], b)
+	return buf, nil
+}
+
+func (p *PacketCipher) Decrypt(b []byte) ([]byte, error) {
+	if len(b) < p.IVLen() {
+		return nil, errors.New("packet too short")
+	}
+	s, err := p.NewDecrypter(p.key, b[:p.IVLen()])
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(b)-p.IVLen())
+	s.XORKeyStream(data, b[p.IVLen():])
+	return data, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/config"
+)
+
+// shuttle diagnostics [-c shuttle.yaml] [-controller 127.0.0.1:8082] [-o bundle.zip]
+// download the diagnostics bundle from the controller of running shuttle
+func diagnosticsCommand(args []string) int {
+	fs := flag.NewFlagSet("diagnostics", flag.ExitOnError)
+	configPath := fs.String("c", "shuttle.yaml", "configuration file path, to find the controller")
+	controller := fs.String("controller", "", "controller address, default: from the configuration file")
+	output := fs.String("o", shuttle.DiagnosticsFileName(), "output file")
+	fs.Parse(args)
+	addr := *controller
+	if len(addr) == 0 {
+		data, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		conf, err := config.ParseConfigFile(data, *configPath)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		host := conf.GetControllerInterface()
+		if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		addr = net.JoinHostPort(host, conf.GetControllerPort())
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get("http://" + addr + "/api/diagnostics")
+	if err != nil {
+		fmt.Printf("shuttle is not running or controller [%s] is unreachable: %v\n", addr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		fmt.Printf("generate diagnostics failed: %s\n", data)
+		return 1
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf("diagnostics bundle is saved to %s, secrets are masked\n", *output)
+	return 0
+}
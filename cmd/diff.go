@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/sipt/shuttle"
+)
+
+// shuttle diff old.yaml new.yaml [--json] [--reveal]
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print differences as json")
+	reveal := fs.Bool("reveal", false, "print secrets without masking")
+	var files []string
+	for len(args) > 0 {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) > 0 {
+			files, args = append(files, args[0]), args[1:]
+		}
+	}
+	if len(files) != 2 {
+		fmt.Println("usage: shuttle diff old.yaml new.yaml [--json] [--reveal]")
+		return 2
+	}
+	changes, err := shuttle.DiffConfigFiles(files[0], files[1], *reveal)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, c := range changes {
+			fmt.Println(c.String())
+		}
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
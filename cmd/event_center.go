@@ -4,8 +4,7 @@ import (
 	"fmt"
 	"github.com/sipt/shuttle/config"
 	. "github.com/sipt/shuttle/constant"
-	"github.com/sipt/shuttle/controller"
-	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/engine"
 	"os"
 	"os/exec"
 	"runtime"
@@ -23,31 +22,22 @@ func dealEvent(c chan *EventObj) {
 		t := <-c
 		switch t.Type {
 		case EventShutdown.Type:
-			log.Logger.Info("[Shuttle] is shutdown, see you later!")
-			shutdown(config.CurrentConfig().General.SetAsSystemProxy)
+			shuttleLog.Info("[Shuttle] is shutdown, see you later!")
+			shutdown(config.CurrentConfig())
 			os.Exit(0)
 			return
 		case EventReloadConfig.Type:
-			_, err := reloadConfig(config.CurrentConfigFile(), StopSocksSignal, StopHTTPSignal)
-			if err != nil {
-				log.Logger.Error("Reload Config failed: ", err)
-				fmt.Println(err.Error())
-				os.Exit(1)
+			// a broken config is refused by validation, shuttle keeps running with the current one
+			if _, err := engine.HandleEvent(t); err != nil {
+				shuttleLog.Error("Reload Config failed, keep running: ", err)
 			}
-		case EventRestartHttpProxy.Type:
-			StopHTTPSignal <- true
-			go HandleHTTP(config.CurrentConfig(), StopHTTPSignal)
-		case EventRestartSocksProxy.Type:
-			StopSocksSignal <- true
-			go HandleSocks5(config.CurrentConfig(), StopSocksSignal)
-		case EventRestartController.Type:
-			controller.ShutdownController()
-			go controller.StartController(config.CurrentConfig(), eventChan)
+		case EventRestartHttpProxy.Type, EventRestartSocksProxy.Type, EventRestartController.Type:
+			engine.HandleEvent(t)
 		case EventUpgrade.Type:
 			//todo
 			fileName := t.GetData().(string)
-			shutdown(config.CurrentConfig().General.SetAsSystemProxy)
-			log.Logger.Info("[Shuttle] is shutdown, for upgrade!")
+			shutdown(config.CurrentConfig())
+			shuttleLog.Info("[Shuttle] is shutdown, for upgrade!")
 			var name string
 			if runtime.GOOS == "windows" {
 				name = "upgrade"
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/engine"
+	"github.com/sipt/shuttle/log"
+)
+
+// shuttle exits [policy...] [-c shuttle.yaml]
+// exit IP and DNS leak through the policies, a sanity check after config changes
+func exitsCommand(args []string) int {
+	fs := flag.NewFlagSet("exits", flag.ExitOnError)
+	configPath := fs.String("c", "shuttle.yaml", "configuration file path")
+	logMode := fs.String("l", "off", "logMode: off | console | file")
+	logPath := fs.String("lp", "logs", "logs path")
+	var policies []string
+	for len(args) > 0 {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) > 0 {
+			policies, args = append(policies, args[0]), args[1:]
+		}
+	}
+	if err := log.InitLogger(*logMode, *logPath); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if _, err := engine.LoadDialConfig(*configPath); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	reports := shuttle.CheckExits(policies)
+	data, _ := json.MarshalIndent(reports, "", "  ")
+	fmt.Println(string(data))
+	for _, r := range reports {
+		if len(r.Error) > 0 || r.DNSLeak {
+			return 1
+		}
+	}
+	return 0
+}
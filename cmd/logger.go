@@ -0,0 +1,9 @@
+package main
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var (
+	shuttleLog  = log.Named("shuttle")
+	validateLog = log.Named("validate")
+)
@@ -3,38 +3,41 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
-	"runtime/debug"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/sipt/shuttle"
 	"github.com/sipt/shuttle/config"
-	"github.com/sipt/shuttle/controller"
-	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/engine"
 	"github.com/sipt/shuttle/extension/network"
 	"github.com/sipt/shuttle/log"
-	"github.com/sipt/shuttle/proxy"
-	"github.com/sipt/shuttle/rule"
-
-	_ "github.com/sipt/shuttle/ciphers"
-	_ "github.com/sipt/shuttle/proxy/protocol"
-	_ "github.com/sipt/shuttle/proxy/selector"
-)
-
-var (
-	StopSocksSignal = make(chan bool, 1)
-	StopHTTPSignal  = make(chan bool, 1)
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "route":
+			os.Exit(routeCommand(os.Args[2:]))
+		case "validate-server":
+			os.Exit(validateServerCommand(os.Args[2:]))
+		case "diff":
+			os.Exit(diffCommand(os.Args[2:]))
+		case "diagnostics":
+			os.Exit(diagnosticsCommand(os.Args[2:]))
+		case "exits":
+			os.Exit(exitsCommand(os.Args[2:]))
+		}
+	}
 	configPath := flag.String("c", "shuttle.yaml", "configuration file path")
 	logMode := flag.String("l", "file", "logMode: off | console | file")
 	logPath := flag.String("lp", "logs", "logs path")
+	test := flag.Bool("t", false, "test configuration file and exit")
 	flag.Parse()
+	if *test {
+		os.Exit(testConfig(*configPath))
+	}
 	var (
 		conf *config.Config
 		err  error
@@ -44,7 +47,11 @@ func main() {
 		fmt.Println(err.Error())
 		return
 	}
-	if conf, err = loadConfig(*configPath); err != nil {
+	if err = engine.InitRuntime(*configPath); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if conf, err = engine.LoadConfig(*configPath); err != nil {
 		fmt.Println(err.Error())
 		return
 	}
@@ -52,11 +59,11 @@ func main() {
 	//event listen
 	ListenEvent()
 
-	// 启动api控制
-	go controller.StartController(conf, eventChan)
-	//go HandleUDP()
-	go HandleHTTP(conf, StopHTTPSignal)
-	go HandleSocks5(conf, StopSocksSignal)
+	//start modules
+	if err = engine.SuperviseModules(eventChan); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 
 	// Catch "Ctrl + C"
 	signalChan := make(chan os.Signal, 1)
@@ -68,92 +75,37 @@ func main() {
 	fmt.Println("success")
 
 	<-signalChan
-	log.Logger.Info("[Shuttle] is shutdown, see you later!")
-	shutdown(conf.General.SetAsSystemProxy)
+	shuttleLog.Info("[Shuttle] is shutdown, see you later!")
+	shutdown(config.CurrentConfig())
 	os.Exit(0)
 	return
 }
 
-//load config
-func loadConfig(configPath string) (conf *config.Config, err error) {
-	//init Config
-	conf, err = config.LoadConfig(configPath)
-	if err != nil {
-		return
-	}
-	//init Config Value
-	shuttle.InitConfigValue(conf)
-	//init DNS & GeoIP
-	if err = dns.ApplyConfig(conf); err != nil {
-		return
-	}
-	//init Logger
-	if err = log.ApplyConfig(conf); err != nil {
-		return
-	}
-	//init Proxy & ProxyGroup
-	if err = proxy.ApplyConfig(conf); err != nil {
-		return
-	}
-	//init Rule
-	if err = rule.ApplyConfig(conf); err != nil {
-		return
-	}
-	//init HttpMap
-	if err = shuttle.ApplyHTTPModifyConfig(conf); err != nil {
-		return
-	}
-	//init MITM
-	if err = shuttle.ApplyMITMConfig(conf); err != nil {
-		return
-	}
-	return
-}
-
-//reload config
-func reloadConfig(configPath string, StopSocksSignal, StopHTTPSignal chan bool) (conf *config.Config, err error) {
-	oldConf := config.CurrentConfig()
-	conf, err = loadConfig(configPath)
+//test config file, print errors
+func testConfig(configPath string) int {
+	errs, err := shuttle.ValidateConfigFile(configPath)
 	if err != nil {
-		return
-	}
-	// controller
-	if oldConf.GetControllerInterface() != conf.GetControllerInterface() ||
-		oldConf.GetControllerPort() != conf.GetControllerPort() {
-		//restart controller
-		controller.ShutdownController()
-		// 启动api控制
-		go controller.StartController(conf, eventChan)
+		fmt.Println(err.Error())
+		return 1
 	}
-
-	// http proxy
-	if oldConf.GetHTTPInterface() != conf.GetHTTPInterface() ||
-		oldConf.GetHTTPPort() != conf.GetHTTPPort() {
-		//restart http proxy
-		StopHTTPSignal <- true
-		go HandleHTTP(conf, StopHTTPSignal)
+	for _, e := range errs {
+		fmt.Println(e.Error())
 	}
-
-	// socks5 proxy
-	if oldConf.GetSOCKSInterface() != conf.GetSOCKSInterface() ||
-		oldConf.GetSOCKSPort() != conf.GetSOCKSPort() {
-		//restart http proxy
-		StopSocksSignal <- true
-		go HandleSocks5(conf, StopSocksSignal)
+	if len(errs) > 0 {
+		fmt.Printf("configuration file %s test failed\n", configPath)
+		return 1
 	}
-	return
+	fmt.Printf("configuration file %s test is successful\n", configPath)
+	return 0
 }
 
-func shutdown(setAsSystemProxy string) {
-	controller.ShutdownController()
-	StopSocksSignal <- true
-	StopHTTPSignal <- true
+func shutdown(conf *config.Config) {
+	setAsSystemProxy := conf.General.SetAsSystemProxy
 	if setAsSystemProxy == "" || setAsSystemProxy == config.SetAsSystemProxyAuto {
 		//disable system proxy
 		DisableSystemProxy()
 	}
-	log.Logger.Close()
-	dns.CloseGeoDB()
+	engine.Shutdown()
 	time.Sleep(time.Second)
 }
 
@@ -170,101 +122,6 @@ func DisableSystemProxy() {
 }
 
 type IProxyConfig interface {
-	ISOCKSProxyConfig
-	IHTTPProxyConfig
-}
-
-//SOCKS5 Proxy
-type ISOCKSProxyConfig interface {
-	GetSOCKSInterface() string
-	SetSOCKSInterface(string)
-	GetSOCKSPort() string
-	SetSOCKSPort(string)
-}
-
-func HandleSocks5(config ISOCKSProxyConfig, stopHandle chan bool) {
-	addr := net.JoinHostPort(config.GetSOCKSInterface(), config.GetSOCKSPort())
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		panic(err)
-	}
-	log.Logger.Info("Listen to [SOCKS]: ", addr)
-	var shutdown = false
-	go func() {
-		if shutdown = <-stopHandle; shutdown {
-			listener.Close()
-			log.Logger.Infof("close socks listener!")
-		}
-	}()
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			if shutdown && strings.Contains(err.Error(), "use of closed network connection") {
-				log.Logger.Info("Stopped HTTP/HTTPS Proxy goroutine...")
-				return
-			} else {
-				log.Logger.Error(err)
-			}
-			continue
-		}
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Logger.Errorf("[HTTP/HTTPS]panic :%v", err)
-					log.Logger.Errorf("[HTTP/HTTPS]stack :%s", debug.Stack())
-					conn.Close()
-				}
-			}()
-			log.Logger.Debug("[SOCKS]Accept tcp connection")
-			shuttle.SocksHandle(conn)
-		}()
-	}
-}
-
-//HTTP Proxy
-type IHTTPProxyConfig interface {
-	GetHTTPInterface() string
-	SetHTTPInterface(string)
-	GetHTTPPort() string
-	SetHTTPPort(string)
-}
-
-func HandleHTTP(config IHTTPProxyConfig, stopHandle chan bool) {
-	addr := net.JoinHostPort(config.GetHTTPInterface(), config.GetHTTPPort())
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		panic(err)
-	}
-	log.Logger.Info("Listen to [HTTP/HTTPS]: ", addr)
-
-	var shutdown = false
-	go func() {
-		if shutdown = <-stopHandle; shutdown {
-			listener.Close()
-			log.Logger.Infof("close HTTP/HTTPS listener!")
-		}
-	}()
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			if shutdown && strings.Contains(err.Error(), "use of closed network connection") {
-				log.Logger.Info("Stopped HTTP/HTTPS Proxy goroutine...")
-				return
-			} else {
-				log.Logger.Error(err)
-			}
-			continue
-		}
-		go func() {
-			defer func() {
-				conn.Close()
-				if err := recover(); err != nil {
-					log.Logger.Errorf("[HTTP/HTTPS]panic :%v", err)
-					log.Logger.Errorf("[HTTP/HTTPS]stack :%s", debug.Stack())
-				}
-			}()
-			log.Logger.Debug("[HTTP/HTTPS]Accept tcp connection")
-			shuttle.HandleHTTP(conn)
-		}()
-	}
+	engine.ISOCKSProxyConfig
+	engine.IHTTPProxyConfig
 }
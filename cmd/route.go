@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/engine"
+	"github.com/sipt/shuttle/log"
+)
+
+// shuttle route example.com:443 [--udp] [--dial] [-c shuttle.yaml]
+func routeCommand(args []string) int {
+	fs := flag.NewFlagSet("route", flag.ExitOnError)
+	configPath := fs.String("c", "shuttle.yaml", "configuration file path")
+	udp := fs.Bool("udp", false, "route as udp request")
+	dial := fs.Bool("dial", false, "dial to the selected server")
+	logMode := fs.String("l", "off", "logMode: off | console | file")
+	logPath := fs.String("lp", "logs", "logs path")
+	var addr string
+	for len(args) > 0 {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) > 0 {
+			addr, args = args[0], args[1:]
+		}
+	}
+	if len(addr) == 0 {
+		fmt.Println("usage: shuttle route host:port [--udp] [--dial] [-c shuttle.yaml]")
+		return 2
+	}
+	if err := log.InitLogger(*logMode, *logPath); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if _, err := engine.LoadConfig(*configPath); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	network := "tcp"
+	if *udp {
+		network = "udp"
+	}
+	trace, err := shuttle.Route(addr, network, *dial)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	data, _ := json.MarshalIndent(trace, "", "  ")
+	fmt.Println(string(data))
+	for _, s := range trace.Steps {
+		if len(s.Error) > 0 {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/log"
+)
+
+const maxValidateBody = 4 << 20
+
+type validateResponse struct {
+	Code    int                      `json:"code"`
+	Message string                   `json:"message"`
+	Data    []*shuttle.ValidateError `json:"data,omitempty"`
+}
+
+// shuttle validate-server [-addr 127.0.0.1:8083]
+// POST /validate with config file as body, return validation report
+func validateServerCommand(args []string) int {
+	fs := flag.NewFlagSet("validate-server", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8083", "listen address")
+	logMode := fs.String("l", "console", "logMode: off | console | file")
+	logPath := fs.String("lp", "logs", "logs path")
+	fs.Parse(args)
+	if err := log.InitLogger(*logMode, *logPath); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	// GeoIP database is loaded once, not per request
+	if err := shuttle.WarmValidation(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", handleValidate)
+	shuttleLog.Info("Listen to [Validate]: ", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(&validateResponse{Code: 1, Message: "method not allowed"})
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxValidateBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&validateResponse{Code: 1, Message: err.Error()})
+		return
+	}
+	errs := shuttle.ValidateUntrustedConfigData(data)
+	resp := &validateResponse{Data: errs}
+	if len(errs) > 0 {
+		resp.Code = 1
+		resp.Message = fmt.Sprintf("%d errors", len(errs))
+	}
+	validateLog.Infof("[Validate] [%s] %d bytes, %d errors", r.RemoteAddr, len(data), len(errs))
+	json.NewEncoder(w).Encode(resp)
+}
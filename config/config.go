@@ -5,6 +5,7 @@ import (
 	"github.com/sipt/shuttle/util"
 	"github.com/sipt/yaml"
 	"io/ioutil"
+	"path/filepath"
 )
 
 const ConfigFileVersion = "v1.0.1"
@@ -23,29 +24,78 @@ func CurrentConfigFile() string {
 
 // load config file
 func LoadConfig(filePath string) (*Config, error) {
+	c, err := ReadConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	SetCurrentConfig(c, filePath)
+	return conf, nil
+}
+
+// read config file without changing current config
+func ReadConfig(filePath string) (*Config, error) {
 	util.RLock(filePath)
 	defer util.RUnLock(filePath)
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("read config file failed: %v", err)
 	}
+	return ParseConfigFile(data, filePath)
+}
+
+func SetCurrentConfig(c *Config, filePath string) {
+	conf = c
+	configFile = filePath
+}
 
-	conf = &Config{}
-	err = yaml.Unmarshal(data, conf)
+// parse config data without changing current config
+func ParseConfig(data []byte) (*Config, error) {
+	return parseConfig(data, "", true)
+}
+
+// parse config data of [file], the rule include file is relative to the directory of [file]
+func ParseConfigFile(data []byte, file string) (*Config, error) {
+	return parseConfig(data, filepath.Dir(file), true)
+}
+
+// parse config data submitted by others, e.g. to the validate server: ${NAME} is kept as it is
+// (${NAME:-default} gives the default) and the rule include file is not read, nothing of the host leaks
+func ParseUntrustedConfig(data []byte) (*Config, error) {
+	return parseConfig(data, "", false)
+}
+
+func parseConfig(data []byte, dir string, trusted bool) (*Config, error) {
+	c := &Config{dir: dir}
+	err := yaml.Unmarshal(data, c)
 	if err != nil {
 		return nil, fmt.Errorf("resolve config file failed: %v", err)
 	}
-	if conf.Ver != ConfigFileVersion {
-		return nil, fmt.Errorf("resolve config file failed: only support ver:%s current:[%s]", ConfigFileVersion, conf.Ver)
+	if c.Ver != ConfigFileVersion {
+		return nil, fmt.Errorf("resolve config file failed: only support ver:%s current:[%s]", ConfigFileVersion, c.Ver)
 	}
-	configFile = filePath
-	return conf, nil
+	if err = expandConfig(c, trusted); err != nil {
+		return nil, err
+	}
+	if !trusted {
+		return c, nil
+	}
+	if c.ruleInclude, err = LoadRuleInclude(c.GetRuleIncludeFile()); err != nil {
+		return nil, err
+	}
+	if err = expandRuleInclude(c.ruleInclude); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 // save config file
 func SaveConfig(configFile string, config *Config) error {
 	util.Lock(configFile)
 	defer util.UnLock(configFile)
+	config, err := restoreConfig(config)
+	if err != nil {
+		return fmt.Errorf("[CONF] restore config failed : %v", err)
+	}
 	bytes, err := yaml.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("[CONF] yaml marshal config failed : %v", err)
@@ -59,6 +109,24 @@ func SaveConfig(configFile string, config *Config) error {
 	return nil
 }
 
+// config file content of [config], secrets are masked unless [reveal]
+func ExportConfig(config *Config, reveal bool) ([]byte, error) {
+	config, err := restoreConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("[CONF] restore config failed : %v", err)
+	}
+	if !reveal {
+		if config, err = MaskConfig(config); err != nil {
+			return nil, fmt.Errorf("[CONF] mask config failed : %v", err)
+		}
+	}
+	bytes, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("[CONF] yaml marshal config failed : %v", err)
+	}
+	return bytes[:EmojiDecode(bytes)], nil
+}
+
 func ReloadConfig() (*Config, error) {
 	if configFile == "" {
 		return nil, fmt.Errorf("config file not found")
@@ -76,29 +144,162 @@ type Config struct {
 	General    *General            `yaml:"General"`
 	Proxy      map[string][]string `yaml:"Proxy,[flow],2quoted"`
 	ProxyGroup map[string][]string `yaml:"Proxy-Group,[flow],2quoted"`
+	Composite  map[string][]string `yaml:"Composite-Policy,[flow],2quoted"`
 	LocalDNSs  [][]string          `yaml:"Local-DNS,[flow],2quoted"`
+	Hosts      map[string]string   `yaml:"Hosts,2quoted"`
 	Mitm       *Mitm               `yaml:"MITM"`
 	Rule       [][]string          `yaml:"Rule,[flow],2quoted"`
 	HttpMap    *HttpMap            `yaml:"Http-Map"`
 	RttUrl     string              `yaml:"rtt-url"`
+	KeepAlive  map[string]string   `yaml:"Keep-Alive,2quoted"`
+	Chaos      map[string][]string `yaml:"Chaos,[flow],2quoted"`
+	SNIRouter  map[string][]string `yaml:"SNI-Router,[flow],2quoted"`
+	Templates  map[string][]string `yaml:"Templates,[flow],2quoted"`
+	Users      map[string]Secret   `yaml:"Users,2quoted"`
+	Mirror     *Mirror             `yaml:"Mirror"`
+	Hedge      *Hedge              `yaml:"Hedge"`
+	Resume     *Resume             `yaml:"Resume"`
+	Multicast  *MulticastRelay     `yaml:"Multicast-Relay"`
+	Sniff      *Sniff              `yaml:"Sniffing"`
+	UDP        *UDP                `yaml:"UDP"`
+	DNSListen  *DNSListen          `yaml:"DNS-Listen"`
+	Export     *RouteExport        `yaml:"Route-Export"`
+	TLSVerify  map[string]string   `yaml:"TLS-Verify,2quoted"`
+	FlowExport *FlowExport         `yaml:"Flow-Export"`
+	Shedding   *LoadShedding       `yaml:"Load-Shedding"`
+	ExitCheck  *ExitCheck          `yaml:"Exit-Check"`
+	Assertions *Assertions         `yaml:"Assertions"`
+
+	raw         *rawValues
+	ruleInclude [][]string
+	dir         string // of the config file, "" for the working directory
 }
 
 type General struct {
 	LogLevel            string   `yaml:"loglevel,2quoted"`
+	LogModules          string   `yaml:"log-modules,2quoted"`
+	LogFormat           string   `yaml:"log-format,2quoted"`
+	LogMaxSize          string   `yaml:"log-max-size,2quoted"`
+	LogMaxAge           string   `yaml:"log-max-age,2quoted"`
+	LogMaxBackups       string   `yaml:"log-max-backups,2quoted"`
+	LogTail             string   `yaml:"log-tail,2quoted"`
 	DNSServer           []string `yaml:"dns-server,2quoted"`
 	HttpPort            string   `yaml:"http-port,2quoted"`
 	HttpInterface       string   `yaml:"http-interface,2quoted"`
 	SocksPort           string   `yaml:"socks-port,2quoted"`
 	SocksInterface      string   `yaml:"socks-interface,2quoted"`
+	SNIPort             string   `yaml:"sni-port,2quoted"`
+	SNIInterface        string   `yaml:"sni-interface,2quoted"`
 	ControllerPort      string   `yaml:"controller-port,2quoted"`
 	ControllerInterface string   `yaml:"controller-interface,2quoted"`
 	SetAsSystemProxy    string   `yaml:"set-as-system-proxy,2quoted"`
+	Sniffing            string   `yaml:"sniffing,2quoted"`
+	DNSMinTTL           string   `yaml:"dns-min-ttl,2quoted"`
+	DNSMaxTTL           string   `yaml:"dns-max-ttl,2quoted"`
+	DNSCacheFile        string   `yaml:"dns-cache-file,2quoted"`
+	DNS0x20             string   `yaml:"dns-0x20,2quoted"`
+	MaxConnections      string   `yaml:"max-connections,2quoted"`
+	MaxDNSCacheEntries  string   `yaml:"max-dns-cache-entries,2quoted"`
+	MaxRttTests         string   `yaml:"max-rtt-tests,2quoted"`
+	SocketStats         string   `yaml:"socket-stats,2quoted"`
+	ReloadQueue         string   `yaml:"reload-queue,2quoted"`
+	ReloadTimeout       string   `yaml:"reload-timeout,2quoted"`
+	RuleInclude         string   `yaml:"rule-include,2quoted"`
+	IdleUnload          string   `yaml:"idle-unload,2quoted"`
+	SelfPolicy          string   `yaml:"self-policy,2quoted"`
 }
 
 type Mitm struct {
 	CA    string   `yaml:"ca,2quoted"`
-	Key   string   `yaml:"key,2quoted"`
+	Key   Secret   `yaml:"key,2quoted"`
 	Rules []string `yaml:"rules,flow,2quoted"`
+	// FileDescriptorSets to name the fields of gRPC messages
+	GRPCDescriptors []string `yaml:"grpc-descriptors,flow,2quoted"`
+}
+
+type Mirror struct {
+	Sink    string   `yaml:"sink,2quoted"`
+	Sample  string   `yaml:"sample,2quoted"`
+	Hosts   []string `yaml:"hosts,flow,2quoted"`
+	HTTP    string   `yaml:"http,2quoted"`
+	MaxBody string   `yaml:"max-body,2quoted"`
+}
+
+type Hedge struct {
+	Delay      string   `yaml:"delay,2quoted"`
+	Budget     string   `yaml:"budget,2quoted"`
+	MinRetries string   `yaml:"min-retries,2quoted"`
+	Groups     []string `yaml:"groups,flow,2quoted"`
+}
+
+type Assertions struct {
+	Mode   string   `yaml:"mode,2quoted"`
+	Assert []string `yaml:"assert,flow,2quoted"`
+}
+
+type Resume struct {
+	MaxRetries string   `yaml:"max-retries,2quoted"`
+	MinSize    string   `yaml:"min-size,2quoted"`
+	Groups     []string `yaml:"groups,flow,2quoted"`
+}
+
+type Sniff struct {
+	Inbounds  map[string]string `yaml:"inbounds,2quoted"`
+	Ports     map[string]string `yaml:"ports,2quoted"`
+	Timeout   string            `yaml:"timeout,2quoted"`
+	Protocols []string          `yaml:"protocols,flow,2quoted"`
+}
+
+type UDP struct {
+	Timeout string            `yaml:"timeout,2quoted"`
+	NAT     map[string]string `yaml:"nat,2quoted"`
+}
+
+type DNSListen struct {
+	Interface string `yaml:"interface,2quoted"`
+	Port      string `yaml:"port,2quoted"`
+	DoHPort   string `yaml:"doh-port,2quoted"`
+	DoTPort   string `yaml:"dot-port,2quoted"`
+	Cert      string `yaml:"cert,2quoted"`
+	Key       string `yaml:"key,2quoted"`
+	Reject    string `yaml:"reject,2quoted"`
+}
+
+type RouteExport struct {
+	Interval string `yaml:"interval,2quoted"`
+	Format   string `yaml:"format,2quoted"`
+	File     string `yaml:"file,2quoted"`
+	Set      string `yaml:"set,2quoted"`
+	Table    string `yaml:"table,2quoted"`
+	Command  string `yaml:"command,2quoted"`
+}
+
+type LoadShedding struct {
+	Memory   string `yaml:"memory,2quoted"`
+	FDs      string `yaml:"fds,2quoted"`
+	Interval string `yaml:"interval,2quoted"`
+}
+
+type ExitCheck struct {
+	IPURL      string   `yaml:"ip-url,2quoted"`
+	LeakDomain string   `yaml:"dns-leak-domain,2quoted"`
+	LeakURL    string   `yaml:"dns-leak-url,2quoted"`
+	Policies   []string `yaml:"policies,flow,2quoted"`
+	Timeout    string   `yaml:"timeout,2quoted"`
+}
+
+type FlowExport struct {
+	Collector        string `yaml:"collector,2quoted"`
+	Version          string `yaml:"version,2quoted"`
+	TemplateInterval string `yaml:"template-interval,2quoted"`
+	Enterprise       string `yaml:"enterprise,2quoted"`
+	DomainID         string `yaml:"domain-id,2quoted"`
+}
+
+type MulticastRelay struct {
+	Interfaces []string `yaml:"interfaces,flow,2quoted"`
+	Groups     []string `yaml:"groups,flow,2quoted"`
+	Broadcast  []string `yaml:"broadcast,flow,2quoted"`
 }
 
 type HttpMap struct {
@@ -127,6 +328,27 @@ func (c *Config) GetLocalDNS() [][]string {
 func (c *Config) SetLocalDNS(localDNSs [][]string) {
 	c.LocalDNSs = localDNSs
 }
+func (c *Config) GetHosts() map[string]string {
+	return c.Hosts
+}
+func (c *Config) SetHosts(hosts map[string]string) {
+	c.Hosts = hosts
+}
+func (c *Config) GetDNSMinTTL() string {
+	return c.General.DNSMinTTL
+}
+func (c *Config) GetDNSMaxTTL() string {
+	return c.General.DNSMaxTTL
+}
+func (c *Config) GetDNSCacheFile() string {
+	return c.General.DNSCacheFile
+}
+func (c *Config) GetDNSCacheSize() string {
+	return c.General.MaxDNSCacheEntries
+}
+func (c *Config) GetDNS0x20() string {
+	return c.General.DNS0x20
+}
 func (c *Config) GetGeoIPDBFile() string {
 	return "GeoLite2-Country.mmdb"
 }
@@ -138,6 +360,35 @@ func (c *Config) GetLogLevel() string {
 func (c *Config) SetLogLevel(l string) {
 	c.General.LogLevel = l
 }
+func (c *Config) GetLogModules() string {
+	return c.General.LogModules
+}
+func (c *Config) GetLogFormat() string {
+	return c.General.LogFormat
+}
+func (c *Config) GetLogMaxSize() string {
+	return c.General.LogMaxSize
+}
+func (c *Config) GetLogMaxAge() string {
+	return c.General.LogMaxAge
+}
+func (c *Config) GetLogMaxBackups() string {
+	return c.General.LogMaxBackups
+}
+func (c *Config) GetLogTail() string {
+	return c.General.LogTail
+}
+
+//sniffing
+func (c *Config) GetSniffing() string {
+	return c.General.Sniffing
+}
+func (c *Config) SetSniffing(s string) {
+	c.General.Sniffing = s
+}
+func (c *Config) GetSniff() *Sniff {
+	return c.Sniff
+}
 
 //controller
 func (c *Config) GetControllerDomain() string {
@@ -184,6 +435,150 @@ func (c *Config) SetSOCKSPort(port string) {
 	c.General.SocksPort = port
 }
 
+//Inbound Users
+func (c *Config) GetUsers() map[string]Secret {
+	return c.Users
+}
+func (c *Config) SetUsers(users map[string]Secret) {
+	c.Users = users
+}
+
+//Mirror
+func (c *Config) GetMirror() *Mirror {
+	return c.Mirror
+}
+
+//Hedge
+func (c *Config) GetHedge() *Hedge {
+	return c.Hedge
+}
+
+//Resume
+func (c *Config) GetResume() *Resume {
+	return c.Resume
+}
+
+//UDP
+func (c *Config) GetUDP() *UDP {
+	return c.UDP
+}
+
+//DNS Listen
+func (c *Config) getDNSListen() *DNSListen {
+	if c.DNSListen == nil {
+		return &DNSListen{}
+	}
+	return c.DNSListen
+}
+
+func (c *Config) GetDNSListenInterface() string {
+	return c.getDNSListen().Interface
+}
+
+func (c *Config) GetDNSListenPort() string {
+	return c.getDNSListen().Port
+}
+
+func (c *Config) GetDoHPort() string {
+	return c.getDNSListen().DoHPort
+}
+
+func (c *Config) GetDoTPort() string {
+	return c.getDNSListen().DoTPort
+}
+
+func (c *Config) GetDNSListenCert() string {
+	return c.getDNSListen().Cert
+}
+
+func (c *Config) GetDNSListenKey() string {
+	return c.getDNSListen().Key
+}
+
+func (c *Config) GetDNSListenReject() string {
+	return c.getDNSListen().Reject
+}
+
+//TLS Verify
+func (c *Config) GetTLSVerify() map[string]string {
+	return c.TLSVerify
+}
+
+//Route Export
+func (c *Config) GetRouteExport() *RouteExport {
+	return c.Export
+}
+
+//Load Shedding
+func (c *Config) GetLoadShedding() *LoadShedding {
+	return c.Shedding
+}
+
+//Flow Export
+func (c *Config) GetFlowExport() *FlowExport {
+	return c.FlowExport
+}
+
+//Exit Check
+func (c *Config) GetExitCheck() *ExitCheck {
+	return c.ExitCheck
+}
+
+//Assertions
+func (c *Config) GetAssertions() *Assertions {
+	return c.Assertions
+}
+
+//Multicast Relay
+func (c *Config) GetMulticastRelay() *MulticastRelay {
+	return c.Multicast
+}
+
+//Limits
+func (c *Config) GetMaxConnections() string {
+	return c.General.MaxConnections
+}
+
+func (c *Config) GetReloadQueue() string {
+	return c.General.ReloadQueue
+}
+
+func (c *Config) GetReloadTimeout() string {
+	return c.General.ReloadTimeout
+}
+func (c *Config) GetMaxRttTests() string {
+	return c.General.MaxRttTests
+}
+func (c *Config) GetSocketStats() string {
+	return c.General.SocketStats
+}
+func (c *Config) GetIdleUnload() string {
+	return c.General.IdleUnload
+}
+func (c *Config) GetSelfPolicy() string {
+	return c.General.SelfPolicy
+}
+
+//SNI Router
+func (c *Config) GetSNIInterface() string {
+	return c.General.SNIInterface
+}
+func (c *Config) SetSNIInterface(inter string) {
+	c.General.SNIInterface = inter
+}
+func (c *Config) GetSNIPort() string {
+	return c.General.SNIPort
+}
+func (c *Config) SetSNIPort(port string) {
+	c.General.SNIPort = port
+}
+func (c *Config) GetSNIRouter() map[string][]string {
+	return c.SNIRouter
+}
+func (c *Config) SetSNIRouter(router map[string][]string) {
+	c.SNIRouter = router
+}
+
 //Proxy & Proxy Group
 func (c *Config) GetProxy() map[string][]string {
 	return c.Proxy
@@ -203,6 +598,20 @@ func (c *Config) SetRttUrl(rttUrl string) {
 func (c *Config) GetRttUrl() string {
 	return c.RttUrl
 }
+func (c *Config) GetKeepAlive() map[string]string {
+	return c.KeepAlive
+}
+func (c *Config) SetKeepAlive(keepAlive map[string]string) {
+	c.KeepAlive = keepAlive
+}
+func (c *Config) GetCompositePolicy() map[string][]string {
+	return c.Composite
+}
+
+//Chaos
+func (c *Config) GetChaos() map[string][]string {
+	return c.Chaos
+}
 
 //Rule
 func (c *Config) GetRule() [][]string {
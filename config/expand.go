@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sipt/yaml"
+)
+
+const TemplatePrefix = "@"
+
+// ${NAME} or ${NAME:-default}
+var envRex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// $1 ~ $9 in templates
+var paramRex = regexp.MustCompile(`\$([1-9])`)
+
+// values before expanding, restored when saving config file
+type rawValues struct {
+	strings map[string][2]string   // path -> [raw, expanded]
+	proxies map[string][2][]string // name -> [template params, expanded params]
+}
+
+// expand ${ENV} in every value (only the defaults unless [env]), then expand proxy templates:
+//   Templates:
+//     "hk": ["ss", "$1.hk.example.com", "12345", "rc4-md5", "${SS_PASSWORD}"]
+//   Proxy:
+//     "HK_a": ["@hk", "a"]
+func expandConfig(c *Config, env bool) error {
+	c.raw = &rawValues{
+		strings: make(map[string][2]string),
+		proxies: make(map[string][2][]string),
+	}
+	var err error
+	walkStrings(reflect.ValueOf(c), "", func(path, s string) string {
+		if err != nil || !strings.Contains(s, "${") {
+			return s
+		}
+		var v string
+		if env {
+			v, err = expandEnv(s)
+		} else {
+			v = expandDefault(s)
+		}
+		if err == nil && v != s {
+			c.raw.strings[path] = [2]string{s, v}
+		}
+		return v
+	})
+	if err != nil {
+		return err
+	}
+	for name, params := range c.Proxy {
+		if len(params) == 0 || !strings.HasPrefix(params[0], TemplatePrefix) {
+			continue
+		}
+		t, ok := c.Templates[params[0][len(TemplatePrefix):]]
+		if !ok {
+			return fmt.Errorf("resolve config file [Proxy] [%s] template [%s] not found", name, params[0])
+		}
+		args := params[1:]
+		expanded := make([]string, len(t))
+		for i, v := range t {
+			expanded[i] = paramRex.ReplaceAllStringFunc(v, func(p string) string {
+				n, _ := strconv.Atoi(p[1:])
+				if n > len(args) {
+					if err == nil {
+						err = fmt.Errorf("resolve config file [Proxy] [%s] template [%s] missing param %s", name, params[0], p)
+					}
+					return p
+				}
+				return args[n-1]
+			})
+		}
+		if err != nil {
+			return err
+		}
+		c.raw.proxies[name] = [2][]string{params, expanded}
+		c.Proxy[name] = expanded
+	}
+	return nil
+}
+
+// ${NAME:-default} to the default, ${NAME} is kept
+func expandDefault(s string) string {
+	return envRex.ReplaceAllStringFunc(s, func(m string) string {
+		if sub := envRex.FindStringSubmatch(m); len(sub[2]) > 0 {
+			return sub[3]
+		}
+		return m
+	})
+}
+
+// expand ${ENV} in the rules of include file, they are never saved into config file
+func expandRuleInclude(rules [][]string) error {
+	for _, r := range rules {
+		for i, v := range r {
+			if !strings.Contains(v, "${") {
+				continue
+			}
+			e, err := expandEnv(v)
+			if err != nil {
+				return fmt.Errorf("%v, in rule include file", err)
+			}
+			r[i] = e
+		}
+	}
+	return nil
+}
+
+func expandEnv(s string) (string, error) {
+	var err error
+	v := envRex.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envRex.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if len(sub[2]) > 0 {
+			return sub[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("resolve config file failed: environment variable [%s] not set", sub[1])
+		}
+		return m
+	})
+	return v, err
+}
+
+// copy config with values before expanding, so secrets never be written into config file
+func restoreConfig(c *Config) (*Config, error) {
+	if c.raw == nil {
+		return c, nil
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	r := &Config{}
+	if err = yaml.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	for name, p := range c.raw.proxies {
+		if v, ok := r.Proxy[name]; ok && reflect.DeepEqual(v, p[1]) {
+			r.Proxy[name] = p[0]
+		}
+	}
+	// only the expanded fields, if they were not changed since
+	walkStrings(reflect.ValueOf(r), "", func(path, s string) string {
+		if v, ok := c.raw.strings[path]; ok && v[1] == s {
+			return v[0]
+		}
+		return s
+	})
+	return r, nil
+}
+
+// replace every string value (not key) by f, [path] locates the value, e.g. "Proxy[HK_a][4]"
+func walkStrings(v reflect.Value, path string, f func(path, s string) string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, f)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				name := v.Type().Field(i).Name
+				if len(path) > 0 {
+					name = path + "." + name
+				}
+				walkStrings(v.Field(i), name, f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), f)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := v.MapIndex(k)
+			p := fmt.Sprintf("%s[%v]", path, k.Interface())
+			if e.Kind() == reflect.String {
+				v.SetMapIndex(k, reflect.ValueOf(f(p, e.String())).Convert(e.Type()))
+			} else {
+				walkStrings(e, p, f)
+			}
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(f(path, v.String()))
+		}
+	}
+}
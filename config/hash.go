@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ConfigStatus struct {
+	Version   string            `json:"version"`
+	File      string            `json:"file"`
+	Hash      string            `json:"hash"`
+	Sections  map[string]string `json:"sections"`
+	AppliedAt time.Time         `json:"applied_at"`
+	StartedAt time.Time         `json:"started_at"`
+}
+
+var (
+	status     = &ConfigStatus{Version: ShuttleVersion, StartedAt: time.Now()}
+	statusLock sync.RWMutex
+)
+
+// hash of every section and the whole config, same config gets same hash
+// no matter the order of keys and the format of file
+func Hash(c *Config) (string, map[string]string) {
+	sections := make(map[string]string)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 { // unexported
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if len(name) == 0 {
+			name = f.Name
+		}
+		data, _ := json.Marshal(hashValue(v.Field(i))) // map keys are sorted
+		sections[name] = hashBytes(data)
+	}
+	// rules of the include file are applied with the config
+	if c.ruleInclude != nil {
+		data, _ := json.Marshal(c.ruleInclude)
+		sections["rule-include"] = hashBytes(data)
+	}
+	names := make([]string, 0, len(sections))
+	for k := range sections {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, k := range names {
+		h.Write([]byte(k + ":" + sections[k] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil)), sections
+}
+
+// plain values of [v] for the hash: Secrets are their values instead of the mask of MarshalJSON,
+// so a change of password or key changes the hash
+func hashValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return hashValue(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); len(f.PkgPath) == 0 {
+				m[f.Name] = hashValue(v.Field(i))
+			}
+		}
+		return m
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = hashValue(v.MapIndex(k))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = hashValue(v.Index(i))
+		}
+		return l
+	case reflect.String:
+		return v.String()
+	}
+	return v.Interface()
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// record the applied config
+func SetApplied(c *Config) {
+	hash, sections := Hash(c)
+	statusLock.Lock()
+	status.File = configFile
+	status.Hash = hash
+	status.Sections = sections
+	status.AppliedAt = time.Now()
+	statusLock.Unlock()
+}
+
+func Status() ConfigStatus {
+	statusLock.RLock()
+	defer statusLock.RUnlock()
+	return *status
+}
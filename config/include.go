@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipt/shuttle/util"
+	"github.com/sipt/yaml"
+)
+
+// local rules out of the config file, e.g. "always proxy this site" of a browser extension:
+//   General:
+//     rule-include: "rule_local.yaml"
+//   rule_local.yaml:
+//     Rule:
+//     - ["DOMAIN-SUFFIX", "example.com", "Proxy", "imported"]
+// matched before the rules of config
+type ruleInclude struct {
+	Rule [][]string `yaml:"Rule,[flow],2quoted"`
+}
+
+// a relative file is in the directory of the config file
+func (c *Config) GetRuleIncludeFile() string {
+	if c.General == nil || len(c.General.RuleInclude) == 0 {
+		return ""
+	}
+	if filepath.IsAbs(c.General.RuleInclude) {
+		return c.General.RuleInclude
+	}
+	return filepath.Join(c.dir, c.General.RuleInclude)
+}
+
+func (c *Config) GetRuleInclude() [][]string {
+	return c.ruleInclude
+}
+
+// rules of [file], none if it does not exist yet
+func LoadRuleInclude(file string) ([][]string, error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+	util.RLock(file)
+	defer util.RUnLock(file)
+	return readRuleInclude(file)
+}
+
+func readRuleInclude(file string) ([][]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read rule include file failed: %v", err)
+	}
+	inc := &ruleInclude{}
+	if err = yaml.Unmarshal(data, inc); err != nil {
+		return nil, fmt.Errorf("resolve rule include file [%s] failed: %v", file, err)
+	}
+	return inc.Rule, nil
+}
+
+// add [rule] to [file], a rule of the same type and value is replaced
+func PutRuleInclude(file string, rule []string) (replaced bool, err error) {
+	util.Lock(file)
+	defer util.UnLock(file)
+	rules, err := readRuleInclude(file)
+	if err != nil {
+		return false, err
+	}
+	for i, r := range rules {
+		if len(r) > 1 && r[0] == rule[0] && strings.EqualFold(r[1], rule[1]) {
+			rules[i], replaced = rule, true
+			break
+		}
+	}
+	if !replaced {
+		rules = append(rules, rule)
+	}
+	data, err := yaml.Marshal(&ruleInclude{Rule: rules})
+	if err != nil {
+		return false, fmt.Errorf("[CONF] yaml marshal rule include failed : %v", err)
+	}
+	if err = writeFile(file, data[:EmojiDecode(data)]); err != nil {
+		return false, fmt.Errorf("[CONF] save rule include file failed : %v", err)
+	}
+	return replaced, nil
+}
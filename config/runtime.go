@@ -0,0 +1,229 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	RuntimeNamespaceGeneral = "general"
+	RuntimeNamespaceGroup   = "group"
+	RuntimeNamespacePin     = "pin"
+	RuntimeNamespaceExclude = "exclude"
+	RuntimeKeyMode          = "mode"
+
+	runtimeKeySep = "."
+)
+
+type RuntimeEvent struct {
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+// runtime values (group selections, mode...), survive restarts and reloads
+type Runtime struct {
+	sync.RWMutex
+	file     string
+	values   map[string]string
+	watchers map[string][]chan *RuntimeEvent
+}
+
+var runtime = NewRuntime("")
+
+func CurrentRuntime() *Runtime {
+	return runtime
+}
+
+// load runtime file and make it current, empty file means memory only
+func InitRuntime(file string) error {
+	r := NewRuntime(file)
+	if err := r.load(); err != nil {
+		return err
+	}
+	runtime.Lock()
+	r.watchers = runtime.watchers
+	runtime.Unlock()
+	runtime = r
+	return nil
+}
+
+func NewRuntime(file string) *Runtime {
+	return &Runtime{
+		file:     file,
+		values:   make(map[string]string),
+		watchers: make(map[string][]chan *RuntimeEvent),
+	}
+}
+
+func RuntimeKey(namespace, name string) string {
+	return namespace + runtimeKeySep + name
+}
+
+func (r *Runtime) Get(key string) (string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	v, ok := r.values[key]
+	return v, ok
+}
+
+func (r *Runtime) GetString(key, def string) string {
+	if v, ok := r.Get(key); ok {
+		return v
+	}
+	return def
+}
+
+func (r *Runtime) GetInt(key string, def int) int {
+	if v, ok := r.Get(key); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func (r *Runtime) GetBool(key string, def bool) bool {
+	if v, ok := r.Get(key); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// all values in namespace, key without namespace
+func (r *Runtime) List(namespace string) map[string]string {
+	prefix := namespace + runtimeKeySep
+	r.RLock()
+	defer r.RUnlock()
+	m := make(map[string]string)
+	for k, v := range r.values {
+		if strings.HasPrefix(k, prefix) {
+			m[k[len(prefix):]] = v
+		}
+	}
+	return m
+}
+
+// set value, persist and notify watchers
+func (r *Runtime) Set(key string, value interface{}) error {
+	v := fmt.Sprint(value)
+	r.Lock()
+	if old, ok := r.values[key]; ok && old == v {
+		r.Unlock()
+		return nil
+	}
+	r.values[key] = v
+	err := r.save()
+	r.Unlock()
+	r.notify(&RuntimeEvent{Key: key, Value: v})
+	return err
+}
+
+func (r *Runtime) Delete(key string) error {
+	r.Lock()
+	if _, ok := r.values[key]; !ok {
+		r.Unlock()
+		return nil
+	}
+	delete(r.values, key)
+	err := r.save()
+	r.Unlock()
+	r.notify(&RuntimeEvent{Key: key, Deleted: true})
+	return err
+}
+
+// subscribe changes of key, key ends with "." subscribes the namespace.
+// call cancel to stop watching
+func (r *Runtime) Watch(key string) (<-chan *RuntimeEvent, func()) {
+	c := make(chan *RuntimeEvent, 8)
+	r.Lock()
+	r.watchers[key] = append(r.watchers[key], c)
+	r.Unlock()
+	return c, func() {
+		r.Lock()
+		defer r.Unlock()
+		cs := r.watchers[key]
+		for i, v := range cs {
+			if v == c {
+				r.watchers[key] = append(cs[:i], cs[i+1:]...)
+				close(c)
+				break
+			}
+		}
+	}
+}
+
+func (r *Runtime) notify(e *RuntimeEvent) {
+	r.RLock()
+	defer r.RUnlock()
+	for k, cs := range r.watchers {
+		if k != e.Key && !(strings.HasSuffix(k, runtimeKeySep) && strings.HasPrefix(e.Key, k)) {
+			continue
+		}
+		for _, c := range cs {
+			select {
+			case c <- e:
+			default: // slow watcher, drop
+			}
+		}
+	}
+}
+
+func (r *Runtime) load() error {
+	if len(r.file) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read runtime file failed: %v", err)
+	}
+	if err = json.Unmarshal(data, &r.values); err != nil {
+		return fmt.Errorf("resolve runtime file failed: %v", err)
+	}
+	return nil
+}
+
+func (r *Runtime) save() error {
+	if len(r.file) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeFile(r.file, data); err != nil {
+		return fmt.Errorf("[CONF] save runtime file failed: %v", err)
+	}
+	return nil
+}
+
+// write to a temp file then rename, the old file is kept if crashed while writing
+func writeFile(file string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/sipt/yaml"
+)
+
+const SecretMask = "******"
+
+// a password or key in config, it is masked when printed or marshaled to json,
+// Reveal is the only way to get the value. config file keeps the value
+type Secret string
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return SecretMask
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// positions of credentials in server params of each protocol, options are appended after them
+var secretParams = map[string][]int{
+	"ss":        {4},    // ["ss", "addr", "port", "method", "password"]
+	"socks":     {3, 4}, // ["socks", "addr", "port", "username", "password"]
+	"socks-tls": {4, 5}, // ["socks-tls", "addr", "port", "verify", "username", "password"]
+}
+
+// options of servers and transports, which may take the place of credentials
+var serverOptions = []string{"transport", "path", "header", "service", "host", "sni", "skip-verify", "front-ip"}
+
+// copy of server (or template) params with credentials masked
+func MaskServerParams(params []string) []string {
+	masked := make([]string, len(params))
+	copy(masked, params)
+	if len(params) == 0 {
+		return masked
+	}
+	for _, i := range secretParams[params[0]] {
+		if i < len(params) && len(params[i]) > 0 && !IsServerOption(params[i]) {
+			masked[i] = SecretMask
+		}
+	}
+	maskHeaders(masked)
+	return masked
+}
+
+const headerOption = "header="
+
+// "header=Authorization:Bearer xxx" -> "header=Authorization:******", values of headers are often tokens
+func maskHeaders(params []string) {
+	for i, v := range params {
+		if !strings.HasPrefix(v, headerOption) {
+			continue
+		}
+		if j := strings.IndexByte(v, ':'); j >= 0 && j+1 < len(v) {
+			params[i] = v[:j+1] + SecretMask
+		}
+	}
+}
+
+// masked credentials submitted back are replaced by the ones of [old]
+func UnmaskServerParams(params, old []string) []string {
+	if len(old) == 0 || len(params) == 0 || params[0] != old[0] {
+		return params
+	}
+	unmasked := make([]string, len(params))
+	copy(unmasked, params)
+	for _, i := range secretParams[params[0]] {
+		if i < len(params) && i < len(old) && params[i] == SecretMask {
+			unmasked[i] = old[i]
+		}
+	}
+	// masked headers by the header name
+	for i, v := range unmasked {
+		if !strings.HasPrefix(v, headerOption) || !strings.HasSuffix(v, ":"+SecretMask) {
+			continue
+		}
+		name := v[:len(v)-len(SecretMask)]
+		for _, o := range old {
+			if strings.HasPrefix(o, name) {
+				unmasked[i] = o
+				break
+			}
+		}
+	}
+	return unmasked
+}
+
+// params of unknown format: the first [keep] ones and options are kept, others are masked
+func MaskUnknownParams(params []string, keep int) []string {
+	masked := make([]string, len(params))
+	for i, v := range params {
+		if i < keep || len(v) == 0 || IsServerOption(v) {
+			masked[i] = v
+		} else {
+			masked[i] = SecretMask
+		}
+	}
+	maskHeaders(masked)
+	return masked
+}
+
+func IsServerOption(v string) bool {
+	for _, o := range serverOptions {
+		if strings.HasPrefix(v, o+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// copy of the config with secrets masked: Secret values, credentials of servers and templates
+func MaskConfig(c *Config) (*Config, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	r := &Config{}
+	if err = yaml.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	maskSecrets(reflect.ValueOf(r))
+	for k, v := range r.Proxy {
+		r.Proxy[k] = MaskServerParams(v)
+	}
+	for k, v := range r.Templates {
+		r.Templates[k] = MaskServerParams(v)
+	}
+	return r, nil
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			maskSecrets(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				maskSecrets(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			maskSecrets(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Type().Elem() == secretType {
+			for _, k := range v.MapKeys() {
+				v.SetMapIndex(k, reflect.ValueOf(Secret(v.MapIndex(k).Interface().(Secret).String())))
+			}
+			return
+		}
+		for _, k := range v.MapKeys() {
+			maskSecrets(v.MapIndex(k))
+		}
+	case reflect.String:
+		if v.Type() == secretType && v.CanSet() {
+			v.SetString(Secret(v.String()).String())
+		}
+	}
+}
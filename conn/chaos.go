@@ -0,0 +1,59 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+var ErrChaosReset = errors.New("connection reset by chaos")
+
+// the connection is reset after [after] bytes of both directions, it can not be spliced
+func ResetConn(c net.Conn, after int64) net.Conn {
+	return &resetConn{Conn: c, left: after}
+}
+
+type resetConn struct {
+	net.Conn
+	left int64
+}
+
+func (c *resetConn) Read(b []byte) (int, error) {
+	left := atomic.LoadInt64(&c.left)
+	if left <= 0 {
+		return 0, c.reset()
+	}
+	if int64(len(b)) > left {
+		b = b[:left]
+	}
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.left, -int64(n))
+	return n, err
+}
+
+func (c *resetConn) Write(b []byte) (int, error) {
+	left := atomic.LoadInt64(&c.left)
+	if left <= 0 {
+		return 0, c.reset()
+	}
+	if int64(len(b)) <= left {
+		n, err := c.Conn.Write(b)
+		atomic.AddInt64(&c.left, -int64(n))
+		return n, err
+	}
+	n, err := c.Conn.Write(b[:left])
+	atomic.AddInt64(&c.left, -int64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, c.reset()
+}
+
+// close with RST like a peer or middlebox aborting the connection
+func (c *resetConn) reset() error {
+	if tc, ok := tcpConn(c.Conn); ok {
+		tc.SetLinger(0)
+	}
+	c.Conn.Close()
+	return ErrChaosReset
+}
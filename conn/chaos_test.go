@@ -0,0 +1,32 @@
+package conn
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestResetConn(t *testing.T) {
+	client, server := tcpPair(t)
+	defer server.Close()
+	c := ResetConn(client, 10)
+	if _, ok := tcpConn(c); !ok {
+		t.Error("tcp connection under reset connection not found")
+	}
+	if n, err := c.Write(make([]byte, 6)); n != 6 || err != nil {
+		t.Fatalf("write before reset: %d, %v", n, err)
+	}
+	if n, err := c.Write(make([]byte, 6)); n != 4 || err != ErrChaosReset {
+		t.Fatalf("write over reset: %d, %v", n, err)
+	}
+	if _, err := c.Read(make([]byte, 1)); err != ErrChaosReset {
+		t.Errorf("read after reset: %v", err)
+	}
+
+	// the peer sees the bytes before the reset, then an error instead of EOF
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(server)
+	if len(data) != 10 || err == nil {
+		t.Errorf("peer read %d bytes, %v", len(data), err)
+	}
+}
@@ -17,6 +17,7 @@ type IConn interface {
 	SetRecordID(id int64)
 	GetNetwork() string
 	Flush() (int, error)
+	// done when the connection is closed, values are added by SetContext with a derived one
 	Context() context.Context
 	SetContext(context.Context)
 }
@@ -27,7 +28,12 @@ func NewDefaultConn(conn net.Conn, network string) (IConn, error) {
 }
 
 func DirectConn(network, host string) (IConn, error) {
-	conn, err := net.DialTimeout(network, host, DefaultTimeOut)
+	return DirectConnContext(context.Background(), network, host)
+}
+
+// the dial is given up when [ctx] is done
+func DirectConnContext(ctx context.Context, network, host string) (IConn, error) {
+	conn, err := (&net.Dialer{Timeout: DefaultTimeOut}).DialContext(ctx, network, host)
 	if err != nil {
 		return nil, err
 	}
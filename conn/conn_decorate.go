@@ -6,7 +6,6 @@ import (
 	"net"
 	"time"
 
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"github.com/sipt/shuttle/util"
 )
@@ -15,21 +14,17 @@ var DefaultTimeOut = 10 * time.Second
 
 //
 func DefaultDecorate(c net.Conn, network string) (IConn, error) {
-	id := util.GetLongID()
-	return &DefaultConn{
-		Conn:    c,
-		ID:      id,
-		Network: network,
-		context: context.Background(),
-	}, nil
+	return DefaultDecorateForTls(c, network, util.GetLongID())
 }
 
 func DefaultDecorateForTls(c net.Conn, network string, id int64) (IConn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &DefaultConn{
 		Conn:    c,
 		ID:      id,
 		Network: network,
-		context: context.Background(),
+		context: ctx,
+		cancel:  cancel,
 	}, nil
 }
 
@@ -39,6 +34,7 @@ type DefaultConn struct {
 	RecordID int64
 	Network  string
 	context  context.Context
+	cancel   context.CancelFunc // of the context, on close
 }
 
 func (c *DefaultConn) GetID() int64 {
@@ -69,16 +65,17 @@ func (c *DefaultConn) SetContext(ctx context.Context) {
 
 func (c *DefaultConn) Read(b []byte) (n int, err error) {
 	n, err = c.Conn.Read(b)
-	log.Logger.Tracef("[ID:%d] Read Data: %v", c.GetID(), b[:n])
+	connLog.Tracef("[ID:%d] Read Data: %v", c.GetID(), b[:n])
 	return
 }
 
 func (c *DefaultConn) Write(b []byte) (n int, err error) {
-	log.Logger.Tracef("[ID:%d] Write Data: %v", c.GetID(), b)
+	connLog.Tracef("[ID:%d] Write Data: %v", c.GetID(), b)
 	return c.Conn.Write(b)
 }
 func (c *DefaultConn) Close() error {
-	log.Logger.Debugf("[ID:%d] close connection", c.GetID())
+	connLog.Debugf("[ID:%d] close connection", c.GetID())
+	c.cancel()
 	return c.Conn.Close()
 }
 
@@ -207,3 +204,37 @@ func (t *Traffic) Write(b []byte) (n int, err error) {
 	}
 	return
 }
+
+//预读装饰, 预读的数据会在第一次Read时返回
+func PeekDecorate(c IConn, timeout time.Duration) (IConn, []byte, error) {
+	buf := pool.GetBuf()
+	c.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.Read(buf)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = nil
+		}
+	}
+	data := make([]byte, n)
+	copy(data, buf)
+	pool.PutBuf(buf)
+	return &PeekConn{
+		IConn: c,
+		buf:   data,
+	}, data, err
+}
+
+type PeekConn struct {
+	IConn
+	buf []byte
+}
+
+func (p *PeekConn) Read(b []byte) (n int, err error) {
+	if len(p.buf) > 0 {
+		n = copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return
+	}
+	return p.IConn.Read(b)
+}
@@ -0,0 +1,103 @@
+package conn
+
+import (
+	"io"
+	"net"
+
+	"github.com/sipt/shuttle/pool"
+)
+
+// bytes spliced per round, traffic is counted after each round
+const spliceChunk = 64 * 1024
+
+// copy from [src] to [dst] until EOF or error,
+// both are plain tcp connections: splice(2) on linux, else with a pooled buffer
+func Copy(dst, src IConn) (written int64, err error) {
+	if spliceEnabled {
+		if dc, dts, ok := unwrapTCP(dst); ok {
+			if sc, sts, ok := unwrapTCP(src); ok {
+				return spliceTCP(dc, sc, dts, sts)
+			}
+		}
+	}
+	buf := pool.GetBuf()
+	defer pool.PutBuf(buf)
+	var n int
+	for {
+		n, err = src.Read(buf)
+		if n > 0 {
+			n, err = dst.Write(buf[:n])
+			written += int64(n)
+			if err != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+// find the tcp connection under DefaultConn, Traffic and WatchedConn without pending data,
+// other decorators change data or timing
+func unwrapTCP(c IConn) (*net.TCPConn, []*Traffic, bool) {
+	var ts []*Traffic
+	for {
+		switch v := c.(type) {
+		case *Traffic:
+			ts = append(ts, v)
+			c = v.IConn
+		case *WatchedConn:
+			if len(v.pending) > 0 || v.err != nil {
+				return nil, nil, false
+			}
+			c = v.IConn
+		case *DefaultConn:
+			tc, ok := v.Conn.(*net.TCPConn)
+			return tc, ts, ok
+		default:
+			return nil, nil, false
+		}
+	}
+}
+
+// the tcp connection under LimitConn and ResetConn
+func tcpConn(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch v := c.(type) {
+		case *limitedConn:
+			c = v.Conn
+		case *resetConn:
+			c = v.Conn
+		default:
+			tc, ok := c.(*net.TCPConn)
+			return tc, ok
+		}
+	}
+}
+
+func spliceTCP(dst, src *net.TCPConn, dts, sts []*Traffic) (written int64, err error) {
+	var n int64
+	for {
+		n, err = dst.ReadFrom(&io.LimitedReader{R: src, N: spliceChunk})
+		if n > 0 {
+			written += n
+			for _, t := range sts {
+				if download != nil && t.GetRecordID() > 0 {
+					download(t.GetRecordID(), int(n))
+				}
+			}
+			for _, t := range dts {
+				if upload != nil && t.GetRecordID() > 0 {
+					upload(t.GetRecordID(), int(n))
+				}
+			}
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
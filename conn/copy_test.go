@@ -0,0 +1,109 @@
+package conn
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+)
+
+// tcp pair: client -> relay(src) ... relay(dst) -> server
+func tcpPair(tb testing.TB) (client, server net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer l.Close()
+	ch := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		ch <- c
+	}()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return client, <-ch
+}
+
+type copyResult struct {
+	n   int64
+	err error
+}
+
+func relay(tb testing.TB, wrap func(IConn) IConn) (in, out net.Conn, done chan copyResult) {
+	in, src := tcpPair(tb)
+	dst, out := tcpPair(tb)
+	s, _ := DefaultDecorate(src, TCP)
+	d, _ := DefaultDecorate(dst, TCP)
+	done = make(chan copyResult, 1)
+	go func() {
+		n, err := Copy(wrap(d), wrap(s))
+		dst.Close()
+		src.Close()
+		done <- copyResult{n, err}
+	}()
+	return
+}
+
+func plain(c IConn) IConn { return c }
+
+func buffered(c IConn) IConn { return &PeekConn{IConn: c} }
+
+func TestCopy(t *testing.T) {
+	for name, wrap := range map[string]func(IConn) IConn{"splice": plain, "buffer": buffered} {
+		in, out, done := relay(t, wrap)
+		data := bytes.Repeat([]byte("shuttle"), 100000)
+		go func() {
+			in.Write(data)
+			in.Close()
+		}()
+		got, err := ioutil.ReadAll(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := <-done
+		if r.err != nil {
+			t.Fatalf("[%s] copy failed: %v", name, r.err)
+		}
+		if r.n != int64(len(data)) || !bytes.Equal(got, data) {
+			t.Errorf("[%s] copy %d bytes, received %d bytes", name, r.n, len(got))
+		}
+	}
+}
+
+func benchmarkCopy(b *testing.B, wrap func(IConn) IConn, conns int) {
+	data := make([]byte, 32*1024)
+	b.SetBytes(int64(len(data) * conns))
+	b.ReportAllocs()
+	pairs := make([][2]net.Conn, conns)
+	for i := range pairs {
+		in, out, _ := relay(b, wrap)
+		pairs[i] = [2]net.Conn{in, out}
+		go io.Copy(ioutil.Discard, out)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg := &sync.WaitGroup{}
+		for _, p := range pairs {
+			wg.Add(1)
+			go func(c net.Conn) {
+				defer wg.Done()
+				c.Write(data)
+			}(p[0])
+		}
+		wg.Wait()
+	}
+	b.StopTimer()
+	for _, p := range pairs {
+		p[0].Close()
+		p[1].Close()
+	}
+}
+
+func BenchmarkCopySplice(b *testing.B)        { benchmarkCopy(b, plain, 1) }
+func BenchmarkCopyBuffer(b *testing.B)        { benchmarkCopy(b, buffered, 1) }
+func BenchmarkCopySpliceConns1k(b *testing.B) { benchmarkCopy(b, plain, 1000) }
+func BenchmarkCopyBufferConns1k(b *testing.B) { benchmarkCopy(b, buffered, 1000) }
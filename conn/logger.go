@@ -0,0 +1,6 @@
+package conn
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var connLog = log.Named("conn")
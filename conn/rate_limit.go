@@ -0,0 +1,66 @@
+package conn
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// unused rate is saved up to this, so short bursts are not delayed
+const rateBurst = 100 * time.Millisecond
+
+// bandwidth shared by connections, bytes per second of each direction
+type RateLimiter struct {
+	up, down *rateBucket
+}
+
+func NewRateLimiter(rate uint64) *RateLimiter {
+	return &RateLimiter{
+		up:   &rateBucket{rate: rate},
+		down: &rateBucket{rate: rate},
+	}
+}
+
+type rateBucket struct {
+	sync.Mutex
+	rate uint64
+	at   time.Time // when the bytes passed are paid off
+}
+
+// wait until n bytes are allowed
+func (b *rateBucket) wait(n int) {
+	b.Lock()
+	now := time.Now()
+	if b.at.Before(now.Add(-rateBurst)) {
+		b.at = now.Add(-rateBurst)
+	}
+	b.at = b.at.Add(time.Duration(uint64(n) * uint64(time.Second) / b.rate))
+	d := b.at.Sub(now)
+	b.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// the connection can not be spliced, its bytes pass the limiter
+func LimitConn(c net.Conn, l *RateLimiter) net.Conn {
+	return &limitedConn{Conn: c, limiter: l}
+}
+
+type limitedConn struct {
+	net.Conn
+	limiter *RateLimiter
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.limiter.down.wait(n)
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	c.limiter.up.wait(len(b))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,29 @@
+package conn
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLimitConn(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+	c, _ := DefaultDecorate(LimitConn(client, NewRateLimiter(1000)), TCP)
+	if _, _, ok := unwrapTCP(c); ok {
+		t.Error("limited connection is spliced")
+	}
+
+	// 100ms of burst, then 1000 bytes per second
+	go io.Copy(io.Discard, server)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := c.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Errorf("400 bytes at 1000 B/s in %s", d)
+	}
+}
@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"net"
+	"sync"
+)
+
+// closed connections are dropped when the marked ones are twice as many as the last sweep
+const minSelfSweep = 1024
+
+// tcp connections dialed by shuttle by the local address, an inbound connection from one of them
+// is shuttle's own traffic routed back into itself
+type selfConns struct {
+	sync.Mutex
+	conns map[string]*net.TCPConn
+	sweep int
+}
+
+var selfs = &selfConns{conns: make(map[string]*net.TCPConn), sweep: minSelfSweep}
+
+// mark an outbound connection of shuttle, e.g. to a server, a health check or a direct connection
+func MarkSelf(c net.Conn) {
+	tc, ok := tcpConn(c)
+	if !ok {
+		return
+	}
+	selfs.Lock()
+	defer selfs.Unlock()
+	selfs.conns[tc.LocalAddr().String()] = tc
+	if len(selfs.conns) >= selfs.sweep {
+		for k, v := range selfs.conns {
+			if !tcpOpen(v) {
+				delete(selfs.conns, k)
+			}
+		}
+		selfs.sweep = 2 * len(selfs.conns)
+		if selfs.sweep < minSelfSweep {
+			selfs.sweep = minSelfSweep
+		}
+	}
+}
+
+// mark the tcp connection under DefaultConn and Traffic
+func MarkSelfIConn(c IConn) {
+	if tc, _, ok := unwrapTCP(c); ok {
+		MarkSelf(tc)
+	}
+}
+
+// whether the inbound connection [c] comes from an open connection of shuttle
+func IsSelf(c net.Conn) bool {
+	remote, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	key := remote.String()
+	selfs.Lock()
+	tc, ok := selfs.conns[key]
+	selfs.Unlock()
+	if !ok {
+		return false
+	}
+	if !tcpOpen(tc) {
+		// the local port is reused by another process
+		selfs.Lock()
+		if selfs.conns[key] == tc {
+			delete(selfs.conns, key)
+		}
+		selfs.Unlock()
+		return false
+	}
+	return true
+}
+
+func tcpOpen(c *net.TCPConn) bool {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return false
+	}
+	return rc.Control(func(uintptr) {}) == nil
+}
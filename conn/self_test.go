@@ -0,0 +1,19 @@
+package conn
+
+import "testing"
+
+func TestIsSelf(t *testing.T) {
+	client, server := tcpPair(t)
+	defer server.Close()
+	if IsSelf(server) {
+		t.Error("unmarked connection is self")
+	}
+	MarkSelf(client)
+	if !IsSelf(server) {
+		t.Error("marked connection is not self")
+	}
+	client.Close()
+	if IsSelf(server) {
+		t.Error("closed connection is self")
+	}
+}
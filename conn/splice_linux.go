@@ -0,0 +1,6 @@
+// +build linux
+
+package conn
+
+// net.TCPConn.ReadFrom uses splice(2) between tcp connections
+const spliceEnabled = true
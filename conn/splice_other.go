@@ -0,0 +1,5 @@
+// +build !linux
+
+package conn
+
+const spliceEnabled = false
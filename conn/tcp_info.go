@@ -0,0 +1,186 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrTCPInfoNotSupported = errors.New("TCP_INFO is not supported on this platform")
+
+// kernel statistics of a tcp socket
+type TCPInfo struct {
+	RTT          time.Duration
+	RTTVar       time.Duration
+	Retransmits  uint32 // unrecovered retransmits of the current segment
+	TotalRetrans uint32
+	Cwnd         uint32 // congestion window in segments
+}
+
+// socket statistics of active connections of a policy
+type SocketStats struct {
+	Policy       string        `json:"policy"`
+	Servers      []string      `json:"servers"`
+	Conns        int           `json:"conns"`
+	RTT          time.Duration `json:"rtt"` // average
+	RTTVar       time.Duration `json:"rtt_var"`
+	MaxRTT       time.Duration `json:"max_rtt"`
+	Retransmits  uint32        `json:"retransmits"`
+	TotalRetrans uint32        `json:"total_retrans"`
+	Cwnd         uint32        `json:"cwnd"` // average
+	Sampled      time.Time     `json:"sampled"`
+}
+
+type trackedConn struct {
+	conn   *net.TCPConn
+	policy string
+	server string
+}
+
+// active proxied connections are sampled every interval, closed ones are dropped on sampling
+type socketCollector struct {
+	sync.Mutex
+	interval time.Duration
+	conns    []*trackedConn
+	stats    []*SocketStats
+	done     chan struct{}
+}
+
+var (
+	collector     *socketCollector
+	collectorLock sync.RWMutex
+)
+
+func getCollector() *socketCollector {
+	collectorLock.RLock()
+	defer collectorLock.RUnlock()
+	return collector
+}
+
+// start sampling every [interval], 0 to stop
+func StartSocketStats(interval time.Duration) {
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+	if collector != nil && collector.interval == interval {
+		return
+	}
+	if collector != nil {
+		close(collector.done)
+		collector = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	collector = &socketCollector{
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go collector.run()
+}
+
+// track a connection to a server of [policy], no-op when stats is off
+func TrackTCP(c net.Conn, policy, server string) {
+	tc, ok := tcpConn(c)
+	s := getCollector()
+	if !ok || s == nil {
+		return
+	}
+	s.Lock()
+	s.conns = append(s.conns, &trackedConn{tc, policy, server})
+	s.Unlock()
+}
+
+// track the tcp connection under DefaultConn and Traffic
+func TrackIConn(c IConn, policy, server string) {
+	if tc, _, ok := unwrapTCP(c); ok {
+		TrackTCP(tc, policy, server)
+	}
+}
+
+// latest statistics per policy, sorted by policy
+func GetSocketStats() []*SocketStats {
+	s := getCollector()
+	if s == nil {
+		return []*SocketStats{}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.stats == nil {
+		return []*SocketStats{}
+	}
+	return s.stats
+}
+
+func (s *socketCollector) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+func (s *socketCollector) sample() {
+	s.Lock()
+	conns := s.conns
+	s.Unlock()
+	now := time.Now()
+	alive := make(map[*trackedConn]bool, len(conns))
+	policies := make(map[string]*SocketStats)
+	servers := make(map[string]map[string]bool)
+	for _, c := range conns {
+		info, err := GetTCPInfo(c.conn)
+		if err != nil {
+			continue // closed
+		}
+		alive[c] = true
+		st, ok := policies[c.policy]
+		if !ok {
+			st = &SocketStats{Policy: c.policy, Sampled: now}
+			policies[c.policy] = st
+			servers[c.policy] = make(map[string]bool)
+		}
+		servers[c.policy][c.server] = true
+		st.Conns++
+		st.RTT += info.RTT
+		st.RTTVar += info.RTTVar
+		if info.RTT > st.MaxRTT {
+			st.MaxRTT = info.RTT
+		}
+		st.Retransmits += info.Retransmits
+		st.TotalRetrans += info.TotalRetrans
+		st.Cwnd += info.Cwnd
+	}
+	stats := make([]*SocketStats, 0, len(policies))
+	for policy, st := range policies {
+		st.RTT /= time.Duration(st.Conns)
+		st.RTTVar /= time.Duration(st.Conns)
+		st.Cwnd /= uint32(st.Conns)
+		for name := range servers[policy] {
+			st.Servers = append(st.Servers, name)
+		}
+		sort.Strings(st.Servers)
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Policy < stats[j].Policy
+	})
+
+	s.Lock()
+	defer s.Unlock()
+	// keep connections tracked while sampling
+	list := make([]*trackedConn, 0, len(alive)+len(s.conns)-len(conns))
+	for _, c := range conns {
+		if alive[c] {
+			list = append(list, c)
+		}
+	}
+	s.conns = append(list, s.conns[len(conns):]...)
+	s.stats = stats
+}
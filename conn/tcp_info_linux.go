@@ -0,0 +1,42 @@
+// +build linux
+
+package conn
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func GetTCPInfo(c *net.TCPConn) (*TCPInfo, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		raw     syscall.TCPInfo
+		sockErr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	return &TCPInfo{
+		RTT:          time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.Rttvar) * time.Microsecond,
+		Retransmits:  uint32(raw.Retransmits),
+		TotalRetrans: raw.Total_retrans,
+		Cwnd:         raw.Snd_cwnd,
+	}, nil
+}
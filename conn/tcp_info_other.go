@@ -0,0 +1,9 @@
+// +build !linux
+
+package conn
+
+import "net"
+
+func GetTCPInfo(c *net.TCPConn) (*TCPInfo, error) {
+	return nil, ErrTCPInfoNotSupported
+}
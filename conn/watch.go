@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxWatchPending = 64 * 1024
+	watchStopRetry  = 10 * time.Millisecond
+)
+
+// detect the peer aborting while nobody reads, e.g. a client waiting for the reply of dialing
+// or for the upstream response: the connection is closed, so its context is done.
+// EOF is a half-close, the peer still reads the reply. data read meanwhile is kept for the next reads
+type WatchedConn struct {
+	IConn
+	pending []byte
+	err     error // of the watch, after pending
+}
+
+func NewWatchedConn(c IConn) *WatchedConn {
+	if w, ok := c.(*WatchedConn); ok {
+		return w
+	}
+	return &WatchedConn{IConn: c}
+}
+
+// watch until stop returns, [closed] is called when the peer aborted meanwhile.
+// the connection must not be read before stop
+func (w *WatchedConn) Watch(closed func()) (stop func()) {
+	var stopped int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 512)
+		for len(w.pending) < maxWatchPending && atomic.LoadInt32(&stopped) == 0 {
+			n, err := w.IConn.Read(buf)
+			w.pending = append(w.pending, buf[:n]...)
+			if err == nil {
+				continue
+			}
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return // stopped, or a read timeout of the connection
+			}
+			w.err = err
+			if err == io.EOF {
+				return // half-closed, the reply is still written
+			}
+			w.IConn.Close()
+			if closed != nil {
+				closed()
+			}
+			return
+		}
+	}()
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		for {
+			// interrupt the read, again if a decorator resets the deadline
+			w.IConn.SetReadDeadline(time.Now())
+			select {
+			case <-done:
+				w.IConn.SetReadDeadline(time.Time{})
+				return
+			case <-time.After(watchStopRetry):
+			}
+		}
+	}
+}
+
+func (w *WatchedConn) Read(b []byte) (int, error) {
+	if len(w.pending) > 0 {
+		n := copy(b, w.pending)
+		w.pending = w.pending[n:]
+		return n, nil
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.IConn.Read(b)
+}
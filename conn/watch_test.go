@@ -0,0 +1,66 @@
+package conn
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWatchedConn(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	c, _ := DefaultDecorate(server, TCP)
+	w := NewWatchedConn(c)
+
+	// data of the peer while watching is read after stop
+	stop := w.Watch(nil)
+	client.Write([]byte("early"))
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	client.Write([]byte(" data"))
+	client.Close()
+	got, _ := ioutil.ReadAll(w)
+	if string(got) != "early data" {
+		t.Errorf("read after watch: %q", got)
+	}
+
+	// the peer half-closing while watching still gets the reply
+	client, server = tcpPair(t)
+	c, _ = DefaultDecorate(server, TCP)
+	w = NewWatchedConn(c)
+	stop = w.Watch(func() { t.Error("half-close is taken as abort") })
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if _, err := w.Write([]byte("reply")); err != nil {
+		t.Errorf("write after half-close: %v", err)
+	}
+	w.Close()
+	got, _ = ioutil.ReadAll(client)
+	if string(got) != "reply" {
+		t.Errorf("peer read after half-close: %q", got)
+	}
+	client.Close()
+
+	// the peer aborting while watching closes the connection and its context
+	client, server = tcpPair(t)
+	c, _ = DefaultDecorate(server, TCP)
+	w = NewWatchedConn(c)
+	closed := make(chan struct{})
+	stop = w.Watch(func() { close(closed) })
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("peer closing is not detected")
+	}
+	stop()
+	select {
+	case <-w.Context().Done():
+	default:
+		t.Error("context is not done after close")
+	}
+}
@@ -13,6 +13,8 @@ func APIRoute(router *gin.RouterGroup, eventChan chan *EventObj) {
 	//records
 	router.GET("/records", GetRecords)
 	router.DELETE("/records", ClearRecords)
+	router.GET("/traffic/users", GetUserTraffic)
+	router.GET("/traffic/tags", GetTagTraffic)
 
 	//dump
 	dump := router.Group("/dump")
@@ -21,6 +23,7 @@ func APIRoute(router *gin.RouterGroup, eventChan chan *EventObj) {
 		dump.GET("/allow", GetAllowDump)
 		dump.GET("/data/:conn_id", DumpRequest)
 		dump.GET("/large/:conn_id", DumpLarge)
+		dump.GET("/grpc/:conn_id", DumpGRPC)
 	}
 
 	//cert
@@ -31,12 +34,39 @@ func APIRoute(router *gin.RouterGroup, eventChan chan *EventObj) {
 	router.GET("/servers", ServerList)
 	router.POST("/server/select", SelectServer)
 	router.POST("/server/select/refresh", SelectRefresh)
+	router.POST("/server/pin", PinServer)
+	router.DELETE("/server/pin", UnpinServer)
+	router.POST("/server/exclude", ExcludeServer)
+	router.DELETE("/server/exclude", IncludeServer)
+	router.GET("/stats/sockets", SocketStats)
+	router.GET("/stats/shedding", ShedStatus)
+
+	//route
+	router.GET("/route", Route)
+	router.GET("/route/export", ExportRoutes)
+
+	//temporary rules
+	router.GET("/rules/temporary", GetTemporaryRules)
+	router.POST("/rules/temporary", AddTemporaryRule)
+	router.DELETE("/rules/temporary", RemoveTemporaryRule)
+	router.POST("/rules/import", NewImportRule(eventChan))
 
 	//general
 	router.GET("/system/proxy/enable", EnableSystemProxy)
 	router.GET("/system/proxy/disable", DisableSystemProxy)
 	router.POST("/shutdown", NewShutdown(eventChan))
 	router.POST("/reload", ReloadConfig(eventChan))
+	router.GET("/canary", GetCanary)
+	router.POST("/canary", StartCanary)
+	router.DELETE("/canary", RollbackCanary)
+	router.POST("/canary/promote", NewPromoteCanary(eventChan))
+	router.GET("/status", GetStatus)
+	router.GET("/logs", GetLogs)
+	router.GET("/logs/level", GetLogLevels)
+	router.POST("/logs/level", SetLogLevels)
+	router.DELETE("/logs/level", ClearLogLevels)
+	router.GET("/diagnostics", GetDiagnostics)
+	router.GET("/diagnostics/exits", GetExitCheck)
 	router.GET("/mode", GetConnMode)
 	router.POST("/mode/:mode", SetConnMode)
 	router.GET("/upgrade/check", CheckUpdate)
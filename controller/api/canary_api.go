@@ -0,0 +1,68 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle"
+	. "github.com/sipt/shuttle/constant"
+)
+
+func GetCanary(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: shuttle.CanaryStatus(),
+	})
+}
+
+func StartCanary(ctx *gin.Context) {
+	file := ctx.PostForm("file")
+	percent, err := strconv.Atoi(ctx.PostForm("percent"))
+	if len(file) == 0 || err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "file is empty or percent is invalid",
+		})
+		return
+	}
+	var window time.Duration
+	if v := ctx.PostForm("window"); len(v) > 0 {
+		if window, err = time.ParseDuration(v); err != nil {
+			ctx.JSON(500, Response{
+				Code: 1, Message: "window is invalid: " + v,
+			})
+			return
+		}
+	}
+	if err = shuttle.StartCanary(file, percent, window); err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	GetCanary(ctx)
+}
+
+func RollbackCanary(ctx *gin.Context) {
+	if !shuttle.RollbackCanary() {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "no candidate config",
+		})
+		return
+	}
+	ctx.JSON(200, Response{})
+}
+
+// reload with the candidate config file
+func NewPromoteCanary(eventChan chan *EventObj) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		file, err := shuttle.PromoteCanary()
+		if err != nil {
+			ctx.JSON(500, Response{
+				Code: 1, Message: err.Error(),
+			})
+			return
+		}
+		ctx.JSON(200, Response{})
+		eventChan <- EventReloadConfig.SetData(file)
+	}
+}
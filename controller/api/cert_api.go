@@ -39,6 +39,7 @@ func GenerateCert(ctx *gin.Context) {
 	conf := config.CurrentConfig()
 	if conf.Mitm != nil {
 		mitm.Rules = conf.Mitm.Rules
+		mitm.GRPCDescriptors = conf.Mitm.GRPCDescriptors
 	}
 	conf.Mitm = mitm
 	err = config.SaveConfig(config.CurrentConfigFile(), conf)
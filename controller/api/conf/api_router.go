@@ -43,6 +43,11 @@ func APIRoute(router *gin.RouterGroup, eventChan chan *EventObj) {
 	//http map
 	router.POST("/http/map", SetHttpMap)
 	router.GET("/http/map", GetHttpMap)
+
+	//config file
+	router.GET("/diff", DiffConfig)
+	router.POST("/diff", DiffConfig)
+	router.GET("/export", ExportConfig)
 }
 
 type Response struct {
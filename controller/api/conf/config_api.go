@@ -0,0 +1,76 @@
+package conf
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/config"
+)
+
+const maxConfigBody = 4 << 20
+
+// semantic differences against the applied config file, of the config file as body (POST),
+// or of the candidate config of blue/green deployment (GET). no other file is read.
+// secrets are masked unless reveal=true
+func DiffConfig(ctx *gin.Context) {
+	reveal := revealed(ctx)
+	var (
+		changes []*shuttle.ConfigChange
+		err     error
+	)
+	if ctx.Request.Method == http.MethodPost {
+		var data []byte
+		data, err = ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxConfigBody))
+		if err == nil {
+			changes, err = shuttle.DiffUntrustedConfigData(data, reveal)
+		}
+	} else if c := shuttle.CanaryStatus(); c != nil {
+		changes, err = shuttle.DiffConfigFiles(config.CurrentConfigFile(), c.File, reveal)
+	} else {
+		ctx.JSON(500, &Response{
+			Code: 1, Message: "no candidate config",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(500, &Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, &Response{
+		Data: changes,
+	})
+}
+
+// the applied config as config file, secrets are masked unless reveal=true
+func ExportConfig(ctx *gin.Context) {
+	data, err := config.ExportConfig(config.CurrentConfig(), revealed(ctx))
+	if err != nil {
+		ctx.JSON(500, &Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.Data(200, "application/x-yaml; charset=utf-8", data)
+}
+
+// reveal=true is honored only for a client on this host which is not a web page (e.g. curl):
+// the controller has no authentication and allows any origin, secrets must not go to a page
+func revealed(ctx *gin.Context) bool {
+	if ctx.Query("reveal") != "true" || len(ctx.GetHeader("Origin")) > 0 {
+		return false
+	}
+	if site := ctx.GetHeader("Sec-Fetch-Site"); len(site) > 0 && site != "none" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -24,6 +24,9 @@ func SetProxies(ctx *gin.Context) {
 		ctx.JSON(500, &Response{Code: 1, Message: err.Error()})
 		return
 	}
+	for k, v := range data {
+		data[k] = config.UnmaskServerParams(v, conf.GetProxy()[k])
+	}
 	newConf.SetProxy(data)
 	err = proxy.ApplyConfig(newConf)
 	if err != nil {
@@ -64,6 +67,9 @@ func GetProxy(ctx *gin.Context) {
 	}
 	proxyConf := config.CurrentConfig().GetProxy()[name]
 	if len(proxyConf) > 0 {
+		if !revealed(ctx) {
+			proxyConf = config.MaskServerParams(proxyConf)
+		}
 		ctx.JSON(200, &Response{
 			Data: &ProxyRequest{
 				Name: name,
@@ -115,6 +121,7 @@ func EditProxy(ctx *gin.Context) {
 		ctx.JSON(500, &Response{Code: 1, Message: err.Error()})
 		return
 	}
+	data.VS = config.UnmaskServerParams(data.VS, config.CurrentConfig().GetProxy()[data.Name])
 	err = proxy.EditProxy(data.Name, data.VS)
 	if err != nil {
 		ctx.JSON(500, &Response{Code: 1, Message: err.Error()})
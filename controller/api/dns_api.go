@@ -6,12 +6,22 @@ import (
 )
 
 func DNSCacheList(ctx *gin.Context) {
+	if domain := ctx.Query("domain"); len(domain) > 0 {
+		answer := dns.LookupDNSCache(domain)
+		if answer == nil {
+			ctx.JSON(200, &Response{Code: 1, Message: "not found"})
+			return
+		}
+		ctx.JSON(200, &Response{Data: answer})
+		return
+	}
 	ctx.JSON(200, &Response{
 		Data: dns.DNSCacheList(),
 	})
 }
 func ClearDNSCache(ctx *gin.Context) {
-	dns.ClearDNSCache()
-	ctx.JSON(200, &Response{})
+	ctx.JSON(200, &Response{
+		Data: dns.FlushDNSCache(ctx.Query("domain")),
+	})
 }
 
@@ -11,8 +11,10 @@ import (
 	"encoding/base64"
 	"compress/gzip"
 	"compress/zlib"
-	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/grpcview"
 	"io"
+	"io/ioutil"
+	"strings"
 )
 
 func SetAllowDump(ctx *gin.Context) {
@@ -146,7 +148,7 @@ func DumpRequest(ctx *gin.Context) {
 		if resp.Header.Get("Content-Encoding") == "gzip" {
 			r, err = gzip.NewReader(resp.Body)
 			if err != nil {
-				log.Logger.Errorf("[Shuttle-Controller] [%d] gzip init for response failed: %v", id, err)
+				controllerLog.Errorf("[Shuttle-Controller] [%d] gzip init for response failed: %v", id, err)
 				response.Code = 1
 				response.Message = err.Error()
 				ctx.JSON(500, response)
@@ -155,7 +157,7 @@ func DumpRequest(ctx *gin.Context) {
 		} else if resp.Header.Get("Content-Encoding") == "deflate" {
 			r, err = zlib.NewReader(resp.Body)
 			if err != nil {
-				log.Logger.Errorf("[Shuttle-Controller] [%d] deflate init for response failed: %v", id, err)
+				controllerLog.Errorf("[Shuttle-Controller] [%d] deflate init for response failed: %v", id, err)
 				response.Code = 1
 				response.Message = err.Error()
 				ctx.JSON(500, response)
@@ -180,6 +182,134 @@ func DumpRequest(ctx *gin.Context) {
 	})
 }
 
+// messages of a gRPC call dumped by MitM, fields are named with [MITM] [grpc-descriptors]
+func DumpGRPC(ctx *gin.Context) {
+	var response Response
+	idStr := ctx.Param("conn_id")
+	id, err := strconvh.ParseInt64(idStr)
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+	r := shuttle.GetRecord(id)
+	if r == nil {
+		response.Code = 1
+		response.Message = idStr + " not exist"
+		ctx.JSON(500, response)
+		return
+	}
+	if r.Status != shuttle.RecordStatusCompleted {
+		response.Code = 1
+		response.Message = idStr + " not Completed"
+		ctx.JSON(500, response)
+		return
+	}
+	dump := shuttle.GetDump()
+	if dump == nil {
+		response.Code = 1
+		response.Message = "IDump is nil"
+		ctx.JSON(500, response)
+		return
+	}
+	reqStream, reqSize, respStream, respSize, err := dump.Dump(id)
+	defer func() {
+		if reqStream != nil {
+			reqStream.Close()
+		}
+		if respStream != nil {
+			respStream.Close()
+		}
+	}()
+	if err == nil && (reqStream == nil || respStream == nil) {
+		err = fmt.Errorf("%s not dumped", idStr)
+	}
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+	if reqSize > shuttle.LargeRequestBody || respSize > shuttle.LargeResponseBody {
+		response.Code = 1
+		response.Message = "large body"
+		ctx.JSON(500, response)
+		return
+	}
+	req, err := http.ReadRequest(bufio.NewReader(reqStream))
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+	if !grpcview.IsGRPC(req.Header.Get("Content-Type")) {
+		response.Code = 1
+		response.Message = idStr + " is not gRPC"
+		ctx.JSON(500, response)
+		return
+	}
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(respStream), req)
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+	// trailers are read with the body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		response.Code = 1
+		response.Message = err.Error()
+		ctx.JSON(500, response)
+		return
+	}
+
+	d := shuttle.GetGRPCDescriptors()
+	in, out := d.Method(req.URL.Path)
+	data := &struct {
+		Path          string              `json:"path"`
+		InputType     string              `json:"input_type"`
+		OutputType    string              `json:"output_type"`
+		Request       []*grpcview.Message `json:"request"`
+		Response      []*grpcview.Message `json:"response"`
+		Status        string              `json:"grpc_status"`
+		StatusMessage string              `json:"grpc_message"`
+		Error         string              `json:"error,omitempty"`
+	}{
+		Path:       req.URL.Path,
+		InputType:  in,
+		OutputType: out,
+	}
+	var errs []string
+	if data.Request, err = grpcview.View(reqBody, req.Header.Get("Grpc-Encoding"), in, d); err != nil {
+		errs = append(errs, "request: "+err.Error())
+	}
+	if data.Response, err = grpcview.View(respBody, resp.Header.Get("Grpc-Encoding"), out, d); err != nil {
+		errs = append(errs, "response: "+err.Error())
+	}
+	data.Error = strings.Join(errs, ", ")
+	// trailers-only responses have the status in headers
+	trailer := resp.Trailer
+	if len(trailer.Get("Grpc-Status")) == 0 {
+		trailer = resp.Header
+	}
+	data.Status, data.StatusMessage = trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message")
+	ctx.JSON(200, Response{
+		Code:    0,
+		Message: "success",
+		Data:    data,
+	})
+}
+
 func DumpLarge(ctx *gin.Context) {
 	response := Response{}
 	fileName := ctx.Query("file_name")
@@ -280,7 +410,7 @@ func DumpLarge(ctx *gin.Context) {
 		if resp.Header.Get("Content-Encoding") == "gzip" {
 			r, err = gzip.NewReader(resp.Body)
 			if err != nil {
-				log.Logger.Errorf("[Shuttle-Controller] [%d] gzip init for response failed: %v", id, err)
+				controllerLog.Errorf("[Shuttle-Controller] [%d] gzip init for response failed: %v", id, err)
 				response.Code = 1
 				response.Message = err.Error()
 				ctx.JSON(500, response)
@@ -289,7 +419,7 @@ func DumpLarge(ctx *gin.Context) {
 		} else if resp.Header.Get("Content-Encoding") == "deflate" {
 			r, err = zlib.NewReader(resp.Body)
 			if err != nil {
-				log.Logger.Errorf("[Shuttle-Controller] [%d] deflate init for response failed: %v", id, err)
+				controllerLog.Errorf("[Shuttle-Controller] [%d] deflate init for response failed: %v", id, err)
 				response.Code = 1
 				response.Message = err.Error()
 				ctx.JSON(500, response)
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"bytes"
 	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle"
 	"github.com/sipt/shuttle/config"
 	. "github.com/sipt/shuttle/constant"
 	"github.com/sipt/shuttle/extension/network"
+	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/rule"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func EnableSystemProxy(ctx *gin.Context) {
@@ -38,6 +43,13 @@ func ReloadConfig(eventChan chan *EventObj) func(ctx *gin.Context) {
 	}
 }
 
+// load shedding and its recent events
+func ShedStatus(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: shuttle.GetShedStatus(),
+	})
+}
+
 func GetConnMode(ctx *gin.Context) {
 	ctx.JSON(200, Response{
 		Data: rule.GetConnMode(),
@@ -48,6 +60,9 @@ func SetConnMode(ctx *gin.Context) {
 	value := ctx.Param("mode")
 	value = strings.ToUpper(value)
 	err := rule.SetConnMode(value)
+	if err == nil {
+		err = config.CurrentRuntime().Set(config.RuntimeKey(config.RuntimeNamespaceGeneral, config.RuntimeKeyMode), value)
+	}
 	if err != nil {
 		ctx.JSON(500, Response{
 			Code:    1,
@@ -56,3 +71,86 @@ func SetConnMode(ctx *gin.Context) {
 	}
 	GetConnMode(ctx)
 }
+
+func GetStatus(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: config.Status(),
+	})
+}
+
+// recent log entries: ?n=100&module=dns&level=debug
+func GetLogs(ctx *gin.Context) {
+	n, _ := strconv.Atoi(ctx.Query("n"))
+	level := log.LogTrace
+	if v := ctx.Query("level"); len(v) > 0 {
+		var ok bool
+		if level, ok = log.LevelMap[v]; !ok {
+			ctx.JSON(500, Response{
+				Code:    1,
+				Message: "not support level: " + v,
+			})
+			return
+		}
+	}
+	ctx.JSON(200, Response{
+		Data: log.Tail(n, ctx.Query("module"), level),
+	})
+}
+
+// configured log levels and the temporary override
+func GetLogLevels(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: log.GetLevels(),
+	})
+}
+
+// temporary log levels: level=debug&modules=dns=debug,rule=trace&duration=15m
+func SetLogLevels(ctx *gin.Context) {
+	var d time.Duration
+	if v := ctx.PostForm("duration"); len(v) > 0 {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			ctx.JSON(500, Response{
+				Code: 1, Message: "duration is invalid: " + v,
+			})
+			return
+		}
+	}
+	if err := log.SetOverride(ctx.PostForm("level"), ctx.PostForm("modules"), d); err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	GetLogLevels(ctx)
+}
+
+func ClearLogLevels(ctx *gin.Context) {
+	if !log.ClearOverride() {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "no temporary log levels",
+		})
+		return
+	}
+	GetLogLevels(ctx)
+}
+
+// zip bundle for bug reports, secrets are masked
+func GetDiagnostics(ctx *gin.Context) {
+	buf := &bytes.Buffer{}
+	if err := shuttle.WriteDiagnostics(buf); err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.Header("Content-Disposition", `attachment; filename="`+shuttle.DiagnosticsFileName()+`"`)
+	ctx.Data(200, "application/zip", buf.Bytes())
+}
+
+// exit IP and DNS leak through every policy of [policy] query, or of [Exit-Check]
+func GetExitCheck(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: shuttle.CheckExits(ctx.QueryArray("policy")),
+	})
+}
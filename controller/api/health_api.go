@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle"
+)
+
+// readiness probe: all modules are ready and no config is being applied
+func Ready(ctx *gin.Context) {
+	list, ok := shuttle.Ready()
+	probe(ctx, list, ok, "not ready")
+}
+
+// liveness probe: no module is crashed or unhealthy
+func Healthz(ctx *gin.Context) {
+	list, ok := shuttle.Healthy()
+	probe(ctx, list, ok, "unhealthy")
+}
+
+func probe(ctx *gin.Context, list []*shuttle.ModuleStatus, ok bool, message string) {
+	if !ok {
+		ctx.JSON(503, Response{
+			Code:    1,
+			Message: message,
+			Data:    list,
+		})
+		return
+	}
+	ctx.JSON(200, Response{
+		Data: list,
+	})
+}
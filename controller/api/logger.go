@@ -0,0 +1,6 @@
+package api
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var controllerLog = log.Named("controller")
@@ -5,9 +5,20 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// ?tag=xxx: records of the tag
 func GetRecords(ctx *gin.Context) {
+	records := shuttle.GetRecords()
+	if tag := ctx.Query("tag"); len(tag) > 0 {
+		tagged := make([]shuttle.Record, 0)
+		for _, r := range records {
+			if r.Tag == tag {
+				tagged = append(tagged, r)
+			}
+		}
+		records = tagged
+	}
 	ctx.JSON(200, &Response{
-		Data: shuttle.GetRecords(),
+		Data: records,
 	})
 }
 func ClearRecords(ctx *gin.Context) {
@@ -18,3 +29,15 @@ func ClearRecords(ctx *gin.Context) {
 	}
 	ctx.JSON(200, &Response{})
 }
+
+func GetUserTraffic(ctx *gin.Context) {
+	ctx.JSON(200, &Response{
+		Data: shuttle.GetUserTraffic(),
+	})
+}
+
+func GetTagTraffic(ctx *gin.Context) {
+	ctx.JSON(200, &Response{
+		Data: shuttle.GetTagTraffic(),
+	})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle"
+)
+
+func Route(ctx *gin.Context) {
+	addr := ctx.Query("addr")
+	if len(addr) == 0 {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "addr is empty",
+		})
+		return
+	}
+	trace, err := shuttle.Route(addr, ctx.Query("network"), ctx.Query("dial") == "true")
+	if err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, Response{
+		Data: trace,
+	})
+}
+
+// DIRECT destinations for external firewalls
+func ExportRoutes(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: shuttle.ExportDirectRoutes(ctx.Query("set")),
+	})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle/config"
+	. "github.com/sipt/shuttle/constant"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+	"github.com/sipt/shuttle/util"
+)
+
+const ImportComment = "imported"
+
+type TemporaryRuleReq struct {
+	Rule []string `json:"rule"` // type, value, policy[, comment]
+	TTL  string   `json:"ttl"`  // e.g. 1h
+}
+
+func GetTemporaryRules(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: rule.TemporaryRules(),
+	})
+}
+
+// not saved to the config file, expires after ttl
+func AddTemporaryRule(ctx *gin.Context) {
+	req := &TemporaryRuleReq{}
+	if err := ctx.BindJSON(req); err != nil {
+		ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		ctx.JSON(500, Response{Code: 1, Message: "invalid ttl: " + err.Error()})
+		return
+	}
+	t, err := rule.AddTemporaryRule(req.Rule, ttl, func(policy string) error {
+		_, err := proxy.GetServer(policy)
+		return err
+	})
+	if err != nil {
+		ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+		return
+	}
+	ctx.JSON(200, Response{
+		Data: t,
+	})
+}
+
+// remove the rule of [id], or all without [id]
+func RemoveTemporaryRule(ctx *gin.Context) {
+	v := ctx.Query("id")
+	if len(v) == 0 {
+		rule.ClearTemporaryRules()
+		ctx.JSON(200, Response{})
+		return
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err == nil {
+		err = rule.RemoveTemporaryRule(id)
+	}
+	if err != nil {
+		ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+		return
+	}
+	ctx.JSON(200, Response{})
+}
+
+type ImportRuleReq struct {
+	Target string `json:"target"` // URL or domain, e.g. https://www.example.com/a
+	Policy string `json:"policy"`
+	Type   string `json:"type"` // DOMAIN-SUFFIX(default), DOMAIN or DOMAIN-KEYWORD
+}
+
+// write a rule for the site to [General] rule-include then reload, e.g. "always proxy this site"
+func NewImportRule(eventChan chan *EventObj) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		req := &ImportRuleReq{}
+		if err := ctx.BindJSON(req); err != nil {
+			ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+			return
+		}
+		file := config.CurrentConfig().GetRuleIncludeFile()
+		if len(file) == 0 {
+			ctx.JSON(500, Response{Code: 1, Message: "[General] rule-include is not set"})
+			return
+		}
+		r, err := importRule(req)
+		if err == nil {
+			_, err = proxy.GetServer(req.Policy)
+		}
+		if err != nil {
+			ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+			return
+		}
+		replaced, err := config.PutRuleInclude(file, r)
+		if err != nil {
+			ctx.JSON(500, Response{Code: 1, Message: err.Error()})
+			return
+		}
+		ctx.JSON(200, Response{
+			Data: struct {
+				Rule     []string `json:"rule"`
+				File     string   `json:"file"`
+				Replaced bool     `json:"replaced"`
+			}{r, file, replaced},
+		})
+		eventChan <- EventReloadConfig
+	}
+}
+
+// rule of the host of [req.Target], IP-CIDR for an IP
+func importRule(req *ImportRuleReq) ([]string, error) {
+	host := strings.TrimSpace(req.Target)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndexByte(host, '@'); i >= 0 {
+		host = host[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	host = util.CanonicalHost(host)
+	if len(host) == 0 {
+		return nil, errors.New("target is empty")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		return []string{rule.RuleIPCIDR, fmt.Sprintf("%s/%d", ip, bits), req.Policy, ImportComment}, nil
+	}
+	switch req.Type {
+	case "":
+		req.Type = rule.RuleDomainSuffix
+	case rule.RuleDomain, rule.RuleDomainSuffix, rule.RuleDomainKeyword:
+	default:
+		return nil, fmt.Errorf("not support rule type [%s]", req.Type)
+	}
+	return []string{req.Type, host, req.Policy, ImportComment}, nil
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
 	"github.com/sipt/shuttle/proxy"
 )
 
@@ -10,11 +13,14 @@ type Group struct {
 	Name       string    `json:"name"`
 	Servers    []*Server `json:"servers"`
 	SelectType string    `json:"select_type"`
+	Pinned     string    `json:"pinned,omitempty"`
 }
 type Server struct {
 	Name     string `json:"name"`
 	Selected bool   `json:"selected"`
 	Rtt      string `json:"rtt,omitempty"`
+	Weight   int    `json:"weight"`
+	Excluded bool   `json:"excluded,omitempty"`
 }
 
 func ServerList(ctx *gin.Context) {
@@ -27,12 +33,15 @@ func ServerList(ctx *gin.Context) {
 			Name:       g.Name,
 			Servers:    make([]*Server, len(g.Servers)),
 			SelectType: g.SelectType,
+			Pinned:     g.Pinned(),
 		}
 		for j, s := range g.Servers {
 			name = s.(proxy.IServer).GetName()
 			group.Servers[j] = &Server{
 				Name:     name,
 				Selected: g.Selector.Current().GetName() == name,
+				Weight:   g.Weight(name),
+				Excluded: g.IsExcluded(name),
 			}
 			if g.SelectType == "rtt" {
 				if ser, ok := s.(*proxy.Server); ok {
@@ -60,6 +69,9 @@ func SelectServer(ctx *gin.Context) {
 		return
 	}
 	err := proxy.SelectServer(groupName, serverName)
+	if err == nil {
+		err = config.CurrentRuntime().Set(config.RuntimeKey(config.RuntimeNamespaceGroup, groupName), serverName)
+	}
 	if err != nil {
 		ctx.JSON(500, Response{
 			Code: 1, Message: err.Error(),
@@ -85,3 +97,87 @@ func SelectRefresh(ctx *gin.Context) {
 	}
 	ctx.JSON(200, Response{})
 }
+
+// pin a server of group until unpinned, survive restarts
+func PinServer(ctx *gin.Context) {
+	groupName := ctx.PostForm("group")
+	serverName := ctx.PostForm("server")
+	if len(groupName) == 0 || len(serverName) == 0 {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "group or server is empty",
+		})
+		return
+	}
+	err := proxy.PinServer(groupName, serverName)
+	if err == nil {
+		err = config.CurrentRuntime().Set(config.RuntimeKey(config.RuntimeNamespacePin, groupName), serverName)
+	}
+	if err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, Response{})
+}
+func UnpinServer(ctx *gin.Context) {
+	groupName := ctx.Query("group")
+	if len(groupName) == 0 {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "group is empty",
+		})
+		return
+	}
+	err := proxy.PinServer(groupName, "")
+	if err == nil {
+		err = config.CurrentRuntime().Delete(config.RuntimeKey(config.RuntimeNamespacePin, groupName))
+	}
+	if err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, Response{})
+}
+
+// exclude a server from group, e.g. drain it for maintenance
+func ExcludeServer(ctx *gin.Context) {
+	setExcluded(ctx, ctx.PostForm("group"), ctx.PostForm("server"), true)
+}
+func IncludeServer(ctx *gin.Context) {
+	setExcluded(ctx, ctx.Query("group"), ctx.Query("server"), false)
+}
+func setExcluded(ctx *gin.Context, groupName, serverName string, excluded bool) {
+	if len(groupName) == 0 || len(serverName) == 0 {
+		ctx.JSON(500, Response{
+			Code: 1, Message: "group or server is empty",
+		})
+		return
+	}
+	err := proxy.ExcludeServer(groupName, serverName, excluded)
+	if err == nil {
+		g, _ := proxy.GroupExist(groupName)
+		key := config.RuntimeKey(config.RuntimeNamespaceExclude, groupName)
+		if list := g.Excluded(); len(list) > 0 {
+			data, _ := json.Marshal(list)
+			err = config.CurrentRuntime().Set(key, string(data))
+		} else {
+			err = config.CurrentRuntime().Delete(key)
+		}
+	}
+	if err != nil {
+		ctx.JSON(500, Response{
+			Code: 1, Message: err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, Response{})
+}
+
+// TCP_INFO of active connections per policy, see socket-stats of General
+func SocketStats(ctx *gin.Context) {
+	ctx.JSON(200, Response{
+		Data: connect.GetSocketStats(),
+	})
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"net/http"
 	"github.com/sipt/shuttle"
-	"github.com/sipt/shuttle/log"
 	"time"
 	"fmt"
 )
@@ -11,7 +10,7 @@ import (
 func WsSpeedHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Logger.Errorf("[Shuttle-Controller] [Speed] Failed to set websocket upgrade: %v", err)
+		controllerLog.Errorf("[Shuttle-Controller] [Speed] Failed to set websocket upgrade: %v", err)
 		return
 	}
 	ticker := time.NewTicker(time.Second)
@@ -12,7 +12,6 @@ import (
 	. "github.com/sipt/shuttle/constant"
 	"github.com/sipt/shuttle/controller/api"
 	"github.com/sipt/shuttle/controller/api/conf"
-	"github.com/sipt/shuttle/log"
 )
 
 func staticHandler(urlPrefix string, fs http.FileSystem) gin.HandlerFunc {
@@ -44,7 +43,8 @@ type IControllerConfig interface {
 	GetLogLevel() string
 }
 
-func StartController(config IControllerConfig, eventChan chan *EventObj) {
+// serve until ShutdownController, ready is called once listening
+func StartController(config IControllerConfig, eventChan chan *EventObj, ready func()) error {
 	//if level == "info" {
 	//gin.SetMode(gin.ReleaseMode)
 	//gin.DefaultWriter = ioutil.Discard
@@ -53,6 +53,9 @@ func StartController(config IControllerConfig, eventChan chan *EventObj) {
 	e.Use(Cors())
 	api.APIRoute(e.Group("/api"), eventChan)
 	conf.APIRoute(e.Group("/api/config"), eventChan)
+	//probes
+	e.GET("/ready", api.Ready)
+	e.GET("/healthz", api.Healthz)
 	e.GET("/", index)
 	//config
 	e.GET("/general", index)
@@ -66,12 +69,21 @@ func StartController(config IControllerConfig, eventChan chan *EventObj) {
 	e.GET("/dns-cache", index)
 	e.Use(staticHandler("/", assets.HTTP))
 
-	server = &http.Server{
+	s := &http.Server{
 		Addr:    net.JoinHostPort(config.GetControllerInterface(), config.GetControllerPort()),
 		Handler: e,
 	}
-	log.Logger.Infof("[Controller] listen to:%s", server.Addr)
-	server.ListenAndServe()
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	server = s
+	controllerLog.Infof("[Controller] listen to:%s", s.Addr)
+	ready()
+	if err = s.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 func ShutdownController() {
@@ -81,7 +93,7 @@ func ShutdownController() {
 		return
 	}
 	s.RegisterOnShutdown(func() {
-		log.Logger.Infof("Stopped Controller goroutine...")
+		controllerLog.Infof("Stopped Controller goroutine...")
 	})
 	go func() {
 		ctx := context.Background()
@@ -0,0 +1,196 @@
+package shuttle
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/rule"
+)
+
+// environment of the running shuttle
+type DiagnosticsVersion struct {
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	StartedAt time.Time `json:"started_at"`
+	Generated time.Time `json:"generated"`
+}
+
+// versions of rule sources: hash of every config section, GeoIP database
+type DiagnosticsRules struct {
+	Hash      string            `json:"hash"`
+	Sections  map[string]string `json:"sections"`
+	AppliedAt time.Time         `json:"applied_at"`
+	Rules     map[string]int    `json:"rules"` // count by type
+	GeoIPFile string            `json:"geoip_file"`
+	GeoIPTime time.Time         `json:"geoip_time,omitempty"` // modified
+}
+
+// connections summary without URLs
+type DiagnosticsStats struct {
+	Goroutines int                    `json:"goroutines"`
+	Records    int                    `json:"records"`
+	Status     map[string]int         `json:"status"` // records by status
+	Servers    map[string]int         `json:"servers"`
+	Up         int                    `json:"up"`
+	Down       int                    `json:"down"`
+	Sockets    []*connect.SocketStats `json:"sockets"`
+	Users      map[string]UserTraffic `json:"users"`
+	Tags       map[string]UserTraffic `json:"tags"`
+	Mode       string                 `json:"mode"`
+	Memory     map[string]uint64      `json:"memory"`
+}
+
+// zip bundle to attach to bug reports: config with secrets masked, recent logs with URLs redacted, version,
+// versions of rules, connection stats and goroutine dump
+func WriteDiagnostics(w io.Writer) error {
+	z := zip.NewWriter(w)
+	status := config.Status()
+	if err := addZipJSON(z, "version.json", &DiagnosticsVersion{
+		Version:   config.ShuttleVersion,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		StartedAt: status.StartedAt,
+		Generated: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if conf := config.CurrentConfig(); conf != nil {
+		data, err := config.ExportConfig(conf, false)
+		if err != nil {
+			return err
+		}
+		if err = addZipFile(z, "config.yaml", data); err != nil {
+			return err
+		}
+		if err = addZipJSON(z, "rules.json", diagnosticsRules(conf, status)); err != nil {
+			return err
+		}
+	}
+	if err := addZipJSON(z, "logs.json", redactLogs(log.Tail(0, "", log.LogTrace))); err != nil {
+		return err
+	}
+	if err := addZipJSON(z, "stats.json", diagnosticsStats()); err != nil {
+		return err
+	}
+	f, err := createZipFile(z, "goroutines.txt")
+	if err != nil {
+		return err
+	}
+	if err = pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+var urlRex = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s\]"']+`)
+
+// only scheme and host of URLs are kept in log messages, paths and queries may carry tokens
+func redactLogs(entries []log.Entry) []log.Entry {
+	for i, e := range entries {
+		entries[i].Message = urlRex.ReplaceAllStringFunc(e.Message, redactURL)
+	}
+	return entries
+}
+
+func redactURL(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || len(u.Host) == 0 {
+		return config.SecretMask
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if len(u.Path) > 1 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		redacted += "/" + config.SecretMask
+	}
+	return redacted
+}
+
+func createZipFile(z *zip.Writer, name string) (io.Writer, error) {
+	return z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+func addZipFile(z *zip.Writer, name string, data []byte) error {
+	f, err := createZipFile(z, name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func addZipJSON(z *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal %s failed: %v", name, err)
+	}
+	return addZipFile(z, name, data)
+}
+
+func diagnosticsRules(conf *config.Config, status config.ConfigStatus) *DiagnosticsRules {
+	r := &DiagnosticsRules{
+		Hash:      status.Hash,
+		Sections:  status.Sections,
+		AppliedAt: status.AppliedAt,
+		Rules:     make(map[string]int),
+		GeoIPFile: conf.GetGeoIPDBFile(),
+	}
+	for _, v := range conf.Rule {
+		if len(v) > 0 {
+			r.Rules[v[0]]++
+		}
+	}
+	if info, err := os.Stat(r.GeoIPFile); err == nil {
+		r.GeoIPTime = info.ModTime()
+	}
+	return r
+}
+
+func diagnosticsStats() *DiagnosticsStats {
+	s := &DiagnosticsStats{
+		Goroutines: runtime.NumGoroutine(),
+		Status:     make(map[string]int),
+		Servers:    make(map[string]int),
+		Sockets:    connect.GetSocketStats(),
+		Users:      GetUserTraffic(),
+		Tags:       GetTagTraffic(),
+		Mode:       rule.GetConnMode(),
+	}
+	records := GetRecords()
+	s.Records = len(records)
+	for _, r := range records {
+		s.Status[r.Status]++
+		if r.Proxy != nil {
+			s.Servers[r.Proxy.Name]++
+		}
+		s.Up += r.Up
+		s.Down += r.Down
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s.Memory = map[string]uint64{
+		"alloc":       m.Alloc,
+		"sys":         m.Sys,
+		"heap_inuse":  m.HeapInuse,
+		"stack_inuse": m.StackInuse,
+		"num_gc":      uint64(m.NumGC),
+	}
+	return s
+}
+
+// file name of the bundle
+func DiagnosticsFileName() string {
+	return "shuttle-diagnostics-" + time.Now().Format("20060102-150405") + ".zip"
+}
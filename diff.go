@@ -0,0 +1,323 @@
+package shuttle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sipt/shuttle/config"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+	DiffMoved   = "moved"
+)
+
+// a semantic difference between two configs, secrets are masked unless revealed
+type ConfigChange struct {
+	Section string `json:"section"`
+	Key     string `json:"key,omitempty"`
+	Type    string `json:"type"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+	From    int    `json:"from,omitempty"` // position in old list, from 1
+	To      int    `json:"to,omitempty"`   // position in new list, from 1
+	Secret  bool   `json:"secret,omitempty"`
+}
+
+func (c *ConfigChange) String() string {
+	key := "[" + c.Section + "]"
+	if len(c.Key) > 0 {
+		key += " [" + c.Key + "]"
+	}
+	if c.Secret {
+		key += " (credentials)"
+	}
+	switch c.Type {
+	case DiffAdded:
+		if c.To > 0 {
+			return fmt.Sprintf("+ %s %d: %s", key, c.To, c.New)
+		}
+		return fmt.Sprintf("+ %s %s", key, c.New)
+	case DiffRemoved:
+		if c.From > 0 {
+			return fmt.Sprintf("- %s %d: %s", key, c.From, c.Old)
+		}
+		return fmt.Sprintf("- %s %s", key, c.Old)
+	case DiffMoved:
+		return fmt.Sprintf("> %s %d -> %d: %s", key, c.From, c.To, c.New)
+	}
+	return fmt.Sprintf("~ %s %s -> %s", key, c.Old, c.New)
+}
+
+// parse both files and diff them
+func DiffConfigFiles(oldFile, newFile string, reveal bool) ([]*ConfigChange, error) {
+	oldConf, err := parseConfigFile(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newConf, err := parseConfigFile(newFile)
+	if err != nil {
+		return nil, err
+	}
+	return DiffConfig(oldConf, newConf, reveal), nil
+}
+
+// diff config data submitted by others against the applied config file, both are parsed as untrusted:
+// ${NAME} is kept as it is and rule-include is not read, nothing of the host leaks
+func DiffUntrustedConfigData(data []byte, reveal bool) ([]*ConfigChange, error) {
+	file := config.CurrentConfigFile()
+	applied, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed: %v", err)
+	}
+	oldConf, err := config.ParseUntrustedConfig(applied)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %v", file, err)
+	}
+	newConf, err := config.ParseUntrustedConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return DiffConfig(oldConf, newConf, reveal), nil
+}
+
+func parseConfigFile(file string) (*config.Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed: %v", err)
+	}
+	conf, err := config.ParseConfigFile(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %v", file, err)
+	}
+	return conf, nil
+}
+
+// differences of sections in the order of config file
+func DiffConfig(oldConf, newConf *config.Config, reveal bool) []*ConfigChange {
+	d := &configDiff{changes: []*ConfigChange{}, reveal: reveal}
+	d.diffStruct("", reflect.ValueOf(oldConf).Elem(), reflect.ValueOf(newConf).Elem())
+	return d.changes
+}
+
+type configDiff struct {
+	changes []*ConfigChange
+	reveal  bool
+}
+
+func (d *configDiff) add(c *ConfigChange) {
+	d.changes = append(d.changes, c)
+}
+
+// yaml name of the field
+func fieldName(f reflect.StructField) string {
+	tag := f.Tag.Get("yaml")
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) == 0 {
+		return f.Name
+	}
+	return tag
+}
+
+// fields of the top level are sections, fields of a section are keys
+func (d *configDiff) diffStruct(section string, o, n reflect.Value) {
+	t := o.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue // unexported
+		}
+		name := fieldName(f)
+		sec, key := name, ""
+		if len(section) > 0 {
+			sec, key = section, name
+		}
+		ov, nv := o.Field(i), n.Field(i)
+		switch ov.Kind() {
+		case reflect.Ptr:
+			if ov.IsNil() && nv.IsNil() {
+				continue
+			}
+			if ov.IsNil() || nv.IsNil() || len(section) > 0 {
+				if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+					d.add(&ConfigChange{Section: sec, Key: key, Type: DiffChanged, Old: presence(ov), New: presence(nv)})
+				}
+				continue
+			}
+			d.diffStruct(name, ov.Elem(), nv.Elem())
+		case reflect.Map:
+			d.diffMap(sec, ov, nv)
+		case reflect.Slice:
+			switch ov.Type().Elem().Kind() {
+			case reflect.Slice:
+				d.diffList(sec, toLines(ov), toLines(nv))
+			case reflect.String:
+				if o, n := formatValue(ov), formatValue(nv); o != n {
+					d.add(&ConfigChange{Section: sec, Key: key, Type: DiffChanged, Old: o, New: n})
+				}
+			default:
+				if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+					d.add(&ConfigChange{Section: sec, Key: key, Type: DiffChanged,
+						Old: fmt.Sprintf("%d items", ov.Len()), New: fmt.Sprintf("%d items", nv.Len())})
+				}
+			}
+		default:
+			o, n := formatValue(ov), formatValue(nv)
+			if o == n {
+				continue
+			}
+			c := &ConfigChange{Section: sec, Key: key, Type: DiffChanged, Old: o, New: n}
+			if ov.Type() == secretType {
+				c.Old, c.New, c.Secret = d.mask(ov), d.mask(nv), true
+			}
+			d.add(c)
+		}
+	}
+}
+
+func presence(v reflect.Value) string {
+	if v.IsNil() {
+		return "<none>"
+	}
+	return "<set>"
+}
+
+// maps are keyed by name: servers, groups, hosts ...
+func (d *configDiff) diffMap(section string, o, n reflect.Value) {
+	keys := make(map[string]bool)
+	for _, k := range o.MapKeys() {
+		keys[k.String()] = true
+	}
+	for _, k := range n.MapKeys() {
+		keys[k.String()] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		ov := o.MapIndex(reflect.ValueOf(k))
+		nv := n.MapIndex(reflect.ValueOf(k))
+		switch {
+		case !ov.IsValid():
+			d.add(&ConfigChange{Section: section, Key: k, Type: DiffAdded, New: d.mask(nv)})
+		case !nv.IsValid():
+			d.add(&ConfigChange{Section: section, Key: k, Type: DiffRemoved, Old: d.mask(ov)})
+		case !reflect.DeepEqual(ov.Interface(), nv.Interface()):
+			c := &ConfigChange{Section: section, Key: k, Type: DiffChanged, Old: d.mask(ov), New: d.mask(nv)}
+			c.Secret = ov.Type() == secretType || c.Old == c.New
+			d.add(c)
+		}
+	}
+}
+
+// ordered lists: rules, local dns. a line kept in both is moved when it is out of the order of the others
+func (d *configDiff) diffList(section string, o, n []string) {
+	// the k-th occurrence of a line in old matches the k-th one in new
+	positions := make(map[string][]int)
+	for i, v := range o {
+		positions[v] = append(positions[v], i)
+	}
+	matched := make([]int, len(n)) // old index of new lines, -1 if added
+	kept := make([]bool, len(o))
+	for i, v := range n {
+		matched[i] = -1
+		if p := positions[v]; len(p) > 0 {
+			matched[i], positions[v] = p[0], p[1:]
+			kept[p[0]] = true
+		}
+	}
+	for i, v := range o {
+		if !kept[i] {
+			d.add(&ConfigChange{Section: section, Type: DiffRemoved, Old: v, From: i + 1})
+		}
+	}
+	stay := longestIncreasing(matched)
+	for i, v := range n {
+		if matched[i] < 0 {
+			d.add(&ConfigChange{Section: section, Type: DiffAdded, New: v, To: i + 1})
+		} else if !stay[i] {
+			d.add(&ConfigChange{Section: section, Type: DiffMoved, New: v, From: matched[i] + 1, To: i + 1})
+		}
+	}
+}
+
+// marks of the longest increasing subsequence of non-negative values
+func longestIncreasing(values []int) []bool {
+	var (
+		tails []int // index in values of the smallest tail of each length
+		prev  = make([]int, len(values))
+	)
+	for i, v := range values {
+		if v < 0 {
+			continue
+		}
+		j := sort.Search(len(tails), func(k int) bool {
+			return values[tails[k]] >= v
+		})
+		if j > 0 {
+			prev[i] = tails[j-1]
+		} else {
+			prev[i] = -1
+		}
+		if j == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[j] = i
+		}
+	}
+	marks := make([]bool, len(values))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			marks[i] = true
+		}
+	}
+	return marks
+}
+
+func toLines(v reflect.Value) []string {
+	lines := make([]string, v.Len())
+	for i := range lines {
+		lines[i] = formatValue(v.Index(i))
+	}
+	return lines
+}
+
+// ["a", "b", ""] -> "a,b", trailing empty values are dropped
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		values := v.Interface().([]string)
+		for len(values) > 0 && len(values[len(values)-1]) == 0 {
+			values = values[:len(values)-1]
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+var secretType = reflect.TypeOf(config.Secret(""))
+
+// secrets and credentials of servers and templates are masked unless revealed
+func (d *configDiff) mask(v reflect.Value) string {
+	if d.reveal {
+		return formatValue(v)
+	}
+	if v.Type() == secretType {
+		return v.Interface().(config.Secret).String()
+	}
+	if params, ok := v.Interface().([]string); ok && len(params) > 0 {
+		return formatValue(reflect.ValueOf(config.MaskServerParams(params)))
+	}
+	return formatValue(v)
+}
@@ -2,8 +2,13 @@ package dns
 
 import (
 	"container/heap"
-	"github.com/sipt/shuttle/log"
+	"context"
+	"encoding/json"
+	"github.com/sipt/shuttle/util"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +21,7 @@ var dnsCacheManager *CacheManager
 func InitDNSCache() {
 	if dnsCacheManager == nil {
 		dnsCacheManager = NewCacheManager()
+		dnsCacheManager.Run()
 	} else {
 		dnsCacheManager.Clear()
 	}
@@ -23,13 +29,19 @@ func InitDNSCache() {
 
 // resolve domain and through the DNS-Cache
 func ResolveDomainByCache(domain string) (*Answer, error) {
+	return ResolveDomainByCacheContext(context.Background(), domain)
+}
+
+// resolve domain and through the DNS-Cache, given up when [ctx] is done
+func ResolveDomainByCacheContext(ctx context.Context, domain string) (*Answer, error) {
 	if net.ParseIP(domain) != nil {
 		return nil, nil
 	}
+	domain = util.CanonicalHost(domain)
 
 	matched := dnsCacheManager.Range(func(data interface{}) bool {
 		answer := data.(*Answer)
-		if answer.Domain == domain {
+		if answer.Domain == domain && answer.Expires.After(time.Now()) {
 			return true
 		}
 		return false
@@ -37,17 +49,23 @@ func ResolveDomainByCache(domain string) (*Answer, error) {
 	var answer *Answer
 	if matched != nil {
 		answer = matched.(*Answer)
-		log.Logger.Infof("[DNS] [Cache] resolve [%s] -> [%s] [%s]", domain, strings.Join(answer.IPs, ","), answer.Country)
+		dnsLog.Infof("[DNS] [Cache] resolve [%s] -> [%s] [%s]", domain, strings.Join(answer.IPs, ","), answer.Country)
 		return answer, nil
 	}
 	//cache miss
-	answer, err := ResolveDomain(domain)
+	answer, err := ResolveDomainContext(ctx, domain)
 	if err != nil {
 		return nil, err
 	}
 	if answer != nil {
-		dnsCacheManager.Push(answer, CacheTTL)
-		log.Logger.Infof("[DNS] [Cache] resolve [%s] -> [%s] [%s]", domain, strings.Join(answer.IPs, ","), answer.Country)
+		if answer.Type == DNSTypeStatic {
+			answer.TTL = dnsConfig.maxTTL
+		} else {
+			answer.TTL = clampTTL(answer.TTL)
+		}
+		answer.Expires = time.Now().Add(answer.TTL)
+		dnsCacheManager.Push(answer, answer.TTL)
+		dnsLog.Infof("[DNS] [Cache] resolve [%s] -> [%s] [%s]", domain, strings.Join(answer.IPs, ","), answer.Country)
 	}
 	return answer, nil
 }
@@ -56,6 +74,117 @@ func ClearDNSCache() {
 	dnsCacheManager.Clear()
 }
 
+// remove [domain] from DNS-Cache, return the count of removed answers
+func FlushDNSCache(domain string) int {
+	if len(domain) == 0 {
+		n := dnsCacheManager.pool.Len()
+		dnsCacheManager.Clear()
+		return n
+	}
+	domain = util.CanonicalHost(domain)
+	n := 0
+	for dnsCacheManager.Remove(func(data interface{}) bool {
+		return data.(*Answer).Domain == domain
+	}) {
+		n++
+	}
+	return n
+}
+
+// keep at most [n] answers under memory pressure, 0 restores [dns-cache-size]
+func ShrinkDNSCache(n int) {
+	if n <= 0 || (dnsConfig.cacheSize > 0 && dnsConfig.cacheSize < n) {
+		n = dnsConfig.cacheSize
+	}
+	dnsCacheManager.SetMax(n)
+}
+
+// lookup [domain] in DNS-Cache without resolving
+func LookupDNSCache(domain string) *Answer {
+	domain = util.CanonicalHost(domain)
+	matched := dnsCacheManager.Range(func(data interface{}) bool {
+		return data.(*Answer).Domain == domain
+	})
+	if matched == nil {
+		return nil
+	}
+	return matched.(*Answer)
+}
+
+// the domain of an unexpired answer with [ip] in DNS-Cache, e.g. of a connection to an IP which
+// was resolved by shuttle. empty if none
+func ReverseDNSCache(ip string) string {
+	now := time.Now()
+	matched := dnsCacheManager.Range(func(data interface{}) bool {
+		answer := data.(*Answer)
+		if !answer.Expires.After(now) {
+			return false
+		}
+		for _, v := range answer.IPs {
+			if v == ip {
+				return true
+			}
+		}
+		return false
+	})
+	if matched == nil {
+		return ""
+	}
+	return matched.(*Answer).Domain
+}
+
+// save DNS-Cache to dns-cache-file
+func SaveDNSCache() error {
+	if dnsConfig == nil || len(dnsConfig.cacheFile) == 0 || dnsCacheManager == nil {
+		return nil
+	}
+	data, err := json.Marshal(DNSCacheList())
+	if err != nil {
+		return err
+	}
+	// write to a temp file then rename, the old file is kept if interrupted while writing
+	tmp := filepath.Join(filepath.Dir(dnsConfig.cacheFile), "."+filepath.Base(dnsConfig.cacheFile)+".save")
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, dnsConfig.cacheFile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		dnsLog.Errorf("[DNS] [Cache] save to [%s] failed: %v", dnsConfig.cacheFile, err)
+		return err
+	}
+	dnsLog.Infof("[DNS] [Cache] saved to [%s]", dnsConfig.cacheFile)
+	return nil
+}
+
+// load unexpired answers from dns-cache-file
+func LoadDNSCache() error {
+	if len(dnsConfig.cacheFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(dnsConfig.cacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		dnsLog.Errorf("[DNS] [Cache] load from [%s] failed: %v", dnsConfig.cacheFile, err)
+		return nil
+	}
+	list := make([]*Answer, 0, 64)
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		dnsLog.Errorf("[DNS] [Cache] load from [%s] failed: %v", dnsConfig.cacheFile, err)
+		return nil
+	}
+	now := time.Now()
+	for _, v := range list {
+		if v.Expires.After(now) {
+			dnsCacheManager.Push(v, v.Expires.Sub(now))
+		}
+	}
+	dnsLog.Infof("[DNS] [Cache] loaded from [%s]", dnsConfig.cacheFile)
+	return nil
+}
+
 func DNSCacheList() []*Answer {
 	list := make([]*Answer, 0, 64)
 	dnsCacheManager.Range(func(data interface{}) bool {
@@ -146,6 +275,17 @@ func (c *CachePool) Range(f func(data interface{}) (breaked bool)) interface{} {
 	return nil
 }
 
+func (c *CachePool) Index(f func(data interface{}) bool) int {
+	c.RLock()
+	defer c.RUnlock()
+	for i, v := range c.list {
+		if f(v.data) {
+			return i
+		}
+	}
+	return -1
+}
+
 func (c *CachePool) Clear() {
 	c.Lock()
 	defer c.Unlock()
@@ -164,6 +304,17 @@ type CacheManager struct {
 	timer  *time.Timer
 	cancel chan bool
 	pool   *CachePool
+	max    int // guarded by heapLock
+}
+
+// max count of entities, 0: unlimited
+func (c *CacheManager) SetMax(max int) {
+	heapLock.Lock()
+	defer heapLock.Unlock()
+	c.max = max
+	for c.max > 0 && c.pool.Len() > c.max {
+		heap.Pop(c.pool)
+	}
 }
 
 func (c *CacheManager) Range(f func(data interface{}) (breaked bool)) interface{} {
@@ -171,13 +322,29 @@ func (c *CacheManager) Range(f func(data interface{}) (breaked bool)) interface{
 }
 
 func (c *CacheManager) Push(data interface{}, ttl time.Duration) {
-	Push(c.pool, &CacheEntity{
+	heapLock.Lock()
+	if c.max > 0 && c.pool.Len() >= c.max {
+		heap.Pop(c.pool) // drop the earliest expired one
+	}
+	heap.Push(c.pool, &CacheEntity{
 		data:    data,
 		expires: time.Now().Add(ttl),
 	})
+	heapLock.Unlock()
 	c.refresh()
 }
 
+// remove the first data which f returned true
+func (c *CacheManager) Remove(f func(data interface{}) bool) bool {
+	heapLock.Lock()
+	i := c.pool.Index(f)
+	if i >= 0 {
+		heap.Remove(c.pool, i)
+	}
+	heapLock.Unlock()
+	return i >= 0
+}
+
 func (c *CacheManager) Run() {
 	go func() {
 		for {
@@ -209,7 +376,7 @@ func (c *CacheManager) refresh() {
 			wait = entity.expires.Sub(time.Now())
 		}
 	}
-	if wait < -1 {
+	if wait < 0 {
 		wait = 10 * 60 * time.Second
 	}
 	c.timer.Reset(wait)
@@ -41,3 +41,32 @@ func TestCacheManager(t *testing.T) {
 		return false
 	})
 }
+
+func TestCacheManagerMax(t *testing.T) {
+	m := NewCacheManager()
+	m.Run()
+	defer m.Stop()
+	m.SetMax(8)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 200; j++ {
+				m.Push(j, time.Minute)
+			}
+			done <- true
+		}()
+	}
+	go func() {
+		for j := 0; j < 200; j++ {
+			m.SetMax(4 + j%8)
+		}
+		done <- true
+	}()
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	m.SetMax(8)
+	if n := m.pool.Len(); n > 8 {
+		t.Errorf("len: %d, max: 8", n)
+	}
+}
@@ -0,0 +1,30 @@
+package dns
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+var ErrChaosTimeout = errors.New("i/o timeout by chaos")
+
+// rates of timeouts injected into the exchanges by DNS server as written in the config,
+// only set by a build injecting faults
+var (
+	chaosTimeouts = make(map[string]float64)
+	chaosLock     sync.RWMutex
+)
+
+func SetChaosTimeouts(rates map[string]float64) {
+	chaosLock.Lock()
+	chaosTimeouts = rates
+	chaosLock.Unlock()
+}
+
+// the exchange with [u] does not get an answer
+func chaosTimeout(u *Upstream) bool {
+	chaosLock.RLock()
+	rate := chaosTimeouts[u.Raw]
+	chaosLock.RUnlock()
+	return rate > 0 && rand.Float64() < rate
+}
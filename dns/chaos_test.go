@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaosTimeout(t *testing.T) {
+	old := dnsConfig
+	defer func() { dnsConfig = old }()
+	dnsConfig = &DNSConfig{}
+	var upstreams []*Upstream
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := ip
+		server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+			r := &dns.Msg{}
+			r.SetReply(m)
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + a)
+			r.Answer = append(r.Answer, rr)
+			w.WriteMsg(r)
+		})}
+		go server.ActivateAndServe()
+		defer server.Shutdown()
+		u, _ := ParseUpstream(pc.LocalAddr().String())
+		upstreams = append(upstreams, u)
+	}
+	SetChaosTimeouts(map[string]float64{upstreams[0].Raw: 1})
+	defer SetChaosTimeouts(map[string]float64{})
+
+	// the exchange with the server waits for the timeout or the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m := &dns.Msg{}
+	m.SetQuestion("example.com.", dns.TypeA)
+	if _, err := exchangeUpstream(ctx, upstreams[0], m); err != ErrChaosTimeout {
+		t.Errorf("exchange with timeout: %v", err)
+	}
+
+	// the resolver takes the answer of the other server
+	ips, server, _, err := directResolve(context.Background(), upstreams, "example.com")
+	if err != nil || len(ips) != 1 || ips[0] != "2.2.2.2" || server != upstreams[1].Raw {
+		t.Errorf("resolve with timeout: %v %s %v", ips, server, err)
+	}
+}
@@ -1,10 +1,10 @@
 package dns
 
 import (
+	"context"
 	"fmt"
 	"github.com/miekg/dns"
-	"github.com/sipt/shuttle/log"
-	"net"
+	"github.com/sipt/shuttle/util"
 	"strings"
 	"time"
 )
@@ -20,6 +20,8 @@ type Answer struct {
 	Type      string
 	Country   string
 	Duration  time.Duration
+	TTL       time.Duration
+	Expires   time.Time
 }
 
 func (a *Answer) GetIP() string {
@@ -33,25 +35,60 @@ func (a *Answer) GetIP() string {
 
 //resolve domain
 func ResolveDomain(domain string) (answer *Answer, err error) {
+	return ResolveDomainContext(context.Background(), domain)
+}
+
+// resolve domain, given up when [ctx] is done
+func ResolveDomainContext(ctx context.Context, domain string) (answer *Answer, err error) {
+	return resolveDomain(ctx, util.CanonicalHost(domain), 0)
+}
+
+func resolveDomain(ctx context.Context, domain string, depth int) (answer *Answer, err error) {
+	//Hosts
+	if h, ok := dnsConfig.hosts[domain]; ok {
+		if len(h.IPs) > 0 {
+			dnsLog.Debugf("[DNS] [Hosts] [%s] -> %v", domain, h.IPs)
+			answer = &Answer{
+				MatchType: MatchTypeHosts,
+				Domain:    domain,
+				IPs:       h.IPs,
+				Type:      DNSTypeStatic,
+				Country:   GeoLookUp(h.IPs[0]),
+			}
+			return
+		}
+		if depth >= maxHostsDepth {
+			return nil, fmt.Errorf("[DNS] [Hosts] resolve domain [%s] failed: too many aliases", domain)
+		}
+		dnsLog.Debugf("[DNS] [Hosts] [%s] -> [%s]", domain, h.Alias)
+		answer, err = resolveDomain(ctx, h.Alias, depth+1)
+		if answer != nil {
+			a := *answer
+			a.MatchType = MatchTypeHosts
+			a.Domain = domain
+			answer = &a
+		}
+		return
+	}
 LOOP:
 	for _, v := range dnsConfig.localDNS {
 		switch v.MatchType {
 		case MatchTypeDomainSuffix:
 			if strings.HasSuffix(domain, v.Domain) {
-				log.Logger.Debug("[DNS] [Local] ", v.String())
-				answer, err = localResolve(v, domain)
+				dnsLog.Debug("[DNS] [Local] ", v.String())
+				answer, err = localResolve(ctx, v, domain)
 				break LOOP
 			}
 		case MatchTypeDomain:
 			if domain == v.Domain {
-				log.Logger.Debug("[DNS] [Local] ", v.String())
-				answer, err = localResolve(v, domain)
+				dnsLog.Debug("[DNS] [Local] ", v.String())
+				answer, err = localResolve(ctx, v, domain)
 				break LOOP
 			}
 		case MatchTypeDomainKeyword:
-			if strings.Index(domain, v.Domain) >= 0 {
-				log.Logger.Debug("[DNS] [Local] ", v.String())
-				answer, err = localResolve(v, domain)
+			if strings.Contains(domain, v.Domain) || strings.Contains(util.UnicodeHost(domain), v.Domain) {
+				dnsLog.Debug("[DNS] [Local] ", v.String())
+				answer, err = localResolve(ctx, v, domain)
 				break LOOP
 			}
 		}
@@ -65,9 +102,9 @@ LOOP:
 		}
 		start := time.Now()
 		var err error
-		answer.IPs, answer.Server, err = directResolve(dnsConfig.servers, domain)
+		answer.IPs, answer.Server, answer.TTL, err = directResolve(ctx, dnsConfig.servers, domain)
 		if err != nil {
-			log.Logger.Errorf("[DNS] [direct] resolve domain [%s] failed: %s", domain, err.Error())
+			dnsLog.Errorf("[DNS] [direct] resolve domain [%s] failed: %s", domain, err.Error())
 			return nil, err
 		}
 		answer.Duration = time.Now().Sub(start)
@@ -90,7 +127,7 @@ func ResolveIP(ip string) (*Answer, error) {
 	}, nil
 }
 
-func localResolve(d *DNS, domain string) (*Answer, error) {
+func localResolve(ctx context.Context, d *DNS, domain string) (*Answer, error) {
 	answer := &Answer{
 		MatchType: d.MatchType,
 		Domain:    d.Domain,
@@ -106,9 +143,9 @@ func localResolve(d *DNS, domain string) (*Answer, error) {
 		//connect to DNS server
 		start := time.Now()
 		var err error
-		answer.IPs, answer.Server, err = directResolve(d.DNSs, domain)
+		answer.IPs, answer.Server, answer.TTL, err = directResolve(ctx, d.upstreams, domain)
 		if err != nil {
-			log.Logger.Errorf("[DNS] [direct] resolve domain [%s] failed: %s", domain, err.Error())
+			dnsLog.Errorf("[DNS] [direct] resolve domain [%s] failed: %s", domain, err.Error())
 			return nil, err
 		}
 		answer.Duration = time.Now().Sub(start)
@@ -123,52 +160,67 @@ type _Reply struct {
 	Msg  *dns.Msg
 }
 
-func directResolve(servers []string, domain string) ([]string, string, error) {
+func directResolve(ctx context.Context, servers []*Upstream, domain string) ([]string, string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
 	replyChan := make(chan *_Reply, 1)
 	for _, s := range servers {
-		go resolveDomain(s, "53", domain, replyChan)
+		go exchange(ctx, s, domain, replyChan)
 	}
-	timer := time.NewTimer(2 * time.Second)
 	select {
 	case reply := <-replyChan:
 		var (
 			a   *dns.A
 			ok  bool
+			ttl uint32
 			ips = make([]string, 0, len(reply.Msg.Answer))
 		)
 		for _, v := range reply.Msg.Answer {
 			a, ok = v.(*dns.A)
 			if ok {
 				ips = append(ips, a.A.String())
+				if ttl == 0 || a.Hdr.Ttl < ttl {
+					ttl = a.Hdr.Ttl
+				}
 			}
 		}
 		if len(ips) == 0 {
-			return nil, "", fmt.Errorf("resolve domain [%s] is empty", domain)
+			return nil, "", 0, fmt.Errorf("resolve domain [%s] is empty", domain)
 		}
-		return ips, reply.Addr, nil
-	case <-timer.C:
-		log.Logger.Errorf("[DNS] [Local] resolve domain [%s] failed: %s", domain)
-		return nil, "", fmt.Errorf("resolve domain [%s] failed: %s", domain)
+		return ips, reply.Addr, time.Duration(ttl) * time.Second, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return nil, "", 0, fmt.Errorf("resolve domain [%s] failed: %v", domain, ctx.Err())
+		}
+		dnsLog.Errorf("[DNS] [Local] resolve domain [%s] failed: timeout", domain)
+		return nil, "", 0, fmt.Errorf("resolve domain [%s] failed: timeout", domain)
 	}
 }
 
-func resolveDomain(addr, port, domain string, c chan *_Reply) {
+func exchange(ctx context.Context, u *Upstream, domain string, c chan *_Reply) {
+	name := dns.Fqdn(domain)
+	if dnsConfig.case0x20 && !u.Encrypted() {
+		name = randomizeCase(name)
+	}
 	m := &dns.Msg{}
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	m.SetQuestion(name, dns.TypeA)
 	m.RecursionDesired = true
-	r, err := dns.Exchange(m, net.JoinHostPort(addr, port))
+	r, err := exchangeUpstream(ctx, u, m)
+	if ctx.Err() != nil {
+		return // answered by another server, or given up
+	}
 	if err != nil {
-		log.Logger.Errorf("[DNS] [Local] connect [%s:%s] resolve domain [%s] failed: %s",
-			addr, port, domain, err.Error())
+		dnsLog.Errorf("[DNS] [Local] connect [%s] resolve domain [%s] failed: %s",
+			u.Raw, domain, err.Error())
 		return
 	}
 	if r == nil || r.Rcode != dns.RcodeSuccess {
-		log.Logger.Errorf("[DNS] [Local] connect [%s:%s] resolve domain [%s] failed ",
-			addr, port, domain)
+		dnsLog.Errorf("[DNS] [Local] connect [%s] resolve domain [%s] failed ",
+			u.Raw, domain)
 		return
 	}
 	select {
-	case c <- &_Reply{Addr: addr, Msg: r}:
+	case c <- &_Reply{Addr: u.Raw, Msg: r}:
 	default:
 	}
 }
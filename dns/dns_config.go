@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sipt/shuttle/util"
 )
 
 const (
@@ -18,6 +22,9 @@ const (
 	MatchTypeDomain        = "DOMAIN"
 	MatchTypeDomainKeyword = "DOMAIN-KEYWORD"
 	MatchNone              = "NONE"
+	MatchTypeHosts         = "HOSTS"
+
+	maxHostsDepth = 8
 )
 
 type IDNSConfig interface {
@@ -25,6 +32,13 @@ type IDNSConfig interface {
 	SetDNSServers([]string)
 	GetLocalDNS() [][]string
 	SetLocalDNS([][]string)
+	GetHosts() map[string]string
+	SetHosts(map[string]string)
+	GetDNSMinTTL() string
+	GetDNSMaxTTL() string
+	GetDNSCacheFile() string
+	GetDNSCacheSize() string
+	GetDNS0x20() string
 
 	GetControllerDomain() string
 	GetControllerPort() string
@@ -40,6 +54,7 @@ type DNS struct {
 	Port      string
 	Type      string
 	Country   string
+	upstreams []*Upstream
 }
 
 func (d *DNS) String() string {
@@ -62,9 +77,21 @@ func (d *DNS) String() string {
 	return buffer.String()
 }
 
+// static host: IPs or an alias domain
+type Host struct {
+	IPs   []string
+	Alias string
+}
+
 type DNSConfig struct {
-	servers  []string
-	localDNS []*DNS
+	servers   []*Upstream
+	case0x20  bool
+	localDNS  []*DNS
+	hosts     map[string]*Host
+	minTTL    time.Duration
+	maxTTL    time.Duration
+	cacheFile string
+	cacheSize int
 }
 
 var dnsConfig *DNSConfig
@@ -76,15 +103,14 @@ func ApplyConfig(config IDNSConfig) error {
 	if len(servers) == 0 {
 		return errors.New("[DNS] [InitDNS] servers is empty")
 	}
-	dnsConfig.servers = make([]string, len(servers))
-	for i, s := range servers {
-		dnsConfig.servers[i] = s
-		if net.ParseIP(s) == nil {
-			return fmt.Errorf("[DNS] [InitDNS] %s is not a IP address", s)
-		}
+	var err error
+	dnsConfig.servers, err = parseUpstreams(servers)
+	if err != nil {
+		return fmt.Errorf("[DNS] [InitDNS] %v", err)
 	}
+	dnsConfig.case0x20 = config.GetDNS0x20() == "true"
 	//Geo IP
-	err := InitGeoIP(config.GetGeoIPDBFile())
+	err = InitGeoIP(config.GetGeoIPDBFile())
 	if err != nil {
 		return err
 	}
@@ -94,7 +120,7 @@ func ApplyConfig(config IDNSConfig) error {
 	localDNS := make([]*DNS, len(inputs)+1)
 	localDNS[0] = &DNS{
 		MatchType: MatchTypeDomain,
-		Domain:    config.GetControllerDomain(),
+		Domain:    util.CanonicalHost(config.GetControllerDomain()),
 		Type:      DNSTypeStatic,
 		Port:      config.GetControllerPort(),
 		IPs:       []string{"127.0.0.1"},
@@ -107,9 +133,12 @@ func ApplyConfig(config IDNSConfig) error {
 		}
 		localDNS[i] = &DNS{
 			MatchType: v[0],
-			Domain:    v[1],
+			Domain:    util.CanonicalHost(v[1]),
 			Type:      v[2],
 		}
+		if v[0] == MatchTypeDomainKeyword {
+			localDNS[i].Domain = strings.ToLower(v[1])
+		}
 		if v[0] != MatchTypeDomain && v[0] != MatchTypeDomainSuffix && v[0] != MatchTypeDomainKeyword {
 			return fmt.Errorf("resolve config file [host] not support rule type [%v]", v[0])
 		}
@@ -118,12 +147,87 @@ func ApplyConfig(config IDNSConfig) error {
 			localDNS[i].IPs = strings.Split(v[3], ",")
 		case DNSTypeDirect:
 			localDNS[i].DNSs = strings.Split(v[3], ",")
+			localDNS[i].upstreams, err = parseUpstreams(localDNS[i].DNSs)
+			if err != nil {
+				return fmt.Errorf("resolve config file [host] [%s] %v", v[1], err)
+			}
 		case DNSTypeRemote:
 		default:
 			return fmt.Errorf("resolve config file [host] not support DNSType [%s]", v[1])
 		}
 	}
 	dnsConfig.localDNS = localDNS
+
+	//Hosts
+	dnsConfig.hosts = make(map[string]*Host, len(config.GetHosts()))
+	for domain, v := range config.GetHosts() {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			return fmt.Errorf("resolve config file [Hosts] [%s] is empty", domain)
+		}
+		h := &Host{}
+		ips := strings.Split(v, ",")
+		if net.ParseIP(strings.TrimSpace(ips[0])) != nil {
+			for _, ip := range ips {
+				ip = strings.TrimSpace(ip)
+				if net.ParseIP(ip) == nil {
+					return fmt.Errorf("resolve config file [Hosts] [%s] %s is not a IP address", domain, ip)
+				}
+				h.IPs = append(h.IPs, ip)
+			}
+		} else {
+			h.Alias = util.CanonicalHost(v)
+		}
+		dnsConfig.hosts[util.CanonicalHost(domain)] = h
+	}
+
+	//TTL
+	dnsConfig.minTTL, err = ParseTTL(config.GetDNSMinTTL(), 0)
+	if err != nil {
+		return fmt.Errorf("resolve config file [dns-min-ttl] failed: %v", err)
+	}
+	dnsConfig.maxTTL, err = ParseTTL(config.GetDNSMaxTTL(), CacheTTL)
+	if err != nil {
+		return fmt.Errorf("resolve config file [dns-max-ttl] failed: %v", err)
+	}
+	if dnsConfig.minTTL > dnsConfig.maxTTL {
+		return fmt.Errorf("resolve config file dns-min-ttl [%s] is greater than dns-max-ttl [%s]",
+			dnsConfig.minTTL, dnsConfig.maxTTL)
+	}
+	dnsConfig.cacheFile = config.GetDNSCacheFile()
+	if v := config.GetDNSCacheSize(); len(v) > 0 {
+		dnsConfig.cacheSize, err = strconv.Atoi(v)
+		if err != nil || dnsConfig.cacheSize < 0 {
+			return fmt.Errorf("resolve config file [max-dns-cache-entries] [%s] failed", v)
+		}
+	}
+	first := dnsCacheManager == nil
 	InitDNSCache()
+	dnsCacheManager.SetMax(dnsConfig.cacheSize)
+	if first {
+		return LoadDNSCache()
+	}
 	return nil
 }
+
+// parse "600", "10m" to duration, empty means [def]
+func ParseTTL(v string, def time.Duration) (time.Duration, error) {
+	if len(v) == 0 {
+		return def, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// clamp ttl to [dns-min-ttl, dns-max-ttl]
+func clampTTL(ttl time.Duration) time.Duration {
+	if ttl < dnsConfig.minTTL {
+		return dnsConfig.minTTL
+	}
+	if ttl > dnsConfig.maxTTL {
+		return dnsConfig.maxTTL
+	}
+	return ttl
+}
@@ -0,0 +1,17 @@
+package dns
+
+import "testing"
+
+func TestResolveCanonicalHost(t *testing.T) {
+	old := dnsConfig
+	defer func() { dnsConfig = old }()
+	dnsConfig = &DNSConfig{hosts: map[string]*Host{
+		"xn--e1aybc.xn--p1ai": {IPs: []string{"10.0.0.1"}},
+	}}
+	for _, domain := range []string{"тест.рф", "ТЕСТ.РФ.", "xn--e1aybc.xn--p1ai", "XN--E1AYBC.xn--p1ai."} {
+		answer, err := ResolveDomain(domain)
+		if err != nil || answer.GetIP() != "10.0.0.1" {
+			t.Errorf("%s: got %v, %v", domain, answer, err)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package dns
 
 import (
 	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
 	"github.com/sipt/shuttle/assets"
-	"github.com/sipt/shuttle/log"
 	"net"
 )
 
@@ -13,7 +13,7 @@ func InitGeoIP(dbFile string) error {
 	var err error
 	geoipFileBytes, err := assets.ReadFile(dbFile)
 	if err != nil {
-		log.Logger.Errorf("[GeoIP] read failed [%v]", err)
+		geoipLog.Errorf("[GeoIP] read failed [%v]", err)
 		return err
 	}
 	geoipDB, err = geoip2.FromBytes(geoipFileBytes)
@@ -33,13 +33,42 @@ func GeoLookUp(ip string) (countryCode string) {
 	}
 	country, err := geoipDB.Country(netIP)
 	if err == nil && country != nil {
-		log.Logger.Debugf("[GeoIP] lookup [%s] country -> [%s]", ip, country.Country.IsoCode)
+		geoipLog.Debugf("[GeoIP] lookup [%s] country -> [%s]", ip, country.Country.IsoCode)
 		return country.Country.IsoCode
 	}
-	log.Logger.Debugf("[GeoIP] lookup [%s] country failed: %s", ip, err.Error())
+	geoipLog.Debugf("[GeoIP] lookup [%s] country failed: %s", ip, err.Error())
 	return
 }
 
+// ISO codes of all countries in the GeoIP database [dbFile]
+func GeoCountries(dbFile string) (map[string]bool, error) {
+	data, err := assets.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	countries := make(map[string]bool)
+	networks := db.Networks()
+	for networks.Next() {
+		var record struct {
+			Country struct {
+				IsoCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if _, err = networks.Network(&record); err != nil {
+			return nil, err
+		}
+		if len(record.Country.IsoCode) > 0 {
+			countries[record.Country.IsoCode] = true
+		}
+	}
+	return countries, networks.Err()
+}
+
 func CloseGeoDB() error {
 	return geoipDB.Close()
 }
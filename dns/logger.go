@@ -0,0 +1,9 @@
+package dns
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var (
+	dnsLog   = log.Named("dns")
+	geoipLog = log.Named("geoip")
+)
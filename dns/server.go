@@ -0,0 +1,364 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DoHPath = "/dns-query"
+
+	dohMediaType  = "application/dns-message"
+	maxDoHMsgSize = 65535
+	minAnswerTTL  = 1
+	rejectTTL     = 60
+
+	// answer of domains rejected by the rules
+	RejectReal     = "real" // the real answer, the connection is rejected
+	RejectNXDomain = "nxdomain"
+	RejectZero     = "zero" // 0.0.0.0 and ::
+)
+
+// listeners serving shuttle's resolver, for LAN devices and browsers with secure DNS
+type IDNSServerConfig interface {
+	GetDNSListenInterface() string
+	GetDNSListenPort() string
+	GetDoHPort() string
+	GetDoTPort() string
+	GetDNSListenCert() string
+	GetDNSListenKey() string
+	GetDNSListenReject() string
+}
+
+type serverOptions struct {
+	iface   string
+	port    string // plain DNS over UDP and TCP
+	dohPort string
+	dotPort string
+	cert    string
+	key     string
+}
+
+var (
+	serverLock    sync.Mutex
+	serverCurrent serverOptions
+	serverClosers []io.Closer
+
+	serverReject  atomic.Value // string
+	rejectMatcher func(domain string) bool
+)
+
+// [f] tells whether a domain is rejected by the rules, registered by the rule package
+func SetRejectMatcher(f func(domain string) bool) {
+	rejectMatcher = f
+}
+
+func parseReject(v string) (string, error) {
+	switch v {
+	case "":
+		return RejectReal, nil
+	case RejectReal, RejectNXDomain, RejectZero:
+		return v, nil
+	}
+	return "", fmt.Errorf("[reject] not support [%s], support: real, nxdomain, zero", v)
+}
+
+func parseServerOptions(config IDNSServerConfig) (serverOptions, error) {
+	o := serverOptions{
+		iface:   config.GetDNSListenInterface(),
+		port:    config.GetDNSListenPort(),
+		dohPort: config.GetDoHPort(),
+		dotPort: config.GetDoTPort(),
+		cert:    config.GetDNSListenCert(),
+		key:     config.GetDNSListenKey(),
+	}
+	for _, p := range [][]string{{"port", o.port}, {"doh-port", o.dohPort}, {"dot-port", o.dotPort}} {
+		if len(p[1]) == 0 {
+			continue
+		}
+		if n, err := strconv.Atoi(p[1]); err != nil || n <= 0 || n > 65535 {
+			return o, fmt.Errorf("[%s] invalid port [%s]", p[0], p[1])
+		}
+	}
+	if (len(o.dohPort) > 0 || len(o.dotPort) > 0) && (len(o.cert) == 0 || len(o.key) == 0) {
+		return o, fmt.Errorf("[cert] and [key] are required by DoH and DoT")
+	}
+	if _, err := parseReject(config.GetDNSListenReject()); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// validate [DNS-Listen] without listening
+func ValidateServerConfig(config IDNSServerConfig) error {
+	o, err := parseServerOptions(config)
+	if err != nil {
+		return err
+	}
+	if len(o.cert) > 0 || len(o.key) > 0 {
+		if _, err = tls.LoadX509KeyPair(o.cert, o.key); err != nil {
+			return fmt.Errorf("[cert] [key] load failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// (re)start the listeners when [DNS-Listen] changed
+func ApplyServerConfig(config IDNSServerConfig) error {
+	o, err := parseServerOptions(config)
+	if err != nil {
+		return fmt.Errorf("resolve config file [DNS-Listen] %v", err)
+	}
+	reject, _ := parseReject(config.GetDNSListenReject())
+	serverReject.Store(reject)
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if o == serverCurrent && len(serverClosers) > 0 {
+		return nil
+	}
+	shutdownServer()
+	serverCurrent = o
+	if err = startServer(o); err != nil {
+		shutdownServer()
+		return fmt.Errorf("resolve config file [DNS-Listen] %v", err)
+	}
+	return nil
+}
+
+// close all listeners
+func ShutdownServer() {
+	serverLock.Lock()
+	shutdownServer()
+	serverCurrent = serverOptions{}
+	serverLock.Unlock()
+}
+
+func shutdownServer() {
+	for _, c := range serverClosers {
+		c.Close()
+	}
+	serverClosers = nil
+}
+
+func startServer(o serverOptions) error {
+	handler := dns.HandlerFunc(serveDNS)
+	if len(o.port) > 0 {
+		addr := net.JoinHostPort(o.iface, o.port)
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		serverClosers = append(serverClosers, pc)
+		go (&dns.Server{PacketConn: pc, Handler: handler}).ActivateAndServe()
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		serverClosers = append(serverClosers, l)
+		go (&dns.Server{Listener: l, Handler: handler}).ActivateAndServe()
+		dnsLog.Info("Listen to [DNS]: ", addr)
+	}
+	if len(o.dohPort) == 0 && len(o.dotPort) == 0 {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+	if err != nil {
+		return fmt.Errorf("[cert] [key] load failed: %v", err)
+	}
+	if len(o.dotPort) > 0 {
+		addr := net.JoinHostPort(o.iface, o.dotPort)
+		l, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			return err
+		}
+		serverClosers = append(serverClosers, l)
+		go (&dns.Server{Listener: l, Net: "tcp-tls", Handler: handler}).ActivateAndServe()
+		dnsLog.Info("Listen to [DoT]: ", addr)
+	}
+	if len(o.dohPort) > 0 {
+		addr := net.JoinHostPort(o.iface, o.dohPort)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(DoHPath, serveDoH)
+		s := &http.Server{
+			Handler:     mux,
+			TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}},
+			ReadTimeout: 10 * time.Second,
+		}
+		serverClosers = append(serverClosers, s)
+		go s.ServeTLS(l, "", "")
+		dnsLog.Info("Listen to [DoH]: ", addr)
+	}
+	return nil
+}
+
+func serveDNS(w dns.ResponseWriter, m *dns.Msg) {
+	w.WriteMsg(answerMsg(m, w.RemoteAddr().String()))
+}
+
+// RFC 8484: GET ?dns=base64url, POST application/dns-message
+func serveDoH(w http.ResponseWriter, r *http.Request) {
+	var (
+		data []byte
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(r.URL.Query().Get("dns"), "="))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(r.Body, maxDoHMsgSize))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := &dns.Msg{}
+	if err == nil {
+		err = m.Unpack(data)
+	}
+	if err != nil || len(data) == 0 {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+	reply := answerMsg(m, r.RemoteAddr)
+	data, err = reply.Pack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohMediaType)
+	if ttl, ok := minTTL(reply); ok {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl)))
+	}
+	w.Write(data)
+}
+
+// answer a packed DNS message of [client], for resolvers without a listener, e.g. a packet tunnel
+func ServeMessage(data []byte, client string) ([]byte, error) {
+	m := &dns.Msg{}
+	if err := m.Unpack(data); err != nil {
+		return nil, err
+	}
+	return answerMsg(m, client).Pack()
+}
+
+// A: shuttle's resolver (hosts, local DNS, cache), AAAA: empty, shuttle resolves IPv4 only,
+// others and domains resolved by the remote server: forward to [dns-server]
+func answerMsg(m *dns.Msg, client string) *dns.Msg {
+	reply := &dns.Msg{}
+	if len(m.Question) != 1 {
+		return reply.SetRcode(m, dns.RcodeFormatError)
+	}
+	q := m.Question[0]
+	domain := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	dnsLog.Debugf("[DNS] [Server] [%s] query [%s] [%s]", client, domain, dns.TypeToString[q.Qtype])
+	if reject, _ := serverReject.Load().(string); len(reject) > 0 && reject != RejectReal &&
+		rejectMatcher != nil && rejectMatcher(domain) {
+		dnsLog.Debugf("[DNS] [Server] [%s] [%s] rejected, answer [%s]", client, domain, reject)
+		return rejectMsg(m, reject)
+	}
+	if q.Qclass == dns.ClassINET {
+		switch q.Qtype {
+		case dns.TypeA:
+			answer, err := ResolveDomainByCache(domain)
+			if err != nil {
+				return reply.SetRcode(m, dns.RcodeServerFailure)
+			}
+			if answer != nil {
+				reply.SetReply(m)
+				reply.RecursionAvailable = true
+				ttl := answerTTL(answer)
+				for _, ip := range answer.IPs {
+					if ip4 := net.ParseIP(ip).To4(); ip4 != nil {
+						reply.Answer = append(reply.Answer, &dns.A{
+							Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+							A:   ip4,
+						})
+					}
+				}
+				return reply
+			}
+		case dns.TypeAAAA:
+			reply.SetReply(m)
+			reply.RecursionAvailable = true
+			return reply
+		}
+	}
+	return forward(m)
+}
+
+// NXDOMAIN, or 0.0.0.0 and :: so the client fails fast without a connect timeout
+func rejectMsg(m *dns.Msg, reject string) *dns.Msg {
+	reply := &dns.Msg{}
+	if reject == RejectNXDomain {
+		reply.SetRcode(m, dns.RcodeNameError)
+		reply.RecursionAvailable = true
+		return reply
+	}
+	reply.SetReply(m)
+	reply.RecursionAvailable = true
+	q := m.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: rejectTTL}
+	switch q.Qtype {
+	case dns.TypeA:
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: net.IPv4zero.To4()})
+	case dns.TypeAAAA:
+		reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero})
+	}
+	return reply
+}
+
+func forward(m *dns.Msg) *dns.Msg {
+	var lastErr error
+	for _, u := range dnsConfig.servers {
+		r, err := exchangeUpstream(context.Background(), u, m.Copy())
+		if err == nil {
+			r.Id = m.Id
+			return r
+		}
+		lastErr = err
+	}
+	dnsLog.Errorf("[DNS] [Server] forward [%s] failed: %v", m.Question[0].Name, lastErr)
+	return (&dns.Msg{}).SetRcode(m, dns.RcodeServerFailure)
+}
+
+// remaining time in the cache
+func answerTTL(a *Answer) uint32 {
+	ttl := int64(time.Until(a.Expires) / time.Second)
+	if ttl < minAnswerTTL {
+		ttl = minAnswerTTL
+	}
+	return uint32(ttl)
+}
+
+func minTTL(m *dns.Msg) (uint32, bool) {
+	if len(m.Answer) == 0 {
+		return 0, false
+	}
+	ttl := m.Answer[0].Header().Ttl
+	for _, rr := range m.Answer[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl, true
+}
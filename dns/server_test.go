@@ -0,0 +1,28 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnswerRejected(t *testing.T) {
+	old := rejectMatcher
+	defer func() { rejectMatcher = old; serverReject.Store(RejectReal) }()
+	rejectMatcher = func(domain string) bool { return domain == "ads.example.com" }
+
+	m := (&dns.Msg{}).SetQuestion("ads.example.com.", dns.TypeA)
+	serverReject.Store(RejectNXDomain)
+	if r := answerMsg(m, "test"); r.Rcode != dns.RcodeNameError {
+		t.Errorf("nxdomain: got rcode %d", r.Rcode)
+	}
+	serverReject.Store(RejectZero)
+	r := answerMsg(m, "test")
+	if len(r.Answer) != 1 || !r.Answer[0].(*dns.A).A.IsUnspecified() {
+		t.Errorf("zero: got %v", r.Answer)
+	}
+	r = answerMsg((&dns.Msg{}).SetQuestion("ads.example.com.", dns.TypeAAAA), "test")
+	if len(r.Answer) != 1 || !r.Answer[0].(*dns.AAAA).AAAA.IsUnspecified() {
+		t.Errorf("zero AAAA: got %v", r.Answer)
+	}
+}
@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	UpstreamUDP = "udp"
+	UpstreamTLS = "tls"
+
+	exchangeTimeout = 2 * time.Second
+	paddingBlock    = 128 // RFC 8467: pad queries to a multiple of 128 bytes
+	ednsUDPSize     = 4096
+	portWindow      = 16
+	minPortSpread   = 1024
+)
+
+// DNS server: "8.8.8.8", "8.8.8.8:53", "tls://1.1.1.1", "tls://1.1.1.1:853#cloudflare-dns.com"
+type Upstream struct {
+	Raw        string
+	Network    string
+	Addr       string
+	ServerName string
+}
+
+func (u *Upstream) Encrypted() bool {
+	return u.Network == UpstreamTLS
+}
+
+func ParseUpstream(s string) (*Upstream, error) {
+	u := &Upstream{Raw: s, Network: UpstreamUDP}
+	port := "53"
+	if strings.HasPrefix(s, UpstreamTLS+"://") {
+		u.Network, port = UpstreamTLS, "853"
+		s = s[len(UpstreamTLS)+3:]
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			u.ServerName, s = s[i+1:], s[:i]
+		}
+	}
+	host := s
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%s is not a IP address", host)
+	}
+	if len(u.ServerName) == 0 {
+		u.ServerName = host
+	}
+	u.Addr = net.JoinHostPort(host, port)
+	return u, nil
+}
+
+func parseUpstreams(servers []string) ([]*Upstream, error) {
+	list := make([]*Upstream, len(servers))
+	for i, s := range servers {
+		u, err := ParseUpstream(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		list[i] = u
+	}
+	return list, nil
+}
+
+// DNS 0x20: flip the case of letters randomly, a spoofed answer has to guess it
+func randomizeCase(name string) string {
+	bits := make([]byte, len(name))
+	rand.Read(bits)
+	b := []byte(name)
+	for i, c := range b {
+		if bits[i]&1 == 0 {
+			continue
+		}
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if 'A' <= c && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+// EDNS padding (RFC 7830), for encrypted upstreams to hide the length of queries
+func padMsg(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(ednsUDPSize, false)
+		opt = m.IsEdns0()
+	}
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+	data, err := m.Pack()
+	if err != nil {
+		return
+	}
+	if size := len(data); size%paddingBlock != 0 {
+		padding.Padding = make([]byte, paddingBlock-size%paddingBlock)
+	}
+}
+
+// the answer must echo ID and question of the query, or it is spoofed
+func matchReply(m, r *dns.Msg) bool {
+	if r.Id != m.Id || len(r.Question) != 1 {
+		return false
+	}
+	q := r.Question[0]
+	return q.Name == m.Question[0].Name && q.Qtype == m.Question[0].Qtype && q.Qclass == m.Question[0].Qclass
+}
+
+// the exchange is given up when [ctx] is done
+func exchangeUpstream(ctx context.Context, u *Upstream, m *dns.Msg) (*dns.Msg, error) {
+	if chaosTimeout(u) {
+		// like a server not answering, the others are asked meanwhile
+		timer := time.NewTimer(exchangeTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		return nil, ErrChaosTimeout
+	}
+	if u.Encrypted() {
+		padMsg(m)
+		c := &dns.Client{
+			Net:       "tcp-tls",
+			TLSConfig: &tls.Config{ServerName: u.ServerName},
+			Timeout:   exchangeTimeout,
+		}
+		r, _, err := c.ExchangeContext(ctx, m, u.Addr)
+		if err == nil && !matchReply(m, r) {
+			err = fmt.Errorf("answer does not match the query")
+		}
+		return r, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", u.Addr)
+	if err != nil {
+		return nil, err
+	}
+	// nil local address, the source port is chosen by the system
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	sourcePorts.add(conn.LocalAddr().(*net.UDPAddr).Port)
+	co := &dns.Conn{Conn: conn, UDPSize: ednsUDPSize}
+	conn.SetDeadline(time.Now().Add(exchangeTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if err = co.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	for {
+		r, err := co.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if matchReply(m, r) {
+			return r, nil
+		}
+		// drop it and wait for the real one
+		dnsLog.Errorf("[DNS] [%s] answer of [%s] does not match the query, maybe spoofed", u.Raw, m.Question[0].Name)
+	}
+}
+
+// verify the system randomizes source ports of queries, warn once when recent ports are fixed or sequential
+type portChecker struct {
+	sync.Mutex
+	ports  [portWindow]int
+	n      int
+	warned bool
+}
+
+var sourcePorts = &portChecker{}
+
+func (p *portChecker) add(port int) {
+	p.Lock()
+	defer p.Unlock()
+	p.ports[p.n%portWindow] = port
+	p.n++
+	if p.warned || p.n < portWindow {
+		return
+	}
+	min, max := p.ports[0], p.ports[0]
+	for _, v := range p.ports {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max-min < minPortSpread {
+		p.warned = true
+		dnsLog.Errorf("[DNS] source ports of the last %d queries are in [%d, %d], not randomized, answers are easier to spoof",
+			portWindow, min, max)
+	}
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseUpstream(t *testing.T) {
+	cases := []struct {
+		input, network, addr, serverName string
+	}{
+		{"8.8.8.8", UpstreamUDP, "8.8.8.8:53", "8.8.8.8"},
+		{"8.8.8.8:5353", UpstreamUDP, "8.8.8.8:5353", "8.8.8.8"},
+		{"tls://1.1.1.1", UpstreamTLS, "1.1.1.1:853", "1.1.1.1"},
+		{"tls://1.1.1.1:8853#cloudflare-dns.com", UpstreamTLS, "1.1.1.1:8853", "cloudflare-dns.com"},
+	}
+	for _, c := range cases {
+		u, err := ParseUpstream(c.input)
+		if err != nil {
+			t.Fatalf("%s: %v", c.input, err)
+		}
+		if u.Network != c.network || u.Addr != c.addr || u.ServerName != c.serverName {
+			t.Errorf("%s: got %+v", c.input, u)
+		}
+	}
+	if _, err := ParseUpstream("dns.google"); err == nil {
+		t.Errorf("domain should be rejected")
+	}
+}
+
+func TestCase0x20(t *testing.T) {
+	name := "www.example-domain.com."
+	m := &dns.Msg{}
+	m.SetQuestion(randomizeCase(name), dns.TypeA)
+	if !strings.EqualFold(m.Question[0].Name, name) {
+		t.Fatalf("randomized name %s is not %s", m.Question[0].Name, name)
+	}
+	r := &dns.Msg{}
+	r.SetReply(m)
+	if !matchReply(m, r) {
+		t.Errorf("reply should match")
+	}
+	r.Question[0].Name = strings.ToLower(name)
+	if m.Question[0].Name != r.Question[0].Name && matchReply(m, r) {
+		t.Errorf("reply with other case should not match")
+	}
+}
+
+func TestPadMsg(t *testing.T) {
+	for _, name := range []string{"a.com.", "www.example.com.", strings.Repeat("a", 60) + ".example.com."} {
+		m := &dns.Msg{}
+		m.SetQuestion(name, dns.TypeA)
+		padMsg(m)
+		data, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data)%paddingBlock != 0 {
+			t.Errorf("%s: packed length %d", name, len(data))
+		}
+	}
+}
+
+func TestExchangeUpstream(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower := false
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		r := &dns.Msg{}
+		r.SetReply(m)
+		if lower {
+			r.Question[0].Name = strings.ToLower(r.Question[0].Name)
+		}
+		a, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 1.2.3.4")
+		r.Answer = append(r.Answer, a)
+		w.WriteMsg(r)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	u, _ := ParseUpstream(pc.LocalAddr().String())
+	m := &dns.Msg{}
+	m.SetQuestion("WwW.ExAmPlE.CoM.", dns.TypeA)
+	r, err := exchangeUpstream(context.Background(), u, m)
+	if err != nil || len(r.Answer) != 1 {
+		t.Fatalf("exchange failed: %v %v", r, err)
+	}
+	lower = true
+	m.Id = dns.Id()
+	if _, err = exchangeUpstream(context.Background(), u, m); err == nil {
+		t.Errorf("answer with other case should be dropped")
+	}
+}
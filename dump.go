@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"sync"
 	"io"
-	"github.com/sipt/shuttle/log"
 )
 
 var dump IDump
@@ -69,12 +68,12 @@ type fileDumpEntity struct {
 func (f *FileDump) InitDump(id int64) error {
 	reqBuf, err := os.OpenFile(fmt.Sprintf(DumpRequestFile, id), os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		log.Logger.Errorf("[%d] create data file %s failed: %v", id, err)
+		shuttleLog.Errorf("[%d] create data file %s failed: %v", id, err)
 		return err
 	}
 	respBuf, err := os.OpenFile(fmt.Sprintf(DumpResponseFile, id), os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		log.Logger.Errorf("[%d] create data file %s failed: %v", id, err)
+		shuttleLog.Errorf("[%d] create data file %s failed: %v", id, err)
 		return err
 	}
 	sequenceHeap := NewSequenceHeap()
@@ -202,13 +201,13 @@ func (f *FileDump) Clear() error {
 	if !os.IsNotExist(err) {
 		err := os.RemoveAll("temp")
 		if err != nil {
-			log.Logger.Errorf("delete dir error: %v", err)
+			shuttleLog.Errorf("delete dir error: %v", err)
 			return err
 		}
 	}
 	err = os.Mkdir("temp", os.ModePerm)
 	if err != nil {
-		log.Logger.Errorf("mkdir failed![%v]\n", err)
+		shuttleLog.Errorf("mkdir failed![%v]\n", err)
 		return err
 	}
 	f.Unlock()
@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/config"
+	. "github.com/sipt/shuttle/constant"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+
+	_ "github.com/sipt/shuttle/ciphers"
+	_ "github.com/sipt/shuttle/proxy/protocol"
+	_ "github.com/sipt/shuttle/proxy/selector"
+	_ "github.com/sipt/shuttle/proxy/transport"
+)
+
+// the engine of shuttle without the process around it (flags, signals, system proxy),
+// run by the command or embedded by an app, e.g. a network extension:
+// InitRuntime -> LoadConfig -> SuperviseModules, then Shutdown
+
+// validate and apply the config file, servers, rules and the others take effect at once,
+// inbounds are restarted by ReloadConfig when their addresses changed
+func LoadConfig(configPath string) (conf *config.Config, err error) {
+	//validate before apply, keep the running config when it's broken
+	errs, err := shuttle.ValidateConfigFile(configPath)
+	if err != nil {
+		return
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			configLog.Errorf("[CONF] %s", e.Error())
+		}
+		return nil, errs[0]
+	}
+	//init Config
+	conf, err = config.ReadConfig(configPath)
+	if err != nil {
+		return
+	}
+	//check Assertions by the candidate, a failure keeps the running config.
+	//at start nothing runs, DNS is not applied yet: they are checked after applied
+	running := config.CurrentConfig() != nil
+	if running {
+		if err = shuttle.CheckCandidateAssertions(conf); err != nil {
+			return nil, err
+		}
+	}
+	config.SetCurrentConfig(conf, configPath)
+	//new connections wait until applied
+	shuttle.BeginReload()
+	defer shuttle.EndReload()
+	//init Config Value
+	shuttle.InitConfigValue(conf)
+	//init DNS & GeoIP
+	if err = dns.ApplyConfig(conf); err != nil {
+		return
+	}
+	//init Logger
+	if err = log.ApplyConfig(conf); err != nil {
+		return
+	}
+	//init Proxy & ProxyGroup
+	if err = proxy.ApplyConfig(conf); err != nil {
+		return
+	}
+	//init Rule
+	if err = rule.ApplyConfig(conf); err != nil {
+		return
+	}
+	//init HttpMap
+	if err = shuttle.ApplyHTTPModifyConfig(conf); err != nil {
+		return
+	}
+	//init MITM
+	if err = shuttle.ApplyMITMConfig(conf); err != nil {
+		return
+	}
+	//init TLS Verify
+	if err = shuttle.ApplyTLSVerifyConfig(conf); err != nil {
+		return
+	}
+	//init Sniffing
+	if err = shuttle.ApplySniffConfig(conf); err != nil {
+		return
+	}
+	//init SNI Router
+	if err = shuttle.ApplySNIConfig(conf); err != nil {
+		return
+	}
+	//init Inbound Users
+	if err = shuttle.ApplyAuthConfig(conf); err != nil {
+		return
+	}
+	//init Limits
+	if err = shuttle.ApplyLimitConfig(conf); err != nil {
+		return
+	}
+	//init Reload
+	if err = shuttle.ApplyReloadConfig(conf); err != nil {
+		return
+	}
+	//init Socket Stats
+	if err = shuttle.ApplySocketStatsConfig(conf); err != nil {
+		return
+	}
+	//init Self Policy
+	if err = shuttle.ApplySelfPolicyConfig(conf); err != nil {
+		return
+	}
+	//init Idle Unload
+	if err = shuttle.ApplyIdleUnloadConfig(conf); err != nil {
+		return
+	}
+	//init Mirror
+	if err = shuttle.ApplyMirrorConfig(conf); err != nil {
+		return
+	}
+	//init Hedge
+	if err = shuttle.ApplyHedgeConfig(conf); err != nil {
+		return
+	}
+	//init Resume
+	if err = shuttle.ApplyResumeConfig(conf); err != nil {
+		return
+	}
+	//init UDP
+	if err = shuttle.ApplyUDPConfig(conf); err != nil {
+		return
+	}
+	//init Multicast Relay
+	if err = shuttle.ApplyMulticastRelayConfig(conf); err != nil {
+		return
+	}
+	//init DNS Server
+	if err = dns.ApplyServerConfig(conf); err != nil {
+		return
+	}
+	//init Route Export
+	if err = shuttle.ApplyRouteExportConfig(conf); err != nil {
+		return
+	}
+	//init Flow Export
+	if err = shuttle.ApplyFlowExportConfig(conf); err != nil {
+		return
+	}
+	//init Load Shedding
+	if err = shuttle.ApplyLoadSheddingConfig(conf); err != nil {
+		return
+	}
+	//init Exit Check
+	if err = shuttle.ApplyExitCheckConfig(conf); err != nil {
+		return
+	}
+	//restore Runtime
+	restoreRuntime()
+	//check Assertions
+	if !running {
+		if err = shuttle.CheckAssertions(conf); err != nil {
+			return
+		}
+	}
+	config.SetApplied(conf)
+	return
+}
+
+// apply only what dials through the policies: dns, servers and groups, rules and the exit check.
+// Nothing listens or exports, so a command can run beside the daemon of the same config, e.g. exits
+func LoadDialConfig(configPath string) (conf *config.Config, err error) {
+	errs, err := shuttle.ValidateConfigFile(configPath)
+	if err != nil {
+		return
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if conf, err = config.LoadConfig(configPath); err != nil {
+		return
+	}
+	if err = dns.ApplyConfig(conf); err != nil {
+		return
+	}
+	if err = proxy.ApplyConfig(conf); err != nil {
+		return
+	}
+	if err = rule.ApplyConfig(conf); err != nil {
+		return
+	}
+	if err = shuttle.ApplyExitCheckConfig(conf); err != nil {
+		return
+	}
+	return
+}
+
+// apply the config file again, inbounds and the controller are restarted when their addresses changed
+func ReloadConfig(configPath string) (conf *config.Config, err error) {
+	oldConf := config.CurrentConfig()
+	conf, err = LoadConfig(configPath)
+	if err != nil {
+		return
+	}
+	// controller
+	if oldConf.GetControllerInterface() != conf.GetControllerInterface() ||
+		oldConf.GetControllerPort() != conf.GetControllerPort() {
+		//restart controller
+		shuttle.RestartModule(ModuleController)
+	}
+
+	// http proxy
+	if oldConf.GetHTTPInterface() != conf.GetHTTPInterface() ||
+		oldConf.GetHTTPPort() != conf.GetHTTPPort() {
+		//restart http proxy
+		shuttle.RestartModule(ModuleHTTP)
+	}
+
+	// socks5 proxy
+	if oldConf.GetSOCKSInterface() != conf.GetSOCKSInterface() ||
+		oldConf.GetSOCKSPort() != conf.GetSOCKSPort() {
+		//restart socks proxy
+		shuttle.RestartModule(ModuleSOCKS)
+	}
+
+	// sni router
+	if oldConf.GetSNIInterface() != conf.GetSNIInterface() ||
+		oldConf.GetSNIPort() != conf.GetSNIPort() {
+		shuttle.RestartModule(ModuleSNI)
+	}
+	return
+}
+
+// handle events of the controller that don't need the process, false for the others (shutdown, upgrade)
+func HandleEvent(e *EventObj) (bool, error) {
+	switch e.Type {
+	case EventReloadConfig.Type:
+		file := config.CurrentConfigFile()
+		if f, ok := e.GetData().(string); ok && len(f) > 0 {
+			file = f // promoted candidate
+		}
+		_, err := ReloadConfig(file)
+		return true, err
+	case EventRestartHttpProxy.Type:
+		return true, shuttle.RestartModule(ModuleHTTP)
+	case EventRestartSocksProxy.Type:
+		return true, shuttle.RestartModule(ModuleSOCKS)
+	case EventRestartController.Type:
+		return true, shuttle.RestartModule(ModuleController)
+	}
+	return false, nil
+}
+
+// stop the modules and the DNS server, save the DNS cache
+func Shutdown() {
+	shuttle.StopModules()
+	dns.ShutdownServer()
+	dns.SaveDNSCache()
+	log.Logger.Close()
+	dns.CloseGeoDB()
+}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"net"
+	"runtime/debug"
+
+	"github.com/sipt/shuttle"
+)
+
+//SOCKS5 Proxy
+type ISOCKSProxyConfig interface {
+	GetSOCKSInterface() string
+	SetSOCKSInterface(string)
+	GetSOCKSPort() string
+	SetSOCKSPort(string)
+}
+
+func HandleSocks5(config ISOCKSProxyConfig, stop chan struct{}, ready func()) error {
+	addr := net.JoinHostPort(config.GetSOCKSInterface(), config.GetSOCKSPort())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	shuttleLog.Info("Listen to [SOCKS]: ", addr)
+	ready()
+	go func() {
+		<-stop
+		listener.Close()
+		shuttleLog.Infof("close socks listener!")
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				shuttleLog.Info("Stopped SOCKS Proxy goroutine...")
+				return nil
+			default:
+			}
+			shuttleLog.Error(err)
+			continue
+		}
+		if !shuttle.AcquireConn() {
+			socksLog.Errorf("[SOCKS] max-connections reached, close connection from [%s]", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer shuttle.ReleaseConn()
+			defer func() {
+				if err := recover(); err != nil {
+					httpLog.Errorf("[HTTP/HTTPS]panic :%v", err)
+					httpLog.Errorf("[HTTP/HTTPS]stack :%s", debug.Stack())
+					conn.Close()
+				}
+			}()
+			socksLog.Debug("[SOCKS]Accept tcp connection")
+			shuttle.SocksHandle(conn)
+		}()
+	}
+}
+
+//SNI Router
+type ISNIRouterConfig interface {
+	GetSNIInterface() string
+	GetSNIPort() string
+}
+
+func HandleSNI(config ISNIRouterConfig, stop chan struct{}, ready func()) error {
+	addr := net.JoinHostPort(config.GetSNIInterface(), config.GetSNIPort())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	shuttleLog.Info("Listen to [SNI]: ", addr)
+	ready()
+	go func() {
+		<-stop
+		listener.Close()
+		shuttleLog.Infof("close SNI listener!")
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				shuttleLog.Info("Stopped SNI Router goroutine...")
+				return nil
+			default:
+			}
+			shuttleLog.Error(err)
+			continue
+		}
+		if !shuttle.AcquireConn() {
+			sniLog.Errorf("[SNI] max-connections reached, close connection from [%s]", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer shuttle.ReleaseConn()
+			defer func() {
+				if err := recover(); err != nil {
+					sniLog.Errorf("[SNI]panic :%v", err)
+					sniLog.Errorf("[SNI]stack :%s", debug.Stack())
+					conn.Close()
+				}
+			}()
+			sniLog.Debug("[SNI]Accept tcp connection")
+			shuttle.SNIHandle(conn)
+		}()
+	}
+}
+
+//HTTP Proxy
+type IHTTPProxyConfig interface {
+	GetHTTPInterface() string
+	SetHTTPInterface(string)
+	GetHTTPPort() string
+	SetHTTPPort(string)
+}
+
+func HandleHTTP(config IHTTPProxyConfig, stop chan struct{}, ready func()) error {
+	addr := net.JoinHostPort(config.GetHTTPInterface(), config.GetHTTPPort())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	shuttleLog.Info("Listen to [HTTP/HTTPS]: ", addr)
+	ready()
+	go func() {
+		<-stop
+		listener.Close()
+		shuttleLog.Infof("close HTTP/HTTPS listener!")
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				shuttleLog.Info("Stopped HTTP/HTTPS Proxy goroutine...")
+				return nil
+			default:
+			}
+			shuttleLog.Error(err)
+			continue
+		}
+		if !shuttle.AcquireConn() {
+			httpLog.Errorf("[HTTP/HTTPS] max-connections reached, close connection from [%s]", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer shuttle.ReleaseConn()
+			defer func() {
+				conn.Close()
+				if err := recover(); err != nil {
+					httpLog.Errorf("[HTTP/HTTPS]panic :%v", err)
+					httpLog.Errorf("[HTTP/HTTPS]stack :%s", debug.Stack())
+				}
+			}()
+			httpLog.Debug("[HTTP/HTTPS]Accept tcp connection")
+			shuttle.HandleHTTP(conn)
+		}()
+	}
+}
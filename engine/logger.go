@@ -0,0 +1,13 @@
+package engine
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var (
+	configLog  = log.Named("config")
+	httpLog    = log.Named("http")
+	runtimeLog = log.Named("runtime")
+	shuttleLog = log.Named("shuttle")
+	sniLog     = log.Named("sni")
+	socksLog   = log.Named("socks")
+)
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/config"
+	. "github.com/sipt/shuttle/constant"
+	"github.com/sipt/shuttle/controller"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const (
+	ModuleDNS        = "dns"
+	ModuleServers    = "servers"
+	ModuleGroups     = "groups"
+	ModuleRules      = "rules"
+	ModuleHTTP       = "http"
+	ModuleSOCKS      = "socks"
+	ModuleSNI        = "sni"
+	ModuleController = "controller"
+)
+
+// dns -> servers -> groups -> rules -> inbounds, the controller is independent.
+// dns, servers, groups and rules are state applied by LoadConfig, not goroutines: they only order
+// the start and report their health. Inbounds and the controller are restarted alone when they crash.
+// [events] of the controller are handled by the caller, see HandleEvent
+func SuperviseModules(events chan *EventObj) error {
+	ms := []*shuttle.Module{
+		shuttle.StaticModule(ModuleDNS, nil),
+		shuttle.StaticModule(ModuleServers, checkServers, ModuleDNS),
+		shuttle.StaticModule(ModuleGroups, checkGroups, ModuleServers),
+		shuttle.StaticModule(ModuleRules, checkRules, ModuleGroups),
+		{
+			Name: ModuleController,
+			Run: func(stop chan struct{}, ready func()) error {
+				return runController(events, stop, ready)
+			},
+		},
+		{
+			Name:    ModuleHTTP,
+			Depends: []string{ModuleRules},
+			Run: func(stop chan struct{}, ready func()) error {
+				return HandleHTTP(config.CurrentConfig(), stop, ready)
+			},
+		},
+		{
+			Name:    ModuleSOCKS,
+			Depends: []string{ModuleRules},
+			Run: func(stop chan struct{}, ready func()) error {
+				return HandleSocks5(config.CurrentConfig(), stop, ready)
+			},
+		},
+		{
+			Name:    ModuleSNI,
+			Depends: []string{ModuleRules},
+			Run: func(stop chan struct{}, ready func()) error {
+				conf := config.CurrentConfig()
+				if len(conf.GetSNIPort()) == 0 {
+					// off
+					ready()
+					<-stop
+					return nil
+				}
+				return HandleSNI(conf, stop, ready)
+			},
+		},
+	}
+	for _, m := range ms {
+		if err := shuttle.Supervise(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runController(events chan *EventObj, stop chan struct{}, ready func()) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- controller.StartController(config.CurrentConfig(), events, ready)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-stop:
+		controller.ShutdownController()
+		return nil
+	}
+}
+
+func checkServers() error {
+	if _, err := proxy.GetServer(rule.PolicyDirect); err != nil {
+		return err
+	}
+	return nil
+}
+
+// every group has a server to select
+func checkGroups() error {
+	for _, g := range proxy.GetGroups() {
+		if _, err := g.GetServer(); err != nil {
+			return fmt.Errorf("group [%s]: %v", g.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// the rules of an idle profile are not rebuilt for the check
+func checkRules() error {
+	if rule.Unloaded() {
+		return nil
+	}
+	if len(rule.Rules()) == 0 {
+		return errors.New("no rules")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const RuntimeFile = "runtime.json"
+
+// load runtime file beside the config file, and follow its changes
+func InitRuntime(configPath string) error {
+	err := config.InitRuntime(filepath.Join(filepath.Dir(configPath), RuntimeFile))
+	if err != nil {
+		return err
+	}
+	modes, _ := config.CurrentRuntime().Watch(config.RuntimeKey(config.RuntimeNamespaceGeneral, config.RuntimeKeyMode))
+	groups, _ := config.CurrentRuntime().Watch(config.RuntimeNamespaceGroup + ".")
+	go func() {
+		for {
+			select {
+			case e := <-modes:
+				if !e.Deleted && e.Value != rule.GetConnMode() {
+					applyRuntimeMode(e.Value)
+				}
+			case e := <-groups:
+				if !e.Deleted {
+					applyRuntimeGroup(strings.TrimPrefix(e.Key, config.RuntimeNamespaceGroup+"."), e.Value)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// restore mode and group selections, after config applied
+func restoreRuntime() {
+	r := config.CurrentRuntime()
+	if mode := r.GetString(config.RuntimeKey(config.RuntimeNamespaceGeneral, config.RuntimeKeyMode), ""); len(mode) > 0 {
+		applyRuntimeMode(mode)
+	}
+	for group, server := range r.List(config.RuntimeNamespaceGroup) {
+		applyRuntimeGroup(group, server)
+	}
+	for group, server := range r.List(config.RuntimeNamespacePin) {
+		if err := proxy.PinServer(group, server); err != nil {
+			runtimeLog.Errorf("[Runtime] restore pin of group [%s] failed: %v", group, err)
+		}
+	}
+	for group, list := range r.List(config.RuntimeNamespaceExclude) {
+		var servers []string
+		if err := json.Unmarshal([]byte(list), &servers); err != nil {
+			runtimeLog.Errorf("[Runtime] restore excluded servers of group [%s] failed: %v", group, err)
+			continue
+		}
+		for _, server := range servers {
+			if err := proxy.ExcludeServer(group, server, true); err != nil {
+				runtimeLog.Errorf("[Runtime] restore excluded servers of group [%s] failed: %v", group, err)
+			}
+		}
+	}
+}
+
+func applyRuntimeMode(mode string) {
+	if err := rule.SetConnMode(mode); err != nil {
+		runtimeLog.Errorf("[Runtime] restore mode [%s] failed: %v", mode, err)
+	}
+}
+
+func applyRuntimeGroup(group, server string) {
+	g, ok := proxy.GroupExist(group)
+	if !ok || g.Selector == nil {
+		return
+	}
+	if c := g.Selector.Current(); c != nil && c.GetName() == server {
+		return
+	}
+	if err := proxy.SelectServer(group, server); err != nil {
+		runtimeLog.Errorf("[Runtime] restore group [%s] server [%s] failed: %v", group, server, err)
+	}
+}
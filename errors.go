@@ -6,6 +6,8 @@ var (
 	ErrorReadTimeOut  = errors.New("read time out")
 	ErrorWriteTimeOut = errors.New("write time out")
 	ErrorReject       = errors.New("connection reject")
+	ErrorCanceled     = errors.New("closed by the client")
+	ErrorUDPOff       = errors.New("udp is off by the policy")
 
 	ErrorServerNotFound = errors.New("server or server group not found")
 
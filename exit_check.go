@@ -0,0 +1,269 @@
+package shuttle
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	DefaultExitIPURL   = "https://ipinfo.io/json"
+	DefaultExitTimeout = 10 * time.Second
+
+	exitLeakID      = "{id}"
+	exitMaxBody     = 64 * 1024
+	exitLeakSettled = time.Second // resolvers seen by the leak endpoint
+)
+
+var (
+	// IPv4, or IPv6 with at least two colons, checked by net.ParseIP
+	exitIPRex = regexp.MustCompile(`[0-9]{1,3}(\.[0-9]{1,3}){3}|[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}`)
+	// location fields of "what is my IP" JSON: ipinfo.io, ip-api.com, ipapi.co...
+	exitLocationKeys = []string{"country", "country_code", "countryCode", "country_name", "region", "regionName",
+		"city", "org", "isp", "as", "asn"}
+)
+
+type IExitCheckConfig interface {
+	GetExitCheck() *config.ExitCheck
+}
+
+// through every policy: the exit IP by [ip-url], then a random name of [dns-leak-domain]
+// is dialed as a request and [dns-leak-url] tells the resolvers which asked for it
+type exitCheck struct {
+	ipURL      string
+	leakDomain string // with {id}
+	leakURL    string // with {id}
+	policies   []string
+	timeout    time.Duration
+}
+
+type ExitResolver struct {
+	IP      string `json:"ip"`
+	Country string `json:"country"`
+}
+
+type ExitReport struct {
+	Policy    string            `json:"policy"`
+	Server    string            `json:"server"` // selected
+	IP        string            `json:"ip"`
+	Country   string            `json:"country"` // of GeoIP database
+	Location  map[string]string `json:"location,omitempty"`
+	Resolvers []*ExitResolver   `json:"resolvers,omitempty"`
+	// resolvers out of the country of the exit IP
+	DNSLeak  bool          `json:"dns_leak"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+var (
+	currentExitCheck = &exitCheck{ipURL: DefaultExitIPURL, timeout: DefaultExitTimeout}
+	exitCheckLock    sync.RWMutex
+)
+
+func ApplyExitCheckConfig(config IExitCheckConfig) error {
+	c, err := parseExitCheck(config.GetExitCheck())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Exit-Check] %v", err)
+	}
+	exitCheckLock.Lock()
+	currentExitCheck = c
+	exitCheckLock.Unlock()
+	return nil
+}
+
+func parseExitCheck(c *config.ExitCheck) (*exitCheck, error) {
+	e := &exitCheck{ipURL: DefaultExitIPURL, timeout: DefaultExitTimeout}
+	if c == nil {
+		return e, nil
+	}
+	if len(c.IPURL) > 0 {
+		if !strings.HasPrefix(c.IPURL, "http://") && !strings.HasPrefix(c.IPURL, "https://") {
+			return nil, fmt.Errorf("[ip-url] invalid [%s], http or https URL", c.IPURL)
+		}
+		e.ipURL = c.IPURL
+	}
+	if (len(c.LeakDomain) == 0) != (len(c.LeakURL) == 0) {
+		return nil, fmt.Errorf("[dns-leak-domain] and [dns-leak-url] must be set together")
+	}
+	if len(c.LeakDomain) > 0 {
+		if !strings.Contains(c.LeakDomain, exitLeakID) || !strings.Contains(c.LeakURL, exitLeakID) {
+			return nil, fmt.Errorf("[dns-leak-domain] and [dns-leak-url] must contain %s", exitLeakID)
+		}
+		if !strings.HasPrefix(c.LeakURL, "http://") && !strings.HasPrefix(c.LeakURL, "https://") {
+			return nil, fmt.Errorf("[dns-leak-url] invalid [%s], http or https URL", c.LeakURL)
+		}
+		e.leakDomain, e.leakURL = c.LeakDomain, c.LeakURL
+	}
+	e.policies = c.Policies
+	if len(c.Timeout) > 0 {
+		var err error
+		if e.timeout, err = time.ParseDuration(c.Timeout); err != nil || e.timeout <= 0 {
+			return nil, fmt.Errorf("[timeout] invalid [%s]", c.Timeout)
+		}
+	}
+	return e, nil
+}
+
+// [policies] of the request, default: [policies] of config, or DIRECT and every group
+func CheckExits(policies []string) []*ExitReport {
+	exitCheckLock.RLock()
+	c := currentExitCheck
+	exitCheckLock.RUnlock()
+	if len(policies) == 0 {
+		policies = c.policies
+	}
+	if len(policies) == 0 {
+		policies = []string{proxy.ProxyDirect}
+		for _, g := range proxy.GetGroups() {
+			policies = append(policies, g.Name)
+		}
+	}
+	reports := make([]*ExitReport, len(policies))
+	wg := &sync.WaitGroup{}
+	for i, p := range policies {
+		wg.Add(1)
+		go func(i int, policy string) {
+			defer wg.Done()
+			reports[i] = c.check(policy)
+		}(i, p)
+	}
+	wg.Wait()
+	return reports
+}
+
+func (c *exitCheck) check(policy string) *ExitReport {
+	r := &ExitReport{Policy: policy}
+	start := time.Now()
+	defer func() { r.Duration = time.Now().Sub(start) }()
+	client := c.client(policy, r)
+
+	body, err := exitGet(client, c.ipURL)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.IP, r.Location = parseExitIP(body)
+	if len(r.IP) == 0 {
+		r.Error = fmt.Sprintf("no IP in the response of [%s]", c.ipURL)
+		return r
+	}
+	r.Country = dns.GeoLookUp(r.IP)
+	if len(c.leakDomain) == 0 {
+		return r
+	}
+
+	id := exitLeakToken()
+	// resolved as every request: locally for rules, then by the server
+	exitGet(client, "http://"+strings.Replace(c.leakDomain, exitLeakID, id, -1)+"/")
+	time.Sleep(exitLeakSettled)
+	body, err = exitGet(client, strings.Replace(c.leakURL, exitLeakID, id, -1))
+	if err != nil {
+		r.Error = "dns leak: " + err.Error()
+		return r
+	}
+	seen := make(map[string]bool)
+	for _, v := range exitIPRex.FindAllString(string(body), -1) {
+		ip := net.ParseIP(v)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		resolver := &ExitResolver{IP: ip.String(), Country: dns.GeoLookUp(ip.String())}
+		r.Resolvers = append(r.Resolvers, resolver)
+		if len(r.Country) > 0 && len(resolver.Country) > 0 && resolver.Country != r.Country {
+			r.DNSLeak = true
+		}
+	}
+	return r
+}
+
+// requests dialed through [policy] as the requests of clients, the selected server is set in [r]
+func (c *exitCheck) client(policy string, r *ExitReport) *http.Client {
+	return &http.Client{
+		Timeout: c.timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				req := NewHttpRequest(connect.TCP, host, "", port, "", addr, util.NextID(), nil, nil)
+				if ip := net.ParseIP(host); ip != nil {
+					req.domain, req.ip = "", host
+				}
+				req.policy = policy
+				rule, server, err := FilterByReq(req)
+				if err != nil {
+					return nil, err
+				}
+				r.Server = server.Name
+				return server.ConnWithDialer(req, proxy.PolicyDialer(rule.Policy, server.Name))
+			},
+		},
+	}
+}
+
+func exitGet(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, exitMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("[%s] status %d", url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// JSON with "ip" (ipinfo.io, ipify), "query" (ip-api.com) or "origin" (httpbin), or plain text
+func parseExitIP(body []byte) (string, map[string]string) {
+	values := make(map[string]interface{})
+	if json.Unmarshal(body, &values) == nil {
+		for _, k := range []string{"ip", "query", "origin"} {
+			v, _ := values[k].(string)
+			if ip := net.ParseIP(strings.TrimSpace(v)); ip != nil {
+				location := make(map[string]string)
+				for _, k := range exitLocationKeys {
+					switch v := values[k].(type) {
+					case string:
+						location[k] = v
+					case float64:
+						location[k] = fmt.Sprint(v)
+					}
+				}
+				return ip.String(), location
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+		return ip.String(), nil
+	}
+	return "", nil
+}
+
+func exitLeakToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,6 @@
+package network
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var networkLog = log.Named("network")
@@ -4,7 +4,6 @@ package network
 
 import (
 	"bytes"
-	"github.com/sipt/shuttle/log"
 	"os/exec"
 	"strings"
 )
@@ -35,30 +34,30 @@ var networkServices = []string{"Wi-Fi", "Thunderbolt Bridge", "Thunderbolt Ether
 func EnableSystemProxy(host, port string) {
 	err := WebProxySwitch(true, host, port)
 	if err != nil {
-		log.Logger.Errorf("Enable WebProxy failed: %v", err)
+		networkLog.Errorf("Enable WebProxy failed: %v", err)
 	}
 	err = SecureWebProxySwitch(true, host, port)
 	if err != nil {
-		log.Logger.Errorf("Enable SecureWeb failed: %v", err)
+		networkLog.Errorf("Enable SecureWeb failed: %v", err)
 	}
 	err = SocksProxySwitch(true, host, port)
 	if err != nil {
-		log.Logger.Errorf("Enable SocksProxy failed: %v", err)
+		networkLog.Errorf("Enable SocksProxy failed: %v", err)
 	}
 }
 
 func DisableSystemProxy() {
 	err := WebProxySwitch(false)
 	if err != nil {
-		log.Logger.Errorf("Disable WebProxy failed: %v", err)
+		networkLog.Errorf("Disable WebProxy failed: %v", err)
 	}
 	err = SecureWebProxySwitch(false)
 	if err != nil {
-		log.Logger.Errorf("Disable SecureWebProxy failed: %v", err)
+		networkLog.Errorf("Disable SecureWebProxy failed: %v", err)
 	}
 	err = SocksProxySwitch(false)
 	if err != nil {
-		log.Logger.Errorf("Disable SocksProxy failed: %v", err)
+		networkLog.Errorf("Disable SocksProxy failed: %v", err)
 	}
 }
 
@@ -6,7 +6,6 @@ import (
 	"os/exec"
 	"bytes"
 	"net"
-	"github.com/sipt/shuttle/log"
 )
 
 //reg add "HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings" /v ProxyEnable /t REG_DWORD /d 1 /f
@@ -26,14 +25,14 @@ const (
 func EnableSystemProxy(host, port string) {
 	err := WebProxySwitch(true, host, port)
 	if err != nil {
-		log.Logger.Errorf("Enable WebProxy failed: %v", err)
+		networkLog.Errorf("Enable WebProxy failed: %v", err)
 	}
 }
 
 func DisableSystemProxy() {
 	err := WebProxySwitch(false)
 	if err != nil {
-		log.Logger.Errorf("Disable WebProxy failed: %v", err)
+		networkLog.Errorf("Disable WebProxy failed: %v", err)
 	}
 }
 
@@ -0,0 +1,54 @@
+package process
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+var ErrorNotFound = errors.New("process not found")
+
+type Process struct {
+	PID  int
+	Name string
+	Path string
+}
+
+// find the local process which owns the socket bound to [addr]
+func Lookup(network string, addr net.Addr) (*Process, error) {
+	if addr == nil {
+		return nil, ErrorNotFound
+	}
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, ErrorNotFound
+	}
+	if !isLocalIP(ip) {
+		return nil, ErrorNotFound
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return findProcess(network, ip, uint16(port))
+}
+
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, v := range addrs {
+		if ipNet, ok := v.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// +build darwin,cgo
+
+package process
+
+/*
+#include <libproc.h>
+#include <stdlib.h>
+#include <sys/proc_info.h>
+#include <arpa/inet.h>
+
+static int find_pid(int udp, unsigned short port) {
+	int size = proc_listpids(PROC_ALL_PIDS, 0, NULL, 0);
+	if (size <= 0) {
+		return -1;
+	}
+	pid_t *pids = malloc(size);
+	size = proc_listpids(PROC_ALL_PIDS, 0, pids, size);
+	int count = size / sizeof(pid_t);
+	for (int i = 0; i < count; i++) {
+		pid_t pid = pids[i];
+		if (pid <= 0) {
+			continue;
+		}
+		int fdSize = proc_pidinfo(pid, PROC_PIDLISTFDS, 0, NULL, 0);
+		if (fdSize <= 0) {
+			continue;
+		}
+		struct proc_fdinfo *fds = malloc(fdSize);
+		fdSize = proc_pidinfo(pid, PROC_PIDLISTFDS, 0, fds, fdSize);
+		int fdCount = fdSize / PROC_PIDLISTFD_SIZE;
+		for (int j = 0; j < fdCount; j++) {
+			if (fds[j].proc_fdtype != PROX_FDTYPE_SOCKET) {
+				continue;
+			}
+			struct socket_fdinfo si;
+			int n = proc_pidfdinfo(pid, fds[j].proc_fd, PROC_PIDFDSOCKETINFO, &si, PROC_PIDFDSOCKETINFO_SIZE);
+			if (n != PROC_PIDFDSOCKETINFO_SIZE) {
+				continue;
+			}
+			int lport = -1;
+			if (udp && si.psi.soi_kind == SOCKINFO_IN) {
+				lport = ntohs(si.psi.soi_proto.pri_in.insi_lport);
+			} else if (!udp && si.psi.soi_kind == SOCKINFO_TCP) {
+				lport = ntohs(si.psi.soi_proto.pri_tcp.tcpsi_ini.insi_lport);
+			}
+			if (lport == port) {
+				free(fds);
+				free(pids);
+				return pid;
+			}
+		}
+		free(fds);
+	}
+	free(pids);
+	return -1;
+}
+
+static int pid_path(int pid, char *buf) {
+	return proc_pidpath(pid, buf, PROC_PIDPATHINFO_MAXSIZE);
+}
+*/
+import "C"
+
+import (
+	"net"
+	"path/filepath"
+	"unsafe"
+)
+
+func findProcess(network string, ip net.IP, port uint16) (*Process, error) {
+	udp := 0
+	if network == "udp" {
+		udp = 1
+	}
+	pid := int(C.find_pid(C.int(udp), C.ushort(port)))
+	if pid < 0 {
+		return nil, ErrorNotFound
+	}
+	buf := (*C.char)(C.malloc(C.PROC_PIDPATHINFO_MAXSIZE))
+	defer C.free(unsafe.Pointer(buf))
+	p := &Process{PID: pid}
+	if n := C.pid_path(C.int(pid), buf); n > 0 {
+		p.Path = C.GoStringN(buf, n)
+		p.Name = filepath.Base(p.Path)
+	}
+	return p, nil
+}
@@ -0,0 +1,126 @@
+// +build linux
+
+package process
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	procNetTCP  = "/proc/net/tcp"
+	procNetTCP6 = "/proc/net/tcp6"
+	procNetUDP  = "/proc/net/udp"
+	procNetUDP6 = "/proc/net/udp6"
+)
+
+func findProcess(network string, ip net.IP, port uint16) (*Process, error) {
+	var files []string
+	switch network {
+	case "udp":
+		files = []string{procNetUDP, procNetUDP6}
+	default:
+		files = []string{procNetTCP, procNetTCP6}
+	}
+	var inode string
+	for _, f := range files {
+		inode = findInode(f, ip, port)
+		if len(inode) > 0 {
+			break
+		}
+	}
+	if len(inode) == 0 {
+		return nil, ErrorNotFound
+	}
+	pid, err := findPID(inode)
+	if err != nil {
+		return nil, err
+	}
+	p := &Process{PID: pid}
+	p.Path, _ = os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	comm, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err == nil {
+		p.Name = strings.TrimSpace(string(comm))
+	} else {
+		p.Name = filepath.Base(p.Path)
+	}
+	return p, nil
+}
+
+//  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 12345
+func findInode(file string, ip net.IP, port uint16) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Scan() // skip header
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		localIP, localPort, ok := parseAddr(fields[1])
+		if !ok || localPort != port {
+			continue
+		}
+		if localIP.Equal(ip) || localIP.IsUnspecified() {
+			return fields[9]
+		}
+	}
+	return ""
+}
+
+// parse "0100007F:1F90", ip is stored as little-endian 32-bit words
+func parseAddr(s string) (net.IP, uint16, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, false
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || len(b)%4 != 0 {
+		return nil, 0, false
+	}
+	for j := 0; j < len(b); j += 4 {
+		b[j], b[j+1], b[j+2], b[j+3] = b[j+3], b[j+2], b[j+1], b[j]
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	return net.IP(b), uint16(port), true
+}
+
+func findPID(inode string) (int, error) {
+	target := "socket:[" + inode + "]"
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err == nil && link == target {
+				return pid, nil
+			}
+		}
+	}
+	return 0, ErrorNotFound
+}
@@ -0,0 +1,12 @@
+// +build !linux,!windows,!darwin darwin,!cgo
+
+package process
+
+import (
+	"errors"
+	"net"
+)
+
+func findProcess(network string, ip net.IP, port uint16) (*Process, error) {
+	return nil, errors.New("process lookup is not supported on this platform")
+}
@@ -0,0 +1,90 @@
+// +build windows
+
+package process
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tcpTableOwnerPIDAll = 5
+	udpTableOwnerPID    = 1
+
+	processQueryLimitedInformation = 0x1000
+)
+
+var (
+	iphlpapi                  = syscall.NewLazyDLL("iphlpapi.dll")
+	kernel32                  = syscall.NewLazyDLL("kernel32.dll")
+	procGetExtendedTcpTable   = iphlpapi.NewProc("GetExtendedTcpTable")
+	procGetExtendedUdpTable   = iphlpapi.NewProc("GetExtendedUdpTable")
+	procQueryFullProcessImage = kernel32.NewProc("QueryFullProcessImageNameW")
+)
+
+func findProcess(network string, ip net.IP, port uint16) (*Process, error) {
+	family := uint32(syscall.AF_INET)
+	if ip.To4() == nil {
+		family = syscall.AF_INET6
+	}
+	var (
+		proc       *syscall.LazyProc
+		class      uint32
+		rowSize    int
+		portOffset int
+		pidOffset  int
+	)
+	switch {
+	case network == "udp" && family == syscall.AF_INET:
+		proc, class, rowSize, portOffset, pidOffset = procGetExtendedUdpTable, udpTableOwnerPID, 12, 4, 8
+	case network == "udp":
+		proc, class, rowSize, portOffset, pidOffset = procGetExtendedUdpTable, udpTableOwnerPID, 28, 20, 24
+	case family == syscall.AF_INET:
+		proc, class, rowSize, portOffset, pidOffset = procGetExtendedTcpTable, tcpTableOwnerPIDAll, 24, 8, 20
+	default:
+		proc, class, rowSize, portOffset, pidOffset = procGetExtendedTcpTable, tcpTableOwnerPIDAll, 56, 20, 52
+	}
+	var size uint32
+	proc.Call(0, uintptr(unsafe.Pointer(&size)), 0, uintptr(family), uintptr(class), 0)
+	if size == 0 {
+		return nil, ErrorNotFound
+	}
+	buf := make([]byte, size)
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0,
+		uintptr(family), uintptr(class), 0)
+	if r != 0 {
+		return nil, err
+	}
+	count := int(binary.LittleEndian.Uint32(buf[:4]))
+	for i := 0; i < count; i++ {
+		row := buf[4+i*rowSize : 4+(i+1)*rowSize]
+		// port is stored in network byte order
+		if binary.BigEndian.Uint16(row[portOffset:portOffset+2]) != port {
+			continue
+		}
+		pid := int(binary.LittleEndian.Uint32(row[pidOffset : pidOffset+4]))
+		p := &Process{PID: pid}
+		p.Path = processPath(pid)
+		p.Name = filepath.Base(p.Path)
+		return p, nil
+	}
+	return nil, ErrorNotFound
+}
+
+func processPath(pid int) string {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return ""
+	}
+	defer syscall.CloseHandle(h)
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	r, _, _ := procQueryFullProcessImage.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf[:size])
+}
@@ -1,9 +1,10 @@
 package shuttle
 
 import (
+	"context"
 	"errors"
 	"github.com/sipt/shuttle/dns"
-	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/extension/process"
 	"github.com/sipt/shuttle/proxy"
 	"github.com/sipt/shuttle/rule"
 )
@@ -15,6 +16,8 @@ type IRequest interface {
 	Port() string
 	Answer() *dns.Answer
 	SetAnswer(*dns.Answer)
+	Process() *process.Process
+	User() string
 
 	ID() int64    //return request id
 	Host() string //return [domain/ip]:[port]
@@ -22,46 +25,68 @@ type IRequest interface {
 }
 
 func FilterByReq(req IRequest) (r *rule.Rule, s *proxy.Server, err error) {
+	return FilterByReqContext(context.Background(), req)
+}
+
+// FilterByReq which gives up resolving the domain when [ctx] is done
+func FilterByReqContext(ctx context.Context, req IRequest) (r *rule.Rule, s *proxy.Server, err error) {
+	//wake up the idle profile
+	activeProfile()
+	//wait for reload
+	if err = waitReload(); err != nil {
+		ruleLog.Errorf("[FilterByReq] [ID:%d] [%s] %s", req.ID(), req.Host(), err.Error())
+		return
+	}
 	//DNS
 	var answer *dns.Answer
 	if len(req.IP()) == 0 {
-		answer, err = dns.ResolveDomainByCache(req.Domain())
+		answer, err = dns.ResolveDomainByCacheContext(ctx, req.Domain())
 	} else {
 		answer, err = dns.ResolveIP(req.IP())
 	}
 	if err != nil {
 		// skip error
-		log.Logger.Errorf("[FilterByReq] %s", err.Error())
+		ruleLog.Errorf("[FilterByReq] %s", err.Error())
 		return
 	}
 	req.SetAnswer(answer)
+	//policy of the request
+	if p, ok := req.(policyRequest); ok && len(p.Policy()) > 0 {
+		return filterByPolicy(req, p.Policy())
+	}
+	//blue/green candidate
+	filter, getServer := rule.RuleFilter, proxy.GetServer
+	if c := pickCanary(); c != nil {
+		ruleLog.Debugf("[RULE] [ID:%d] [%s] through candidate [%s]", req.ID(), req.Host(), c.File)
+		filter, getServer = c.filter, c.servers.GetServer
+	}
 	//Rules RuleFilter
-	r, err = rule.RuleFilter(req)
+	r, err = filter(req)
 	if err != nil {
 		return
 	}
 	if r == rule.RejectRule {
-		s, _ = proxy.GetServer(r.Policy)
+		s, _ = getServer(r.Policy)
 		err = ErrorReject
 		return
 	}
 	if r == nil {
-		log.Logger.Infof("[RULE] [ID:%d] [%s] rule: [%v]", req.ID(), req.Host(), rule.PolicyDirect)
-		s, err = proxy.GetServer(rule.PolicyDirect) // 没有匹配规则，直连
+		ruleLog.Infof("[RULE] [ID:%d] [%s] rule: [%v]", req.ID(), req.Host(), rule.PolicyDirect)
+		s, err = getServer(rule.PolicyDirect) // 没有匹配规则，直连
 	} else {
 		country := ""
 		if req.Answer() != nil {
 			country = req.Answer().Country
 		}
-		log.Logger.Infof("[RULE] [ID:%d] [%s, %s, %s] rule: [%s, %s, %s]", req.ID(), req.Host(), req.Addr(),
+		ruleLog.Infof("[RULE] [ID:%d] [%s, %s, %s] rule: [%s, %s, %s]", req.ID(), req.Host(), req.Addr(),
 			country, r.Type, r.Value, r.Policy)
 		//Select proxy server
-		s, err = proxy.GetServer(r.Policy)
+		s, err = getServer(r.Policy)
 		if err != nil {
 			err = errors.New(err.Error() + ":" + r.Policy)
 			return
 		}
-		log.Logger.Debugf("[RULE] [ID:%d] Get server by policy [%s] => [%s]", req.ID(), r.Policy, s.Name)
+		ruleLog.Debugf("[RULE] [ID:%d] Get server by policy [%s] => [%s]", req.ID(), r.Policy, s.Name)
 	}
 	return
 }
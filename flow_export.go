@@ -0,0 +1,502 @@
+package shuttle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/dns"
+)
+
+const (
+	FlowExportIPFIX    = "ipfix"
+	FlowExportNetFlow9 = "netflow9"
+
+	DefaultFlowTemplateInterval = time.Minute
+
+	flowQueueSize     = 1024
+	flowFlushInterval = time.Second
+	flowMaxPacket     = 1400
+	flowMaxPolicy     = 254 // a variable length of 255 means 3 bytes of length follow
+	v9PolicyLength    = 32
+
+	templateIPv4 = 256
+	templateIPv6 = 257
+
+	// information elements, the same ids in NetFlow v9
+	ieProtocol       = 4
+	ieSrcPort        = 7
+	ieSrcIPv4        = 8
+	ieDstPort        = 11
+	ieDstIPv4        = 12
+	ieSrcIPv6        = 27
+	ieDstIPv6        = 28
+	ieAppName        = 96 // policy, unless [enterprise]
+	ieStartMillis    = 152
+	ieEndMillis      = 153
+	ieInitiatorBytes = 231
+	ieResponderBytes = 232
+	v9InBytes        = 1
+	v9LastSwitched   = 21
+	v9FirstSwitched  = 22
+	v9OutBytes       = 23
+
+	ieEnterpriseBit    = 0x8000
+	iePolicyEnterprise = 1 // policy in [enterprise]
+	ieVariableLength   = 65535
+)
+
+type IFlowExportConfig interface {
+	GetFlowExport() *config.FlowExport
+}
+
+// a completed record
+type flowRecord struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	protocol         uint8
+	start, end       time.Time
+	up, down         uint64
+	policy           string
+}
+
+type flowField struct {
+	id, length uint16
+	pen        uint32
+}
+
+type flowExporter struct {
+	version  string
+	addr     string
+	domain   uint32
+	pen      uint32
+	interval time.Duration
+	queue    chan *flowRecord
+	done     chan struct{}
+
+	started      time.Time
+	sequence     uint32
+	lastTemplate time.Time
+}
+
+var (
+	currentFlowExporter *flowExporter
+	flowExporterLock    sync.RWMutex
+)
+
+func ApplyFlowExportConfig(config IFlowExportConfig) error {
+	e, err := parseFlowExport(config.GetFlowExport())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Flow-Export] %v", err)
+	}
+	flowExporterLock.Lock()
+	old := currentFlowExporter
+	currentFlowExporter = e
+	flowExporterLock.Unlock()
+	if old != nil {
+		close(old.done)
+	}
+	if e != nil {
+		go e.run()
+		flowExportLog.Infof("[Flow-Export] export %s flows to [%s]", e.version, e.addr)
+	}
+	return nil
+}
+
+// nil config or empty collector means off
+func parseFlowExport(c *config.FlowExport) (*flowExporter, error) {
+	if c == nil || len(c.Collector) == 0 {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(c.Collector); err != nil {
+		return nil, fmt.Errorf("[collector] [%s] %v", c.Collector, err)
+	}
+	e := &flowExporter{
+		version:  c.Version,
+		addr:     c.Collector,
+		interval: DefaultFlowTemplateInterval,
+		queue:    make(chan *flowRecord, flowQueueSize),
+		done:     make(chan struct{}),
+	}
+	switch e.version {
+	case "":
+		e.version = FlowExportIPFIX
+	case FlowExportIPFIX, FlowExportNetFlow9:
+	default:
+		return nil, fmt.Errorf("[version] not support [%s], support: ipfix, netflow9", c.Version)
+	}
+	if len(c.TemplateInterval) > 0 {
+		d, err := time.ParseDuration(c.TemplateInterval)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("[template-interval] invalid [%s], at least 1s", c.TemplateInterval)
+		}
+		e.interval = d
+	}
+	if len(c.Enterprise) > 0 {
+		if e.version != FlowExportIPFIX {
+			return nil, fmt.Errorf("[enterprise] is only supported by ipfix")
+		}
+		n, err := strconv.ParseUint(c.Enterprise, 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("[enterprise] invalid private enterprise number [%s]", c.Enterprise)
+		}
+		e.pen = uint32(n)
+	}
+	if len(c.DomainID) > 0 {
+		n, err := strconv.ParseUint(c.DomainID, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("[domain-id] invalid [%s]", c.DomainID)
+		}
+		e.domain = uint32(n)
+	}
+	return e, nil
+}
+
+func getFlowExporter() *flowExporter {
+	flowExporterLock.RLock()
+	defer flowExporterLock.RUnlock()
+	return currentFlowExporter
+}
+
+// export a record appended with its final status
+func exportRecord(r *Record) {
+	if r.Status != RecordStatusActive {
+		exportFlow(r)
+	}
+}
+
+// export the record of [id] when it's completed
+func exportStatus(id int64, status string) {
+	if status == RecordStatusActive || getFlowExporter() == nil {
+		return
+	}
+	if r := storage.Get(id); r != nil {
+		exportFlow(r)
+	}
+}
+
+func exportFlow(r *Record) {
+	e := getFlowExporter()
+	if e == nil {
+		return
+	}
+	f := &flowRecord{
+		protocol: 6,
+		start:    r.Created,
+		end:      time.Now(),
+		up:       uint64(r.Up),
+		down:     uint64(r.Down),
+	}
+	if r.Protocol == ProtocolSocksUDP {
+		f.protocol = 17
+	}
+	if r.Rule != nil {
+		f.policy = r.Rule.Policy
+	}
+	f.src, f.srcPort = splitFlowAddr(r.Src)
+	f.dst, f.dstPort = splitFlowAddr(recordAddr(r.URL))
+	if f.dst == nil {
+		// domain of the record, the IP resolved by shuttle if any
+		if a := dns.LookupDNSCache(recordHost(r.URL)); a != nil {
+			f.dst = net.ParseIP(a.GetIP())
+		}
+	}
+	if f.src == nil {
+		f.src = net.IPv4zero
+	}
+	if f.dst == nil {
+		f.dst = net.IPv4zero
+	}
+	select {
+	case e.queue <- f:
+	default:
+		flowExportLog.Debugf("[Flow-Export] queue is full, drop flow of [%d]", r.ID)
+	}
+}
+
+// "host:port" of record url, port of the scheme if omitted
+func recordAddr(u string) string {
+	port := ""
+	if strings.HasPrefix(u, "https://") {
+		port = "443"
+	} else if strings.HasPrefix(u, "http://") {
+		port = "80"
+	}
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	if i := strings.IndexByte(u, '/'); i >= 0 {
+		u = u[:i]
+	}
+	if _, _, err := net.SplitHostPort(u); err == nil || len(port) == 0 {
+		return u
+	}
+	return net.JoinHostPort(u, port)
+}
+
+func splitFlowAddr(addr string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return net.ParseIP(host), uint16(p)
+}
+
+func (e *flowExporter) run() {
+	e.started = time.Now()
+	ticker := time.NewTicker(flowFlushInterval)
+	defer ticker.Stop()
+	var (
+		conn  net.Conn
+		flows []*flowRecord
+		err   error
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-e.done:
+			return
+		case f := <-e.queue:
+			flows = append(flows, f)
+			if len(flows) < flowQueueSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(flows) == 0 {
+				continue
+			}
+		}
+		if conn == nil {
+			if conn, err = net.Dial("udp", e.addr); err != nil {
+				flowExportLog.Errorf("[Flow-Export] dial collector [%s] failed: %v", e.addr, err)
+				conn, flows = nil, nil
+				continue
+			}
+		}
+		for _, packet := range e.packets(flows, time.Now()) {
+			if _, err = conn.Write(packet); err != nil {
+				flowExportLog.Errorf("[Flow-Export] send to collector [%s] failed: %v", e.addr, err)
+				conn.Close()
+				conn = nil
+				break
+			}
+		}
+		flows = nil
+	}
+}
+
+func (e *flowExporter) fields(ipv6 bool) []flowField {
+	src, dst, l := uint16(ieSrcIPv4), uint16(ieDstIPv4), uint16(4)
+	if ipv6 {
+		src, dst, l = ieSrcIPv6, ieDstIPv6, 16
+	}
+	if e.version == FlowExportNetFlow9 {
+		return []flowField{
+			{id: src, length: l}, {id: ieSrcPort, length: 2}, {id: dst, length: l}, {id: ieDstPort, length: 2},
+			{id: ieProtocol, length: 1}, {id: v9FirstSwitched, length: 4}, {id: v9LastSwitched, length: 4},
+			{id: v9InBytes, length: 8}, {id: v9OutBytes, length: 8}, {id: ieAppName, length: v9PolicyLength},
+		}
+	}
+	policy := flowField{id: ieAppName, length: ieVariableLength}
+	if e.pen > 0 {
+		policy = flowField{id: ieEnterpriseBit | iePolicyEnterprise, length: ieVariableLength, pen: e.pen}
+	}
+	return []flowField{
+		{id: src, length: l}, {id: ieSrcPort, length: 2}, {id: dst, length: l}, {id: ieDstPort, length: 2},
+		{id: ieProtocol, length: 1}, {id: ieStartMillis, length: 8}, {id: ieEndMillis, length: 8},
+		{id: ieInitiatorBytes, length: 8}, {id: ieResponderBytes, length: 8}, policy,
+	}
+}
+
+// messages of [flows], templates are sent in the first one every template-interval
+func (e *flowExporter) packets(flows []*flowRecord, now time.Time) [][]byte {
+	var (
+		packets [][]byte
+		sets    []byte
+		count   int // records of NetFlow v9, data records of IPFIX
+		data    int
+	)
+	flush := func() {
+		if len(sets) > 0 {
+			packets = append(packets, e.message(sets, count, now))
+			if e.version == FlowExportIPFIX {
+				e.sequence += uint32(data)
+			} else {
+				e.sequence++
+			}
+		}
+		sets, count, data = nil, 0, 0
+	}
+	if now.Sub(e.lastTemplate) >= e.interval {
+		e.lastTemplate = now
+		sets = e.templateSet()
+		count = 2
+		if e.version == FlowExportIPFIX {
+			count = 0
+		}
+	}
+	for _, ipv6 := range []bool{false, true} {
+		var set []byte
+		for _, f := range flows {
+			if (f.src.To4() == nil || f.dst.To4() == nil) != ipv6 {
+				continue
+			}
+			record := e.dataRecord(f, ipv6, now)
+			if len(set) > 0 && len(sets)+len(set)+len(record)+24 > flowMaxPacket {
+				sets = append(sets, e.dataSet(ipv6, set)...)
+				flush()
+				set = nil
+			}
+			set = append(set, record...)
+			count++
+			data++
+		}
+		if len(set) > 0 {
+			sets = append(sets, e.dataSet(ipv6, set)...)
+		}
+	}
+	flush()
+	return packets
+}
+
+func (e *flowExporter) message(sets []byte, count int, now time.Time) []byte {
+	var header []byte
+	if e.version == FlowExportNetFlow9 {
+		header = make([]byte, 20)
+		binary.BigEndian.PutUint16(header[0:], 9)
+		binary.BigEndian.PutUint16(header[2:], uint16(count))
+		binary.BigEndian.PutUint32(header[4:], e.uptime(now))
+		binary.BigEndian.PutUint32(header[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(header[12:], e.sequence)
+		binary.BigEndian.PutUint32(header[16:], e.domain)
+	} else {
+		header = make([]byte, 16)
+		binary.BigEndian.PutUint16(header[0:], 10)
+		binary.BigEndian.PutUint16(header[2:], uint16(16+len(sets)))
+		binary.BigEndian.PutUint32(header[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(header[8:], e.sequence)
+		binary.BigEndian.PutUint32(header[12:], e.domain)
+	}
+	return append(header, sets...)
+}
+
+// template set (IPFIX id 2) or template flowset (NetFlow v9 id 0) of IPv4 and IPv6
+func (e *flowExporter) templateSet() []byte {
+	var body []byte
+	for id, ipv6 := range map[uint16]bool{templateIPv4: false, templateIPv6: true} {
+		fields := e.fields(ipv6)
+		body = appendUint16(body, id)
+		body = appendUint16(body, uint16(len(fields)))
+		for _, f := range fields {
+			body = appendUint16(body, f.id)
+			body = appendUint16(body, f.length)
+			if f.id&ieEnterpriseBit != 0 {
+				body = appendUint32(body, f.pen)
+			}
+		}
+	}
+	id := uint16(2)
+	if e.version == FlowExportNetFlow9 {
+		id = 0
+	}
+	return e.set(id, body)
+}
+
+func (e *flowExporter) dataSet(ipv6 bool, records []byte) []byte {
+	id := uint16(templateIPv4)
+	if ipv6 {
+		id = templateIPv6
+	}
+	return e.set(id, records)
+}
+
+// set header, NetFlow v9 flowsets are padded to 4 bytes
+func (e *flowExporter) set(id uint16, body []byte) []byte {
+	length := 4 + len(body)
+	if e.version == FlowExportNetFlow9 && length%4 != 0 {
+		length += 4 - length%4
+	}
+	b := make([]byte, 4, length)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+	b = append(b, body...)
+	return append(b, make([]byte, length-len(b))...)
+}
+
+func (e *flowExporter) dataRecord(f *flowRecord, ipv6 bool, now time.Time) []byte {
+	var b []byte
+	for _, field := range e.fields(ipv6) {
+		switch field.id {
+		case ieSrcIPv4:
+			b = append(b, f.src.To4()...)
+		case ieDstIPv4:
+			b = append(b, f.dst.To4()...)
+		case ieSrcIPv6:
+			b = append(b, f.src.To16()...)
+		case ieDstIPv6:
+			b = append(b, f.dst.To16()...)
+		case ieSrcPort:
+			b = appendUint16(b, f.srcPort)
+		case ieDstPort:
+			b = appendUint16(b, f.dstPort)
+		case ieProtocol:
+			b = append(b, f.protocol)
+		case ieStartMillis:
+			b = appendUint64(b, uint64(f.start.UnixNano()/int64(time.Millisecond)))
+		case ieEndMillis:
+			b = appendUint64(b, uint64(f.end.UnixNano()/int64(time.Millisecond)))
+		case v9FirstSwitched:
+			b = appendUint32(b, e.uptime(f.start))
+		case v9LastSwitched:
+			b = appendUint32(b, e.uptime(f.end))
+		case ieInitiatorBytes, v9InBytes:
+			b = appendUint64(b, f.up)
+		case ieResponderBytes, v9OutBytes:
+			b = appendUint64(b, f.down)
+		default: // policy
+			policy := f.policy
+			if len(policy) > flowMaxPolicy {
+				policy = policy[:flowMaxPolicy]
+			}
+			if field.length == ieVariableLength {
+				b = append(b, byte(len(policy)))
+				b = append(b, policy...)
+			} else {
+				v := make([]byte, field.length)
+				copy(v, policy)
+				b = append(b, v...)
+			}
+		}
+	}
+	return b
+}
+
+// NetFlow v9 time, milliseconds since the exporter started
+func (e *flowExporter) uptime(t time.Time) uint32 {
+	if t.Before(e.started) {
+		return 0
+	}
+	return uint32(t.Sub(e.started) / time.Millisecond)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(appendUint16(b, uint16(v>>16)), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
@@ -1,11 +1,17 @@
 module github.com/sipt/shuttle
 
+go 1.23
+
 require (
 	github.com/miekg/dns v1.0.15
 	github.com/oschwald/geoip2-golang v1.2.1
-	github.com/oschwald/maxminddb-golang v1.3.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.3.0
 	github.com/sipt/yaml v0.0.0-20181127084323-eeedbff8afd4
-	golang.org/x/crypto v0.0.0-20181126163421-e657309f52e7 // indirect
-	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a // indirect
+	golang.org/x/crypto v0.0.0-20181126163421-e657309f52e7
+	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a
+)
+
+require (
 	golang.org/x/sys v0.0.0-20181122145206-62eef0e2fa9b // indirect
+	golang.org/x/text v0.3.0 // indirect
 )
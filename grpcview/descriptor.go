@@ -0,0 +1,207 @@
+package grpcview
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// messages and methods of FileDescriptorSets, e.g. protoc --include_imports --descriptor_set_out=api.protoset
+type Descriptors struct {
+	messages map[string]*messageDesc // full name without the leading dot
+	methods  map[string][2]string    // "/package.Service/Method": input, output
+}
+
+type messageDesc struct {
+	name   string
+	fields map[int]*fieldDesc
+}
+
+type fieldDesc struct {
+	name     string
+	typ      int
+	typeName string
+}
+
+func NewDescriptors() *Descriptors {
+	return &Descriptors{
+		messages: make(map[string]*messageDesc),
+		methods:  make(map[string][2]string),
+	}
+}
+
+func LoadDescriptors(files []string) (*Descriptors, error) {
+	d := NewDescriptors()
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if err = d.Add(data); err != nil {
+			return nil, fmt.Errorf("[%s] %v", f, err)
+		}
+	}
+	return d, nil
+}
+
+// add a serialized FileDescriptorSet
+func (d *Descriptors) Add(data []byte) error {
+	set, err := readFields(data)
+	if err != nil {
+		return err
+	}
+	for _, f := range set {
+		if f.num == 1 && f.wire == wireBytes {
+			if err = d.addFile(f.b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FileDescriptorProto: 2 package, 4 message_type, 6 service
+func (d *Descriptors) addFile(data []byte) error {
+	fs, err := readFields(data)
+	if err != nil {
+		return err
+	}
+	pkg := ""
+	for _, f := range fs {
+		if f.num == 2 && f.wire == wireBytes {
+			pkg = string(f.b)
+		}
+	}
+	for _, f := range fs {
+		if f.wire != wireBytes {
+			continue
+		}
+		switch f.num {
+		case 4:
+			err = d.addMessage(pkg, f.b)
+		case 6:
+			err = d.addService(pkg, f.b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DescriptorProto: 1 name, 2 field, 3 nested_type
+func (d *Descriptors) addMessage(scope string, data []byte) error {
+	fs, err := readFields(data)
+	if err != nil {
+		return err
+	}
+	m := &messageDesc{fields: make(map[int]*fieldDesc)}
+	for _, f := range fs {
+		if f.num == 1 && f.wire == wireBytes {
+			m.name = join(scope, string(f.b))
+		}
+	}
+	for _, f := range fs {
+		if f.wire != wireBytes {
+			continue
+		}
+		switch f.num {
+		case 2:
+			fd, num, err := parseField(f.b)
+			if err != nil {
+				return err
+			}
+			m.fields[num] = fd
+		case 3:
+			if err = d.addMessage(m.name, f.b); err != nil {
+				return err
+			}
+		}
+	}
+	d.messages[m.name] = m
+	return nil
+}
+
+// FieldDescriptorProto: 1 name, 3 number, 5 type, 6 type_name
+func parseField(data []byte) (*fieldDesc, int, error) {
+	fs, err := readFields(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	fd, num := &fieldDesc{}, 0
+	for _, f := range fs {
+		switch {
+		case f.num == 1 && f.wire == wireBytes:
+			fd.name = string(f.b)
+		case f.num == 3 && f.wire == wireVarint:
+			num = int(f.v)
+		case f.num == 5 && f.wire == wireVarint:
+			fd.typ = int(f.v)
+		case f.num == 6 && f.wire == wireBytes:
+			fd.typeName = strings.TrimPrefix(string(f.b), ".")
+		}
+	}
+	return fd, num, nil
+}
+
+// ServiceDescriptorProto: 1 name, 2 method. MethodDescriptorProto: 1 name, 2 input_type, 3 output_type
+func (d *Descriptors) addService(pkg string, data []byte) error {
+	fs, err := readFields(data)
+	if err != nil {
+		return err
+	}
+	service := ""
+	for _, f := range fs {
+		if f.num == 1 && f.wire == wireBytes {
+			service = join(pkg, string(f.b))
+		}
+	}
+	for _, f := range fs {
+		if f.num != 2 || f.wire != wireBytes {
+			continue
+		}
+		ms, err := readFields(f.b)
+		if err != nil {
+			return err
+		}
+		var name, in, out string
+		for _, m := range ms {
+			if m.wire != wireBytes {
+				continue
+			}
+			switch m.num {
+			case 1:
+				name = string(m.b)
+			case 2:
+				in = strings.TrimPrefix(string(m.b), ".")
+			case 3:
+				out = strings.TrimPrefix(string(m.b), ".")
+			}
+		}
+		d.methods["/"+service+"/"+name] = [2]string{in, out}
+	}
+	return nil
+}
+
+// input and output types of the method of the request path, empty if unknown
+func (d *Descriptors) Method(path string) (string, string) {
+	if d == nil {
+		return "", ""
+	}
+	m := d.methods[path]
+	return m[0], m[1]
+}
+
+func (d *Descriptors) message(name string) *messageDesc {
+	if d == nil || len(name) == 0 {
+		return nil
+	}
+	return d.messages[name]
+}
+
+func join(scope, name string) string {
+	if len(scope) == 0 {
+		return name
+	}
+	return scope + "." + name
+}
@@ -0,0 +1,318 @@
+package grpcview
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// gRPC messages in HTTP/2 bodies: a 5 bytes prefix (compressed flag, length) per message,
+// printed from the protobuf wire format, with names when the descriptors are loaded
+
+const ContentType = "application/grpc"
+
+var (
+	ErrorTruncated    = errors.New("truncated message")
+	ErrorWireType     = errors.New("unsupported wire type")
+	ErrorNotSupported = errors.New("compression not supported")
+)
+
+type Message struct {
+	Compressed bool   `json:"compressed"`
+	Size       int    `json:"size"`
+	Text       string `json:"text"`
+	Error      string `json:"error,omitempty"`
+}
+
+// application/grpc and application/grpc+proto, not +json
+func IsGRPC(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+	return contentType == ContentType || contentType == ContentType+"+proto"
+}
+
+type Frame struct {
+	Compressed bool
+	Data       []byte
+}
+
+// split [body] into messages, an incomplete one at the end is an error
+func Frames(body []byte) ([]Frame, error) {
+	var frames []Frame
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return frames, ErrorTruncated
+		}
+		n := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			return frames, ErrorTruncated
+		}
+		frames = append(frames, Frame{Compressed: body[0] == 1, Data: body[5 : 5+n]})
+		body = body[5+n:]
+	}
+	return frames, nil
+}
+
+// messages of [body] of type [typeName] (empty: unknown), [encoding] is grpc-encoding
+func View(body []byte, encoding, typeName string, d *Descriptors) ([]*Message, error) {
+	frames, err := Frames(body)
+	msgs := make([]*Message, 0, len(frames))
+	for _, f := range frames {
+		m := &Message{Compressed: f.Compressed, Size: len(f.Data)}
+		data := f.Data
+		var e error
+		if f.Compressed {
+			data, e = decompress(data, encoding)
+		}
+		if e == nil {
+			m.Text, e = Format(data, typeName, d)
+		}
+		if e != nil {
+			m.Error = e.Error()
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, err
+}
+
+func decompress(data []byte, encoding string) ([]byte, error) {
+	if encoding != "gzip" {
+		return nil, ErrorNotSupported
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type field struct {
+	num  int
+	wire int
+	v    uint64 // varint, fixed64, fixed32
+	b    []byte // length-delimited
+}
+
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// fields of a message in the wire format
+func readFields(data []byte) ([]field, error) {
+	var fs []field
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		if n == 0 {
+			return nil, ErrorTruncated
+		}
+		data = data[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		if f.num <= 0 {
+			return nil, fmt.Errorf("invalid field number %d", f.num)
+		}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = readVarint(data); n == 0 {
+				return nil, ErrorTruncated
+			}
+		case wireFixed64:
+			if n = 8; len(data) < n {
+				return nil, ErrorTruncated
+			}
+			f.v = binary.LittleEndian.Uint64(data)
+		case wireFixed32:
+			if n = 4; len(data) < n {
+				return nil, ErrorTruncated
+			}
+			f.v = uint64(binary.LittleEndian.Uint32(data))
+		case wireBytes:
+			l, m := readVarint(data)
+			if m == 0 || uint64(len(data)-m) < l {
+				return nil, ErrorTruncated
+			}
+			f.b = data[m : m+int(l)]
+			n = m + int(l)
+		default:
+			return nil, ErrorWireType
+		}
+		data = data[n:]
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// text of a message like protoc --decode(_raw): "name: value", nested messages in braces.
+// fields are named by the descriptors of [typeName], numbered otherwise
+func Format(data []byte, typeName string, d *Descriptors) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := format(buf, data, d.message(typeName), d, 0); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func format(buf *bytes.Buffer, data []byte, md *messageDesc, d *Descriptors, depth int) error {
+	fs, err := readFields(data)
+	if err != nil {
+		return err
+	}
+	indent := strings.Repeat("  ", depth)
+	for _, f := range fs {
+		var fd *fieldDesc
+		if md != nil {
+			fd = md.fields[f.num]
+		}
+		name := strconv.Itoa(f.num)
+		if fd != nil {
+			name = fd.name
+		}
+		switch {
+		case fd == nil:
+			formatRaw(buf, indent, name, f, d, depth)
+		case f.wire == wireBytes && fd.typ == typeMessage:
+			fmt.Fprintf(buf, "%s%s {\n", indent, name)
+			if err := format(buf, f.b, d.message(fd.typeName), d, depth+1); err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "%s}\n", indent)
+		case f.wire == wireBytes && fd.typ != typeString && fd.typ != typeBytes:
+			// packed repeated scalars
+			for _, v := range unpack(f.b, fd.typ) {
+				fmt.Fprintf(buf, "%s%s: %s\n", indent, name, scalar(v, fd.typ))
+			}
+		case f.wire == wireBytes:
+			fmt.Fprintf(buf, "%s%s: %s\n", indent, name, quote(f.b))
+		default:
+			fmt.Fprintf(buf, "%s%s: %s\n", indent, name, scalar(f.v, fd.typ))
+		}
+	}
+	return nil
+}
+
+// without descriptor: bytes are a nested message if they parse, a string if printable
+func formatRaw(buf *bytes.Buffer, indent, name string, f field, d *Descriptors, depth int) {
+	switch f.wire {
+	case wireVarint:
+		fmt.Fprintf(buf, "%s%s: %d\n", indent, name, f.v)
+	case wireFixed64:
+		fmt.Fprintf(buf, "%s%s: 0x%016x\n", indent, name, f.v)
+	case wireFixed32:
+		fmt.Fprintf(buf, "%s%s: 0x%08x\n", indent, name, f.v)
+	case wireBytes:
+		if len(f.b) > 0 && !printable(f.b) {
+			nested := &bytes.Buffer{}
+			if format(nested, f.b, nil, d, depth+1) == nil {
+				fmt.Fprintf(buf, "%s%s {\n%s%s}\n", indent, name, nested.String(), indent)
+				return
+			}
+		}
+		fmt.Fprintf(buf, "%s%s: %s\n", indent, name, quote(f.b))
+	}
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(b []byte) string {
+	if printable(b) {
+		return strconv.Quote(string(b))
+	}
+	return fmt.Sprintf("%q", b)
+}
+
+// field types of FieldDescriptorProto
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+)
+
+func unpack(b []byte, typ int) []uint64 {
+	var vs []uint64
+	for len(b) > 0 {
+		switch typ {
+		case typeDouble, typeFixed64, typeSfixed64:
+			if len(b) < 8 {
+				return vs
+			}
+			vs, b = append(vs, binary.LittleEndian.Uint64(b)), b[8:]
+		case typeFloat, typeFixed32, typeSfixed32:
+			if len(b) < 4 {
+				return vs
+			}
+			vs, b = append(vs, uint64(binary.LittleEndian.Uint32(b))), b[4:]
+		default:
+			v, n := readVarint(b)
+			if n == 0 {
+				return vs
+			}
+			vs, b = append(vs, v), b[n:]
+		}
+	}
+	return vs
+}
+
+func scalar(v uint64, typ int) string {
+	switch typ {
+	case typeDouble:
+		return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+	case typeFloat:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32)
+	case typeInt64, typeSfixed64:
+		return strconv.FormatInt(int64(v), 10)
+	case typeInt32, typeSfixed32, typeEnum:
+		return strconv.FormatInt(int64(int32(v)), 10)
+	case typeSint32, typeSint64:
+		return strconv.FormatInt(int64(v>>1)^-int64(v&1), 10)
+	case typeBool:
+		return strconv.FormatBool(v != 0)
+	}
+	return strconv.FormatUint(v, 10)
+}
@@ -0,0 +1,88 @@
+package grpcview
+
+import (
+	"testing"
+)
+
+// field [num] of length-delimited [b]
+func lenField(num int, b []byte) []byte {
+	return append([]byte{byte(num<<3 | wireBytes), byte(len(b))}, b...)
+}
+
+func varintField(num int, v byte) []byte {
+	return []byte{byte(num << 3), v}
+}
+
+func join2(bs ...[]byte) []byte {
+	var b []byte
+	for _, v := range bs {
+		b = append(b, v...)
+	}
+	return b
+}
+
+func TestFrames(t *testing.T) {
+	body := []byte{0, 0, 0, 0, 2, 0x08, 0x01, 1, 0, 0, 0, 1, 0xff, 0, 0, 0}
+	frames, err := Frames(body)
+	if err != ErrorTruncated || len(frames) != 2 {
+		t.Fatalf("frames failed: %v %v", frames, err)
+	}
+	if frames[0].Compressed || string(frames[0].Data) != "\x08\x01" || !frames[1].Compressed {
+		t.Errorf("frames failed: %v", frames)
+	}
+	if !IsGRPC("application/grpc+proto; charset=utf-8") || IsGRPC("application/grpc+json") {
+		t.Errorf("content type failed")
+	}
+}
+
+func TestFormatRaw(t *testing.T) {
+	// 1: 150, 2: "hi", 3 {1: 1}
+	data := join2([]byte{0x08, 0x96, 0x01}, lenField(2, []byte("hi")), lenField(3, varintField(1, 1)))
+	text, err := Format(data, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "1: 150\n2: \"hi\"\n3 {\n  1: 1\n}\n" {
+		t.Errorf("format raw failed: %q", text)
+	}
+	if _, err = Format([]byte{0x0a, 0x05, 'a'}, "", nil); err != ErrorTruncated {
+		t.Errorf("format truncated failed: %v", err)
+	}
+}
+
+func TestFormatDescriptors(t *testing.T) {
+	// package demo; message Inner {sint32 v = 1;} message Req {string name = 1; Inner inner = 2; repeated int32 ids = 3;}
+	// service Greeter {rpc Hello(Req) returns (Inner);}
+	field := func(name string, num, typ byte, typeName string) []byte {
+		b := join2(lenField(1, []byte(name)), varintField(3, num), varintField(5, typ))
+		if len(typeName) > 0 {
+			b = append(b, lenField(6, []byte(typeName))...)
+		}
+		return b
+	}
+	inner := join2(lenField(1, []byte("Inner")), lenField(2, field("v", 1, typeSint32, "")))
+	req := join2(lenField(1, []byte("Req")),
+		lenField(2, field("name", 1, typeString, "")),
+		lenField(2, field("inner", 2, typeMessage, ".demo.Inner")),
+		lenField(2, field("ids", 3, typeInt32, "")))
+	method := join2(lenField(1, []byte("Hello")), lenField(2, []byte(".demo.Req")), lenField(3, []byte(".demo.Inner")))
+	service := join2(lenField(1, []byte("Greeter")), lenField(2, method))
+	file := join2(lenField(2, []byte("demo")), lenField(4, inner), lenField(4, req), lenField(6, service))
+	d := NewDescriptors()
+	if err := d.Add(lenField(1, file)); err != nil {
+		t.Fatal(err)
+	}
+	in, out := d.Method("/demo.Greeter/Hello")
+	if in != "demo.Req" || out != "demo.Inner" {
+		t.Fatalf("method failed: [%s] [%s]", in, out)
+	}
+	// name: "a", inner {v: -2}, ids: [1, 2] packed
+	data := join2(lenField(1, []byte("a")), lenField(2, varintField(1, 3)), lenField(3, []byte{1, 2}))
+	text, err := Format(data, in, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "name: \"a\"\ninner {\n  v: -2\n}\nids: 1\nids: 2\n" {
+		t.Errorf("format failed: %q", text)
+	}
+}
@@ -0,0 +1,320 @@
+package shuttle
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/proxy"
+	rule2 "github.com/sipt/shuttle/rule"
+)
+
+const (
+	defaultHedgeDelay      = 500 * time.Millisecond
+	defaultHedgeBudget     = 0.1
+	defaultHedgeMinRetries = 3
+	budgetWindow           = 10 // seconds
+)
+
+type IHedgeConfig interface {
+	GetHedge() *config.Hedge
+}
+
+type hedge struct {
+	delay  time.Duration
+	groups []string
+	budget *retryBudget
+}
+
+var (
+	currentHedge *hedge
+	hedgeLock    sync.RWMutex
+)
+
+func ApplyHedgeConfig(config IHedgeConfig) error {
+	h, err := parseHedge(config.GetHedge())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Hedge] failed: %v", err)
+	}
+	hedgeLock.Lock()
+	currentHedge = h
+	hedgeLock.Unlock()
+	if h != nil {
+		hedgeLog.Infof("[Hedge] hedge requests of %v after %s, budget: %v%%", h.groups, h.delay, h.budget.ratio*100)
+	}
+	return nil
+}
+
+// nil config or empty groups means hedge off
+func parseHedge(c *config.Hedge) (*hedge, error) {
+	if c == nil || len(c.Groups) == 0 {
+		return nil, nil
+	}
+	h := &hedge{
+		delay:  defaultHedgeDelay,
+		groups: c.Groups,
+		budget: &retryBudget{ratio: defaultHedgeBudget, min: defaultHedgeMinRetries},
+	}
+	var err error
+	if len(c.Delay) > 0 {
+		h.delay, err = time.ParseDuration(c.Delay)
+		if err != nil || h.delay <= 0 {
+			return nil, fmt.Errorf("delay [%s] is invalid", c.Delay)
+		}
+	}
+	if len(c.Budget) > 0 {
+		h.budget.ratio, err = strconv.ParseFloat(c.Budget, 64)
+		if err != nil || h.budget.ratio < 0 || h.budget.ratio > 1 {
+			return nil, fmt.Errorf("budget [%s] must be in [0, 1]", c.Budget)
+		}
+	}
+	if len(c.MinRetries) > 0 {
+		h.budget.min, err = strconv.Atoi(c.MinRetries)
+		if err != nil || h.budget.min < 0 {
+			return nil, fmt.Errorf("min-retries [%s] is invalid", c.MinRetries)
+		}
+	}
+	return h, nil
+}
+
+func getHedge() *hedge {
+	hedgeLock.RLock()
+	defer hedgeLock.RUnlock()
+	return currentHedge
+}
+
+// group of the rule if its requests can be hedged
+func (h *hedge) group(hreq *http.Request, rule *rule2.Rule) *proxy.ServerGroup {
+	if rule == nil || !idempotent(hreq) {
+		return nil
+	}
+	for _, v := range h.groups {
+		if v == "*" || v == rule.Policy {
+			if g, ok := proxy.GroupExist(rule.Policy); ok {
+				return g
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// safe to send twice: idempotent method or Idempotency-Key, without body and upgrade
+func idempotent(hreq *http.Request) bool {
+	if hreq.ContentLength != 0 || len(hreq.TransferEncoding) > 0 || len(hreq.Header.Get("Upgrade")) > 0 {
+		return false
+	}
+	switch hreq.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return len(hreq.Header.Get("Idempotency-Key")) > 0 || len(hreq.Header.Get("X-Idempotency-Key")) > 0
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	conn   connect.IConn
+	reader *bufio.Reader
+	server *proxy.Server
+	err    error
+}
+
+// read response of [hreq] which is sent to [sc], when no response in delay, send it again through
+// another member of the group, the first response wins and the other connection is closed
+func hedgeResponse(hreq *http.Request, lc, sc connect.IConn, scBuf *bufio.Reader, rule *rule2.Rule,
+	server *proxy.Server, recordID int64, isHttps bool) hedgeResult {
+	primary := func() hedgeResult {
+		resp, err := http.ReadResponse(scBuf, nil)
+		return hedgeResult{resp, sc, scBuf, server, err}
+	}
+	h := getHedge()
+	if h == nil || server == nil {
+		return primary()
+	}
+	g := h.group(hreq, rule)
+	if g == nil {
+		return primary()
+	}
+	h.budget.request()
+	results := make(chan hedgeResult, 2)
+	go func() {
+		results <- primary()
+	}()
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r
+	case <-timer.C:
+	}
+	alt := g.Alternate(server.Name)
+	if alt == nil {
+		return <-results
+	}
+	if !h.budget.withdraw() {
+		hedgeLog.Debugf("[Hedge] [ID:%d] retry budget is exhausted", lc.GetID())
+		return <-results
+	}
+	hedgeLog.Infof("[Hedge] [ID:%d] no response from [%s] in %s, hedge through [%s]", lc.GetID(), server.Name, h.delay, alt.Name)
+	go func() {
+		results <- hedgeSend(hreq, lc, alt, rule.Policy, recordID, isHttps)
+	}()
+	winner := <-results
+	if winner.err != nil {
+		winner = <-results
+	} else {
+		go func() {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+	}
+	if winner.err != nil {
+		hedgeLog.Errorf("[Hedge] [ID:%d] both [%s] and [%s] failed", lc.GetID(), server.Name, alt.Name)
+	}
+	if winner.conn != sc {
+		// unblock the primary reading, the connection has an outstanding response
+		sc.Close()
+		if winner.err == nil {
+			hedgeLog.Infof("[Hedge] [ID:%d] response from [%s] wins", lc.GetID(), alt.Name)
+			boxChan <- &Box{recordID, RecordProxy, alt}
+		}
+	}
+	return winner
+}
+
+// connect through [server] and send [hreq] again
+func hedgeSend(hreq *http.Request, lc connect.IConn, server *proxy.Server, policy string, recordID int64, isHttps bool) hedgeResult {
+	req := upstreamRequest(hreq, lc, isHttps)
+	var (
+		answer *dns.Answer
+		err    error
+	)
+	if len(req.ip) > 0 {
+		answer, err = dns.ResolveIP(req.ip)
+	} else {
+		answer, err = dns.ResolveDomainByCacheContext(lc.Context(), req.domain)
+	}
+	if err != nil {
+		return hedgeResult{server: server, err: err}
+	}
+	req.SetAnswer(answer)
+	sc, err := server.ConnWithDialer(req, proxy.PolicyDialerContext(lc.Context(), policy, server.Name))
+	if err != nil {
+		return hedgeResult{server: server, err: err}
+	}
+	sc, reader, resp, err := sendUpstream(sc, req, hreq, recordID, isHttps)
+	return hedgeResult{resp, sc, reader, server, err}
+}
+
+// request to the host of [hreq] as the one of [lc]
+func upstreamRequest(hreq *http.Request, lc connect.IConn, isHttps bool) *HttpRequest {
+	host := hreq.Host
+	if len(host) == 0 {
+		host = hreq.URL.Host
+	}
+	req := &HttpRequest{
+		network:  connect.TCP,
+		domain:   host,
+		connID:   lc.GetID(),
+		protocol: HTTP,
+		srcAddr:  lc.RemoteAddr(),
+		user:     connUser(lc),
+	}
+	if isHttps {
+		req.protocol = HTTPS
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		req.domain, req.port = h, p
+	}
+	if len(net.ParseIP(req.domain)) > 0 {
+		req.ip, req.domain = req.domain, ""
+	}
+	return req
+}
+
+// send [hreq] to [sc], over TLS for MitM, and read the response. [sc] is closed on error
+func sendUpstream(sc connect.IConn, req *HttpRequest, hreq *http.Request, recordID int64, isHttps bool) (connect.IConn, *bufio.Reader, *http.Response, error) {
+	if isHttps {
+		scTls := tls.Client(sc, upstreamTLSConfig(req.Addr()))
+		sc.SetDeadline(time.Now().Add(connect.DefaultTimeOut))
+		if err := scTls.Handshake(); err != nil {
+			sc.Close()
+			return nil, nil, nil, fmt.Errorf("tls hand shake: %v", err)
+		}
+		sc.SetDeadline(time.Time{})
+		tc, err := connect.DefaultDecorateForTls(scTls, connect.TCP, sc.GetID())
+		if err != nil {
+			sc.Close()
+			return nil, nil, nil, err
+		}
+		sc = tc
+	}
+	sc.SetRecordID(recordID)
+	if err := hreq.Write(sc); err != nil {
+		sc.Close()
+		return nil, nil, nil, err
+	}
+	reader := bufio.NewReader(sc)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		sc.Close()
+		return nil, nil, nil, err
+	}
+	return sc, reader, resp, nil
+}
+
+// hedges in the last window are limited to max(min, ratio * requests)
+type retryBudget struct {
+	sync.Mutex
+	ratio    float64
+	min      int
+	stamps   [budgetWindow]int64
+	requests [budgetWindow]int
+	retries  [budgetWindow]int
+}
+
+func (b *retryBudget) bucket(now int64) int {
+	i := int(now % budgetWindow)
+	if b.stamps[i] != now {
+		b.stamps[i], b.requests[i], b.retries[i] = now, 0, 0
+	}
+	return i
+}
+
+func (b *retryBudget) request() {
+	b.Lock()
+	b.requests[b.bucket(time.Now().Unix())]++
+	b.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now().Unix()
+	i := b.bucket(now)
+	requests, retries := 0, 0
+	for j := range b.stamps {
+		if now-b.stamps[j] < budgetWindow {
+			requests += b.requests[j]
+			retries += b.retries[j]
+		}
+	}
+	allowed := int(b.ratio * float64(requests))
+	if allowed < b.min {
+		allowed = b.min
+	}
+	if retries >= allowed {
+		return false
+	}
+	b.retries[i]++
+	return true
+}
@@ -12,7 +12,6 @@ import (
 	"context"
 	"github.com/sipt/shuttle/config"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/proxy"
 	rule2 "github.com/sipt/shuttle/rule"
 	"github.com/sipt/shuttle/util"
@@ -41,6 +40,11 @@ func GetAllowDump() bool {
 	return allowDump
 }
 
+// dump new connections, off while load shedding
+func dumpEnabled() bool {
+	return allowDump && !isShedding()
+}
+
 // init MitMRules
 func SetMitMRules(rs []string) {
 	MitMRules = rs
@@ -68,22 +72,32 @@ func RemoveMitMRules(r string) { // For controller API
 }
 
 func HandleHTTP(co net.Conn) {
-	log.Logger.Debug("start conn.IConn wrap net.Con")
+	shuttleLog.Debug("start conn.IConn wrap net.Con")
 	conn, err := connect.NewDefaultConn(co, connect.TCP)
 	if err != nil {
-		log.Logger.Errorf("[HTTP] shuttle.IConn wrap net.Conn failed: %v", err)
+		httpLog.Errorf("[HTTP] shuttle.IConn wrap net.Conn failed: %v", err)
 		return
 	}
-	log.Logger.Debugf("[HTTP] [ID:%d] shuttle.IConn wrap net.Conn success", conn.GetID())
-	log.Logger.Debugf("[HTTP] [ID:%d] start read http request", conn.GetID())
+	httpLog.Debugf("[HTTP] [ID:%d] shuttle.IConn wrap net.Conn success", conn.GetID())
+	markSelfConn(co, conn, "HTTP")
+	httpLog.Debugf("[HTTP] [ID:%d] start read http request", conn.GetID())
 	//prepare request
 	hreq, err := prepareRequest(conn)
 	if err != nil {
 		if err != io.EOF {
-			log.Logger.Errorf("[HTTP] [ID:%d] prepareRequest failed: %s", conn.GetID(), err.Error())
+			httpLog.Errorf("[HTTP] [ID:%d] prepareRequest failed: %s", conn.GetID(), err.Error())
 		}
 		return
 	}
+	if AuthRequired() {
+		user, ok := httpProxyAuth(hreq)
+		if !ok {
+			httpLog.Errorf("[HTTP] [ID:%d] %s [%s]", conn.GetID(), ErrorAuthFailed.Error(), user)
+			replyProxyAuthRequired(conn)
+			return
+		}
+		setConnUser(conn, user)
+	}
 
 	//switch hreq.Proto {
 	//case "HTTP/2":
@@ -92,24 +106,49 @@ func HandleHTTP(co net.Conn) {
 	if hreq.URL.Scheme == HTTP { // HTTP
 		ProxyHTTP(conn, hreq)
 	} else { // HTTPS
+		setConnTag(conn, httpTag(hreq))
+		setConnPolicy(conn, httpPolicy(hreq))
 		ProxyHTTPS(conn, hreq)
 	}
 	//}
 }
 
 func ProxyHTTP(lc connect.IConn, hreq *http.Request) {
-	HttpTransport(lc, nil, allowDump, hreq)
+	HttpTransport(lc, nil, dumpEnabled(), hreq)
 }
 func ProxyHTTPS(lc connect.IConn, hreq *http.Request) {
 	// Handshake
 	_, err := lc.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
 	if err != nil {
-		log.Logger.Errorf("[HTTPS] [ID:%d] reply https-CONNECT failed: %s", lc.GetID(), err.Error())
+		httpsLog.Errorf("[HTTPS] [ID:%d] reply https-CONNECT failed: %s", lc.GetID(), err.Error())
+		lc.Close()
+		return
+	}
+	lc, host, err := sniffConnect(lc, hreq.URL.Hostname(), hreq.URL.Port())
+	if err != nil {
+		httpsLog.Errorf("[HTTPS] [ID:%d] sniff failed: %s", lc.GetID(), err.Error())
 		lc.Close()
 		return
 	}
+	if host != hreq.URL.Hostname() {
+		hreq.URL.Host = net.JoinHostPort(host, hreq.URL.Port())
+		hreq.Host = hreq.URL.Host
+	}
 	domain := hreq.URL.Hostname()
-	rule, server, sc, err := ConnectFilter(hreq, lc.GetID())
+	wc := connect.NewWatchedConn(lc)
+	lc = wc
+	var (
+		rule   *rule2.Rule
+		server *proxy.Server
+	)
+	sc, err := dialWatched(wc, func() (c connect.IConn, err error) {
+		rule, server, c, err = ConnectFilter(hreq, lc, connPolicy(lc))
+		return
+	})
+	if err == ErrorCanceled {
+		httpsLog.Debugf("[HTTPS] [ID:%d] [%s] %v while connecting", lc.GetID(), hreq.URL.Host, err)
+		return
+	}
 	record := &Record{
 		Protocol: HTTPS,
 		Created:  time.Now(),
@@ -117,6 +156,9 @@ func ProxyHTTPS(lc connect.IConn, hreq *http.Request) {
 		URL:      hreq.URL.String(),
 		Proxy:    server,
 		Rule:     rule,
+		User:     connUser(lc),
+		Tag:      connTag(lc),
+		Src:      srcString(lc.RemoteAddr()),
 	}
 	if hreq.URL.Scheme == "" {
 		record.URL = "https:" + record.URL
@@ -131,22 +173,25 @@ func ProxyHTTPS(lc connect.IConn, hreq *http.Request) {
 		}
 		record.ID = util.NextID()
 		boxChan <- &Box{Op: RecordAppend, Value: record}
+		lc.Close()
 		return
 	}
 	// MitM
 	mitm := false
-	if allowMitm {
+	if allowMitm && isShedding() {
+		httpsLog.Debugf("[HTTPS] [ID:%d] load shedding, no MitM for [%s]", lc.GetID(), domain)
+	} else if allowMitm {
 		for _, v := range MitMRules {
 			if v == "*" { // 通配
-				log.Logger.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
+				httpsLog.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
 				mitm = true
 				break
 			} else if v == domain { // 全区配
-				log.Logger.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
+				httpsLog.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
 				mitm = true
 				break
 			} else if v[0] == '*' && strings.HasSuffix(domain, v[1:]) { // 后缀匹配
-				log.Logger.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
+				httpsLog.Debugf("[HTTPS] [ID:%d] MitM RuleFilter [%s] use [%s]", lc.GetID(), domain, v)
 				mitm = true
 				break
 			}
@@ -154,10 +199,10 @@ func ProxyHTTPS(lc connect.IConn, hreq *http.Request) {
 	}
 	//MitM Decorate
 	if mitm {
-		log.Logger.Debugf("[HTTPS] [ID:%d] MitM Decorate", lc.GetID())
-		lct, sct, err := Mimt(lc, sc)
+		httpsLog.Debugf("[HTTPS] [ID:%d] MitM Decorate", lc.GetID())
+		lct, sct, proto, err := Mimt(lc, sc, domain)
 		if err != nil {
-			log.Logger.Error("[HTTPS] [ID:%d] MitM failed: %s", lc.GetID(), err.Error())
+			httpsLog.Error("[HTTPS] [ID:%d] MitM failed: %s", lc.GetID(), err.Error())
 			record.Status = RecordStatusFailed
 			boxChan <- &Box{Op: RecordAppend, Value: record}
 			lc.Close()
@@ -169,7 +214,11 @@ func ProxyHTTPS(lc connect.IConn, hreq *http.Request) {
 		ctx = context.WithValue(ctx, "rule", rule)
 		ctx = context.WithValue(ctx, "server", server)
 		sc.SetContext(ctx)
-		HttpTransport(lc, sc, allowDump, nil)
+		if proto == "h2" {
+			H2Transport(lc, sc, dumpEnabled())
+			return
+		}
+		HttpTransport(lc, sc, dumpEnabled(), nil)
 		return
 	}
 
@@ -191,7 +240,7 @@ func prepareRequest(conn connect.IConn) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Logger.Debugf("[ID:%d] [HTTP/HTTPS] %s:%s", conn.GetID(), hreq.URL.Hostname(), hreq.URL.Port())
+	httpLog.Debugf("[ID:%d] [HTTP/HTTPS] %s:%s", conn.GetID(), hreq.URL.Hostname(), hreq.URL.Port())
 	return hreq, nil
 }
 
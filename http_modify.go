@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/sipt/shuttle/config"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"github.com/sipt/shuttle/proxy"
 	"github.com/sipt/shuttle/rule"
@@ -143,6 +142,7 @@ func RequestModifyOrMock(req *HttpRequest, hreq *http.Request, isHttps bool) (re
 		respBuf = buffer.Bytes()
 		//mock record to storage
 		id := util.NextID()
+		dumped := dumpEnabled()
 		boxChan <- &Box{
 			Op: RecordAppend,
 			Value: &Record{
@@ -151,12 +151,12 @@ func RequestModifyOrMock(req *HttpRequest, hreq *http.Request, isHttps bool) (re
 				Created:  time.Now(),
 				Proxy:    proxy.MockServer,
 				Status:   RecordStatusCompleted,
-				Dumped:   allowDump,
+				Dumped:   dumped,
 				URL:      req.target,
 				Rule:     &rule.Rule{},
 			},
 		}
-		if allowDump {
+		if dumped {
 			go func(id int64, respBuf []byte) {
 				dump.InitDump(id)
 				writer := bytes.NewBuffer(pool.GetBuf()[:0])
@@ -207,24 +207,24 @@ func modifyMock(v *ModifyPolicy, req *http.Request, _ bool) *http.Response {
 		var err error
 		switch e.Type {
 		case ModifyTypeHeader:
-			log.Logger.Debugf("[Http Modify] [Mock] response set Header [%s:%s]", e.Key, e.Value)
+			httpModifyLog.Debugf("[Http Modify] [Mock] response set Header [%s:%s]", e.Key, e.Value)
 			resp.Header.Set(e.Key, e.Value)
 		case ModifyTypeBody:
 			file, err := os.Open(e.Value)
 			if err != nil {
-				log.Logger.Errorf("[HTTP MODIFY] open mock file failed: %v", err)
+				httpModifyLog.Errorf("[HTTP MODIFY] open mock file failed: %v", err)
 				return nil
 			}
 			status, err := file.Stat()
 			if err != nil {
-				log.Logger.Errorf("[HTTP MODIFY] read mock file [FileInfo] failed: %v", err)
+				httpModifyLog.Errorf("[HTTP MODIFY] read mock file [FileInfo] failed: %v", err)
 				return nil
 			}
-			log.Logger.Debugf("[Http Modify] [Mock] response set body [ContentLength:%d]", status.Size())
+			httpModifyLog.Debugf("[Http Modify] [Mock] response set body [ContentLength:%d]", status.Size())
 			resp.ContentLength = status.Size()
 			resp.Body = file
 		case ModifyTypeStatus:
-			log.Logger.Debugf("[Http Modify] [Mock] response set body [Status:%s]", e.Value)
+			httpModifyLog.Debugf("[Http Modify] [Mock] response set body [Status:%s]", e.Value)
 			resp.StatusCode, err = strconv.Atoi(e.Value)
 			if err != nil {
 				resp.StatusCode = 200
@@ -249,7 +249,7 @@ func modifyUpdate(v *ModifyPolicy, req *http.Request, isHttps bool) {
 			l = v.rex.ReplaceAllString(l, e.Value)
 			u, err := url.Parse(l)
 			if err != nil {
-				log.Logger.Errorf("[HTTP MODIFY] parse [%s] to url failed: %v", e.Value, err)
+				httpModifyLog.Errorf("[HTTP MODIFY] parse [%s] to url failed: %v", e.Value, err)
 				return
 			}
 			req.Host = u.Host
@@ -260,13 +260,13 @@ func modifyUpdate(v *ModifyPolicy, req *http.Request, isHttps bool) {
 				u.Host = ""
 			}
 			if req.URL.Scheme != u.Scheme {
-				log.Logger.Errorf("[HTTP MODIFY] not support [%s] to [%s]", req.URL.Scheme, u.Scheme)
+				httpModifyLog.Errorf("[HTTP MODIFY] not support [%s] to [%s]", req.URL.Scheme, u.Scheme)
 				return
 			}
-			log.Logger.Debugf("[Http Modify] [Update] response set URL [%s]", e.Value)
+			httpModifyLog.Debugf("[Http Modify] [Update] response set URL [%s]", e.Value)
 			req.URL = u
 		case ModifyTypeHeader:
-			log.Logger.Debugf("[Http Modify] [Update] response set Header [%s:%s]", e.Key, e.Value)
+			httpModifyLog.Debugf("[Http Modify] [Update] response set Header [%s:%s]", e.Key, e.Value)
 			req.Header.Set(e.Key, e.Value)
 		}
 	}
@@ -292,10 +292,10 @@ func ResponseModify(req *http.Request, resp *http.Response, isHttps bool) {
 			for _, e := range v.MVs {
 				switch e.Type {
 				case ModifyTypeHeader:
-					log.Logger.Debugf("[Http Modify] [Update] response set Header [%s, %s]", e.Key, e.Value)
+					httpModifyLog.Debugf("[Http Modify] [Update] response set Header [%s, %s]", e.Key, e.Value)
 					resp.Header.Set(e.Key, e.Value)
 				case ModifyTypeStatus:
-					log.Logger.Debugf("[Http Modify] [Update] response  [Status:%s]", e.Value)
+					httpModifyLog.Debugf("[Http Modify] [Update] response  [Status:%s]", e.Value)
 					code, err := strconv.Atoi(e.Value)
 					if err == nil {
 						resp.StatusCode = code
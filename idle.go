@@ -0,0 +1,115 @@
+package shuttle
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/rule"
+)
+
+const minIdleUnload = 10 * time.Second
+
+type IIdleUnloadConfig interface {
+	GetIdleUnload() string
+}
+
+// the profile of this process without new connections in [idle] drops the DNS cache (saved to dns-cache-file)
+// and the rules, they are loaded again on the next connection
+type idleUnloader struct {
+	idle time.Duration
+	done chan struct{}
+}
+
+var (
+	lastActive  int64 // unix nano of the last new connection
+	profileIdle int32
+	currentIdle *idleUnloader
+	idleLock    sync.Mutex
+)
+
+func ApplyIdleUnloadConfig(config IIdleUnloadConfig) error {
+	idle, err := parseIdleUnload(config.GetIdleUnload())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [idle-unload] failed: %v", err)
+	}
+	// the config is applied just now
+	activeProfile()
+	idleLock.Lock()
+	defer idleLock.Unlock()
+	if currentIdle != nil {
+		close(currentIdle.done)
+		currentIdle = nil
+	}
+	if idle == 0 {
+		return nil
+	}
+	currentIdle = &idleUnloader{idle: idle, done: make(chan struct{})}
+	go currentIdle.run()
+	return nil
+}
+
+// empty means off
+func parseIdleUnload(v string) (time.Duration, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < minIdleUnload {
+		return 0, fmt.Errorf("invalid duration [%s], at least %s", v, minIdleUnload)
+	}
+	return d, nil
+}
+
+func (u *idleUnloader) run() {
+	ticker := time.NewTicker(u.idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+			u.check()
+		}
+	}
+}
+
+func (u *idleUnloader) check() {
+	if atomic.LoadInt32(&profileIdle) == 1 ||
+		time.Now().Sub(time.Unix(0, atomic.LoadInt64(&lastActive))) < u.idle {
+		return
+	}
+	idleLock.Lock()
+	defer idleLock.Unlock()
+	select {
+	case <-u.done:
+		return // replaced by a new config
+	default:
+	}
+	if !atomic.CompareAndSwapInt32(&profileIdle, 0, 1) {
+		return
+	}
+	dns.SaveDNSCache()
+	dns.ClearDNSCache()
+	// a connection at the same time rebuilds the rules by itself
+	rule.Unload()
+	debug.FreeOSMemory()
+	idleLog.Infof("[Idle] no new connection in %s, DNS cache and rules are unloaded", u.idle)
+}
+
+// for every new connection, the rules are rebuilt on the first match
+func activeProfile() {
+	atomic.StoreInt64(&lastActive, time.Now().UnixNano())
+	if atomic.LoadInt32(&profileIdle) == 0 {
+		return
+	}
+	idleLock.Lock()
+	defer idleLock.Unlock()
+	if atomic.CompareAndSwapInt32(&profileIdle, 1, 0) {
+		dns.LoadDNSCache()
+		idleLog.Infof("[Idle] profile is active again")
+	}
+}
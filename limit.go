@@ -0,0 +1,55 @@
+package shuttle
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+type ILimitConfig interface {
+	GetMaxConnections() string
+}
+
+var (
+	maxConns    int64 // 0: unlimited
+	activeConns int64
+)
+
+func ApplyLimitConfig(config ILimitConfig) error {
+	n, err := parseLimit(config.GetMaxConnections())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [max-connections] failed: %v", err)
+	}
+	atomic.StoreInt64(&maxConns, int64(n))
+	return nil
+}
+
+// acquire a slot for a new inbound connection, return false when max-connections reached
+func AcquireConn() bool {
+	max := atomic.LoadInt64(&maxConns)
+	if n := atomic.AddInt64(&activeConns, 1); max > 0 && n > max {
+		atomic.AddInt64(&activeConns, -1)
+		return false
+	}
+	return true
+}
+
+func ReleaseConn() {
+	atomic.AddInt64(&activeConns, -1)
+}
+
+func ActiveConns() int64 {
+	return atomic.LoadInt64(&activeConns)
+}
+
+// empty means unlimited
+func parseLimit(v string) (int, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit [%s]", v)
+	}
+	return n, nil
+}
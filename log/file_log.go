@@ -8,6 +8,7 @@ import (
 	"time"
 	"io"
 	"strconv"
+	"sort"
 )
 
 func NewFileLogger(filePath string, level, multiSize int) (ILogger, error) {
@@ -82,23 +83,47 @@ func (l *FileLogger) Close() error {
 }
 
 type LogFile struct {
-	path      string
-	file      *os.File
-	multiSize int
-	size      int
-	index     int
+	path       string
+	file       *os.File
+	multiSize  int
+	maxAge     time.Duration // 0: no time based rotation
+	maxBackups int           // 0: keep all files
+	created    time.Time
+	size       int
+	index      int
 	sync.RWMutex
 }
 
+// log files in [filePath], rotated by size and age
+func NewLogFile(filePath string, multiSize int, maxAge time.Duration, maxBackups int) (*LogFile, error) {
+	if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lf := &LogFile{
+		path:       filePath,
+		multiSize:  multiSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	return lf, lf.Create()
+}
+
+func (l *LogFile) SetRotation(multiSize int, maxAge time.Duration, maxBackups int) {
+	l.Lock()
+	l.multiSize, l.maxAge, l.maxBackups = multiSize, maxAge, maxBackups
+	l.Unlock()
+}
+
 func (l *LogFile) Write(b []byte) (int, error) {
 	l.Lock()
 	defer l.Unlock()
 	n, err := l.file.Write(b)
 	l.size += n
-	if l.size > l.multiSize {
+	if l.size > l.multiSize || (l.maxAge > 0 && time.Now().Sub(l.created) > l.maxAge) {
 		l.file.Close()
 		l.Create()
 		l.size = 0
+		l.removeBackups()
 	}
 	return n, err
 }
@@ -111,9 +136,42 @@ func (l *LogFile) Create() error {
 		return err
 	}
 	l.file = file
+	l.created = time.Now()
 	return nil
 }
 
+// remove the oldest log files, keep [maxBackups] files besides the current one
+func (l *LogFile) removeBackups() {
+	if l.maxBackups <= 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(l.path, "*.log"))
+	if err != nil {
+		return
+	}
+	current := l.file.Name()
+	type backup struct {
+		path    string
+		modTime time.Time
+	}
+	backups := make([]backup, 0, len(files))
+	for _, f := range files {
+		if f == current {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil {
+			backups = append(backups, backup{f, info.ModTime()})
+		}
+	}
+	if len(backups) <= l.maxBackups {
+		return
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].modTime.After(backups[j].modTime) })
+	for _, b := range backups[l.maxBackups:] {
+		os.Remove(b.path)
+	}
+}
+
 func (l *LogFile) Close() error {
 	l.Lock()
 	defer l.Unlock()
@@ -2,6 +2,11 @@ package log
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,27 +18,36 @@ const (
 
 type ILogConfig interface {
 	GetLogLevel() string
+	GetLogModules() string
+	GetLogFormat() string
+	GetLogMaxSize() string
+	GetLogMaxAge() string
+	GetLogMaxBackups() string
+	GetLogTail() string
 }
 
+const (
+	defaultMaxSize = 100 // MB
+
+	DefaultOverrideDuration = 10 * time.Minute
+	MaxOverrideDuration     = 24 * time.Hour
+)
+
+var logFile *LogFile
+
 func InitLogger(logMode, logPath string) (err error) {
 	var l ILogger
 	switch logMode {
 	case LogModeOff:
-		l, err = NewSkipLogger()
-		if err != nil {
-			return errors.New("init logger failed:" + err.Error())
-		}
+		l = NewModuleLogger(nil, false)
 	case LogModeConsole:
-		l, err = NewStdLogger(LogInfo)
-		if err != nil {
-			return errors.New("init logger failed:" + err.Error())
-		}
+		l = NewModuleLogger(os.Stdout, true)
 	case LogModeFile:
-		//multiSize: 100MB
-		l, err = NewFileLogger(logPath, LogInfo, 100*1000*1000)
+		logFile, err = NewLogFile(logPath, defaultMaxSize*1000*1000, 0, 0)
 		if err != nil {
 			return errors.New("init logger failed:" + err.Error())
 		}
+		l = NewModuleLogger(logFile, false)
 	default:
 		return errors.New("not support LogMode:" + logMode)
 	}
@@ -41,15 +55,161 @@ func InitLogger(logMode, logPath string) (err error) {
 	return
 }
 
+// log to [out], e.g. a callback of an app embedding shuttle
+func InitLoggerOutput(out io.WriteCloser) {
+	Logger = NewModuleLogger(out, false)
+}
+
 func ApplyConfig(logConfig ILogConfig) error {
 	levelFlag, ok := LevelMap[logConfig.GetLogLevel()]
 	if !ok {
 		return errors.New("not support LogLevel:" + logConfig.GetLogLevel())
 	}
 	Logger.SetLevel(levelFlag)
+	l, ok := Logger.(*ModuleLogger)
+	if !ok {
+		return nil
+	}
+	modules, err := ParseModuleLevels(logConfig.GetLogModules())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [log-modules] failed: %v", err)
+	}
+	format := logConfig.GetLogFormat()
+	switch format {
+	case "":
+		format = LogFormatConsole
+	case LogFormatConsole, LogFormatJSON:
+	default:
+		return fmt.Errorf("resolve config file [General] [log-format] not support [%s]", format)
+	}
+	maxSize, maxAge, maxBackups, tail, err := ParseRotation(logConfig.GetLogMaxSize(), logConfig.GetLogMaxAge(),
+		logConfig.GetLogMaxBackups(), logConfig.GetLogTail())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] %v", err)
+	}
+	l.SetModuleLevels(modules)
+	l.SetFormat(format)
+	l.SetTailSize(tail)
+	if logFile != nil {
+		logFile.SetRotation(maxSize*1000*1000, maxAge, maxBackups)
+	}
+	return nil
+}
+
+// "dns=debug,rule=info" -> {"dns": LogDebug, "rule": LogInfo}
+func ParseModuleLevels(v string) (map[string]int, error) {
+	modules := make(map[string]int)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		i := strings.IndexByte(item, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("[%s] must be \"module=level\"", item)
+		}
+		level, ok := LevelMap[strings.TrimSpace(item[i+1:])]
+		if !ok {
+			return nil, fmt.Errorf("[%s] not support LogLevel [%s]", item, item[i+1:])
+		}
+		modules[strings.ToLower(strings.TrimSpace(item[:i]))] = level
+	}
+	return modules, nil
+}
+
+// max size in MB, max age as duration, count of backups and tail size, empty for default
+func ParseRotation(size, age, backups, tail string) (maxSize int, maxAge time.Duration, maxBackups, tailSize int, err error) {
+	maxSize, tailSize = defaultMaxSize, DefaultTailSize
+	if len(size) > 0 {
+		if maxSize, err = strconv.Atoi(size); err != nil || maxSize <= 0 {
+			return 0, 0, 0, 0, fmt.Errorf("[log-max-size] invalid [%s]", size)
+		}
+	}
+	if len(age) > 0 {
+		if maxAge, err = time.ParseDuration(age); err != nil || maxAge < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("[log-max-age] invalid [%s]", age)
+		}
+	}
+	if len(backups) > 0 {
+		if maxBackups, err = strconv.Atoi(backups); err != nil || maxBackups < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("[log-max-backups] invalid [%s]", backups)
+		}
+	}
+	if len(tail) > 0 {
+		if tailSize, err = strconv.Atoi(tail); err != nil || tailSize < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("[log-tail] invalid [%s]", tail)
+		}
+	}
+	return maxSize, maxAge, maxBackups, tailSize, nil
+}
+
+// last [n] log entries, see ModuleLogger.Tail
+func Tail(n int, module string, level int) []Entry {
+	if l, ok := Logger.(*ModuleLogger); ok {
+		return l.Tail(n, module, level)
+	}
+	return []Entry{}
+}
+
+type Levels struct {
+	Level    string            `json:"level"`
+	Modules  map[string]string `json:"modules"`
+	Override *Override         `json:"override"` // null: no override
+}
+
+type Override struct {
+	Level   string            `json:"level,omitempty"` // empty: the configured level
+	Modules map[string]string `json:"modules"`
+	Expires time.Time         `json:"expires"`
+}
+
+// change levels at runtime without touching config: [level] for default level (empty to keep the configured one),
+// [modules] like "dns=debug,rule=trace", reverted after [d] (0 for default), so debug is never left on
+func SetOverride(level, modules string, d time.Duration) error {
+	l, ok := Logger.(*ModuleLogger)
+	if !ok {
+		return errors.New("logger not support temporary levels")
+	}
+	levelFlag := -1
+	if len(level) > 0 {
+		if levelFlag, ok = LevelMap[level]; !ok {
+			return errors.New("not support LogLevel:" + level)
+		}
+	}
+	m, err := ParseModuleLevels(modules)
+	if err != nil {
+		return fmt.Errorf("modules %v", err)
+	}
+	if levelFlag < 0 && len(m) == 0 {
+		return errors.New("level and modules are empty")
+	}
+	if d == 0 {
+		d = DefaultOverrideDuration
+	}
+	if d < 0 || d > MaxOverrideDuration {
+		return fmt.Errorf("duration [%s] must be in (0, %s]", d, MaxOverrideDuration)
+	}
+	l.SetOverride(levelFlag, m, d)
+	logLog.Infof("[Log] temporary log levels: level [%s] modules [%s] until %s", level, modules, time.Now().Add(d).Format("2006-01-02 15:04:05"))
 	return nil
 }
 
+// revert to the configured levels, false if there is no override
+func ClearOverride() bool {
+	if l, ok := Logger.(*ModuleLogger); ok && l.ClearOverride() {
+		logLog.Infof("[Log] temporary log levels cleared, reverted to the configured ones")
+		return true
+	}
+	return false
+}
+
+func GetLevels() *Levels {
+	if l, ok := Logger.(*ModuleLogger); ok {
+		return l.Levels()
+	}
+	return &Levels{Modules: map[string]string{}}
+}
+
 var Logger ILogger = &StdLogger{Level: LogDebug}
 
 func SetLogger(logger ILogger) {
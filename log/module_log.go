@@ -0,0 +1,306 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+
+	DefaultTailSize = 1000
+)
+
+var levelNames = []string{"trace", "debug", "info", "error", "off"}
+
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module,omitempty"`
+	Message string    `json:"message"`
+}
+
+// logger with per-module levels, module is given by the named logger of a package, see Named.
+// module levels are looked up by hierarchy: "dns.cache" -> "dns" -> default level
+type ModuleLogger struct {
+	sync.RWMutex
+	level    int
+	modules  map[string]int
+	format   string
+	color    bool
+	out      io.WriteCloser // nil: no output, tail only
+	tail     *ring
+	override *override
+}
+
+func NewModuleLogger(out io.WriteCloser, color bool) *ModuleLogger {
+	return &ModuleLogger{
+		level:   LogInfo,
+		modules: make(map[string]int),
+		format:  LogFormatConsole,
+		color:   color,
+		out:     out,
+		tail:    newRing(DefaultTailSize),
+	}
+}
+
+func (l *ModuleLogger) SetLevel(level int) {
+	l.Lock()
+	l.level = level
+	l.Unlock()
+}
+
+// replace all module levels
+func (l *ModuleLogger) SetModuleLevels(modules map[string]int) {
+	m := make(map[string]int, len(modules))
+	for k, v := range modules {
+		m[strings.ToLower(k)] = v
+	}
+	l.Lock()
+	l.modules = m
+	l.Unlock()
+}
+
+func (l *ModuleLogger) SetFormat(format string) {
+	l.Lock()
+	l.format = format
+	l.Unlock()
+}
+
+func (l *ModuleLogger) SetTailSize(size int) {
+	l.Lock()
+	if l.tail.size() != size {
+		l.tail = newRing(size)
+	}
+	l.Unlock()
+}
+
+// last [n] entries of [module] (and its sub modules) at [level] or above, empty module for all
+func (l *ModuleLogger) Tail(n int, module string, level int) []Entry {
+	l.RLock()
+	entries := l.tail.list()
+	l.RUnlock()
+	module = strings.ToLower(module)
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if LevelMap[e.Level] < level {
+			continue
+		}
+		if len(module) > 0 && e.Module != module && !strings.HasPrefix(e.Module, module+".") {
+			continue
+		}
+		list = append(list, e)
+	}
+	if n > 0 && len(list) > n {
+		list = list[len(list)-n:]
+	}
+	return list
+}
+
+// module levels of override, then of config, then level of override, then of config
+func (l *ModuleLogger) enabled(level int, module string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	o := l.override
+	if o != nil {
+		if v, ok := lookupModule(o.modules, module); ok {
+			return level >= v
+		}
+	}
+	if v, ok := lookupModule(l.modules, module); ok {
+		return level >= v
+	}
+	if o != nil && o.level >= 0 {
+		return level >= o.level
+	}
+	return level >= l.level
+}
+
+func lookupModule(modules map[string]int, module string) (int, bool) {
+	for m := module; len(m) > 0; {
+		if v, ok := modules[m]; ok {
+			return v, true
+		}
+		i := strings.LastIndexByte(m, '.')
+		if i < 0 {
+			break
+		}
+		m = m[:i]
+	}
+	return 0, false
+}
+
+// temporary levels over the configured ones, never saved to config file
+type override struct {
+	level   int // -1: the configured level
+	modules map[string]int
+	expires time.Time
+	timer   *time.Timer
+}
+
+// replace the override, it is reverted after [d]
+func (l *ModuleLogger) SetOverride(level int, modules map[string]int, d time.Duration) {
+	o := &override{level: level, modules: make(map[string]int, len(modules)), expires: time.Now().Add(d)}
+	for k, v := range modules {
+		o.modules[strings.ToLower(k)] = v
+	}
+	l.Lock()
+	if l.override != nil {
+		l.override.timer.Stop()
+	}
+	l.override = o
+	o.timer = time.AfterFunc(d, func() {
+		if l.clearOverride(o) {
+			l.log(LogInfo, logModule, func() string { return "[Log] temporary log levels expired, reverted to the configured ones" })
+		}
+	})
+	l.Unlock()
+}
+
+// revert to the configured levels, false if there is no override
+func (l *ModuleLogger) ClearOverride() bool {
+	l.RLock()
+	o := l.override
+	l.RUnlock()
+	return o != nil && l.clearOverride(o)
+}
+
+// clear [o] if it is still the current one
+func (l *ModuleLogger) clearOverride(o *override) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.override != o {
+		return false
+	}
+	o.timer.Stop()
+	l.override = nil
+	return true
+}
+
+// configured levels and the override
+func (l *ModuleLogger) Levels() *Levels {
+	l.RLock()
+	defer l.RUnlock()
+	levels := &Levels{Level: levelNames[l.level], Modules: levelsOf(l.modules)}
+	if o := l.override; o != nil {
+		levels.Override = &Override{Modules: levelsOf(o.modules), Expires: o.expires}
+		if o.level >= 0 {
+			levels.Override.Level = levelNames[o.level]
+		}
+	}
+	return levels
+}
+
+func levelsOf(modules map[string]int) map[string]string {
+	m := make(map[string]string, len(modules))
+	for k, v := range modules {
+		m[k] = levelNames[v]
+	}
+	return m
+}
+
+func (l *ModuleLogger) log(level int, module string, msg func() string) {
+	if !l.enabled(level, module) {
+		return
+	}
+	e := Entry{
+		Time:    time.Now(),
+		Level:   levelNames[level],
+		Module:  module,
+		Message: msg(),
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.tail.put(e)
+	if l.out == nil {
+		return
+	}
+	var line []byte
+	if l.format == LogFormatJSON {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else if l.color {
+		line = []byte(fmt.Sprintf("%s %c[1;0;%dm[%s]%c[0m %s\n", e.Time.Format("2006-01-02 15:04:05"),
+			0x1B, levelColors[level], strings.ToUpper(e.Level), 0x1B, e.Message))
+	} else {
+		line = []byte(fmt.Sprintf("%s [%s] %s\n", e.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(e.Level), e.Message))
+	}
+	l.out.Write(line)
+}
+
+var levelColors = []int{33, 34, 32, 31, 0}
+
+func (l *ModuleLogger) Trace(params ...interface{}) {
+	l.log(LogTrace, "", func() string { return fmt.Sprint(params...) })
+}
+func (l *ModuleLogger) Debug(params ...interface{}) {
+	l.log(LogDebug, "", func() string { return fmt.Sprint(params...) })
+}
+func (l *ModuleLogger) Info(params ...interface{}) {
+	l.log(LogInfo, "", func() string { return fmt.Sprint(params...) })
+}
+func (l *ModuleLogger) Error(params ...interface{}) {
+	l.log(LogError, "", func() string { return fmt.Sprint(params...) })
+}
+func (l *ModuleLogger) Tracef(format string, params ...interface{}) {
+	l.log(LogTrace, "", func() string { return fmt.Sprintf(format, params...) })
+}
+func (l *ModuleLogger) Debugf(format string, params ...interface{}) {
+	l.log(LogDebug, "", func() string { return fmt.Sprintf(format, params...) })
+}
+func (l *ModuleLogger) Infof(format string, params ...interface{}) {
+	l.log(LogInfo, "", func() string { return fmt.Sprintf(format, params...) })
+}
+func (l *ModuleLogger) Errorf(format string, params ...interface{}) {
+	l.log(LogError, "", func() string { return fmt.Sprintf(format, params...) })
+}
+
+func (l *ModuleLogger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	return l.out.Close()
+}
+
+// ring buffer of last entries
+type ring struct {
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func newRing(size int) *ring {
+	return &ring{entries: make([]Entry, size)}
+}
+
+func (r *ring) size() int {
+	return len(r.entries)
+}
+
+func (r *ring) put(e Entry) {
+	if len(r.entries) == 0 {
+		return
+	}
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+func (r *ring) list() []Entry {
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	list := make([]Entry, 0, len(r.entries))
+	list = append(list, r.entries[r.next:]...)
+	return append(list, r.entries[:r.next]...)
+}
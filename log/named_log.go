@@ -0,0 +1,55 @@
+package log
+
+import "fmt"
+
+const logModule = "log"
+
+var logLog = Named(logModule)
+
+// logger of a module, e.g. a package: var logger = log.Named("dns").
+// it writes to the current Logger, so it can be declared before InitLogger
+type NamedLogger struct {
+	module string
+}
+
+func Named(module string) *NamedLogger {
+	return &NamedLogger{module: module}
+}
+
+func (n *NamedLogger) Module() string {
+	return n.module
+}
+
+// the module of a ModuleLogger, else the plain logger
+func (n *NamedLogger) log(level int, msg func() string, plain func(ILogger)) {
+	if l, ok := Logger.(*ModuleLogger); ok {
+		l.log(level, n.module, msg)
+		return
+	}
+	plain(Logger)
+}
+
+func (n *NamedLogger) Trace(params ...interface{}) {
+	n.log(LogTrace, func() string { return fmt.Sprint(params...) }, func(l ILogger) { l.Trace(params...) })
+}
+func (n *NamedLogger) Debug(params ...interface{}) {
+	n.log(LogDebug, func() string { return fmt.Sprint(params...) }, func(l ILogger) { l.Debug(params...) })
+}
+func (n *NamedLogger) Info(params ...interface{}) {
+	n.log(LogInfo, func() string { return fmt.Sprint(params...) }, func(l ILogger) { l.Info(params...) })
+}
+func (n *NamedLogger) Error(params ...interface{}) {
+	n.log(LogError, func() string { return fmt.Sprint(params...) }, func(l ILogger) { l.Error(params...) })
+}
+func (n *NamedLogger) Tracef(format string, params ...interface{}) {
+	n.log(LogTrace, func() string { return fmt.Sprintf(format, params...) }, func(l ILogger) { l.Tracef(format, params...) })
+}
+func (n *NamedLogger) Debugf(format string, params ...interface{}) {
+	n.log(LogDebug, func() string { return fmt.Sprintf(format, params...) }, func(l ILogger) { l.Debugf(format, params...) })
+}
+func (n *NamedLogger) Infof(format string, params ...interface{}) {
+	n.log(LogInfo, func() string { return fmt.Sprintf(format, params...) }, func(l ILogger) { l.Infof(format, params...) })
+}
+func (n *NamedLogger) Errorf(format string, params ...interface{}) {
+	n.log(LogError, func() string { return fmt.Sprintf(format, params...) }, func(l ILogger) { l.Errorf(format, params...) })
+}
@@ -0,0 +1,31 @@
+package shuttle
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var (
+	assertionsLog   = log.Named("assertions")
+	canaryLog       = log.Named("canary")
+	flowExportLog   = log.Named("flow-export")
+	hedgeLog        = log.Named("hedge")
+	httpLog         = log.Named("http")
+	httpModifyLog   = log.Named("http-modify")
+	httpsLog        = log.Named("https")
+	idleLog         = log.Named("idle")
+	loadSheddingLog = log.Named("load-shedding")
+	mirrorLog       = log.Named("mirror")
+	multicastLog    = log.Named("multicast")
+	processLog      = log.Named("process")
+	reloadLog       = log.Named("reload")
+	resumeLog       = log.Named("resume")
+	routeExportLog  = log.Named("route-export")
+	ruleLog         = log.Named("rule")
+	shuntLog        = log.Named("shunt")
+	shuttleLog      = log.Named("shuttle")
+	sniLog          = log.Named("sni")
+	sniffLog        = log.Named("sniff")
+	socketLog       = log.Named("socket")
+	socksLog        = log.Named("socks")
+	storageLog      = log.Named("storage")
+	supervisorLog   = log.Named("supervisor")
+)
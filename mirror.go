@@ -0,0 +1,320 @@
+package shuttle
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+)
+
+const (
+	MirrorEventFlow     = "flow"
+	MirrorEventRequest  = "request"
+	MirrorEventResponse = "response"
+	MirrorEventClose    = "close"
+
+	MirrorSinkFile = "file"
+	MirrorSinkUnix = "unix"
+	MirrorSinkTCP  = "tcp"
+	MirrorSinkUDP  = "udp"
+
+	defaultMirrorSample  = 0.01
+	defaultMirrorMaxBody = 64 * 1024
+	mirrorQueueSize      = 256
+)
+
+type IMirrorConfig interface {
+	GetMirror() *config.Mirror
+}
+
+// one line of JSON written to the sink
+type MirrorEvent struct {
+	Type     string    `json:"type"`
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Rule     string    `json:"rule,omitempty"`
+	Server   string    `json:"server,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Tag      string    `json:"tag,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Up       int       `json:"up,omitempty"`
+	Down     int       `json:"down,omitempty"`
+	Data     []byte    `json:"data,omitempty"`
+}
+
+type mirror struct {
+	network string
+	addr    string
+	sample  float64
+	hosts   []string
+	http    bool
+	maxBody int
+	queue   chan *MirrorEvent
+	done    chan struct{}
+}
+
+var (
+	currentMirror *mirror
+	mirrorLock    sync.RWMutex
+)
+
+func ApplyMirrorConfig(config IMirrorConfig) error {
+	m, err := parseMirror(config.GetMirror())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Mirror] failed: %v", err)
+	}
+	mirrorLock.Lock()
+	old := currentMirror
+	currentMirror = m
+	mirrorLock.Unlock()
+	if old != nil {
+		close(old.done)
+	}
+	if m != nil {
+		go m.run()
+		mirrorLog.Infof("[Mirror] mirror %v%% flows of %v to %s://%s", m.sample*100, m.hosts, m.network, m.addr)
+	}
+	return nil
+}
+
+// nil config or empty sink means mirror off
+func parseMirror(c *config.Mirror) (*mirror, error) {
+	if c == nil || len(c.Sink) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Sink)
+	if err != nil {
+		return nil, fmt.Errorf("sink [%s] %v", c.Sink, err)
+	}
+	m := &mirror{
+		network: u.Scheme,
+		sample:  defaultMirrorSample,
+		hosts:   c.Hosts,
+		http:    c.HTTP == "true",
+		maxBody: defaultMirrorMaxBody,
+		queue:   make(chan *MirrorEvent, mirrorQueueSize),
+		done:    make(chan struct{}),
+	}
+	switch m.network {
+	case MirrorSinkFile, MirrorSinkUnix:
+		m.addr = u.Path
+	case MirrorSinkTCP, MirrorSinkUDP:
+		m.addr = u.Host
+		if _, _, err := net.SplitHostPort(m.addr); err != nil {
+			return nil, fmt.Errorf("sink [%s] %v", c.Sink, err)
+		}
+	default:
+		return nil, fmt.Errorf("sink [%s] not support [%s]", c.Sink, m.network)
+	}
+	if len(m.addr) == 0 {
+		return nil, fmt.Errorf("sink [%s] address is empty", c.Sink)
+	}
+	if len(m.hosts) == 0 {
+		return nil, fmt.Errorf("hosts is empty, use [\"*\"] to mirror all hosts")
+	}
+	if len(c.Sample) > 0 {
+		m.sample, err = strconv.ParseFloat(c.Sample, 64)
+		if err != nil || m.sample <= 0 || m.sample > 1 {
+			return nil, fmt.Errorf("sample [%s] must be in (0, 1]", c.Sample)
+		}
+	}
+	if len(c.MaxBody) > 0 {
+		m.maxBody, err = strconv.Atoi(c.MaxBody)
+		if err != nil || m.maxBody < 0 {
+			return nil, fmt.Errorf("max-body [%s] is invalid", c.MaxBody)
+		}
+	}
+	return m, nil
+}
+
+func getMirror() *mirror {
+	mirrorLock.RLock()
+	defer mirrorLock.RUnlock()
+	return currentMirror
+}
+
+// sampling is decided by record id, so every part of a flow gets the same result
+func (m *mirror) selected(r *Record) bool {
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatInt(r.ID, 10)))
+	if float64(h.Sum32()%10000) >= m.sample*10000 {
+		return false
+	}
+	host := recordHost(r.URL)
+	for _, v := range m.hosts {
+		if v == "*" || host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// never block the caller, drop the event when the sink is slow
+func (m *mirror) push(e *MirrorEvent) {
+	select {
+	case m.queue <- e:
+	default:
+		mirrorLog.Debugf("[Mirror] queue is full, drop [%s] of [%d]", e.Type, e.ID)
+	}
+}
+
+func (m *mirror) run() {
+	var w io.WriteCloser
+	defer func() {
+		if w != nil {
+			w.Close()
+		}
+	}()
+	for {
+		select {
+		case <-m.done:
+			return
+		case e := <-m.queue:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if w == nil {
+				if w, err = m.open(); err != nil {
+					mirrorLog.Errorf("[Mirror] open sink [%s://%s] failed: %v", m.network, m.addr, err)
+					w = nil
+					continue
+				}
+			}
+			if _, err = w.Write(append(data, '\n')); err != nil {
+				mirrorLog.Errorf("[Mirror] write sink [%s://%s] failed: %v", m.network, m.addr, err)
+				w.Close()
+				w = nil
+			}
+		}
+	}
+}
+
+func (m *mirror) open() (io.WriteCloser, error) {
+	if m.network == MirrorSinkFile {
+		return os.OpenFile(m.addr, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	return net.DialTimeout(m.network, m.addr, time.Second)
+}
+
+// mirror metadata of a new record
+func mirrorRecord(r *Record) {
+	m := getMirror()
+	if m == nil || !m.selected(r) {
+		return
+	}
+	e := &MirrorEvent{
+		Type:     MirrorEventFlow,
+		ID:       r.ID,
+		Time:     r.Created,
+		Protocol: r.Protocol,
+		URL:      r.URL,
+		User:     r.User,
+		Tag:      r.Tag,
+		Status:   r.Status,
+	}
+	if r.Rule != nil {
+		e.Rule = r.Rule.Type + "," + r.Rule.Value + "," + r.Rule.Policy
+	}
+	if r.Proxy != nil {
+		e.Server = r.Proxy.Name
+	}
+	m.push(e)
+}
+
+// mirror the final status and traffic of a record
+func mirrorStatus(id int64, status string) {
+	m := getMirror()
+	if m == nil || status == RecordStatusActive {
+		return
+	}
+	r := storage.Get(id)
+	if r == nil || !m.selected(r) {
+		return
+	}
+	m.push(&MirrorEvent{
+		Type:   MirrorEventClose,
+		ID:     id,
+		Time:   time.Now(),
+		Status: status,
+		Up:     r.Up,
+		Down:   r.Down,
+	})
+}
+
+// buffer of HTTP request or response data, nil if the record is not mirrored
+func mirrorWriter(r *Record, typ string) *mirrorBuffer {
+	m := getMirror()
+	if m == nil || !m.http || m.maxBody == 0 || isShedding() || !m.selected(r) {
+		return nil
+	}
+	return &mirrorBuffer{m: m, id: r.ID, typ: typ}
+}
+
+// keep at most max-body bytes, pushed as one event on Flush
+type mirrorBuffer struct {
+	m    *mirror
+	id   int64
+	typ  string
+	data []byte
+}
+
+func (b *mirrorBuffer) Write(p []byte) (int, error) {
+	if remain := b.m.maxBody - len(b.data); remain > 0 {
+		if len(p) > remain {
+			b.data = append(b.data, p[:remain]...)
+		} else {
+			b.data = append(b.data, p...)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *mirrorBuffer) Flush() {
+	if b == nil || len(b.data) == 0 {
+		return
+	}
+	b.m.push(&MirrorEvent{
+		Type: b.typ,
+		ID:   b.id,
+		Time: time.Now(),
+		Data: b.data,
+	})
+	b.data = nil
+}
+
+// host of record url: "http://host:port/path" or "host:port"
+func recordHost(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	if i := strings.IndexByte(u, '/'); i >= 0 {
+		u = u[:i]
+	}
+	if host, _, err := net.SplitHostPort(u); err == nil {
+		return host
+	}
+	return u
+}
+
+// combine dump and mirror writers
+func mirrorDumpWriter(dump io.Writer, b *mirrorBuffer) io.Writer {
+	if b == nil {
+		return dump
+	}
+	if dump == nil {
+		return b
+	}
+	return io.MultiWriter(dump, b)
+}
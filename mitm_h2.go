@@ -0,0 +1,243 @@
+package shuttle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/pool"
+	"github.com/sipt/shuttle/proxy"
+	rule2 "github.com/sipt/shuttle/rule"
+	"github.com/sipt/shuttle/util"
+	"golang.org/x/net/http2"
+)
+
+// MitM of HTTP/2 negotiated by both ends, e.g. gRPC: the streams are proxied over one upstream
+// connection, each one is a record. the dump is HTTP/1.1 with chunked bodies and trailers
+func H2Transport(lc, sc connect.IConn, allowDump bool) {
+	defer func() {
+		lc.Close()
+		sc.Close()
+	}()
+	ctx := sc.Context()
+	h := &H2Channel{
+		lc:        lc,
+		sc:        sc,
+		allowDump: allowDump,
+	}
+	h.rule, _ = ctx.Value("rule").(*rule2.Rule)
+	h.server, _ = ctx.Value("server").(*proxy.Server)
+	var err error
+	h.cc, err = (&http2.Transport{}).NewClientConn(sc)
+	if err != nil {
+		httpLog.Errorf("[ID:%d] [H2Channel] H2Channel Transport upstream: %v", sc.GetID(), err)
+		return
+	}
+	(&http2.Server{}).ServeConn(lc, &http2.ServeConnOpts{Handler: h})
+}
+
+type H2Channel struct {
+	lc, sc    connect.IConn
+	cc        *http2.ClientConn
+	rule      *rule2.Rule
+	server    *proxy.Server
+	allowDump bool
+}
+
+func (h *H2Channel) ServeHTTP(w http.ResponseWriter, hreq *http.Request) {
+	tag := httpTag(hreq)
+	if len(tag) == 0 {
+		tag = connTag(h.lc)
+	}
+	// the upstream connection is shared by the streams, the policy of the connection applies
+	httpPolicy(hreq)
+	hreq.URL.Scheme, hreq.URL.Host = HTTPS, hreq.Host
+	hreq.RequestURI = ""
+	resp := RequestModify(hreq, true)
+	record := &Record{
+		ID:       util.NextID(),
+		Protocol: HTTPS,
+		URL:      hreq.URL.String(),
+		Status:   RecordStatusActive,
+		Created:  time.Now(),
+		Dumped:   h.allowDump,
+		Rule:     h.rule,
+		Proxy:    h.server,
+		User:     connUser(h.lc),
+		Tag:      tag,
+		Src:      srcString(h.lc.RemoteAddr()),
+	}
+	if resp != nil {
+		record.Rule = rule2.MockRule
+		record.Proxy = proxy.MockServer
+	}
+	boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+	h.sc.SetRecordID(record.ID)
+	httpLog.Debugf("[ID:%d] [H2Channel] [reqID:%d] H2Channel Transport c->[hreq]: %s", h.lc.GetID(), record.ID, record.URL)
+
+	// dump
+	var reqDump, respDump io.Writer
+	if h.allowDump {
+		dump.InitDump(record.ID)
+		reqDump = ToWriter(func(b []byte) (int, error) {
+			return dump.WriteRequest(record.ID, b)
+		})
+		respDump = ToWriter(func(b []byte) (int, error) {
+			return dump.WriteResponse(record.ID, b)
+		})
+	}
+	reqMirror := mirrorWriter(record, MirrorEventRequest)
+	respMirror := mirrorWriter(record, MirrorEventResponse)
+	reqDump = mirrorDumpWriter(reqDump, reqMirror)
+	respDump = mirrorDumpWriter(respDump, respMirror)
+	status := RecordStatusCompleted
+	defer func() {
+		reqMirror.Flush()
+		respMirror.Flush()
+		if h.allowDump {
+			go func() {
+				dump.Complete(record.ID)
+			}()
+		}
+		boxChan <- &Box{record.ID, RecordStatus, status}
+	}()
+
+	if reqDump != nil {
+		chunked := hasBody(hreq.Body)
+		dumpHead(reqDump, fmt.Sprintf("%s %s HTTP/1.1", hreq.Method, hreq.URL.RequestURI()), hreq.Host, hreq.Header, chunked)
+		if chunked {
+			hreq.Body = &chunkedDump{ReadCloser: hreq.Body, w: reqDump, trailer: func() http.Header { return hreq.Trailer }}
+		}
+	}
+	var err error
+	if resp != nil {
+		// response mock, the request is not sent
+		io.Copy(ioutil.Discard, hreq.Body)
+		hreq.Body.Close()
+		if resp.Body == nil {
+			resp.Body = http.NoBody
+		}
+	} else {
+		resp, err = h.cc.RoundTrip(hreq)
+		if err != nil {
+			httpLog.Errorf("[ID:%d] [H2Channel] H2Channel Transport [hreq]->s: %v", h.sc.GetID(), err)
+			status = RecordStatusFailed
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// the rest is requested over HTTP/1.1, the shared upstream connection is kept for other streams
+		if resumed := resumeResponse(hreq, resp, h.lc, h.rule, connPolicy(h.lc), record.ID, true); resumed != nil {
+			defer resumed.closeConn()
+		}
+		ResponseModify(hreq, resp, true)
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(resp.StatusCode)
+	body := io.Reader(resp.Body)
+	if respDump != nil {
+		chunked := hasBody(resp.Body)
+		dumpHead(respDump, fmt.Sprintf("HTTP/1.1 %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)), "", resp.Header, chunked)
+		if chunked {
+			body = &chunkedDump{ReadCloser: resp.Body, w: respDump, trailer: func() http.Header { return resp.Trailer }}
+		}
+	}
+	// streamed: every message is flushed to the client
+	buf := pool.GetBuf()
+	defer pool.PutBuf(buf)
+	flusher, _ := w.(http.Flusher)
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if _, err = w.Write(buf[:n]); err != nil {
+				status = RecordStatusReject
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			httpLog.Errorf("[ID:%d] [H2Channel] H2Channel Transport s->[b]: %v", h.sc.GetID(), rerr)
+			status = RecordStatusFailed
+			// reset the stream of the client
+			panic(http.ErrAbortHandler)
+		}
+	}
+	for k, vs := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+k] = vs
+	}
+}
+
+func hasBody(body io.ReadCloser) bool {
+	return body != nil && body != http.NoBody
+}
+
+// head of HTTP/1.1, chunked for bodies of unknown length
+func dumpHead(w io.Writer, first, host string, header http.Header, chunked bool) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(first + "\r\n")
+	if len(host) > 0 {
+		buf.WriteString("Host: " + host + "\r\n")
+	}
+	exclude := map[string]bool{"Transfer-Encoding": true}
+	if chunked {
+		exclude["Content-Length"] = true
+	}
+	header.WriteSubset(buf, exclude)
+	if chunked {
+		buf.WriteString("Transfer-Encoding: chunked\r\n")
+	}
+	buf.WriteString("\r\n")
+	w.Write(buf.Bytes())
+}
+
+// body copied to [w] in chunks, the last chunk and trailers on EOF or close
+type chunkedDump struct {
+	io.ReadCloser
+	w       io.Writer
+	trailer func() http.Header
+	once    sync.Once
+}
+
+func (c *chunkedDump) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		// the dump keeps the slice
+		chunk := make([]byte, 0, n+16)
+		chunk = append(chunk, fmt.Sprintf("%x\r\n", n)...)
+		chunk = append(chunk, p[:n]...)
+		c.w.Write(append(chunk, "\r\n"...))
+	}
+	if err == io.EOF {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *chunkedDump) Close() error {
+	c.finish()
+	return c.ReadCloser.Close()
+}
+
+func (c *chunkedDump) finish() {
+	c.once.Do(func() {
+		buf := bytes.NewBufferString("0\r\n")
+		if t := c.trailer(); len(t) > 0 {
+			t.Write(buf)
+		}
+		buf.WriteString("\r\n")
+		c.w.Write(buf.Bytes())
+	})
+}
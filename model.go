@@ -3,17 +3,20 @@ package shuttle
 import (
 	"github.com/sipt/shuttle/conn"
 	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/extension/process"
 	"net"
 	"strconv"
 )
 
 const (
-	CmdTCP = 0x01
-	CmdUDP = 0x03
+	CmdTCP  = 0x01
+	CmdBind = 0x02
+	CmdUDP  = 0x03
 
 	ProtocolSocks = "SOCKS"
 	ProtocolHttp  = "HTTP"
 	ProtocolHttps = "HTTPS"
+	ProtocolSNI   = "SNI"
 
 	AddrTypeIPv4   = 0x01 //    0x01：IPv4
 	AddrTypeDomain = 0x03 //    0x03：域名
@@ -34,11 +37,18 @@ type SocksRequest struct {
 	target   string
 	connID   int64
 	answer   *dns.Answer
+	srcAddr  net.Addr
+	process  *process.Process
+	looked   bool
+	sniffed  string //sniffed domain, only for rule
+	user     string //authenticated user of inbound
+	tag      string //tag of inbound, only for records
+	policy   string //policy of the request, see request_policy.go
 }
 
 func (r *SocksRequest) Network() string {
 	switch r.cmd {
-	case CmdTCP:
+	case CmdTCP, CmdBind:
 		return conn.TCP
 	case CmdUDP:
 		return conn.UDP
@@ -46,6 +56,9 @@ func (r *SocksRequest) Network() string {
 	return ""
 }
 func (r *SocksRequest) Domain() string {
+	if len(r.addr) == 0 {
+		return r.sniffed
+	}
 	return r.addr
 }
 func (r *SocksRequest) IP() string {
@@ -75,6 +88,25 @@ func (r *SocksRequest) ID() int64 {
 	return r.connID
 }
 
+//return local process which created the request
+func (r *SocksRequest) Process() *process.Process {
+	if !r.looked {
+		r.looked = true
+		r.process = lookupProcess(r.connID, r.Network(), r.srcAddr)
+	}
+	return r.process
+}
+
+//return authenticated user of inbound
+func (r *SocksRequest) User() string {
+	return r.user
+}
+
+//return policy of the request
+func (r *SocksRequest) Policy() string {
+	return r.policy
+}
+
 //return domain!=""?domain:ip
 func (r *SocksRequest) Addr() string {
 	if len(r.addr) > 0 {
@@ -92,7 +124,7 @@ func (r *SocksRequest) Host() string {
 }
 
 func NewHttpRequest(network string, domain string, ip string, port string, protocol string,
-	target string, connID int64, answer *dns.Answer) *HttpRequest {
+	target string, connID int64, answer *dns.Answer, srcAddr net.Addr) *HttpRequest {
 	return &HttpRequest{
 		network:  network,
 		domain:   domain,
@@ -102,6 +134,7 @@ func NewHttpRequest(network string, domain string, ip string, port string, proto
 		target:   target,
 		connID:   connID,
 		answer:   answer,
+		srcAddr:  srcAddr,
 	}
 }
 
@@ -115,12 +148,21 @@ type HttpRequest struct {
 	target   string
 	connID   int64
 	answer   *dns.Answer
+	srcAddr  net.Addr
+	process  *process.Process
+	looked   bool
+	user     string
+	sniffed  string //sniffed domain, only for rule
+	policy   string //policy of the request, see request_policy.go
 }
 
 func (r *HttpRequest) Network() string {
 	return r.network
 }
 func (r *HttpRequest) Domain() string {
+	if len(r.domain) == 0 {
+		return r.sniffed
+	}
 	return r.domain
 }
 func (r *HttpRequest) IP() string {
@@ -156,6 +198,25 @@ func (r *HttpRequest) ID() int64 {
 	return r.connID
 }
 
+//return local process which created the request
+func (r *HttpRequest) Process() *process.Process {
+	if !r.looked {
+		r.looked = true
+		r.process = lookupProcess(r.connID, r.network, r.srcAddr)
+	}
+	return r.process
+}
+
+//return authenticated user of inbound
+func (r *HttpRequest) User() string {
+	return r.user
+}
+
+//return policy of the request
+func (r *HttpRequest) Policy() string {
+	return r.policy
+}
+
 //return domain!=""?domain:ip
 func (r *HttpRequest) Addr() string {
 	if len(r.domain) > 0 {
@@ -171,3 +232,13 @@ func (r *HttpRequest) Host() string {
 	}
 	return net.JoinHostPort(r.Addr(), r.Port())
 }
+
+func lookupProcess(connID int64, network string, srcAddr net.Addr) *process.Process {
+	p, err := process.Lookup(network, srcAddr)
+	if err != nil {
+		processLog.Debugf("[Process] [ID:%d] lookup process of [%v] failed: %v", connID, srcAddr, err)
+		return nil
+	}
+	processLog.Debugf("[Process] [ID:%d] [%v] -> [%d] [%s]", connID, srcAddr, p.PID, p.Path)
+	return p
+}
@@ -0,0 +1,354 @@
+package shuttle
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	multicastBufSize      = 64 * 1024
+	multicastReplyTimeout = 30 * time.Second
+)
+
+// well-known discovery groups, replies means the answers are unicast to the requester
+var multicastGroups = map[string]*multicastGroup{
+	"mdns": {name: "mdns", addr: &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}},
+	"ssdp": {name: "ssdp", addr: &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}, replies: true},
+}
+
+type IMulticastRelayConfig interface {
+	GetMulticastRelay() *config.MulticastRelay
+}
+
+type multicastGroup struct {
+	name    string
+	addr    *net.UDPAddr
+	replies bool
+}
+
+// reflect multicast and broadcast packets between interfaces, so discovery works across the segments
+// behind the gateway
+type multicastRelay struct {
+	interfaces []*net.Interface
+	groups     []*multicastGroup
+	broadcast  []int
+
+	local    map[string]bool // addresses of this host, packets from them are not relayed again
+	conns    []*ipv4.PacketConn
+	sessions map[string]*replySession
+	sync.Mutex
+}
+
+var (
+	currentMulticastRelay *multicastRelay
+	multicastRelayLock    sync.Mutex
+)
+
+func ApplyMulticastRelayConfig(config IMulticastRelayConfig) error {
+	r, err := parseMulticastRelay(config.GetMulticastRelay())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Multicast-Relay] failed: %v", err)
+	}
+	multicastRelayLock.Lock()
+	defer multicastRelayLock.Unlock()
+	if currentMulticastRelay != nil {
+		currentMulticastRelay.stop()
+	}
+	currentMulticastRelay = r
+	if r != nil {
+		r.start()
+	}
+	return nil
+}
+
+// nil config or empty groups and broadcast means relay off
+func parseMulticastRelay(c *config.MulticastRelay) (*multicastRelay, error) {
+	if c == nil || len(c.Groups)+len(c.Broadcast) == 0 {
+		return nil, nil
+	}
+	if len(c.Interfaces) < 2 {
+		return nil, fmt.Errorf("at least 2 interfaces are required")
+	}
+	r := &multicastRelay{sessions: make(map[string]*replySession)}
+	for _, name := range c.Interfaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface [%s] not found", name)
+		}
+		r.interfaces = append(r.interfaces, ifi)
+	}
+	for _, v := range c.Groups {
+		g, ok := multicastGroups[v]
+		if !ok {
+			addr, err := net.ResolveUDPAddr("udp4", v)
+			if err != nil || addr.IP.To4() == nil || !addr.IP.IsMulticast() || addr.Port == 0 {
+				return nil, fmt.Errorf("group [%s] is invalid, support: mdns, ssdp or [ipv4 multicast address:port]", v)
+			}
+			g = &multicastGroup{name: v, addr: addr}
+		}
+		r.groups = append(r.groups, g)
+	}
+	for _, v := range c.Broadcast {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("broadcast port [%s] is invalid", v)
+		}
+		r.broadcast = append(r.broadcast, port)
+	}
+	return r, nil
+}
+
+func (r *multicastRelay) start() {
+	r.local = make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				r.local[ipNet.IP.String()] = true
+			}
+		}
+	}
+	for _, g := range r.groups {
+		pc, err := r.listenGroup(g)
+		if err != nil {
+			multicastLog.Errorf("[Multicast] relay [%s] failed: %v", g.name, err)
+			continue
+		}
+		r.conns = append(r.conns, pc)
+		go r.relayGroup(pc, g)
+		multicastLog.Infof("[Multicast] relay [%s] %s between %s", g.name, g.addr, r.interfaceNames())
+	}
+	for _, port := range r.broadcast {
+		pc, err := r.listenBroadcast(port)
+		if err != nil {
+			multicastLog.Errorf("[Multicast] relay broadcast of port [%d] failed: %v", port, err)
+			continue
+		}
+		r.conns = append(r.conns, pc)
+		go r.relayBroadcast(pc, port)
+		multicastLog.Infof("[Multicast] relay broadcast of port [%d] between %s", port, r.interfaceNames())
+	}
+}
+
+func (r *multicastRelay) stop() {
+	r.Lock()
+	defer r.Unlock()
+	for _, pc := range r.conns {
+		pc.Close()
+	}
+	for key, s := range r.sessions {
+		s.conn.Close()
+		delete(r.sessions, key)
+	}
+}
+
+func (r *multicastRelay) interfaceNames() []string {
+	names := make([]string, len(r.interfaces))
+	for i, ifi := range r.interfaces {
+		names[i] = ifi.Name
+	}
+	return names
+}
+
+func (r *multicastRelay) listenGroup(g *multicastGroup) (*ipv4.PacketConn, error) {
+	c, err := net.ListenMulticastUDP("udp4", r.interfaces[0], g.addr)
+	if err != nil {
+		return nil, err
+	}
+	pc := ipv4.NewPacketConn(c)
+	for _, ifi := range r.interfaces[1:] {
+		if err = pc.JoinGroup(ifi, &net.UDPAddr{IP: g.addr.IP}); err != nil {
+			pc.Close()
+			return nil, fmt.Errorf("join group on [%s]: %v", ifi.Name, err)
+		}
+	}
+	if err = pc.SetControlMessage(ipv4.FlagInterface|ipv4.FlagTTL, true); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("not supported on this platform: %v", err)
+	}
+	pc.SetMulticastLoopback(false)
+	return pc, nil
+}
+
+func (r *multicastRelay) listenBroadcast(port int) (*ipv4.PacketConn, error) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	pc := ipv4.NewPacketConn(c)
+	if err = pc.SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst, true); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("not supported on this platform: %v", err)
+	}
+	return pc, nil
+}
+
+// the interface a packet comes in, nil if it is not relayed
+func (r *multicastRelay) inbound(cm *ipv4.ControlMessage, src net.Addr) *net.Interface {
+	if cm == nil {
+		return nil
+	}
+	if addr, ok := src.(*net.UDPAddr); !ok || r.local[addr.IP.String()] {
+		return nil
+	}
+	for _, ifi := range r.interfaces {
+		if ifi.Index == cm.IfIndex {
+			return ifi
+		}
+	}
+	return nil
+}
+
+func (r *multicastRelay) relayGroup(pc *ipv4.PacketConn, g *multicastGroup) {
+	buf := make([]byte, multicastBufSize)
+	for {
+		n, cm, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return // closed
+		}
+		in := r.inbound(cm, src)
+		if in == nil {
+			continue
+		}
+		ttl := cm.TTL
+		if ttl <= 0 {
+			ttl = 1
+		}
+		for _, out := range r.interfaces {
+			if out == in {
+				continue
+			}
+			if g.replies {
+				err = r.sendWithReplies(buf[:n], src.(*net.UDPAddr), out, g.addr, ttl)
+			} else {
+				if err = pc.SetMulticastInterface(out); err == nil {
+					pc.SetMulticastTTL(ttl)
+					_, err = pc.WriteTo(buf[:n], nil, g.addr)
+				}
+			}
+			if err != nil {
+				multicastLog.Debugf("[Multicast] relay [%s] from [%s] to [%s] failed: %v", g.name, in.Name, out.Name, err)
+			}
+		}
+	}
+}
+
+func (r *multicastRelay) relayBroadcast(pc *ipv4.PacketConn, port int) {
+	buf := make([]byte, multicastBufSize)
+	for {
+		n, cm, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return // closed
+		}
+		in := r.inbound(cm, src)
+		if in == nil || !isBroadcast(cm.Dst, in) {
+			continue
+		}
+		for _, out := range r.interfaces {
+			if out == in {
+				continue
+			}
+			ip := broadcastAddr(out)
+			if ip == nil {
+				continue
+			}
+			if _, err = pc.WriteTo(buf[:n], nil, &net.UDPAddr{IP: ip, Port: port}); err != nil {
+				multicastLog.Debugf("[Multicast] relay broadcast of port [%d] from [%s] to [%s] failed: %v", port, in.Name, out.Name, err)
+			}
+		}
+	}
+}
+
+func isBroadcast(ip net.IP, ifi *net.Interface) bool {
+	if ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	bcast := broadcastAddr(ifi)
+	return bcast != nil && ip.Equal(bcast)
+}
+
+// directed broadcast address of the first ipv4 network of the interface
+func broadcastAddr(ifi *net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		mask := ipNet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		bcast := make(net.IP, net.IPv4len)
+		for i := range ip {
+			bcast[i] = ip[i] | ^mask[i]
+		}
+		return bcast
+	}
+	return nil
+}
+
+// requests of a group with unicast replies (e.g. SSDP M-SEARCH) are sent from a socket per requester,
+// replies to it are sent back to the requester
+type replySession struct {
+	conn *net.UDPConn
+	pc   *ipv4.PacketConn
+}
+
+func (r *multicastRelay) sendWithReplies(b []byte, src *net.UDPAddr, out *net.Interface, group *net.UDPAddr, ttl int) error {
+	key := src.String() + "/" + out.Name
+	r.Lock()
+	s, ok := r.sessions[key]
+	if !ok {
+		c, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			r.Unlock()
+			return err
+		}
+		s = &replySession{conn: c, pc: ipv4.NewPacketConn(c)}
+		if err = s.pc.SetMulticastInterface(out); err != nil {
+			r.Unlock()
+			c.Close()
+			return err
+		}
+		s.pc.SetMulticastLoopback(false)
+		r.sessions[key] = s
+		go r.relayReplies(key, s, src)
+	}
+	r.Unlock()
+	s.conn.SetReadDeadline(time.Now().Add(multicastReplyTimeout))
+	s.pc.SetMulticastTTL(ttl)
+	_, err := s.conn.WriteTo(b, group)
+	return err
+}
+
+// send replies back to the requester until no reply in timeout
+func (r *multicastRelay) relayReplies(key string, s *replySession, src *net.UDPAddr) {
+	defer func() {
+		r.Lock()
+		if r.sessions[key] == s {
+			delete(r.sessions, key)
+		}
+		r.Unlock()
+		s.conn.Close()
+	}()
+	buf := make([]byte, multicastBufSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return // timeout or closed
+		}
+		if _, err = s.conn.WriteTo(buf[:n], src); err != nil {
+			multicastLog.Debugf("[Multicast] reply to [%s] failed: %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package netext embeds shuttle in an iOS/macOS Network Extension (NEPacketTunnelProvider),
+// built by gomobile: gomobile bind -target=ios github.com/sipt/shuttle/netext
+//
+// Route the default route (and NEDNSSettings) into the tunnel: DNS queries are answered by shuttle's
+// resolver, TCP and UDP of the apps go through the rules, the sockets of the extension itself do not
+// enter its tunnel. NEProxySettings to 127.0.0.1:HTTPPort() is optional, it keeps the domains of
+// the requests for apps resolving names by themselves.
+package netext
+
+import (
+	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/tunnel"
+)
+
+// packets to the system, e.g. NEPacketTunnelFlow.writePackets
+type PacketWriter interface {
+	WritePacket(packet []byte)
+}
+
+// start shuttle with the config file (e.g. in the app group container), logs are written to [logPath].
+// replies of the tunnel are written to [writer]
+func Start(configPath, logPath string, writer PacketWriter) error {
+	if tunnel.Started() {
+		return tunnel.ErrStarted
+	}
+	if err := log.InitLogger(log.LogModeFile, logPath); err != nil {
+		return err
+	}
+	return tunnel.Start(configPath, writer.WritePacket)
+}
+
+// stop shuttle, e.g. in stopTunnel
+func Stop() {
+	tunnel.Stop()
+}
+
+// a packet read from the system, e.g. NEPacketTunnelFlow.readPackets
+func InputPacket(packet []byte) {
+	tunnel.InputPacket(packet)
+}
+
+// packets dropped by the tunnel, not supported or too many waiting
+func DroppedPackets() int64 {
+	return tunnel.DroppedPackets()
+}
+
+// replace the config file with [data] from the app and reload it, a broken one is refused
+func PushConfig(data []byte) error {
+	return tunnel.PushConfig(data)
+}
+
+// port of the HTTP/HTTPS inbound for the optional NEProxySettings, empty if not started
+func HTTPPort() string {
+	return tunnel.HTTPPort()
+}
+
+// port of the SOCKS5 inbound
+func SOCKSPort() string {
+	return tunnel.SOCKSPort()
+}
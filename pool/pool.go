@@ -21,5 +21,8 @@ func GetBuf() []byte {
 }
 
 func PutBuf(buf []byte) {
+	if cap(buf) < BufferSize {
+		return
+	}
 	pool.Put(buf)
 }
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/dns"
+)
+
+const (
+	ChaosDialFail   = "dial-fail"
+	ChaosDNSTimeout = "dns-timeout"
+	ChaosReset      = "reset"
+	ChaosResetAfter = "reset-after"
+
+	defaultChaosResetAfter = 64 << 10
+)
+
+var ErrorChaosDial = errors.New("dial failed by chaos")
+
+type IChaosConfig interface {
+	GetChaos() map[string][]string
+}
+
+// faults injected into the connections of a policy (rule's policy, or server name) at rates per connection:
+// ["dial-fail=10%", "reset=2%", "reset-after=1MB"], or into the exchanges of the resolver with a DNS server
+// (as written in dns-server or Local-DNS) at rates per exchange: ["dns-timeout=5%"]
+type Chaos struct {
+	DialFail   float64
+	DNSTimeout float64 // of a DNS server
+	Reset      float64
+	ResetAfter uint64 // a connection to reset is reset at a random byte of this many
+}
+
+var (
+	chaos     = make(map[string]*Chaos)
+	chaosLock sync.RWMutex
+)
+
+// only a build with "-tags chaos" injects faults, other builds refuse the section
+func ApplyChaosConfig(config IChaosConfig) error {
+	cs := make(map[string]*Chaos, len(config.GetChaos()))
+	timeouts := make(map[string]float64)
+	for name, params := range config.GetChaos() {
+		if !ChaosEnabled {
+			return fmt.Errorf("resolve config file [Chaos] failed: build with \"-tags chaos\" to inject faults")
+		}
+		c, err := ParseChaos(params)
+		if err != nil {
+			return fmt.Errorf("resolve config file [Chaos] [%s] failed: %v", name, err)
+		}
+		cs[name] = c
+		if c.DNSTimeout > 0 {
+			timeouts[name] = c.DNSTimeout
+		}
+	}
+	chaosLock.Lock()
+	chaos = cs
+	chaosLock.Unlock()
+	dns.SetChaosTimeouts(timeouts)
+	return nil
+}
+
+func ParseChaos(params []string) (*Chaos, error) {
+	c := &Chaos{ResetAfter: defaultChaosResetAfter}
+	for _, v := range params {
+		key, value := v, ""
+		if i := strings.Index(v, "="); i >= 0 {
+			key, value = strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:])
+		}
+		var err error
+		switch key {
+		case ChaosDialFail:
+			c.DialFail, err = parseRate(value)
+		case ChaosDNSTimeout:
+			c.DNSTimeout, err = parseRate(value)
+		case ChaosReset:
+			c.Reset, err = parseRate(value)
+		case ChaosResetAfter:
+			c.ResetAfter, err = parseBandwidth(value)
+		default:
+			err = fmt.Errorf("not support fault [%s]", v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// "10%", "0.5%"
+func parseRate(v string) (float64, error) {
+	if !strings.HasSuffix(v, "%") {
+		return 0, fmt.Errorf("invalid rate [%s]", v)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("invalid rate [%s]", v)
+	}
+	return n / 100, nil
+}
+
+// policy first, then server. nil if no fault is injected
+func getChaos(policy, server string) *Chaos {
+	chaosLock.RLock()
+	defer chaosLock.RUnlock()
+	if c, ok := chaos[policy]; ok {
+		return c
+	}
+	return chaos[server]
+}
+
+func chaosHit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+type chaosDialer struct {
+	forward IDialer
+	chaos   *Chaos
+}
+
+func (d *chaosDialer) Dial(network, addr string) (net.Conn, error) {
+	if chaosHit(d.chaos.DialFail) {
+		return nil, fmt.Errorf("dial [%s] failed: %v", addr, ErrorChaosDial)
+	}
+	c, err := d.forward.Dial(network, addr)
+	if err != nil || !chaosHit(d.chaos.Reset) {
+		return c, err
+	}
+	return conn.ResetConn(c, rand.Int63n(int64(d.chaos.ResetAfter))+1), nil
+}
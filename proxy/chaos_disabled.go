@@ -0,0 +1,6 @@
+// +build !chaos
+
+package proxy
+
+// [Chaos] is refused, faults are never injected by a release
+const ChaosEnabled = false
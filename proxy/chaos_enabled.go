@@ -0,0 +1,6 @@
+// +build chaos
+
+package proxy
+
+// faults of [Chaos] are injected
+const ChaosEnabled = true
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sipt/shuttle/conn"
+)
+
+const (
+	CompositeInterface = "interface"
+	CompositeQoS       = "qos"
+	CompositeBandwidth = "bandwidth"
+	CompositeNoUDP     = "no-udp"
+)
+
+type ICompositePolicyConfig interface {
+	GetCompositePolicy() map[string][]string
+}
+
+// a named target (server or group) with modifiers of its connections, referenced by rules as a policy:
+// ["Proxy", "interface=en0", "qos=af41", "bandwidth=2MB", "no-udp"]
+type CompositePolicy struct {
+	Name      string
+	Target    string
+	Interface string
+	TOS       int    // dscp << 2, 0: unchanged
+	Bandwidth uint64 // bytes per second shared by the connections, 0: unlimited
+	NoUDP     bool
+
+	limiter *conn.RateLimiter
+}
+
+var (
+	composites    = make(map[string]*CompositePolicy)
+	compositeLock sync.RWMutex
+)
+
+// after the servers and groups
+func ApplyCompositePolicyConfig(config ICompositePolicyConfig) error {
+	cs := make(map[string]*CompositePolicy, len(config.GetCompositePolicy()))
+	for name, params := range config.GetCompositePolicy() {
+		c, err := ParseCompositePolicy(name, params)
+		if err != nil {
+			return fmt.Errorf("resolve config file [Composite-Policy] [%s] failed: %v", name, err)
+		}
+		if _, err = findServer(groups, servers, name); err == nil {
+			return fmt.Errorf("resolve config file [Composite-Policy] [%s] failed: name is a server or group", name)
+		}
+		if _, ok := config.GetCompositePolicy()[c.Target]; ok {
+			return fmt.Errorf("resolve config file [Composite-Policy] [%s] failed: target [%s] is a composite policy", name, c.Target)
+		}
+		if _, err = findServer(groups, servers, c.Target); err != nil || c.Target == ProxyGlobal {
+			return fmt.Errorf("resolve config file [Composite-Policy] [%s] failed: target [%s] not found", name, c.Target)
+		}
+		cs[name] = c
+	}
+	compositeLock.Lock()
+	composites = cs
+	compositeLock.Unlock()
+	return nil
+}
+
+// target first, then the modifiers
+func ParseCompositePolicy(name string, params []string) (*CompositePolicy, error) {
+	if len(params) == 0 || len(params[0]) == 0 {
+		return nil, fmt.Errorf("target is empty")
+	}
+	c := &CompositePolicy{Name: name, Target: params[0]}
+	for _, v := range params[1:] {
+		key, value := v, ""
+		if i := strings.Index(v, "="); i >= 0 {
+			key, value = strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:])
+		}
+		var err error
+		switch key {
+		case CompositeInterface:
+			if len(value) == 0 {
+				err = fmt.Errorf("interface is empty")
+			}
+			c.Interface = value
+		case CompositeQoS:
+			c.TOS, err = parseQoS(value)
+		case CompositeBandwidth:
+			c.Bandwidth, err = parseBandwidth(value)
+			c.limiter = conn.NewRateLimiter(c.Bandwidth)
+		case CompositeNoUDP:
+			c.NoUDP = true
+		default:
+			err = fmt.Errorf("not support modifier [%s]", v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// "ef", "af11" - "af43", "cs0" - "cs7" or dscp 0 - 63
+func parseQoS(v string) (int, error) {
+	v = strings.ToLower(v)
+	dscp := -1
+	switch {
+	case v == "ef":
+		dscp = 46
+	case len(v) == 4 && strings.HasPrefix(v, "af") && v[2] >= '1' && v[2] <= '4' && v[3] >= '1' && v[3] <= '3':
+		dscp = int(v[2]-'0')*8 + int(v[3]-'0')*2
+	case len(v) == 3 && strings.HasPrefix(v, "cs") && v[2] >= '0' && v[2] <= '7':
+		dscp = int(v[2]-'0') * 8
+	default:
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 63 {
+			dscp = n
+		}
+	}
+	if dscp < 0 {
+		return 0, fmt.Errorf("invalid qos class [%s]", v)
+	}
+	return dscp << 2, nil
+}
+
+// "512KB", "2MB", "1GB" or bytes, per second
+func parseBandwidth(v string) (uint64, error) {
+	s := strings.ToUpper(v)
+	unit := uint64(1)
+	for _, u := range []struct {
+		suffix string
+		n      uint64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSuffix(s, u.suffix), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid bandwidth [%s]", v)
+	}
+	return n * unit, nil
+}
+
+// nil if [name] is not a composite policy
+func GetCompositePolicy(name string) *CompositePolicy {
+	compositeLock.RLock()
+	defer compositeLock.RUnlock()
+	return composites[name]
+}
+
+// dial with the modifiers, keep-alive of [forward] is kept
+func (c *CompositePolicy) dialer(ctx context.Context, forward IDialer) IDialer {
+	if len(c.Interface) == 0 && c.TOS == 0 && c.limiter == nil {
+		return forward
+	}
+	d := &net.Dialer{Timeout: conn.DefaultTimeOut, Control: c.control}
+	if k, ok := forward.(*keepAliveDialer); ok {
+		k.setup(d)
+	}
+	return &compositeDialer{d, c, ctx}
+}
+
+type compositeDialer struct {
+	*net.Dialer
+	policy *CompositePolicy
+	ctx    context.Context
+}
+
+func (d *compositeDialer) Dial(network, addr string) (net.Conn, error) {
+	dialer := *d.Dialer
+	local, err := d.policy.localAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil {
+		dialer.LocalAddr = local
+	}
+	c, err := dialContext(d.ctx, &dialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.policy.limiter != nil {
+		c = conn.LimitConn(c, d.policy.limiter)
+	}
+	return c, nil
+}
@@ -0,0 +1,42 @@
+// +build linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// bind to the interface and mark the class of the packets
+func (c *CompositePolicy) control(network, address string, rc syscall.RawConn) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if len(c.Interface) > 0 {
+			if err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, c.Interface); err != nil {
+				err = fmt.Errorf("bind to interface [%s]: %v", c.Interface, err)
+				return
+			}
+		}
+		if c.TOS != 0 {
+			if strings.HasSuffix(network, "6") {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, c.TOS)
+			} else {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, c.TOS)
+			}
+			if err != nil {
+				err = fmt.Errorf("set qos: %v", err)
+			}
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// the socket is bound to the device
+func (c *CompositePolicy) localAddr(network, addr string) (net.Addr, error) {
+	return nil, nil
+}
@@ -0,0 +1,39 @@
+// +build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// qos is not supported
+func (c *CompositePolicy) control(network, address string, rc syscall.RawConn) error {
+	return nil
+}
+
+// an address of the interface in the family of [addr]
+func (c *CompositePolicy) localAddr(network, addr string) (net.Addr, error) {
+	if len(c.Interface) == 0 {
+		return nil, nil
+	}
+	iface, err := net.InterfaceByName(c.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("bind to interface [%s]: %v", c.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("bind to interface [%s]: %v", c.Interface, err)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	v6 := net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || (ipNet.IP.To4() == nil) != v6 {
+			continue
+		}
+		return &net.TCPAddr{IP: ipNet.IP}, nil
+	}
+	return nil, fmt.Errorf("bind to interface [%s]: no address", c.Interface)
+}
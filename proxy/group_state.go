@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultWeight = 1
+
+// manual state of a group: weights from config, pinned and excluded members from runtime
+type groupState struct {
+	weights  map[string]int
+	pinned   string
+	excluded map[string]bool
+	sync.RWMutex
+}
+
+// split "name=weight" of group member, weight is 1 by default
+func SplitWeight(member string) (string, int) {
+	i := strings.LastIndexByte(member, '=')
+	if i <= 0 {
+		return member, defaultWeight
+	}
+	w, err := strconv.Atoi(member[i+1:])
+	if err != nil || w <= 0 {
+		return member, defaultWeight
+	}
+	return member[:i], w
+}
+
+func (s *ServerGroup) Weight(name string) int {
+	s.state.RLock()
+	defer s.state.RUnlock()
+	if w, ok := s.state.weights[name]; ok {
+		return w
+	}
+	return defaultWeight
+}
+
+func (s *ServerGroup) setWeight(name string, w int) {
+	s.state.Lock()
+	if s.state.weights == nil {
+		s.state.weights = make(map[string]int)
+	}
+	s.state.weights[name] = w
+	s.state.Unlock()
+}
+
+func (s *ServerGroup) Pinned() string {
+	s.state.RLock()
+	defer s.state.RUnlock()
+	return s.state.pinned
+}
+
+func (s *ServerGroup) IsExcluded(name string) bool {
+	s.state.RLock()
+	defer s.state.RUnlock()
+	return s.state.excluded[name]
+}
+
+func (s *ServerGroup) Excluded() []string {
+	s.state.RLock()
+	list := make([]string, 0, len(s.state.excluded))
+	for k := range s.state.excluded {
+		list = append(list, k)
+	}
+	s.state.RUnlock()
+	sort.Strings(list)
+	return list
+}
+
+// member by name, nil if not found
+func (s *ServerGroup) member(name string) IServer {
+	for _, v := range s.Servers {
+		if is := v.(IServer); is.GetName() == name {
+			return is
+		}
+	}
+	return nil
+}
+
+// all connections go through the pinned member until unpinned, empty name to unpin
+func (s *ServerGroup) Pin(name string) error {
+	if len(name) > 0 {
+		s.RLock()
+		m := s.member(name)
+		s.RUnlock()
+		if m == nil {
+			return fmt.Errorf("server[%s] is not in group[%s]", name, s.Name)
+		}
+	}
+	s.state.Lock()
+	s.state.pinned = name
+	s.state.Unlock()
+	return nil
+}
+
+// excluded members are never selected, e.g. drain a server for maintenance
+func (s *ServerGroup) Exclude(name string, excluded bool) error {
+	s.RLock()
+	m := s.member(name)
+	s.RUnlock()
+	if m == nil {
+		return fmt.Errorf("server[%s] is not in group[%s]", name, s.Name)
+	}
+	s.state.Lock()
+	if excluded {
+		if s.state.excluded == nil {
+			s.state.excluded = make(map[string]bool)
+		}
+		s.state.excluded[name] = true
+	} else {
+		delete(s.state.excluded, name)
+	}
+	s.state.Unlock()
+	return nil
+}
+
+// pinned member first, then the selected one if not excluded, then the first available one
+func (s *ServerGroup) selectServer() (*Server, error) {
+	if pinned := s.Pinned(); len(pinned) > 0 {
+		if m := s.member(pinned); m != nil {
+			return m.GetServer()
+		}
+	}
+	server, err := s.Selector.Get()
+	if current := s.Selector.Current(); err != nil || current == nil || !s.IsExcluded(current.GetName()) {
+		return server, err
+	}
+	for _, v := range s.Servers {
+		if is := v.(IServer); !s.IsExcluded(is.GetName()) {
+			return is.GetServer()
+		}
+	}
+	return nil, fmt.Errorf("all servers of group[%s] are excluded", s.Name)
+}
+
+// another available member for hedged requests, nil if the group is pinned or has no other member
+func (s *ServerGroup) Alternate(current string) *Server {
+	if len(s.Pinned()) > 0 {
+		return nil
+	}
+	s.RLock()
+	defer s.RUnlock()
+	for _, v := range s.Servers {
+		is := v.(IServer)
+		if s.IsExcluded(is.GetName()) {
+			continue
+		}
+		if server, err := is.GetServer(); err == nil && server != nil && server.Name != current {
+			return server
+		}
+	}
+	return nil
+}
+
+func PinServer(groupName, serverName string) error {
+	g, ok := GroupExist(groupName)
+	if !ok {
+		return fmt.Errorf("group[%s] is not exist", groupName)
+	}
+	return g.Pin(serverName)
+}
+
+func ExcludeServer(groupName, serverName string, excluded bool) error {
+	g, ok := GroupExist(groupName)
+	if !ok {
+		return fmt.Errorf("group[%s] is not exist", groupName)
+	}
+	return g.Exclude(serverName, excluded)
+}
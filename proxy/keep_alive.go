@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/conn"
+)
+
+const KeepAliveOff = "off"
+
+type IKeepAliveConfig interface {
+	GetKeepAlive() map[string]string
+	SetKeepAlive(map[string]string)
+}
+
+// tcp keep-alive of go, the first hop is never probed less often
+const defaultTCPKeepAlive = 15 * time.Second
+
+var (
+	keepAliveDialers = make(map[string]IDialer)
+	keepAliveLock    sync.RWMutex
+)
+
+func ApplyKeepAliveConfig(config IKeepAliveConfig) error {
+	ds := make(map[string]IDialer, len(config.GetKeepAlive()))
+	for k, v := range config.GetKeepAlive() {
+		if v == KeepAliveOff {
+			ds[k] = &keepAliveDialer{period: -1, ctx: context.Background()}
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("resolve config file [Keep-Alive] [%s: %s] failed", k, v)
+		}
+		ds[k] = &keepAliveDialer{period: d, ctx: context.Background()}
+	}
+	keepAliveLock.Lock()
+	keepAliveDialers = ds
+	keepAliveLock.Unlock()
+	return nil
+}
+
+// return the dialer of the policy, it probes idle connections with tcp keep-alive and heartbeats
+// through the transport, policy first, then server. the modifiers of a composite policy and the
+// faults of chaos are added
+func PolicyDialer(policy, server string) IDialer {
+	return PolicyDialerContext(context.Background(), policy, server)
+}
+
+// PolicyDialer which gives up dialing, resolving and handshakes of the first hop when [ctx] is done,
+// e.g. the client closed
+func PolicyDialerContext(ctx context.Context, policy, server string) IDialer {
+	keepAliveLock.RLock()
+	d, ok := keepAliveDialers[policy]
+	if !ok {
+		if d, ok = keepAliveDialers[server]; !ok {
+			d = DirectDialer
+		}
+	}
+	keepAliveLock.RUnlock()
+	var heartbeat time.Duration
+	switch k := d.(type) {
+	case *keepAliveDialer:
+		if k.period > 0 {
+			heartbeat = k.period
+		}
+		d = &keepAliveDialer{period: k.period, ctx: ctx}
+	case *directDialer:
+		d = &directDialer{ctx}
+	}
+	if c := GetCompositePolicy(policy); c != nil {
+		d = c.dialer(ctx, d)
+	}
+	if c := getChaos(policy, server); c != nil {
+		d = &chaosDialer{d, c}
+	}
+	return &policyDialer{d, policy, heartbeat}
+}
+
+// carry the policy to the server for socket statistics, and the period of heartbeats
+type policyDialer struct {
+	IDialer
+	policy    string
+	heartbeat time.Duration
+}
+
+type keepAliveDialer struct {
+	period time.Duration
+	ctx    context.Context
+}
+
+func (k *keepAliveDialer) Dial(network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: conn.DefaultTimeOut}
+	k.setup(d)
+	return dialContext(k.ctx, d, network, addr)
+}
+
+// tcp keep-alive of the first hop, probes start after [period] idle, but not later than go's default
+func (k *keepAliveDialer) setup(d *net.Dialer) {
+	if k.period < 0 {
+		d.KeepAlive = -1
+		return
+	}
+	c := net.KeepAliveConfig{Enable: true, Idle: defaultTCPKeepAlive, Interval: defaultTCPKeepAlive}
+	if k.period < defaultTCPKeepAlive {
+		c.Idle, c.Interval = k.period, k.period
+	}
+	d.KeepAliveConfig = c
+}
+
+// a connection of transport sending heartbeats to the server, e.g. websocket ping,
+// they pass every middlebox on the way, which tcp keep-alive of the first hop does not
+type IHeartbeatConn interface {
+	Heartbeat() error
+}
+
+// send heartbeats until the connection is closed
+func keepHeartbeat(c IHeartbeatConn, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := c.Heartbeat(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// protocols which relay UDP datagrams (shadowsocks UDP relay)
+type IPacketProtocol interface {
+	PacketConn() (PacketConn, error)
+}
+
+// a UDP session through a server, every datagram carries its destination
+type PacketConn interface {
+	// send to the destination of the request
+	WriteTo(b []byte, req IRequest) (int, error)
+	// receive from a remote
+	ReadFrom(b []byte) (int, *net.UDPAddr, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// open a UDP session through the server, the server decides whether the remotes see one mapping
+// for all destinations (full-cone is possible) or not
+func (s *Server) PacketConn() (PacketConn, error) {
+	switch s.Name {
+	case ProxyDirect:
+		c, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+		return &directPacketConn{c}, nil
+	case ProxyReject:
+		return nil, ErrorReject
+	}
+	if s.transport != nil {
+		return nil, fmt.Errorf("server [%s] not support UDP with transport [%s]", s.Name, s.Transport)
+	}
+	p, ok := s.IProtocol.(IPacketProtocol)
+	if !ok {
+		return nil, fmt.Errorf("server [%s] protocol [%s] not support UDP", s.Name, s.ProxyProtocol)
+	}
+	return p.PacketConn()
+}
+
+// unconnected socket: one local port for all destinations, receives from any remote
+type directPacketConn struct {
+	*net.UDPConn
+}
+
+func (d *directPacketConn) WriteTo(b []byte, req IRequest) (int, error) {
+	addr, err := net.ResolveUDPAddr("udp", req.Host())
+	if err != nil {
+		return 0, err
+	}
+	return d.UDPConn.WriteToUDP(b, addr)
+}
+
+func (d *directPacketConn) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	return d.UDPConn.ReadFromUDP(b)
+}
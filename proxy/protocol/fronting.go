@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sipt/shuttle/dns"
+)
+
+const (
+	ConfigFrontingSNI  = "sni"
+	ConfigFrontingHost = "host"
+	ConfigFrontingIP   = "front-ip"
+)
+
+// domain fronting: TLS SNI and the IP to connect may differ from server address. an HTTP Host
+// different from SNI needs an HTTP based transport: "transport=wss", "sni=xxx", "host=xxx"
+type fronting struct {
+	SNI string
+	IP  string
+}
+
+// pick "sni=xxx" (TLS protocols only) and "front-ip=xxx" out of params
+func parseFronting(params []string, tls bool) ([]string, *fronting, error) {
+	f := &fronting{}
+	rest := make([]string, 0, len(params))
+	for _, v := range params {
+		i := strings.IndexByte(v, '=')
+		if i < 0 {
+			rest = append(rest, v)
+			continue
+		}
+		switch v[:i] {
+		case ConfigFrontingSNI:
+			if !tls {
+				return nil, nil, fmt.Errorf("%s needs TLS, e.g. transport=wss", ConfigFrontingSNI)
+			}
+			f.SNI = v[i+1:]
+		case ConfigFrontingHost:
+			return nil, nil, fmt.Errorf("%s needs an HTTP based transport, e.g. transport=wss", ConfigFrontingHost)
+		case ConfigFrontingIP:
+			f.IP = v[i+1:]
+			if net.ParseIP(f.IP) == nil {
+				return nil, nil, fmt.Errorf("%s [%s] is not a IP address", ConfigFrontingIP, f.IP)
+			}
+		default:
+			rest = append(rest, v)
+		}
+	}
+	return rest, f, nil
+}
+
+func (f *fronting) serverName(addr string) string {
+	if len(f.SNI) > 0 {
+		return f.SNI
+	}
+	return addr
+}
+
+// the front IP, or the IP of server address
+func (f *fronting) dialAddr(tag, addr string) string {
+	if len(f.IP) > 0 {
+		return f.IP
+	}
+	answer, err := dns.ResolveDomainByCache(addr)
+	if err != nil {
+		proxyLog.Errorf("[%s] [Conn] Resolve domain failed [%s]: %v", tag, addr, err)
+	} else if answer != nil {
+		return answer.GetIP()
+	}
+	return addr
+}
@@ -0,0 +1,6 @@
+package protocol
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var proxyLog = log.Named("proxy")
@@ -4,9 +4,8 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/sipt/shuttle/config"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/dns"
-	"github.com/sipt/shuttle/log"
 	sproxy "github.com/sipt/shuttle/proxy"
 
 	"golang.org/x/net/proxy"
@@ -17,14 +16,20 @@ func init() {
 }
 
 func NewSocks5Protocol(params []string) (sproxy.IProtocol, error) {
-	//[]string{"addr", "port", "username", "password"}
+	//[]string{"addr", "port", "username", "password", "front-ip=xxx"}
+	params, f, err := parseFronting(params, false)
+	if err != nil {
+		proxyLog.Errorf(`[SOCKS5 Server] init socks5 server failed: %v`, err)
+		return nil, fmt.Errorf(`[SOCKS5 Server] init socks5 server failed: %v`, err)
+	}
 	if len(params) != 4 && len(params) != 2 {
-		log.Logger.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port"] or ["addr", "port", "username", "password"], but: %v`, params)
-		return nil, fmt.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port"] or ["addr", "port", "username", "password"], but: %v`, params)
+		proxyLog.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port"] or ["addr", "port", "username", "password"], but: %v`, config.MaskUnknownParams(params, 2))
+		return nil, fmt.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port"] or ["addr", "port", "username", "password"], but: %v`, config.MaskUnknownParams(params, 2))
 	}
 	ser := &socksProtocol{
-		Addr: params[0],
-		Port: params[1],
+		Addr:     params[0],
+		Port:     params[1],
+		Fronting: f,
 	}
 	if len(params) == 4 {
 		ser.UserName = params[2]
@@ -43,21 +48,16 @@ type socksProtocol struct {
 	Port     string
 	UserName string
 	Password string
+	Fronting *fronting
 }
 
-func (s *socksProtocol) Conn(req sproxy.IRequest) (connect.IConn, error) {
+func (s *socksProtocol) Conn(req sproxy.IRequest, forward sproxy.IDialer) (connect.IConn, error) {
 	var auth *proxy.Auth
 	if len(s.UserName) > 0 {
 		auth = &proxy.Auth{User: s.UserName, Password: s.Password}
 	}
-	var addr = s.Addr
-	answer, err := dns.ResolveDomainByCache(s.Addr)
-	if err != nil {
-		log.Logger.Errorf("[SocksProtocol] [Conn] Resolve domain failed [%s]: %v", s.Addr, err)
-	} else if answer != nil {
-		addr = answer.GetIP()
-	}
-	dialer, err := proxy.SOCKS5(req.Network(), net.JoinHostPort(addr, s.Port), auth, nil)
+	addr := s.Fronting.dialAddr("SocksProtocol", s.Addr)
+	dialer, err := proxy.SOCKS5(req.Network(), net.JoinHostPort(addr, s.Port), auth, forward)
 	if err != nil {
 		return nil, err
 	}
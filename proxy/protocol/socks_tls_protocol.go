@@ -3,9 +3,8 @@ package protocol
 import (
 	"crypto/tls"
 	"fmt"
+	"github.com/sipt/shuttle/config"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/dns"
-	"github.com/sipt/shuttle/log"
 	sproxy "github.com/sipt/shuttle/proxy"
 	"golang.org/x/net/proxy"
 	"net"
@@ -20,15 +19,21 @@ func init() {
 }
 
 func NewSocks5TLSProtocol(params []string) (sproxy.IProtocol, error) {
-	//[]string{"addr", "port", "skip-verify","username", "password"}
+	//[]string{"addr", "port", "skip-verify","username", "password", "sni=xxx", "front-ip=xxx"}
+	params, f, err := parseFronting(params, true)
+	if err != nil {
+		proxyLog.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed: %v`, err)
+		return nil, fmt.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed: %v`, err)
+	}
 	if len(params) != 5 && len(params) != 3 {
-		log.Logger.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed params count must be 5 or 3, but: %v`, params)
-		return nil, fmt.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed params count must be 5 or 3, but: %v`, params)
+		proxyLog.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed params count must be 5 or 3, but: %v`, config.MaskUnknownParams(params, 3))
+		return nil, fmt.Errorf(`[SOCKS5 over TLS Server] init socks5 server failed params count must be 5 or 3, but: %v`, config.MaskUnknownParams(params, 3))
 	}
 	ser := &socksTLSProtocol{
 		Addr:               params[0],
 		Port:               params[1],
 		InsecureSkipVerify: params[2] == ConfigSocksTLSSkipVerify,
+		Fronting:           f,
 	}
 	if len(params) == 5 {
 		ser.UserName = params[3]
@@ -48,22 +53,17 @@ type socksTLSProtocol struct {
 	UserName           string
 	Password           string
 	InsecureSkipVerify bool
+	Fronting           *fronting
 }
 
-func (s *socksTLSProtocol) Conn(req sproxy.IRequest) (connect.IConn, error) {
+func (s *socksTLSProtocol) Conn(req sproxy.IRequest, forward sproxy.IDialer) (connect.IConn, error) {
 	var auth *proxy.Auth
 	if len(s.UserName) > 0 {
 		auth = &proxy.Auth{User: s.UserName, Password: s.Password}
 	}
 
-	var addr = s.Addr
-	answer, err := dns.ResolveDomainByCache(s.Addr)
-	if err != nil {
-		log.Logger.Errorf("[SocksOverTlsProtocol] [Conn] Resolve domain failed [%s]: %v", s.Addr, err)
-	} else if answer != nil {
-		addr = answer.GetIP()
-	}
-	dialer, err := proxy.SOCKS5(req.Network(), net.JoinHostPort(addr, s.Port), auth, s)
+	addr := s.Fronting.dialAddr("SocksOverTlsProtocol", s.Addr)
+	dialer, err := proxy.SOCKS5(req.Network(), net.JoinHostPort(addr, s.Port), auth, &tlsDialer{s, forward})
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +83,23 @@ func (s *socksTLSProtocol) Conn(req sproxy.IRequest) (connect.IConn, error) {
 	return connect.TrafficDecorate(c)
 }
 
-func (s *socksTLSProtocol) Dial(network, addr string) (c net.Conn, err error) {
-	return tls.Dial(network, addr, &tls.Config{
-		InsecureSkipVerify: s.InsecureSkipVerify,
-		ServerName:         s.Addr,
+type tlsDialer struct {
+	s       *socksTLSProtocol
+	forward sproxy.IDialer
+}
+
+func (d *tlsDialer) Dial(network, addr string) (c net.Conn, err error) {
+	c, err = d.forward.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(c, &tls.Config{
+		InsecureSkipVerify: d.s.InsecureSkipVerify,
+		ServerName:         d.s.Fronting.serverName(d.s.Addr),
 	})
+	if err = tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
 }
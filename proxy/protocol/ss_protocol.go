@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"github.com/sipt/shuttle"
 	"github.com/sipt/shuttle/ciphers"
+	"github.com/sipt/shuttle/config"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/dns"
-	"github.com/sipt/shuttle/log"
 	sproxy "github.com/sipt/shuttle/proxy"
 	"net"
 	"strconv"
@@ -18,16 +17,22 @@ func init() {
 }
 
 func NewSsProtocol(params []string) (sproxy.IProtocol, error) {
-	//[]string{"addr", "port", "method", "password"}
+	//[]string{"addr", "port", "method", "password", "front-ip=xxx"}
+	params, f, err := parseFronting(params, false)
+	if err != nil {
+		proxyLog.Errorf(`[SS Server] init ss server failed: %v`, err)
+		return nil, fmt.Errorf(`[SS Server] init ss server failed: %v`, err)
+	}
 	if len(params) != 4 {
-		log.Logger.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port", "method", "password"], but: %v`, params)
-		return nil, fmt.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port", "method", "password"], but: %v`, params)
+		proxyLog.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port", "method", "password"], but: %v`, config.MaskUnknownParams(params, 3))
+		return nil, fmt.Errorf(`[SOCKS5 Server] init socks5 server failed params must be ["addr", "port", "method", "password"], but: %v`, config.MaskUnknownParams(params, 3))
 	}
 	ser := &ssProtocol{
 		Addr:     params[0],
 		Port:     params[1],
 		Method:   params[2],
 		Password: params[3],
+		Fronting: f,
 	}
 	return ser, nil
 }
@@ -37,19 +42,14 @@ type ssProtocol struct {
 	Port     string
 	Method   string
 	Password string
+	Fronting *fronting
 }
 
-func (s *ssProtocol) Conn(req sproxy.IRequest) (connect.IConn, error) {
+func (s *ssProtocol) Conn(req sproxy.IRequest, dialer sproxy.IDialer) (connect.IConn, error) {
 	network := req.Network()
 
-	var addr = s.Addr
-	answer, err := dns.ResolveDomainByCache(s.Addr)
-	if err != nil {
-		log.Logger.Errorf("[SsProtocol] [Conn] Resolve domain failed [%s]: %v", s.Addr, err)
-	} else if answer != nil {
-		addr = answer.GetIP()
-	}
-	conn, err := net.DialTimeout(network, net.JoinHostPort(addr, s.Port), connect.DefaultTimeOut)
+	addr := s.Fronting.dialAddr("SsProtocol", s.Addr)
+	conn, err := dialer.Dial(network, net.JoinHostPort(addr, s.Port))
 	if err != nil {
 		return nil, err
 	}
@@ -126,3 +126,80 @@ func AddressEncoding(req sproxy.IRequest) ([]byte, error) {
 	}
 	return buffer.Bytes(), nil
 }
+
+// UDP relay: every datagram is an encrypted packet of [address][payload] to the UDP port of server
+func (s *ssProtocol) PacketConn() (sproxy.PacketConn, error) {
+	cipher, err := ciphers.PacketCipher(s.Password, s.Method)
+	if err != nil {
+		return nil, err
+	}
+	addr := s.Fronting.dialAddr("SsProtocol", s.Addr)
+	c, err := net.Dial(connect.UDP, net.JoinHostPort(addr, s.Port))
+	if err != nil {
+		return nil, err
+	}
+	return &ssPacketConn{Conn: c, cipher: cipher, buf: make([]byte, 64*1024)}, nil
+}
+
+type ssPacketConn struct {
+	net.Conn
+	cipher ciphers.IPacketCipher
+	buf    []byte
+}
+
+func (p *ssPacketConn) WriteTo(b []byte, req sproxy.IRequest) (int, error) {
+	rawAddr, err := AddressEncoding(req)
+	if err != nil {
+		return 0, err
+	}
+	data, err := p.cipher.Encrypt(append(rawAddr, b...))
+	if err != nil {
+		return 0, err
+	}
+	if _, err = p.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// packets which can't be decrypted or decoded are dropped
+func (p *ssPacketConn) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	for {
+		n, err := p.Conn.Read(p.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		data, err := p.cipher.Decrypt(p.buf[:n])
+		if err != nil {
+			proxyLog.Debugf("[SsProtocol] [PacketConn] decrypt failed: %v", err)
+			continue
+		}
+		addr, payload, err := addressDecoding(data)
+		if err != nil {
+			proxyLog.Debugf("[SsProtocol] [PacketConn] %v", err)
+			continue
+		}
+		return copy(b, payload), addr, nil
+	}
+}
+
+// [atyp][ip][port] of replies, domain is not expected
+func addressDecoding(data []byte) (*net.UDPAddr, []byte, error) {
+	var size int
+	if len(data) > 0 {
+		switch data[0] {
+		case shuttle.AddrTypeIPv4:
+			size = net.IPv4len
+		case shuttle.AddrTypeIPv6:
+			size = net.IPv6len
+		default:
+			return nil, nil, fmt.Errorf("not support address type [%d]", data[0])
+		}
+	}
+	if len(data) < 1+size+2 || size == 0 {
+		return nil, nil, fmt.Errorf("packet too short")
+	}
+	ip := net.IP(append([]byte{}, data[1:1+size]...))
+	port := int(data[1+size])<<8 | int(data[2+size])
+	return &net.UDPAddr{IP: ip, Port: port}, data[3+size:], nil
+}
@@ -0,0 +1,82 @@
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sipt/shuttle/proxy"
+)
+
+const SelectLoadBalance = "load-balance"
+
+func init() {
+	proxy.RegisterSelector(SelectLoadBalance, func(group *proxy.ServerGroup) (proxy.ISelector, error) {
+		s := &loadBalanceSelector{}
+		return s, s.Reset(group)
+	})
+}
+
+// smooth weighted round-robin over members which are not excluded
+type loadBalanceSelector struct {
+	group    *proxy.ServerGroup
+	current  map[string]int
+	selected proxy.IServer
+	sync.Mutex
+}
+
+func (l *loadBalanceSelector) Get() (*proxy.Server, error) {
+	l.Lock()
+	var (
+		best   proxy.IServer
+		total  int
+		weight int
+	)
+	for _, v := range l.group.Servers {
+		is := v.(proxy.IServer)
+		name := is.GetName()
+		if l.group.IsExcluded(name) {
+			continue
+		}
+		weight = l.group.Weight(name)
+		total += weight
+		l.current[name] += weight
+		if best == nil || l.current[name] > l.current[best.GetName()] {
+			best = is
+		}
+	}
+	if best == nil {
+		l.Unlock()
+		return nil, errors.New("all servers are excluded")
+	}
+	l.current[best.GetName()] -= total
+	l.selected = best
+	l.Unlock()
+	return best.GetServer()
+}
+func (l *loadBalanceSelector) Select(name string) error {
+	return fmt.Errorf("load-balance group[%s] not support select", l.group.Name)
+}
+func (l *loadBalanceSelector) Refresh() error {
+	l.Lock()
+	l.current = make(map[string]int)
+	l.Unlock()
+	return nil
+}
+func (l *loadBalanceSelector) Reset(group *proxy.ServerGroup) error {
+	if len(group.Servers) == 0 {
+		return fmt.Errorf("load-balance group[%s] is empty", group.Name)
+	}
+	l.Lock()
+	l.group = group
+	l.current = make(map[string]int)
+	l.selected = group.Servers[0].(proxy.IServer)
+	l.Unlock()
+	return nil
+}
+func (l *loadBalanceSelector) Destroy() {}
+func (l *loadBalanceSelector) Current() proxy.IServer {
+	l.Lock()
+	defer l.Unlock()
+	return l.selected
+}
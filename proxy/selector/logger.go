@@ -0,0 +1,6 @@
+package selector
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var rttSelectLog = log.Named("rtt-select")
@@ -0,0 +1,98 @@
+package selector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+)
+
+const ProtocolRelay = "relay"
+
+func init() {
+	proxy.RegisterSelector(ProtocolRelay, func(group *proxy.ServerGroup) (proxy.ISelector, error) {
+		s := &relaySelector{}
+		return s, s.Reset(group)
+	})
+}
+
+// relay all connections through the group's servers in order:
+// local -> Servers[0] -> Servers[1] -> ... -> target
+type relaySelector struct {
+	group  *proxy.ServerGroup
+	server *proxy.Server
+}
+
+func (r *relaySelector) Get() (*proxy.Server, error) {
+	return r.server, nil
+}
+func (r *relaySelector) Select(name string) error {
+	return fmt.Errorf("relay group[%s] not support select", r.group.Name)
+}
+func (r *relaySelector) Refresh() error {
+	return nil
+}
+func (r *relaySelector) Reset(group *proxy.ServerGroup) error {
+	if len(group.Servers) == 0 {
+		return fmt.Errorf("relay group[%s] is empty", group.Name)
+	}
+	r.group = group
+	r.server = &proxy.Server{
+		Name:          group.Name,
+		ProxyProtocol: ProtocolRelay,
+		RttUrl:        group.RttUrl,
+		IProtocol:     &relayProtocol{group: group},
+	}
+	return nil
+}
+func (r *relaySelector) Destroy() {}
+func (r *relaySelector) Current() proxy.IServer {
+	return r.server
+}
+
+type relayProtocol struct {
+	group *proxy.ServerGroup
+}
+
+func (r *relayProtocol) Conn(req proxy.IRequest, dialer proxy.IDialer) (conn.IConn, error) {
+	if req.Network() != conn.TCP {
+		return nil, errors.New("relay only support tcp")
+	}
+	r.group.RLock()
+	hops := make([]*proxy.Server, 0, len(r.group.Servers))
+	for _, v := range r.group.Servers {
+		s, err := v.(proxy.IServer).GetServer()
+		if err != nil {
+			r.group.RUnlock()
+			return nil, err
+		}
+		if s.Name == proxy.ProxyDirect {
+			continue
+		}
+		hops = append(hops, s)
+	}
+	r.group.RUnlock()
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("relay group[%s] has no server", r.group.Name)
+	}
+	for _, s := range hops[:len(hops)-1] {
+		dialer = &serverDialer{server: s, forward: dialer}
+	}
+	return hops[len(hops)-1].ConnWithDialer(req, dialer)
+}
+
+// dial through a server
+type serverDialer struct {
+	server  *proxy.Server
+	forward proxy.IDialer
+}
+
+func (d *serverDialer) Dial(network, addr string) (net.Conn, error) {
+	req, err := proxy.NewRequest(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.server.ConnWithDialer(req, d.forward)
+}
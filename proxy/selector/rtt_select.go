@@ -1,7 +1,6 @@
 package selector
 
 import (
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/proxy"
 	"sync/atomic"
 	"time"
@@ -26,7 +25,11 @@ func init() {
 				case <-s.cancel:
 					return
 				}
-				s.autoTest()
+				if proxy.HealthChecksPaused() {
+					rttSelectLog.Debug("[Rtt-Selector] health checks are paused")
+				} else {
+					s.autoTest()
+				}
 				s.timer.Reset(timerDulation)
 			}
 		}()
@@ -69,13 +72,16 @@ func (r *rttSelector) autoTest() {
 		}
 	}
 	r.timer.Stop()
-	log.Logger.Debug("[Rtt-Selector] start testing ...")
+	rttSelectLog.Debug("[Rtt-Selector] start testing ...")
 	var is proxy.IServer
 	var s *proxy.Server
 	var err error
 	c := make(chan *proxy.Server, 1)
 	for _, v := range r.group.Servers {
 		is = v.(proxy.IServer)
+		if r.group.IsExcluded(is.GetName()) {
+			continue
+		}
 		s, err = is.GetServer()
 		if err != nil {
 			continue
@@ -83,7 +89,7 @@ func (r *rttSelector) autoTest() {
 		go urlTest(s, r.group.GetRttRrl(), c)
 	}
 	s = <-c
-	log.Logger.Infof("[Rtt-Select] rtt select server: [%s]", s.Name)
+	rttSelectLog.Infof("[Rtt-Select] rtt select server: [%s]", s.Name)
 	r.selected = s
 	r.timer.Reset(timerDulation)
 	atomic.CompareAndSwapUint32(&r.status, 1, 0)
@@ -93,7 +99,7 @@ func urlTest(s *proxy.Server, rttUrl string, c chan *proxy.Server) {
 	rtt, err := proxy.TestRTT(s, rttUrl)
 	if err != nil {
 		s.Rtt = -1
-		log.Logger.Debugf("[Rtt-Select] [%s]  url test result: <failed> %v", s.Name, err)
+		rttSelectLog.Debugf("[Rtt-Select] [%s]  url test result: <failed> %v", s.Name, err)
 		return
 	}
 	s.Rtt = rtt
@@ -101,7 +107,7 @@ func urlTest(s *proxy.Server, rttUrl string, c chan *proxy.Server) {
 	case c <- s:
 	default:
 	}
-	log.Logger.Debugf("[Rtt-Select] [%s]  Rtt:[%dms]", s.Name, s.Rtt.Nanoseconds()/1000000)
+	rttSelectLog.Debugf("[Rtt-Select] [%s]  Rtt:[%dms]", s.Name, s.Rtt.Nanoseconds()/1000000)
 }
 func (r *rttSelector) Current() proxy.IServer {
 	return r.selected
@@ -1,9 +1,11 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/sipt/shuttle/conn"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -36,6 +38,10 @@ type IProxyConfig interface {
 	SetProxyGroup(map[string][]string)
 	GetRttUrl() string
 	SetRttUrl(string)
+	GetMaxRttTests() string
+	IKeepAliveConfig
+	ICompositePolicyConfig
+	IChaosConfig
 }
 
 type IRequest interface {
@@ -52,6 +58,30 @@ func ApplyConfig(config IProxyConfig) (err error) {
 		globalRttUrl = config.GetRttUrl()
 	}
 
+	//rtt concurrency
+	if err = applyRttLimit(config.GetMaxRttTests()); err != nil {
+		return
+	}
+
+	gs, ss, err := buildServers(config)
+	if err != nil {
+		return
+	}
+	err = InitServers(gs, ss)
+	if err != nil {
+		return fmt.Errorf("init server failed: %v", err)
+	}
+	if err = ApplyCompositePolicyConfig(config); err != nil {
+		return
+	}
+	if err = ApplyKeepAliveConfig(config); err != nil {
+		return
+	}
+	return ApplyChaosConfig(config)
+}
+
+// servers and groups of config, without GLOBAL group and selectors
+func buildServers(config IProxyConfig) ([]*ServerGroup, []*Server, error) {
 	proxy := config.GetProxy()
 	//Servers
 	ss := make([]*Server, len(proxy)+2)
@@ -60,25 +90,18 @@ func ApplyConfig(config IProxyConfig) (err error) {
 	index ++
 	ss[index] = &Server{Name: ProxyReject} // 拒绝
 	rttUrl := ""
+	var err error
 	for k, v := range proxy {
 		index ++
 		if len(v) < 2 {
-			return fmt.Errorf("resolve config file [proxy] [%s] failed", k)
-		}
-		{ //rtt Url check
-			last := v[len(v)-1]
-			if len(last) > len("http://") {
-				if strings.HasPrefix(last, "http://") || strings.HasPrefix(last, "https://") {
-					rttUrl = last
-					v = v[:len(v)-1]
-				}
-			}
+			return nil, nil, fmt.Errorf("resolve config file [proxy] [%s] failed", k)
 		}
+		v, rttUrl = SplitRttUrl(v)
 		ss[index], err = NewServer(k, v)
-		ss[index].RttUrl = rttUrl
 		if err != nil {
-			return
+			return nil, nil, err
 		}
+		ss[index].RttUrl = rttUrl
 	}
 
 	proxyGroup := config.GetProxyGroup()
@@ -105,34 +128,55 @@ func ApplyConfig(config IProxyConfig) (err error) {
 	for _, v := range gs {
 		cs = proxyGroup[v.Name]
 		if len(cs) < 2 {
-			return fmt.Errorf("resolve config file [proxy_group] [%s] failed", v.Name)
+			return nil, nil, fmt.Errorf("resolve config file [proxy_group] [%s] failed", v.Name)
 		}
 		v.SelectType = cs[0]
-		{ //rtt Url check
-			last := cs[len(cs)-1]
-			if len(last) > len("http://") {
-				if strings.HasPrefix(last, "http://") || strings.HasPrefix(last, "https://") {
-					v.RttUrl = last
-					cs = cs[:len(cs)-1]
-				}
-			}
-		}
+		cs, v.RttUrl = SplitRttUrl(cs)
 		v.Servers = make([]interface{}, len(cs)-1)
 		for i := range v.Servers {
-			v.Servers[i] = getServer(cs[i+1])
+			name, weight := SplitWeight(cs[i+1])
+			if v.Servers[i] = getServer(name); v.Servers[i] == nil {
+				name, weight = cs[i+1], defaultWeight
+				v.Servers[i] = getServer(name)
+			}
 			if v.Servers[i] == nil {
-				return fmt.Errorf("resolve config file [proxy_group] [%s] [%s] not found", v.Name, cs[i+1])
+				return nil, nil, fmt.Errorf("resolve config file [proxy_group] [%s] [%s] not found", v.Name, cs[i+1])
 			}
+			v.setWeight(name, weight)
 		}
 	}
-	err = InitServers(gs, ss)
+	return gs, ss, nil
+}
+
+// split the rtt url at the end of params
+func SplitRttUrl(params []string) ([]string, string) {
+	if len(params) == 0 {
+		return params, ""
+	}
+	last := params[len(params)-1]
+	if len(last) > len("http://") {
+		if strings.HasPrefix(last, "http://") || strings.HasPrefix(last, "https://") {
+			return params[:len(params)-1], last
+		}
+	}
+	return params, ""
+}
+
+func InitServers(gs []*ServerGroup, ss []*Server) error {
+	gs, err := initGroups(gs, ss)
 	if err != nil {
-		return fmt.Errorf("init server failed: %v", err)
+		return err
 	}
+	if len(groups) > 0 {
+		DestroyServers()
+	}
+	groups = gs
+	servers = ss
 	return nil
 }
 
-func InitServers(gs []*ServerGroup, ss []*Server) error {
+// append GLOBAL group and create selectors
+func initGroups(gs []*ServerGroup, ss []*Server) ([]*ServerGroup, error) {
 	g := &ServerGroup{
 		Name:       ProxyGlobal,
 		SelectType: "select",
@@ -155,15 +199,10 @@ func InitServers(gs []*ServerGroup, ss []*Server) error {
 	for i, v := range gs {
 		v.Selector, err = GetSelector(gs[i].SelectType, v)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	if len(groups) > 0 {
-		DestroyServers()
-	}
-	groups = gs
-	servers = ss
-	return nil
+	return gs, nil
 }
 func DestroyServers() {
 	for _, v := range groups {
@@ -205,8 +244,49 @@ type IServer interface {
 type NewProtocol func([]string) (IProtocol, error)
 
 type IProtocol interface {
-	//获取服务器连接
-	Conn(request IRequest) (conn.IConn, error)
+	//获取服务器连接, 通过dialer连接服务器
+	Conn(request IRequest, dialer IDialer) (conn.IConn, error)
+}
+
+//上游连接
+type IDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+var DirectDialer IDialer = &directDialer{context.Background()}
+
+type directDialer struct {
+	ctx context.Context
+}
+
+func (d *directDialer) Dial(network, addr string) (net.Conn, error) {
+	return dialContext(d.ctx, &net.Dialer{Timeout: conn.DefaultTimeOut}, network, addr)
+}
+
+// dial by [d] until [ctx] is done, the connection is closed when it is done later,
+// so the handshakes on it are given up too
+func dialContext(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	c, err := d.DialContext(ctx, network, addr)
+	if err == nil && ctx.Done() != nil {
+		context.AfterFunc(ctx, func() { c.Close() })
+	}
+	return c, err
+}
+
+// track connections to servers for socket statistics and loop detection
+type trackDialer struct {
+	forward IDialer
+	policy  string
+	server  string
+}
+
+func (d *trackDialer) Dial(network, addr string) (net.Conn, error) {
+	c, err := d.forward.Dial(network, addr)
+	if err == nil {
+		conn.MarkSelf(c)
+		conn.TrackTCP(c, d.policy, d.server)
+	}
+	return c, err
 }
 
 type ServerGroup struct {
@@ -215,6 +295,7 @@ type ServerGroup struct {
 	SelectType string
 	Selector   ISelector
 	RttUrl     string
+	state      groupState
 	sync.RWMutex
 }
 
@@ -227,7 +308,7 @@ func (s *ServerGroup) GetName() string {
 func (s *ServerGroup) GetServer() (*Server, error) {
 	s.RLock()
 	defer s.RUnlock()
-	return s.Selector.Get()
+	return s.selectServer()
 }
 
 func (s *ServerGroup) GetRttRrl() string {
@@ -274,17 +355,31 @@ func NewServer(name string, params []string) (*Server, error) {
 	if n == nil {
 		return nil, fmt.Errorf("[Config] [InitServer] Not support protocol: %s", ser.ProxyProtocol)
 	}
-	var err error
-	ser.IProtocol, err = n(params[1:])
+	params, transport, name, err := parseTransport(params[1:], transportHost(params[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("[Config] [InitServer] [%s] %v", ser.Name, err)
+	}
+	ser.Transport, ser.transport = name, transport
+	ser.IProtocol, err = n(params)
 	return ser, err
 }
 
+// the first param of all protocols is server address
+func transportHost(params []string) string {
+	if len(params) > 0 {
+		return params[0]
+	}
+	return ""
+}
+
 type Server struct {
 	Name          string
 	Rtt           time.Duration
 	ProxyProtocol string
 	RttUrl        string
+	Transport     string `json:",omitempty"`
 	IProtocol     `json:"-"`
+	transport     ITransport
 }
 
 func (s *Server) GetName() string {
@@ -301,22 +396,66 @@ func (s *Server) GetRttRrl() string {
 }
 
 func (s *Server) Conn(req IRequest) (conn.IConn, error) {
+	return s.ConnWithDialer(req, DirectDialer)
+}
+
+// connect to server through the upstream dialer
+func (s *Server) ConnWithDialer(req IRequest, dialer IDialer) (conn.IConn, error) {
+	policy := s.Name
+	var heartbeat time.Duration
+	if d, ok := dialer.(*policyDialer); ok {
+		policy, heartbeat, dialer = d.policy, d.heartbeat, d.IDialer
+	}
 	switch s.Name {
 	case ProxyDirect:
-		return conn.DirectConn(req.Network(), req.Host())
+		if d, ok := dialer.(*directDialer); ok {
+			c, err := conn.DirectConnContext(d.ctx, req.Network(), req.Host())
+			if err == nil {
+				conn.MarkSelfIConn(c)
+				conn.TrackIConn(c, policy, s.Name)
+			}
+			return c, err
+		}
+		c, err := dialer.Dial(req.Network(), req.Host())
+		if err != nil {
+			return nil, err
+		}
+		conn.MarkSelf(c)
+		conn.TrackTCP(c, policy, s.Name)
+		ic, err := conn.NewDefaultConn(c, req.Network())
+		if err == nil {
+			ic, err = conn.TrafficDecorate(ic)
+		}
+		return ic, err
 	case ProxyReject:
 		return nil, ErrorReject
 	}
-	return s.IProtocol.Conn(req)
+	dialer = &trackDialer{forward: dialer, policy: policy, server: s.Name}
+	if s.transport != nil {
+		dialer = &transportDialer{transport: s.transport, forward: dialer, heartbeat: heartbeat}
+	}
+	return s.IProtocol.Conn(req, dialer)
 }
 
 func GetServer(name string) (*Server, error) {
+	return lookupServer(groups, servers, name)
+}
+
+func lookupServer(groups []*ServerGroup, servers []*Server, name string) (*Server, error) {
+	if c := GetCompositePolicy(name); c != nil {
+		name = c.Target
+	}
+	return findServer(groups, servers, name)
+}
+
+// without composite policies
+func findServer(groups []*ServerGroup, servers []*Server, name string) (*Server, error) {
 	if name == "REJECT" {
 		return RejectServer, nil
 	}
 	for _, v := range groups {
 		if v.Name == name {
-			return v.Selector.Get()
+			return v.GetServer()
 		}
 	}
 	for i := range servers {
@@ -0,0 +1,40 @@
+package proxy
+
+import "fmt"
+
+// servers and groups of another config beside the applied one, e.g. the candidate of blue/green deployment
+type ServerSet struct {
+	groups  []*ServerGroup
+	servers []*Server
+}
+
+func NewServerSet(config IProxyConfig) (*ServerSet, error) {
+	gs, ss, err := buildServers(config)
+	if err != nil {
+		return nil, err
+	}
+	gs, err = initGroups(gs, ss)
+	if err != nil {
+		return nil, fmt.Errorf("init server failed: %v", err)
+	}
+	return &ServerSet{groups: gs, servers: ss}, nil
+}
+
+func (s *ServerSet) GetServer(name string) (*Server, error) {
+	return lookupServer(s.groups, s.servers, name)
+}
+
+func (s *ServerSet) GroupExist(name string) (*ServerGroup, bool) {
+	for _, v := range s.groups {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *ServerSet) Destroy() {
+	for _, v := range s.groups {
+		v.Selector.Destroy()
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sipt/shuttle/conn"
+)
+
+const (
+	ConfigTransport           = "transport"
+	ConfigTransportPath       = "path"
+	ConfigTransportHeader     = "header"
+	ConfigTransportService    = "service"
+	ConfigTransportHost       = "host"
+	ConfigTransportSNI        = "sni"
+	ConfigTransportSkipVerify = "skip-verify"
+)
+
+var transportCreator = make(map[string]NewTransport)
+
+// options of the transport layer, picked out of server params
+type TransportOptions struct {
+	Name       string
+	Host       string // HTTP Host, default is the server address
+	SNI        string // TLS server name, default is Host
+	Path       string
+	Service    string
+	Header     http.Header
+	SkipVerify bool
+}
+
+func (o *TransportOptions) ServerName() string {
+	if len(o.SNI) > 0 {
+		return o.SNI
+	}
+	return o.Host
+}
+
+type NewTransport func(*TransportOptions) (ITransport, error)
+
+// wrap the connection to server, all data of the server protocol goes through it
+type ITransport interface {
+	Wrap(c net.Conn) (net.Conn, error)
+}
+
+func RegisterTransport(name string, t NewTransport) {
+	transportCreator[name] = t
+}
+
+// pick "transport=xxx" and its options out of params, [host] is the default HTTP Host.
+// "host=", "sni=" and "skip-verify=" belong to the transport only when "transport=" is set
+func parseTransport(params []string, host string) ([]string, ITransport, string, error) {
+	o := &TransportOptions{Host: host, Header: http.Header{}}
+	rest := make([]string, 0, len(params))
+	shared := make([]string, 0, len(params))
+	for _, v := range params {
+		i := strings.IndexByte(v, '=')
+		if i < 0 {
+			rest = append(rest, v)
+			continue
+		}
+		key, value := v[:i], v[i+1:]
+		switch key {
+		case ConfigTransport:
+			o.Name = value
+		case ConfigTransportPath:
+			o.Path = value
+		case ConfigTransportService:
+			o.Service = value
+		case ConfigTransportHeader:
+			j := strings.IndexByte(value, ':')
+			if j <= 0 {
+				return nil, nil, "", fmt.Errorf("%s [%s] must be \"Key:Value\"", ConfigTransportHeader, value)
+			}
+			o.Header.Add(strings.TrimSpace(value[:j]), strings.TrimSpace(value[j+1:]))
+		case ConfigTransportHost, ConfigTransportSNI, ConfigTransportSkipVerify:
+			shared = append(shared, v)
+			rest = append(rest, v)
+		default:
+			rest = append(rest, v)
+		}
+	}
+	if len(o.Name) == 0 {
+		if len(o.Path) > 0 || len(o.Service) > 0 || len(o.Header) > 0 {
+			return nil, nil, "", fmt.Errorf("%s is required", ConfigTransport)
+		}
+		return rest, nil, "", nil
+	}
+	n, ok := transportCreator[o.Name]
+	if !ok {
+		return nil, nil, "", fmt.Errorf("not support transport: %s", o.Name)
+	}
+	for _, v := range shared {
+		i := strings.IndexByte(v, '=')
+		switch v[:i] {
+		case ConfigTransportHost:
+			o.Host = v[i+1:]
+		case ConfigTransportSNI:
+			o.SNI = v[i+1:]
+		case ConfigTransportSkipVerify:
+			o.SkipVerify = v[i+1:] == "true"
+		}
+	}
+	shared = rest
+	rest = make([]string, 0, len(shared))
+	for _, v := range shared {
+		i := strings.IndexByte(v, '=')
+		if i >= 0 {
+			switch v[:i] {
+			case ConfigTransportHost, ConfigTransportSNI, ConfigTransportSkipVerify:
+				continue
+			}
+		}
+		rest = append(rest, v)
+	}
+	t, err := n(o)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return rest, t, o.Name, nil
+}
+
+// dial to server through forward, then wrap tcp connections with the transport
+type transportDialer struct {
+	transport ITransport
+	forward   IDialer
+	heartbeat time.Duration // 0: no heartbeat
+}
+
+func (d *transportDialer) Dial(network, addr string) (net.Conn, error) {
+	c, err := d.forward.Dial(network, addr)
+	if err != nil || network != conn.TCP {
+		return c, err
+	}
+	tc, err := d.transport.Wrap(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if h, ok := tc.(IHeartbeatConn); ok && d.heartbeat > 0 {
+		go keepHeartbeat(h, d.heartbeat)
+	}
+	return tc, nil
+}
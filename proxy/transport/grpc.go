@@ -0,0 +1,209 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	"golang.org/x/net/http2"
+)
+
+const (
+	TransportGRPC = "grpc"
+
+	defaultGRPCService = "GunService"
+	maxGRPCMessageSize = 1 << 20
+)
+
+func init() {
+	proxy.RegisterTransport(TransportGRPC, newGRPCTransport)
+}
+
+func newGRPCTransport(o *proxy.TransportOptions) (proxy.ITransport, error) {
+	if len(o.Service) == 0 {
+		o.Service = defaultGRPCService
+	}
+	return &grpcTransport{o}, nil
+}
+
+// gRPC tunnel over TLS + HTTP/2, a single stream call "/{service}/Tun",
+// each message is a protobuf "Hunk { bytes data = 1; }"
+type grpcTransport struct {
+	*proxy.TransportOptions
+}
+
+func (t *grpcTransport) Wrap(c net.Conn) (net.Conn, error) {
+	tc := tls.Client(c, &tls.Config{
+		ServerName:         t.ServerName(),
+		InsecureSkipVerify: t.SkipVerify,
+		NextProtos:         []string{http2.NextProtoTLS},
+	})
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	if p := tc.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		tc.Close()
+		return nil, fmt.Errorf("[gRPC] negotiated protocol [%s] is not h2", p)
+	}
+	cc, err := (&http2.Transport{}).NewClientConn(tc)
+	if err != nil {
+		tc.Close()
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: "https", Host: t.Host, Path: "/" + t.Service + "/Tun"},
+		Host:   t.Host,
+		Header: http.Header{},
+		Body:   pr,
+	}
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	if h := req.Header.Get("Host"); len(h) > 0 {
+		req.Host = h
+		req.Header.Del("Host")
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	g := &grpcConn{
+		Conn:  tc,
+		cc:    cc,
+		pw:    pw,
+		ready: make(chan struct{}),
+	}
+	go func() {
+		resp, err := cc.RoundTrip(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("[gRPC] response status: %s", resp.Status)
+		}
+		if err != nil {
+			g.err = err
+		} else {
+			g.reader = bufio.NewReader(resp.Body)
+		}
+		close(g.ready)
+	}()
+	return g, nil
+}
+
+// net.Conn over a gRPC stream, the response is read after headers arrived
+type grpcConn struct {
+	net.Conn
+	cc     *http2.ClientConn
+	pw     *io.PipeWriter
+	ready  chan struct{}
+	err    error
+	reader *bufio.Reader
+	remain int
+	wLock  sync.Mutex
+}
+
+func (c *grpcConn) Read(b []byte) (int, error) {
+	<-c.ready
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.remain == 0 {
+		var err error
+		if c.remain, err = c.readHunk(); err != nil {
+			return 0, err
+		}
+	}
+	if len(b) > c.remain {
+		b = b[:c.remain]
+	}
+	n, err := c.reader.Read(b)
+	c.remain -= n
+	return n, err
+}
+
+// read gRPC message header and Hunk header, return length of data
+func (c *grpcConn) readHunk() (int, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, err
+	}
+	if header[0] != 0 {
+		return 0, errors.New("[gRPC] compressed message is not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxGRPCMessageSize {
+		return 0, fmt.Errorf("[gRPC] message too large: %d", size)
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	tag, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if tag != 0x0A {
+		return 0, fmt.Errorf("[gRPC] unexpected field tag: %#x", tag)
+	}
+	l, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return 0, err
+	}
+	if int(l) != int(size)-1-uvarintLen(l) {
+		return 0, errors.New("[gRPC] invalid hunk length")
+	}
+	return int(l), nil
+}
+
+func (c *grpcConn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	n := 0
+	for len(b) > 0 {
+		p := b
+		if len(p) > maxGRPCMessageSize/2 {
+			p = p[:maxGRPCMessageSize/2]
+		}
+		buf := make([]byte, 5+1+binary.MaxVarintLen64+len(p))
+		buf[5] = 0x0A
+		l := binary.PutUvarint(buf[6:], uint64(len(p)))
+		copy(buf[6+l:], p)
+		buf = buf[:6+l+len(p)]
+		binary.BigEndian.PutUint32(buf[1:5], uint32(len(buf)-5))
+		if _, err := c.pw.Write(buf); err != nil {
+			return n, err
+		}
+		n += len(p)
+		b = b[len(p):]
+	}
+	return n, nil
+}
+
+// PING frame of HTTP/2, answered by the server
+func (c *grpcConn) Heartbeat() error {
+	ctx, cancel := context.WithTimeout(context.Background(), conn.DefaultTimeOut)
+	defer cancel()
+	return c.cc.Ping(ctx)
+}
+
+func (c *grpcConn) Close() error {
+	c.pw.Close()
+	return c.Conn.Close()
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
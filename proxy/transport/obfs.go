@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/proxy"
+)
+
+const (
+	TransportObfsHTTP = "obfs-http"
+	TransportObfsTLS  = "obfs-tls"
+
+	maxTLSRecordSize = 16 * 1024
+)
+
+func init() {
+	proxy.RegisterTransport(TransportObfsHTTP, newObfsTransport)
+	proxy.RegisterTransport(TransportObfsTLS, newObfsTransport)
+}
+
+func newObfsTransport(o *proxy.TransportOptions) (proxy.ITransport, error) {
+	if len(o.Path) == 0 {
+		o.Path = "/"
+	}
+	return &obfsTransport{o}, nil
+}
+
+// simple-obfs compatible obfuscation:
+// obfs-http: the first packet is sent as body of a websocket upgrade request;
+// obfs-tls: the first packet is sent in session ticket of a ClientHello, others as application data
+type obfsTransport struct {
+	*proxy.TransportOptions
+}
+
+func (t *obfsTransport) Wrap(c net.Conn) (net.Conn, error) {
+	if t.Name == TransportObfsTLS {
+		return &obfsTLSConn{Conn: c, host: t.ServerName()}, nil
+	}
+	return &obfsHTTPConn{Conn: c, t: t}, nil
+}
+
+type obfsHTTPConn struct {
+	net.Conn
+	t        *obfsTransport
+	reader   *bufio.Reader
+	wLock    sync.Mutex
+	sent     bool
+	received bool
+}
+
+func (c *obfsHTTPConn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	if c.sent {
+		return c.Conn.Write(b)
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "GET %s HTTP/1.1\r\n", c.t.Path)
+	fmt.Fprintf(buf, "Host: %s\r\n", c.t.Host)
+	fmt.Fprintf(buf, "User-Agent: curl/7.%d.%d\r\n", time.Now().Nanosecond()%51, time.Now().Nanosecond()%2)
+	buf.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(buf, "Sec-WebSocket-Key: %s\r\n", base64.StdEncoding.EncodeToString(key))
+	fmt.Fprintf(buf, "Content-Length: %d\r\n", len(b))
+	for k, vs := range c.t.Header {
+		if k == "Host" {
+			continue
+		}
+		for _, v := range vs {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(b)
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	c.sent = true
+	return len(b), nil
+}
+
+func (c *obfsHTTPConn) Read(b []byte) (int, error) {
+	if !c.received {
+		c.reader = bufio.NewReader(c.Conn)
+		for {
+			line, err := c.reader.ReadString('\n')
+			if err != nil {
+				return 0, err
+			}
+			if line == "\r\n" || line == "\n" {
+				break
+			}
+		}
+		c.received = true
+	}
+	return c.reader.Read(b)
+}
+
+type obfsTLSConn struct {
+	net.Conn
+	host     string
+	wLock    sync.Mutex
+	sent     bool
+	remain   int
+	received bool
+}
+
+func (c *obfsTLSConn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	if !c.sent {
+		if _, err := c.Conn.Write(clientHello(c.host, b)); err != nil {
+			return 0, err
+		}
+		c.sent = true
+		return len(b), nil
+	}
+	n := 0
+	header := []byte{0x17, 0x03, 0x03, 0, 0}
+	for len(b) > 0 {
+		p := b
+		if len(p) > maxTLSRecordSize {
+			p = p[:maxTLSRecordSize]
+		}
+		binary.BigEndian.PutUint16(header[3:], uint16(len(p)))
+		if _, err := c.Conn.Write(append(header, p...)); err != nil {
+			return n, err
+		}
+		n += len(p)
+		b = b[len(p):]
+	}
+	return n, nil
+}
+
+// skip ServerHello, ChangeCipherSpec and Finished, return data of application records
+func (c *obfsTLSConn) Read(b []byte) (int, error) {
+	header := make([]byte, 5)
+	for c.remain == 0 {
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		size := int(binary.BigEndian.Uint16(header[3:]))
+		switch header[0] {
+		case 0x17:
+			c.remain = size
+			c.received = true
+		case 0x14, 0x16:
+			if c.received {
+				return 0, errors.New("[obfs-tls] unexpected handshake record")
+			}
+			if _, err := io.CopyN(ioutil.Discard, c.Conn, int64(size)); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("[obfs-tls] unexpected record type: %#x", header[0])
+		}
+	}
+	if len(b) > c.remain {
+		b = b[:c.remain]
+	}
+	n, err := c.Conn.Read(b)
+	c.remain -= n
+	return n, err
+}
+
+// ClientHello carries [data] in session ticket extension
+func clientHello(host string, data []byte) []byte {
+	ext := &bytes.Buffer{}
+	// server_name
+	writeExtension(ext, 0x0000, func(b *bytes.Buffer) {
+		writeUint16(b, uint16(len(host)+3))
+		b.WriteByte(0)
+		writeUint16(b, uint16(len(host)))
+		b.WriteString(host)
+	})
+	// session_ticket
+	writeExtension(ext, 0x0023, func(b *bytes.Buffer) { b.Write(data) })
+	// ec_point_formats
+	writeExtension(ext, 0x000b, func(b *bytes.Buffer) { b.Write([]byte{0x03, 0x00, 0x01, 0x02}) })
+	// supported_groups
+	writeExtension(ext, 0x000a, func(b *bytes.Buffer) {
+		b.Write([]byte{0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x19, 0x00, 0x18})
+	})
+	// signature_algorithms
+	writeExtension(ext, 0x000d, func(b *bytes.Buffer) {
+		b.Write([]byte{0x00, 0x0c, 0x04, 0x03, 0x05, 0x03, 0x06, 0x03, 0x08, 0x04, 0x08, 0x05, 0x04, 0x01})
+	})
+	// encrypt_then_mac, extended_master_secret
+	writeExtension(ext, 0x0016, func(b *bytes.Buffer) {})
+	writeExtension(ext, 0x0017, func(b *bytes.Buffer) {})
+
+	hello := &bytes.Buffer{}
+	hello.Write([]byte{0x03, 0x03})
+	random := make([]byte, 32+32)
+	rand.Read(random)
+	binary.BigEndian.PutUint32(random, uint32(time.Now().Unix()))
+	hello.Write(random[:32])
+	hello.WriteByte(32)
+	hello.Write(random[32:])
+	suites := []byte{0xc0, 0x2c, 0xc0, 0x30, 0x00, 0x9f, 0xcc, 0xa9, 0xcc, 0xa8, 0xcc, 0xaa, 0xc0, 0x2b, 0xc0, 0x2f,
+		0x00, 0x9e, 0xc0, 0x24, 0xc0, 0x28, 0x00, 0x6b, 0xc0, 0x23, 0xc0, 0x27, 0x00, 0x67, 0xc0, 0x0a,
+		0xc0, 0x14, 0x00, 0x39, 0xc0, 0x09, 0xc0, 0x13, 0x00, 0x33, 0x00, 0x9d, 0x00, 0x9c, 0x00, 0x3d,
+		0x00, 0x3c, 0x00, 0x35, 0x00, 0x2f, 0x00, 0xff}
+	writeUint16(hello, uint16(len(suites)))
+	hello.Write(suites)
+	hello.Write([]byte{0x01, 0x00}) // compression: null
+	writeUint16(hello, uint16(ext.Len()))
+	hello.Write(ext.Bytes())
+
+	record := &bytes.Buffer{}
+	record.Write([]byte{0x16, 0x03, 0x01})
+	writeUint16(record, uint16(hello.Len()+4))
+	record.WriteByte(0x01) // client_hello
+	record.Write([]byte{byte(hello.Len() >> 16), byte(hello.Len() >> 8), byte(hello.Len())})
+	record.Write(hello.Bytes())
+	return record.Bytes()
+}
+
+func writeExtension(b *bytes.Buffer, typ uint16, body func(*bytes.Buffer)) {
+	data := &bytes.Buffer{}
+	body(data)
+	writeUint16(b, typ)
+	writeUint16(b, uint16(data.Len()))
+	b.Write(data.Bytes())
+}
+
+func writeUint16(b *bytes.Buffer, v uint16) {
+	b.Write([]byte{byte(v >> 8), byte(v)})
+}
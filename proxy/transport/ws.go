@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+)
+
+const (
+	TransportWS  = "ws"
+	TransportWSS = "wss"
+)
+
+func init() {
+	proxy.RegisterTransport(TransportWS, newWSTransport)
+	proxy.RegisterTransport(TransportWSS, newWSTransport)
+}
+
+func newWSTransport(o *proxy.TransportOptions) (proxy.ITransport, error) {
+	if len(o.Path) == 0 {
+		o.Path = "/"
+	}
+	return &wsTransport{o}, nil
+}
+
+// websocket transport, every write is sent as a binary message
+type wsTransport struct {
+	*proxy.TransportOptions
+}
+
+func (t *wsTransport) Wrap(c net.Conn) (net.Conn, error) {
+	u := &url.URL{Scheme: t.Name, Host: t.Host, Path: t.Path}
+	d := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return c, nil
+		},
+		TLSClientConfig: &tls.Config{
+			ServerName:         t.ServerName(),
+			InsecureSkipVerify: t.SkipVerify,
+		},
+		HandshakeTimeout: conn.DefaultTimeOut,
+	}
+	wc, resp, err := d.Dial(u.String(), t.Header)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wsConn{Conn: wc}, nil
+}
+
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader
+	wLock  sync.Mutex
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, err := c.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	c.wLock.Lock()
+	_ = c.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.wLock.Unlock()
+	return c.Conn.Close()
+}
+
+// ping of websocket, answered by the server
+func (c *wsConn) Heartbeat() error {
+	return c.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.DefaultTimeOut))
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
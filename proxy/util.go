@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,7 +28,45 @@ func Duration2Str(d time.Duration) string {
 	return "0s"
 }
 
+var rttLimit chan struct{} // nil: unlimited
+
+var healthChecksPaused int32
+
+// pause periodic RTT tests of groups, e.g. while load shedding. manual refresh still tests
+func PauseHealthChecks(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&healthChecksPaused, v)
+}
+
+func HealthChecksPaused() bool {
+	return atomic.LoadInt32(&healthChecksPaused) == 1
+}
+
+func applyRttLimit(v string) error {
+	if len(v) == 0 {
+		rttLimit = nil
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("resolve config file [General] [max-rtt-tests] [%s] failed", v)
+	}
+	if n == 0 {
+		rttLimit = nil
+	} else {
+		rttLimit = make(chan struct{}, n)
+	}
+	return nil
+}
+
 func TestRTT(s IServer, testURL string) (rtt time.Duration, err error) {
+	if limit := rttLimit; limit != nil {
+		limit <- struct{}{}
+		defer func() { <-limit }()
+	}
 	var server *Server
 	server, err = s.GetServer()
 	if err != nil {
@@ -72,6 +112,22 @@ func TestRTT(s IServer, testURL string) (rtt time.Duration, err error) {
 	return
 }
 
+func NewRequest(network, addr string) (IRequest, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	req := &_HttpRequest{
+		network: network,
+		domain:  host,
+		port:    port,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		req.domain, req.ip = "", host
+	}
+	return req, nil
+}
+
 //HTTP Request
 type _HttpRequest struct {
 	network string
@@ -0,0 +1,136 @@
+package shuttle
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReloadQueue   = 1024
+	DefaultReloadTimeout = 5 * time.Second
+)
+
+var (
+	ErrorReloadQueueFull = errors.New("reload queue is full")
+	ErrorReloadTimeout   = errors.New("wait for reload timeout")
+)
+
+type IReloadConfig interface {
+	GetReloadQueue() string
+	GetReloadTimeout() string
+}
+
+// new connections wait while the config is being applied, instead of
+// racing against half-built rules and servers
+type reloadGate struct {
+	sync.Mutex
+	done    chan struct{} // closed when applied, nil outside reload
+	waiting int
+	queue   int // 0: no waiting
+	timeout time.Duration
+}
+
+var gate = &reloadGate{
+	queue:   DefaultReloadQueue,
+	timeout: DefaultReloadTimeout,
+}
+
+func ApplyReloadConfig(config IReloadConfig) error {
+	queue, err := parseReloadQueue(config.GetReloadQueue())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [reload-queue] failed: %v", err)
+	}
+	timeout, err := parseReloadTimeout(config.GetReloadTimeout())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [reload-timeout] failed: %v", err)
+	}
+	gate.Lock()
+	gate.queue, gate.timeout = queue, timeout
+	gate.Unlock()
+	return nil
+}
+
+// empty means DefaultReloadQueue
+func parseReloadQueue(v string) (int, error) {
+	if len(v) == 0 {
+		return DefaultReloadQueue, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid queue size [%s]", v)
+	}
+	return n, nil
+}
+
+// empty means DefaultReloadTimeout
+func parseReloadTimeout(v string) (time.Duration, error) {
+	if len(v) == 0 {
+		return DefaultReloadTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout [%s]", v)
+	}
+	return d, nil
+}
+
+// start applying config, new connections wait until EndReload
+func BeginReload() {
+	gate.Lock()
+	if gate.done == nil {
+		gate.done = make(chan struct{})
+	}
+	gate.Unlock()
+}
+
+// config applied, release the waiting connections
+func EndReload() {
+	gate.Lock()
+	if gate.done != nil {
+		close(gate.done)
+		gate.done = nil
+		if gate.waiting > 0 {
+			reloadLog.Debugf("[Reload] release [%d] waiting connections", gate.waiting)
+		}
+	}
+	gate.Unlock()
+}
+
+// wait for the config being applied, returns at once outside reload
+func waitReload() error {
+	gate.Lock()
+	done, timeout := gate.done, gate.timeout
+	if done == nil {
+		gate.Unlock()
+		return nil
+	}
+	if gate.waiting >= gate.queue {
+		gate.Unlock()
+		return ErrorReloadQueueFull
+	}
+	gate.waiting++
+	gate.Unlock()
+	defer func() {
+		gate.Lock()
+		gate.waiting--
+		gate.Unlock()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return ErrorReloadTimeout
+	}
+}
+
+// a config is being applied
+func reloading() bool {
+	gate.Lock()
+	defer gate.Unlock()
+	return gate.done != nil
+}
@@ -0,0 +1,76 @@
+package shuttle
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const (
+	headerShuttlePolicy = "X-Shuttle-Policy"
+
+	RequestPolicyGlobal = "global"
+	RequestPolicyDirect = "direct"
+	RequestPolicyReject = "reject"
+	RequestPolicyRule   = "rule"
+)
+
+// policy of a single request regardless of conn mode, for quick comparisons:
+// SOCKS5 username "user#global", HTTP header "X-Shuttle-Policy: global".
+// global, direct, reject, rule (rules even in global/direct mode) or the name of a server or group
+type policyRequest interface {
+	Policy() string
+}
+
+func connPolicy(c connect.IConn) string {
+	policy, _ := c.Context().Value("policy").(string)
+	return policy
+}
+
+// the policy of a connection of shuttle itself is kept
+func setConnPolicy(c connect.IConn, policy string) {
+	if len(policy) > 0 && !connSelf(c) {
+		c.SetContext(context.WithValue(c.Context(), "policy", policy))
+	}
+}
+
+// policy header of the request, removed before forwarding
+func httpPolicy(hreq *http.Request) string {
+	policy := hreq.Header.Get(headerShuttlePolicy)
+	hreq.Header.Del(headerShuttlePolicy)
+	return policy
+}
+
+func filterByPolicy(req IRequest, policy string) (r *rule.Rule, s *proxy.Server, err error) {
+	r = &rule.Rule{Type: rule.RuleOverride, Value: policy, Policy: policy}
+	switch strings.ToLower(policy) {
+	case RequestPolicyGlobal:
+		r.Policy = rule.PolicyGlobal
+	case RequestPolicyDirect:
+		r.Policy = rule.PolicyDirect
+	case RequestPolicyReject:
+		r.Policy = rule.PolicyReject
+	case RequestPolicyRule:
+		if r, err = rule.MatchRule(req); err != nil {
+			return
+		}
+		if r == nil {
+			r = &rule.Rule{Type: rule.RuleOverride, Value: policy, Policy: rule.PolicyDirect}
+		}
+	}
+	ruleLog.Infof("[RULE] [ID:%d] [%s, %s] policy of request [%s] rule: [%s, %s, %s]", req.ID(), req.Host(), req.Addr(),
+		policy, r.Type, r.Value, r.Policy)
+	s, err = proxy.GetServer(r.Policy)
+	if err != nil {
+		ruleLog.Errorf("[RULE] [ID:%d] policy of request [%s] not found", req.ID(), policy)
+		return
+	}
+	if s == proxy.RejectServer {
+		err = ErrorReject
+	}
+	return
+}
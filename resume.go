@@ -0,0 +1,232 @@
+package shuttle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	rule2 "github.com/sipt/shuttle/rule"
+)
+
+const (
+	defaultResumeMaxRetries = 3
+	defaultResumeMinSize    = 1024 * 1024
+)
+
+type IResumeConfig interface {
+	GetResume() *config.Resume
+}
+
+type resume struct {
+	maxRetries int
+	minSize    int64
+	groups     []string
+}
+
+var (
+	currentResume *resume
+	resumeLock    sync.RWMutex
+)
+
+func ApplyResumeConfig(config IResumeConfig) error {
+	r, err := parseResume(config.GetResume())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Resume] failed: %v", err)
+	}
+	resumeLock.Lock()
+	currentResume = r
+	resumeLock.Unlock()
+	if r != nil {
+		resumeLog.Infof("[Resume] resume downloads of %v from %d bytes, max retries: %d", r.groups, r.minSize, r.maxRetries)
+	}
+	return nil
+}
+
+// nil config or empty groups means resume off
+func parseResume(c *config.Resume) (*resume, error) {
+	if c == nil || len(c.Groups) == 0 {
+		return nil, nil
+	}
+	r := &resume{
+		maxRetries: defaultResumeMaxRetries,
+		minSize:    defaultResumeMinSize,
+		groups:     c.Groups,
+	}
+	var err error
+	if len(c.MaxRetries) > 0 {
+		r.maxRetries, err = strconv.Atoi(c.MaxRetries)
+		if err != nil || r.maxRetries <= 0 {
+			return nil, fmt.Errorf("max-retries [%s] is invalid", c.MaxRetries)
+		}
+	}
+	if len(c.MinSize) > 0 {
+		r.minSize, err = strconv.ParseInt(c.MinSize, 10, 64)
+		if err != nil || r.minSize < 0 {
+			return nil, fmt.Errorf("min-size [%s] is invalid", c.MinSize)
+		}
+	}
+	return r, nil
+}
+
+func getResume() *resume {
+	resumeLock.RLock()
+	defer resumeLock.RUnlock()
+	return currentResume
+}
+
+// body of [resp] which is resumed through the server selected then if it breaks, nil if [resp]
+// can not be resumed: a complete GET of known length and a strong validator, of a group to resume
+func resumeResponse(hreq *http.Request, resp *http.Response, lc connect.IConn, rule *rule2.Rule,
+	policy string, recordID int64, isHttps bool) *resumeBody {
+	r := getResume()
+	if r == nil || rule == nil || hreq.Method != http.MethodGet || len(hreq.Header.Get("Range")) > 0 ||
+		resp.StatusCode != http.StatusOK || resp.ContentLength < r.minSize || resp.ContentLength <= 0 {
+		return nil
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return nil
+	}
+	validator := resp.Header.Get("ETag")
+	if len(validator) == 0 || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if len(validator) == 0 {
+		return nil
+	}
+	matched := false
+	for _, v := range r.groups {
+		if v == "*" || v == rule.Policy {
+			_, matched = proxy.GroupExist(rule.Policy)
+			break
+		}
+	}
+	if !matched {
+		return nil
+	}
+	b := &resumeBody{
+		ReadCloser: resp.Body,
+		hreq:       hreq,
+		lc:         lc,
+		policy:     policy,
+		validator:  validator,
+		recordID:   recordID,
+		isHttps:    isHttps,
+		total:      resp.ContentLength,
+		retries:    r.maxRetries,
+	}
+	resp.Body = b
+	return b
+}
+
+// the client reads one body, the rest is requested by Range when the upstream breaks
+type resumeBody struct {
+	io.ReadCloser
+	hreq      *http.Request
+	lc        connect.IConn
+	policy    string
+	validator string // If-Range
+	recordID  int64
+	isHttps   bool
+	read      int64
+	total     int64
+	retries   int
+
+	// upstream of the last resume, replaces the connection of the channel
+	conn   connect.IConn
+	reader *bufio.Reader
+	server *proxy.Server
+}
+
+func (b *resumeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	for err != nil && err != io.EOF && b.read < b.total && b.retries > 0 {
+		b.retries--
+		resumeLog.Infof("[Resume] [ID:%d] download broken at %d/%d bytes: %v", b.lc.GetID(), b.read, b.total, err)
+		if rerr := b.resume(); rerr != nil {
+			resumeLog.Errorf("[Resume] [ID:%d] resume failed: %v", b.lc.GetID(), rerr)
+			break
+		}
+		err = nil
+		if n == 0 {
+			n, err = b.ReadCloser.Read(p)
+			b.read += int64(n)
+		}
+	}
+	return n, err
+}
+
+// close the upstream of the last resume, for a channel which does not take it over
+func (b *resumeBody) closeConn() {
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn, b.reader, b.server = nil, nil, nil
+	}
+}
+
+// request the rest through the server of the rule now, e.g. after a node switch
+func (b *resumeBody) resume() error {
+	b.ReadCloser.Close()
+	b.closeConn()
+	req := upstreamRequest(b.hreq, b.lc, b.isHttps)
+	req.policy = b.policy
+	rule, server, err := FilterByReq(req)
+	if err != nil {
+		return err
+	}
+	dialPolicy := proxy.ProxyDirect
+	if rule != nil {
+		dialPolicy = rule.Policy
+	}
+	sc, err := server.ConnWithDialer(req, proxy.PolicyDialer(dialPolicy, server.Name))
+	if err != nil {
+		return err
+	}
+	hreq := new(http.Request)
+	*hreq = *b.hreq
+	hreq.Header = make(http.Header, len(b.hreq.Header)+2)
+	for k, vs := range b.hreq.Header {
+		hreq.Header[k] = vs
+	}
+	hreq.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.read))
+	hreq.Header.Set("If-Range", b.validator)
+	hreq.Body = http.NoBody
+	sc, reader, resp, err := sendUpstream(sc, req, hreq, b.recordID, b.isHttps)
+	if err != nil {
+		return err
+	}
+	if err = b.checkRange(resp); err != nil {
+		resp.Body.Close()
+		sc.Close()
+		return err
+	}
+	resumeLog.Infof("[Resume] [ID:%d] resumed from %d bytes through [%s]", b.lc.GetID(), b.read, server.Name)
+	boxChan <- &Box{b.recordID, RecordProxy, server}
+	b.ReadCloser, b.conn, b.reader, b.server = resp.Body, sc, reader, server
+	return nil
+}
+
+// 206 of the same entity from the offset: "bytes start-end/total"
+func (b *resumeBody) checkRange(resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		// 200 of If-Range: the entity changed
+		return fmt.Errorf("status %d of range request", resp.StatusCode)
+	}
+	cr := resp.Header.Get("Content-Range")
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(strings.Replace(cr, "/", " ", 1), "bytes %d-%d %s", &start, &end, &total); err != nil {
+		return fmt.Errorf("Content-Range [%s] is invalid", cr)
+	}
+	if start != b.read || end != b.total-1 || (total != "*" && total != strconv.FormatInt(b.total, 10)) {
+		return fmt.Errorf("Content-Range [%s] is not the rest of %d bytes", cr, b.total)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package shuttle
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	RouteStepDNS    = "dns"
+	RouteStepRule   = "rule"
+	RouteStepSelect = "select"
+	RouteStepDial   = "dial"
+)
+
+type RouteStep struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type RouteTrace struct {
+	Addr     string        `json:"addr"`
+	Network  string        `json:"network"`
+	Rule     *rule.Rule    `json:"rule,omitempty"`
+	Server   string        `json:"server,omitempty"`
+	Path     []string      `json:"path,omitempty"`
+	Steps    []*RouteStep  `json:"steps"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (t *RouteTrace) step(name string, start time.Time, result string, err error) {
+	s := &RouteStep{
+		Name:     name,
+		Result:   result,
+		Duration: time.Now().Sub(start),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	t.Steps = append(t.Steps, s)
+}
+
+// run [addr] through DNS, rules and group selection like a real connection,
+// dial to the selected server if [dial] is true
+func Route(addr, network string, dial bool) (*RouteTrace, error) {
+	return route(addr, network, dial, nil, nil)
+}
+
+// route through [rules] and [servers] of a config not applied yet, DNS is the running one.
+// it never dials
+func RouteCandidate(addr, network string, rules []*rule.Rule, servers *proxy.ServerSet) (*RouteTrace, error) {
+	return route(addr, network, false, rules, servers)
+}
+
+// the applied rules and servers when [servers] is nil
+func route(addr, network string, dial bool, rules []*rule.Rule, servers *proxy.ServerSet) (*RouteTrace, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("[Route] addr [%s] error: %v", addr, err)
+	}
+	if len(network) == 0 {
+		network = connect.TCP
+	}
+	if network != connect.TCP && network != connect.UDP {
+		return nil, fmt.Errorf("[Route] not support network [%s]", network)
+	}
+	req := NewHttpRequest(network, host, "", port, "", addr, util.GetLongID(), nil, nil)
+	if ip := net.ParseIP(host); ip != nil {
+		req.domain, req.ip = "", host
+	}
+	trace := &RouteTrace{
+		Addr:    addr,
+		Network: network,
+	}
+	begin := time.Now()
+	defer func() { trace.Duration = time.Now().Sub(begin) }()
+
+	//DNS
+	start := time.Now()
+	var answer *dns.Answer
+	if len(req.IP()) == 0 {
+		answer, err = dns.ResolveDomainByCache(req.Domain())
+	} else {
+		answer, err = dns.ResolveIP(req.IP())
+	}
+	result := ""
+	if answer != nil {
+		result = fmt.Sprintf("%v %s", answer.IPs, answer.Country)
+	}
+	trace.step(RouteStepDNS, start, result, err)
+	if err != nil {
+		return trace, nil
+	}
+	req.SetAnswer(answer)
+
+	//Rule
+	start = time.Now()
+	var r *rule.Rule
+	if servers == nil {
+		r, err = rule.RuleFilter(req)
+	} else {
+		r, err = rule.FilterRules(rules, req)
+	}
+	if r == nil && err == nil {
+		r = &rule.Rule{Type: rule.PolicyNone, Policy: rule.PolicyDirect}
+	}
+	if r != nil {
+		result = fmt.Sprintf("%s,%s,%s", r.Type, r.Value, r.Policy)
+	}
+	trace.step(RouteStepRule, start, result, err)
+	if err != nil {
+		return trace, nil
+	}
+	trace.Rule = r
+
+	//Group selection
+	start = time.Now()
+	var s *proxy.Server
+	if servers == nil {
+		trace.Path = selectPath(r.Policy, proxy.GroupExist)
+		s, err = proxy.GetServer(r.Policy)
+	} else {
+		trace.Path = selectPath(r.Policy, servers.GroupExist)
+		s, err = servers.GetServer(r.Policy)
+	}
+	if err == nil {
+		trace.Server = s.Name
+	}
+	trace.step(RouteStepSelect, start, trace.Server, err)
+	if err != nil || !dial {
+		return trace, nil
+	}
+
+	//Dial
+	start = time.Now()
+	sc, err := s.ConnWithDialer(req, proxy.PolicyDialer(r.Policy, s.Name))
+	result = ""
+	if err == nil {
+		result = sc.RemoteAddr().String()
+		sc.Close()
+	}
+	trace.step(RouteStepDial, start, result, err)
+	return trace, nil
+}
+
+// return the group chain from policy to the selected server
+func selectPath(policy string, groupExist func(name string) (*proxy.ServerGroup, bool)) []string {
+	path := []string{policy}
+	name := policy
+	if c := proxy.GetCompositePolicy(name); c != nil {
+		name = c.Target
+		path = append(path, name)
+	}
+	for i := 0; i < 16; i++ {
+		g, ok := groupExist(name)
+		if !ok || g.Selector == nil {
+			break
+		}
+		current := g.Selector.Current()
+		if current == nil {
+			break
+		}
+		name = current.GetName()
+		path = append(path, name)
+	}
+	return path
+}
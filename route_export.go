@@ -0,0 +1,299 @@
+package shuttle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/extension/process"
+	"github.com/sipt/shuttle/rule"
+)
+
+const (
+	RouteExportNftables = "nftables"
+	RouteExportIPSet    = "ipset"
+	RouteExportJSON     = "json"
+
+	DefaultRouteExportInterval = time.Minute
+	DefaultRouteExportSet      = "shuttle_direct"
+	DefaultRouteExportTable    = "inet shuttle"
+
+	minRouteExportInterval = 10 * time.Second
+)
+
+type IRouteExportConfig interface {
+	GetRouteExport() *config.RouteExport
+}
+
+// IPv4 destinations which are always DIRECT, routers short-circuit them in kernel space
+type RouteExportFeed struct {
+	Updated time.Time `json:"updated"`
+	Mode    string    `json:"mode"`
+	Set     string    `json:"set"`
+	CIDRs   []string  `json:"cidrs"` // IP-CIDR rules
+	IPs     []string  `json:"ips"`   // DNS cache
+}
+
+type routeExporter struct {
+	interval time.Duration
+	format   string
+	file     string
+	set      string
+	table    string
+	command  []string
+	done     chan struct{}
+	last     []byte
+}
+
+var (
+	exporter     *routeExporter
+	exporterLock sync.Mutex
+)
+
+func ApplyRouteExportConfig(config IRouteExportConfig) error {
+	e, err := parseRouteExport(config.GetRouteExport())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Route-Export] %v", err)
+	}
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	if exporter != nil {
+		close(exporter.done)
+		exporter = nil
+	}
+	if e != nil {
+		exporter = e
+		go e.run()
+	}
+	return nil
+}
+
+// nil means off
+func parseRouteExport(c *config.RouteExport) (*routeExporter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	e := &routeExporter{
+		interval: DefaultRouteExportInterval,
+		format:   c.Format,
+		file:     c.File,
+		set:      c.Set,
+		table:    c.Table,
+		command:  strings.Fields(c.Command),
+		done:     make(chan struct{}),
+	}
+	switch e.format {
+	case RouteExportNftables, RouteExportIPSet, RouteExportJSON:
+	default:
+		return nil, fmt.Errorf("[format] not support [%s], support: nftables, ipset, json", c.Format)
+	}
+	if len(e.file) == 0 {
+		return nil, fmt.Errorf("[file] is empty")
+	}
+	if len(c.Interval) > 0 {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil || d < minRouteExportInterval {
+			return nil, fmt.Errorf("[interval] invalid [%s], at least %s", c.Interval, minRouteExportInterval)
+		}
+		e.interval = d
+	}
+	if len(e.set) == 0 {
+		e.set = DefaultRouteExportSet
+	}
+	if len(e.table) == 0 {
+		e.table = DefaultRouteExportTable
+	}
+	return e, nil
+}
+
+func (e *routeExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.export()
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write the file and run the command when the entries changed
+func (e *routeExporter) export() {
+	if atomic.LoadInt32(&profileIdle) == 1 {
+		return // the rules are unloaded and the DNS cache is empty, keep the last entries
+	}
+	feed := ExportDirectRoutes(e.set)
+	data, err := e.render(feed)
+	if err != nil {
+		routeExportLog.Errorf("[Route-Export] render [%s] failed: %v", e.format, err)
+		return
+	}
+	if bytes.Equal(data, e.last) {
+		return
+	}
+	if err = ioutil.WriteFile(e.file, data, 0644); err != nil {
+		routeExportLog.Errorf("[Route-Export] write [%s] failed: %v", e.file, err)
+		return
+	}
+	e.last = data
+	routeExportLog.Infof("[Route-Export] export [%d] CIDRs [%d] IPs to [%s]", len(feed.CIDRs), len(feed.IPs), e.file)
+	if len(e.command) > 0 {
+		if out, err := exec.Command(e.command[0], e.command[1:]...).CombinedOutput(); err != nil {
+			routeExportLog.Errorf("[Route-Export] run [%s] failed: %v %s", strings.Join(e.command, " "), err, out)
+		}
+	}
+}
+
+// without the update time, it's compared between exports
+func (e *routeExporter) render(feed *RouteExportFeed) ([]byte, error) {
+	entries := append(append([]string{}, feed.CIDRs...), feed.IPs...)
+	buf := &bytes.Buffer{}
+	switch e.format {
+	case RouteExportNftables:
+		fmt.Fprintf(buf, "add table %s\n", e.table)
+		fmt.Fprintf(buf, "add set %s %s { type ipv4_addr; flags interval; auto-merge; }\n", e.table, e.set)
+		fmt.Fprintf(buf, "flush set %s %s\n", e.table, e.set)
+		if len(entries) > 0 {
+			fmt.Fprintf(buf, "add element %s %s { %s }\n", e.table, e.set, strings.Join(entries, ", "))
+		}
+	case RouteExportIPSet:
+		fmt.Fprintf(buf, "create %s hash:net family inet -exist\n", e.set)
+		fmt.Fprintf(buf, "flush %s\n", e.set)
+		for _, v := range entries {
+			fmt.Fprintf(buf, "add %s %s -exist\n", e.set, v)
+		}
+	case RouteExportJSON:
+		f := *feed
+		f.Updated = time.Time{}
+		data, err := json.MarshalIndent(&f, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// IPv4 destinations of [set] routed DIRECT whatever the client is: IP-CIDR rules before any proxied
+// IP-CIDR and before any other rule which is not DIRECT, and cached IPs whose domains are all DIRECT.
+// Empty unless in rule mode
+func ExportDirectRoutes(set string) *RouteExportFeed {
+	if len(set) == 0 {
+		set = DefaultRouteExportSet
+	}
+	feed := &RouteExportFeed{
+		Updated: time.Now(),
+		Mode:    rule.GetConnMode(),
+		Set:     set,
+		CIDRs:   []string{},
+		IPs:     []string{},
+	}
+	if feed.Mode != rule.ConnModeRule {
+		return feed
+	}
+	var cidrs, proxied []*net.IPNet
+LOOP:
+	for _, r := range rule.Rules() {
+		switch r.Type {
+		case rule.RuleFinal:
+			break LOOP
+		case rule.RuleIPCIDR:
+			n := r.IPNet()
+			if n == nil || n.IP.To4() == nil {
+				continue
+			}
+			if r.Policy != rule.PolicyDirect {
+				proxied = append(proxied, n)
+			} else if !overlapNets(n, proxied) && !containsNet(cidrs, n) {
+				cidrs = append(cidrs, n)
+				feed.CIDRs = append(feed.CIDRs, n.String())
+			}
+		default:
+			// a domain, country or client of this rule may be in the later CIDRs
+			if r.Policy != rule.PolicyDirect {
+				break LOOP
+			}
+		}
+	}
+	direct, other := make(map[string]bool), make(map[string]bool)
+	now := time.Now()
+	for _, a := range dns.DNSCacheList() {
+		if len(a.Domain) == 0 || a.Expires.Before(now) {
+			continue
+		}
+		for _, ip := range a.IPs {
+			if net.ParseIP(ip).To4() == nil {
+				continue
+			}
+			r, err := rule.MatchDestination(&exportRequest{domain: a.Domain, ip: ip})
+			if err == nil && r != nil && r.Policy == rule.PolicyDirect {
+				direct[ip] = true
+			} else {
+				other[ip] = true
+			}
+		}
+	}
+	for ip := range direct {
+		if !other[ip] && !containsIP(cidrs, net.ParseIP(ip)) {
+			feed.IPs = append(feed.IPs, ip)
+		}
+	}
+	sort.Strings(feed.IPs)
+	return feed
+}
+
+func overlapNets(n *net.IPNet, nets []*net.IPNet) bool {
+	for _, v := range nets {
+		if v.Contains(n.IP) || n.Contains(v.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNet(nets []*net.IPNet, n *net.IPNet) bool {
+	for _, v := range nets {
+		if v.String() == n.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, v := range nets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// request to a cached destination, no client
+type exportRequest struct {
+	domain string
+	ip     string
+}
+
+func (r *exportRequest) Network() string { return "tcp" }
+func (r *exportRequest) Domain() string  { return r.domain }
+func (r *exportRequest) IP() string      { return r.ip }
+func (r *exportRequest) Port() string    { return "" }
+func (r *exportRequest) Answer() *dns.Answer {
+	answer, _ := dns.ResolveIP(r.ip)
+	return answer
+}
+func (r *exportRequest) Process() *process.Process { return nil }
+func (r *exportRequest) User() string              { return "" }
@@ -0,0 +1,64 @@
+package rule
+
+import (
+	"sync"
+	"time"
+)
+
+// rules of config are dropped while the profile is idle and rebuilt from
+// the lines of the applied config on the first match
+var (
+	rulesLock sync.RWMutex
+	source    ruleList
+	unloaded  bool
+
+	// matched while the rules can not be rebuilt, instead of going DIRECT
+	unbuiltRules = []*Rule{{Type: RuleFinal, Policy: PolicyReject, Comment: "rules not rebuilt"}}
+)
+
+// drop the rules of config until the next match, false if there are none
+func Unload() bool {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	if unloaded || source == nil {
+		return false
+	}
+	rules, unloaded = nil, true
+	return true
+}
+
+func Unloaded() bool {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	return unloaded
+}
+
+// policies were checked when the config was applied, a group without a server
+// to select at the moment must not fail the rebuild
+func policyChecked(string) error {
+	return nil
+}
+
+// rules of config, rebuilt if unloaded
+func configRules() []*Rule {
+	rulesLock.RLock()
+	rs, idle := rules, unloaded
+	rulesLock.RUnlock()
+	if !idle {
+		return rs
+	}
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	if unloaded {
+		start := time.Now()
+		rs, err := ParseRules(source, policyChecked)
+		if err != nil {
+			// still unloaded, rebuilt on the next match
+			ruleLog.Errorf("[Rule] rebuild rules failed, reject: %v", err)
+			return unbuiltRules
+		}
+		rules, unloaded = rs, false
+		ruleLog.Infof("[Rule] %d rules rebuilt in %s", len(rules), time.Now().Sub(start))
+	}
+	return rules
+}
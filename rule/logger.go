@@ -0,0 +1,6 @@
+package rule
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var ruleLog = log.Named("rule")
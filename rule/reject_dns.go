@@ -0,0 +1,20 @@
+package rule
+
+import (
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/proxy"
+)
+
+func init() {
+	dns.SetRejectMatcher(domainRejected)
+}
+
+// rejected by the rules without the IP and the client, a policy (group, composite) of REJECT as well
+func domainRejected(domain string) bool {
+	r := MatchDomain(domain)
+	if r == nil {
+		return false
+	}
+	s, err := proxy.GetServer(r.Policy)
+	return err == nil && s == proxy.RejectServer
+}
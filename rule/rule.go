@@ -3,7 +3,9 @@ package rule
 import (
 	"fmt"
 	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/extension/process"
 	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/util"
 	"net"
 	"strings"
 )
@@ -21,6 +23,10 @@ const (
 	RuleGeoIP         = "GEOIP"
 	RuleFinal         = "FINAL"
 	RuleIPCIDR        = "IP-CIDR"
+	RuleProcessName   = "PROCESS-NAME"
+	RuleProcessPath   = "PROCESS-PATH"
+	RuleUser          = "USER"
+	RuleOverride      = "OVERRIDE" // policy of a single request
 
 	ConnModeDirect = "DIRECT"
 	ConnModeRemote = "REMOTE"
@@ -41,19 +47,51 @@ var (
 	MockRule   = &Rule{Type: "MOCK", Policy: PolicyMock}
 )
 
-var ipCidrMap map[string]*net.IPNet
-
 type IRuleConfig interface {
 	GetRule() [][]string
 	SetRule([][]string)
+	GetRuleInclude() [][]string
 }
 
 func ApplyConfig(config IRuleConfig) error {
+	rs, err := buildRules(config, policyExist)
+	if err != nil {
+		return err
+	}
+	// only the lines are kept to rebuild the rules, not the config
+	lines := make(ruleList, 0, len(rs))
+	lines = append(append(lines, config.GetRuleInclude()...), config.GetRule()...)
+	rulesLock.Lock()
+	rules, source, unloaded = rs, lines, false
+	rulesLock.Unlock()
+	pruneTemporaryRules(policyExist)
+	return nil
+}
+
+func policyExist(policy string) error {
+	_, err := proxy.GetServer(policy)
+	return err
+}
+
+// rules of the include file first
+func buildRules(config IRuleConfig, exist func(policy string) error) ([]*Rule, error) {
+	included, err := ParseRules(ruleList(config.GetRuleInclude()), exist)
+	if err != nil {
+		return nil, fmt.Errorf("[rule-include] %v", err)
+	}
+	rs, err := ParseRules(config, exist)
+	if err != nil {
+		return nil, err
+	}
+	return append(included, rs...), nil
+}
+
+// parse rules of config, [exist] checks whether the policy is a server or group
+func ParseRules(config IRuleConfig, exist func(policy string) error) ([]*Rule, error) {
 	rs := make([]*Rule, len(config.GetRule()))
-	ipCidrMap = make(map[string]*net.IPNet, 16)
 	for i, v := range config.GetRule() {
 		if len(v) != 4 {
-			return fmt.Errorf("resolve config file [rule] %v length must be 4", v)
+			return nil, fmt.Errorf("resolve config file [rule] %v length must be 4", v)
 		}
 		rs[i] = &Rule{
 			Type:    v[0],
@@ -61,28 +99,33 @@ func ApplyConfig(config IRuleConfig) error {
 			Policy:  v[2],
 			Comment: v[3],
 		}
-		if _, err := proxy.GetServer(v[2]); err != nil {
-			return fmt.Errorf("resolve config file [rule] not support policy[%s]", v[2])
+		if err := exist(v[2]); err != nil {
+			return nil, fmt.Errorf("resolve config file [rule] not support policy[%s]", v[2])
+		}
+		switch v[0] {
+		case RuleDomain, RuleDomainSuffix:
+			rs[i].Value = util.CanonicalHost(v[1])
+		case RuleDomainKeyword:
+			rs[i].Value = strings.ToLower(v[1])
 		}
 		if v[0] == RuleIPCIDR {
 			_, ipNet, err := net.ParseCIDR(v[1])
 			if err != nil {
-				return fmt.Errorf("[Rule] [IP-CIDR] [%s] error: %v", v[1], err)
+				return nil, fmt.Errorf("[Rule] [IP-CIDR] [%s] error: %v", v[1], err)
 			}
-			ipCidrMap[v[1]] = ipNet
+			rs[i].ipNet = ipNet
 		}
 	}
-	rules = rs
-	return nil
+	return rs, nil
 }
 
 func SetConnMode(mode string) error {
-	switch connMode {
+	switch mode {
 	case ConnModeDirect, ConnModeRemote, ConnModeRule, ConnModeReject:
 		connMode = mode
 		return nil
 	default:
-		return nil
+		return fmt.Errorf("not support mode [%s]", mode)
 	}
 }
 
@@ -96,6 +139,8 @@ type IRequest interface {
 	IP() string
 	Port() string
 	Answer() *dns.Answer
+	Process() *process.Process
+	User() string
 }
 
 type Rule struct {
@@ -104,9 +149,95 @@ type Rule struct {
 	Policy  string
 	Options []string
 	Comment string
+	ipNet   *net.IPNet
+}
+
+// rules in effect: temporary rules, then the rules of the applied config
+func Rules() []*Rule {
+	return activeRules()
+}
+
+// network of IP-CIDR rule
+func (r *Rule) IPNet() *net.IPNet {
+	return r.ipNet
+}
+
+// match requests to the destination in [req] regardless of conn mode and clients,
+// nil when clients (users, processes) may be routed by other policies
+func MatchDestination(req IRequest) (*Rule, error) {
+	var clients []string // policies of client rules before
+	rs := activeRules()
+	for i, v := range rs {
+		switch v.Type {
+		case RuleProcessName, RuleProcessPath, RuleUser:
+			clients = append(clients, v.Policy)
+			continue
+		}
+		r, err := MatchRules(rs[i:i+1], req)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			continue
+		}
+		for _, p := range clients {
+			if p != r.Policy {
+				return nil, nil
+			}
+		}
+		return r, nil
+	}
+	return nil, nil
+}
+
+// match [domain] without resolving it, nil when a rule before needs the IP or the client
+func MatchDomain(domain string) *Rule {
+	switch connMode {
+	case ConnModeReject:
+		return RejectRule
+	case ConnModeDirect, ConnModeRemote:
+		return nil
+	}
+	domain = util.CanonicalHost(domain)
+	for _, v := range activeRules() {
+		switch v.Type {
+		case RuleDomainSuffix, RuleDomain, RuleDomainKeyword:
+			if r, _ := MatchRules([]*Rule{v}, &domainRequest{domain}); r != nil {
+				return r
+			}
+		case RuleFinal:
+			return v
+		default:
+			return nil
+		}
+	}
+	return nil
 }
 
+// a request of a domain only
+type domainRequest struct {
+	domain string
+}
+
+func (r *domainRequest) Network() string           { return "" }
+func (r *domainRequest) Domain() string            { return r.domain }
+func (r *domainRequest) IP() string                { return "" }
+func (r *domainRequest) Port() string              { return "" }
+func (r *domainRequest) Answer() *dns.Answer       { return nil }
+func (r *domainRequest) Process() *process.Process { return nil }
+func (r *domainRequest) User() string              { return "" }
+
 func RuleFilter(req IRequest) (*Rule, error) {
+	return FilterRules(activeRules(), req)
+}
+
+// match [req] with rules regardless of conn mode
+func MatchRule(req IRequest) (*Rule, error) {
+	return MatchRules(activeRules(), req)
+}
+
+// match [req] with rules [rs], conn mode first
+func FilterRules(rs []*Rule, req IRequest) (*Rule, error) {
 	switch connMode {
 	case ConnModeDirect:
 		return DirectRule, nil
@@ -115,30 +246,45 @@ func RuleFilter(req IRequest) (*Rule, error) {
 	case ConnModeReject:
 		return RejectRule, nil
 	}
+	return MatchRules(rs, req)
+}
 
-	for _, v := range rules {
+func MatchRules(rs []*Rule, req IRequest) (*Rule, error) {
+	domain := util.CanonicalHost(req.Domain())
+	for _, v := range rs {
 		switch v.Type {
 		case RuleDomainSuffix:
-			if req.Domain() == v.Value || strings.HasSuffix(req.Domain(), "."+v.Value) {
+			if domain == v.Value || strings.HasSuffix(domain, "."+v.Value) {
 				return v, nil
 			}
 		case RuleDomain:
-			if req.Domain() == v.Value {
+			if domain == v.Value {
 				return v, nil
 			}
 		case RuleDomainKeyword:
-			if strings.Index(req.Domain(), v.Value) >= 0 {
+			if strings.Contains(domain, v.Value) || strings.Contains(util.UnicodeHost(domain), v.Value) {
 				return v, nil
 			}
 		case RuleIPCIDR:
-			if len(req.IP()) > 0 && ipCidrMap[v.Value].Contains(net.ParseIP(req.IP())) {
-				fmt.Println(v.Value, ":", req.IP(), ipCidrMap[v.Value].Contains(net.ParseIP(req.IP())))
+			if len(req.IP()) > 0 && v.ipNet.Contains(net.ParseIP(req.IP())) {
 				return v, nil
 			}
 		case RuleGeoIP:
 			if req.Answer() != nil && v.Value == req.Answer().Country {
 				return v, nil
 			}
+		case RuleProcessName:
+			if p := req.Process(); p != nil && strings.EqualFold(p.Name, v.Value) {
+				return v, nil
+			}
+		case RuleProcessPath:
+			if p := req.Process(); p != nil && p.Path == v.Value {
+				return v, nil
+			}
+		case RuleUser:
+			if req.User() == v.Value {
+				return v, nil
+			}
 		case RuleFinal:
 			return v, nil
 		}
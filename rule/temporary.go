@@ -0,0 +1,128 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const TemporaryComment = "temporary"
+
+var ErrorTemporaryNotFound = errors.New("temporary rule not found")
+
+// rule injected at runtime, matched before the rules of config until it expires.
+// never saved to the config file
+type TemporaryRule struct {
+	ID      int64     `json:"id"`
+	Rule    []string  `json:"rule"`
+	Expires time.Time `json:"expires"`
+	rule    *Rule
+	timer   *time.Timer
+}
+
+var (
+	temporaries   []*TemporaryRule // newest first
+	temporaryID   int64
+	temporaryLock sync.RWMutex
+)
+
+type ruleList [][]string
+
+func (l ruleList) GetRule() [][]string        { return l }
+func (l ruleList) SetRule([][]string)         {}
+func (l ruleList) GetRuleInclude() [][]string { return nil }
+
+// add [v] (type, value, policy[, comment]) for [ttl], [exist] checks the policy as ParseRules
+func AddTemporaryRule(v []string, ttl time.Duration, exist func(policy string) error) (*TemporaryRule, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl [%s]", ttl)
+	}
+	if len(v) == 3 {
+		v = append(v[:3:3], TemporaryComment)
+	}
+	rs, err := ParseRules(ruleList{v}, exist)
+	if err != nil {
+		return nil, err
+	}
+	temporaryLock.Lock()
+	defer temporaryLock.Unlock()
+	temporaryID++
+	t := &TemporaryRule{
+		ID:      temporaryID,
+		Rule:    v,
+		Expires: time.Now().Add(ttl),
+		rule:    rs[0],
+	}
+	id := t.ID
+	t.timer = time.AfterFunc(ttl, func() {
+		if RemoveTemporaryRule(id) == nil {
+			ruleLog.Infof("[Rule] temporary rule [%d] %v expired", id, v)
+		}
+	})
+	temporaries = append([]*TemporaryRule{t}, temporaries...)
+	ruleLog.Infof("[Rule] add temporary rule [%d] %v for %s", t.ID, v, ttl)
+	return t, nil
+}
+
+func RemoveTemporaryRule(id int64) error {
+	temporaryLock.Lock()
+	defer temporaryLock.Unlock()
+	for i, t := range temporaries {
+		if t.ID == id {
+			t.timer.Stop()
+			temporaries = append(temporaries[:i:i], temporaries[i+1:]...)
+			return nil
+		}
+	}
+	return ErrorTemporaryNotFound
+}
+
+func ClearTemporaryRules() {
+	temporaryLock.Lock()
+	for _, t := range temporaries {
+		t.timer.Stop()
+	}
+	temporaries = nil
+	temporaryLock.Unlock()
+}
+
+func TemporaryRules() []*TemporaryRule {
+	temporaryLock.RLock()
+	defer temporaryLock.RUnlock()
+	return append([]*TemporaryRule{}, temporaries...)
+}
+
+// drop temporary rules whose policy is gone after config applied
+func pruneTemporaryRules(exist func(policy string) error) {
+	temporaryLock.Lock()
+	defer temporaryLock.Unlock()
+	kept := temporaries[:0:0]
+	for _, t := range temporaries {
+		if err := exist(t.rule.Policy); err != nil {
+			t.timer.Stop()
+			ruleLog.Infof("[Rule] remove temporary rule [%d] %v, policy [%s] is gone", t.ID, t.Rule, t.rule.Policy)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	temporaries = kept
+}
+
+// temporary rules first, then the rules of config
+func activeRules() []*Rule {
+	configured := configRules()
+	temporaryLock.RLock()
+	defer temporaryLock.RUnlock()
+	if len(temporaries) == 0 {
+		return configured
+	}
+	now := time.Now()
+	rs := make([]*Rule, 0, len(temporaries)+len(configured))
+	for _, t := range temporaries {
+		if t.Expires.After(now) {
+			rs = append(rs, t.rule)
+		}
+	}
+	return append(rs, configured...)
+}
@@ -0,0 +1,56 @@
+package shuttle
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+)
+
+type ISelfPolicyConfig interface {
+	GetSelfPolicy() string
+}
+
+// connections of shuttle (to servers, health checks, direct) coming back to its own inbounds,
+// e.g. a server or the system proxy pointing to shuttle, go through [selfPolicy] instead of the rules
+var (
+	selfPolicy     = proxy.ProxyDirect
+	selfPolicyLock sync.RWMutex
+)
+
+// after the servers and groups
+func ApplySelfPolicyConfig(config ISelfPolicyConfig) error {
+	policy := config.GetSelfPolicy()
+	if len(policy) == 0 {
+		policy = proxy.ProxyDirect
+	}
+	selfPolicyLock.Lock()
+	selfPolicy = policy
+	selfPolicyLock.Unlock()
+	return nil
+}
+
+func getSelfPolicy() string {
+	selfPolicyLock.RLock()
+	defer selfPolicyLock.RUnlock()
+	return selfPolicy
+}
+
+// an inbound connection [co] from shuttle itself is forced to the self policy, the policy of the request is ignored
+func markSelfConn(co net.Conn, c connect.IConn, protocol string) bool {
+	if !connect.IsSelf(co) {
+		return false
+	}
+	policy := getSelfPolicy()
+	shuttleLog.Infof("[%s] [ID:%d] [%s] connection of shuttle itself, loop is broken by policy [%s]",
+		protocol, c.GetID(), co.RemoteAddr(), policy)
+	c.SetContext(context.WithValue(context.WithValue(c.Context(), "policy", policy), "self", true))
+	return true
+}
+
+func connSelf(c connect.IConn) bool {
+	self, _ := c.Context().Value("self").(bool)
+	return self
+}
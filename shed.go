@@ -0,0 +1,229 @@
+package shuttle
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/proxy"
+)
+
+const (
+	DefaultShedInterval = 5 * time.Second
+
+	shedLeaveRatio   = 0.9 // leave shedding below 90% of the thresholds
+	shedDNSCacheSize = 256 // answers kept while shedding
+	shedRecordRatio  = 5   // records kept while shedding: 1/5
+	maxShedEvents    = 32
+)
+
+type ILoadSheddingConfig interface {
+	GetLoadShedding() *config.LoadShedding
+}
+
+// start or stop of shedding
+type ShedEvent struct {
+	Time     time.Time `json:"time"`
+	Shedding bool      `json:"shedding"`
+	Reason   string    `json:"reason"`
+}
+
+type ShedStatus struct {
+	Shedding  bool         `json:"shedding"`
+	Memory    uint64       `json:"memory"`     // bytes from the OS, not released
+	MaxMemory uint64       `json:"max_memory"` // 0: off
+	FDs       int          `json:"fds"`
+	MaxFDs    int          `json:"max_fds"` // threshold, 0: off or not supported
+	Events    []*ShedEvent `json:"events"`
+}
+
+// shedding while memory or open files are beyond thresholds: no new MitM, no dump and mirror
+// of HTTP data, smaller DNS cache and records, no periodic health checks
+type shedder struct {
+	memory   uint64
+	fds      float64 // ratio of the open files limit
+	interval time.Duration
+	done     chan struct{}
+}
+
+var (
+	shedding    int32
+	currentShed *shedder
+	shedLock    sync.Mutex
+	shedStatus  = &ShedStatus{Events: []*ShedEvent{}}
+)
+
+func ApplyLoadSheddingConfig(config ILoadSheddingConfig) error {
+	s, err := parseLoadShedding(config.GetLoadShedding())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Load-Shedding] %v", err)
+	}
+	shedLock.Lock()
+	defer shedLock.Unlock()
+	if currentShed != nil {
+		close(currentShed.done)
+		currentShed = nil
+	}
+	if s == nil {
+		if isShedding() {
+			setShedding(false, "load shedding is off")
+		}
+		shedStatus.MaxMemory, shedStatus.MaxFDs = 0, 0
+		return nil
+	}
+	currentShed = s
+	go s.run()
+	return nil
+}
+
+// nil means off
+func parseLoadShedding(c *config.LoadShedding) (*shedder, error) {
+	if c == nil || (len(c.Memory) == 0 && len(c.FDs) == 0) {
+		return nil, nil
+	}
+	s := &shedder{
+		interval: DefaultShedInterval,
+		done:     make(chan struct{}),
+	}
+	var err error
+	if len(c.Memory) > 0 {
+		if s.memory, err = parseBytes(c.Memory); err != nil || s.memory == 0 {
+			return nil, fmt.Errorf("[memory] invalid [%s], e.g. 200MB", c.Memory)
+		}
+	}
+	if len(c.FDs) > 0 {
+		if s.fds, err = strconv.ParseFloat(c.FDs, 64); err != nil || s.fds <= 0 || s.fds > 1 {
+			return nil, fmt.Errorf("[fds] invalid [%s], ratio of open files limit in (0, 1]", c.FDs)
+		}
+	}
+	if len(c.Interval) > 0 {
+		if s.interval, err = time.ParseDuration(c.Interval); err != nil || s.interval < time.Second {
+			return nil, fmt.Errorf("[interval] invalid [%s], at least 1s", c.Interval)
+		}
+	}
+	return s, nil
+}
+
+// "512KB", "200MB", "1GB" or bytes
+func parseBytes(v string) (uint64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	unit := uint64(1)
+	for _, u := range []struct {
+		suffix string
+		n      uint64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	return n * unit, err
+}
+
+func isShedding() bool {
+	return atomic.LoadInt32(&shedding) == 1
+}
+
+func GetShedStatus() *ShedStatus {
+	shedLock.Lock()
+	defer shedLock.Unlock()
+	s := *shedStatus
+	s.Shedding = isShedding()
+	s.Events = append([]*ShedEvent{}, shedStatus.Events...)
+	return &s
+}
+
+func (s *shedder) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.check()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *shedder) check() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	memory := m.Sys - m.HeapReleased
+	fds, limit := openFiles()
+	maxFDs := 0
+	if s.fds > 0 && limit > 0 {
+		maxFDs = int(s.fds * float64(limit))
+	}
+
+	var reasons []string
+	leave := true
+	if s.memory > 0 {
+		if memory >= s.memory {
+			reasons = append(reasons, fmt.Sprintf("memory %dMB >= %dMB", memory>>20, s.memory>>20))
+		}
+		leave = leave && float64(memory) < float64(s.memory)*shedLeaveRatio
+	}
+	if maxFDs > 0 {
+		if fds >= maxFDs {
+			reasons = append(reasons, fmt.Sprintf("open files %d >= %d", fds, maxFDs))
+		}
+		leave = leave && float64(fds) < float64(maxFDs)*shedLeaveRatio
+	}
+
+	shedLock.Lock()
+	defer shedLock.Unlock()
+	select {
+	case <-s.done:
+		return // replaced by a new config
+	default:
+	}
+	shedStatus.Memory, shedStatus.MaxMemory = memory, s.memory
+	shedStatus.FDs, shedStatus.MaxFDs = fds, maxFDs
+	switch {
+	case len(reasons) > 0 && !isShedding():
+		setShedding(true, strings.Join(reasons, ", "))
+	case leave && isShedding():
+		setShedding(false, fmt.Sprintf("memory %dMB, open files %d", memory>>20, fds))
+	}
+	if isShedding() {
+		// config may be reloaded while shedding
+		dns.ShrinkDNSCache(shedDNSCacheSize)
+	}
+}
+
+// with shedLock
+func setShedding(on bool, reason string) {
+	if on {
+		atomic.StoreInt32(&shedding, 1)
+		loadSheddingLog.Errorf("[Load-Shedding] start shedding: %s", reason)
+		dns.ShrinkDNSCache(shedDNSCacheSize)
+		proxy.PauseHealthChecks(true)
+		debug.FreeOSMemory()
+	} else {
+		atomic.StoreInt32(&shedding, 0)
+		loadSheddingLog.Infof("[Load-Shedding] stop shedding: %s", reason)
+		dns.ShrinkDNSCache(0)
+		proxy.PauseHealthChecks(false)
+	}
+	shedStatus.Events = append(shedStatus.Events, &ShedEvent{Time: time.Now(), Shedding: on, Reason: reason})
+	if n := len(shedStatus.Events); n > maxShedEvents {
+		shedStatus.Events = shedStatus.Events[n-maxShedEvents:]
+	}
+}
+
+// records kept in storage
+func recordLimit() int {
+	if isShedding() {
+		return maxCount / shedRecordRatio
+	}
+	return maxCount
+}
@@ -0,0 +1,21 @@
+// +build linux
+
+package shuttle
+
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+// open files of the process and the soft limit, 0 if unknown
+func openFiles() (int, int) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, 0
+	}
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0
+	}
+	return len(fds), int(rlimit.Cur)
+}
@@ -1,7 +1,6 @@
 package shuttle
 
 import (
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"io"
 )
@@ -30,7 +29,7 @@ func (s *Shunt) Write(p []byte) (n int, err error) {
 		copy(buf, p)
 		_, err := s.w2.Write(buf[:l])
 		if err != nil {
-			log.Logger.Errorf("[Shunt] [Sub2] Write data failed: %s", err.Error())
+			shuntLog.Errorf("[Shunt] [Sub2] Write data failed: %s", err.Error())
 		}
 	}
 	if s.w1 != nil {
@@ -0,0 +1,189 @@
+package shuttle
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+	"github.com/sipt/shuttle/sniff"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	RuleSNI = "SNI"
+
+	sniDefaultPort = "443"
+)
+
+type ISNIConfig interface {
+	GetSNIRouter() map[string][]string
+}
+
+type sniRoute struct {
+	pattern string
+	backend string
+	policy  string
+}
+
+var (
+	sniRoutes  []*sniRoute
+	sniDefault *sniRoute
+	sniLock    sync.RWMutex
+)
+
+func ApplySNIConfig(config ISNIConfig) error {
+	routes := make([]*sniRoute, 0, len(config.GetSNIRouter()))
+	var def *sniRoute
+	for k, v := range config.GetSNIRouter() {
+		if len(v) != 2 {
+			return fmt.Errorf("resolve config file [SNI-Router] [%s] length must be 2", k)
+		}
+		r := &sniRoute{pattern: canonicalSNIPattern(k), backend: v[0], policy: v[1]}
+		if len(r.backend) > 0 {
+			if _, _, err := net.SplitHostPort(r.backend); err != nil {
+				return fmt.Errorf("resolve config file [SNI-Router] [%s] backend [%s] failed: %v", k, r.backend, err)
+			}
+		}
+		if len(r.policy) > 0 {
+			if _, err := proxy.GetServer(r.policy); err != nil {
+				return fmt.Errorf("resolve config file [SNI-Router] [%s] not support policy [%s]", k, r.policy)
+			}
+		}
+		if k == "*" {
+			def = r
+		} else {
+			routes = append(routes, r)
+		}
+	}
+	sniLock.Lock()
+	sniRoutes, sniDefault = routes, def
+	sniLock.Unlock()
+	return nil
+}
+
+// "*.suffix" keeps the wildcard
+func canonicalSNIPattern(pattern string) string {
+	if strings.HasPrefix(pattern, "*.") {
+		return "*." + util.CanonicalHost(pattern[2:])
+	}
+	if pattern == "*" {
+		return pattern
+	}
+	return util.CanonicalHost(pattern)
+}
+
+// exact domain first, then "*.suffix", then "*"
+func matchSNIRoute(sni string) *sniRoute {
+	sniLock.RLock()
+	defer sniLock.RUnlock()
+	var matched *sniRoute
+	for _, r := range sniRoutes {
+		if r.pattern == sni {
+			return r
+		}
+		if strings.HasPrefix(r.pattern, "*.") && strings.HasSuffix(sni, r.pattern[1:]) {
+			if matched == nil || len(r.pattern) > len(matched.pattern) {
+				matched = r
+			}
+		}
+	}
+	if matched != nil {
+		return matched
+	}
+	return sniDefault
+}
+
+// accept TLS connection, route it by SNI without decrypting
+func SNIHandle(co net.Conn) {
+	conn, err := connect.NewDefaultConn(co, connect.TCP)
+	if err != nil {
+		shuttleLog.Errorf("shuttle.IConn wrap net.Conn failed: %v", err)
+		co.Close()
+		return
+	}
+	self := markSelfConn(co, conn, "SNI")
+	conn, data, err := connect.PeekDecorate(conn, connect.DefaultTimeOut)
+	if err != nil {
+		sniLog.Errorf("[SNI] [ID:%d] read ClientHello failed: %v", conn.GetID(), err)
+		conn.Close()
+		return
+	}
+	sni := util.CanonicalHost(sniff.TLSServerName(data))
+	if len(sni) == 0 {
+		sniLog.Errorf("[SNI] [ID:%d] SNI not found", conn.GetID())
+		conn.Close()
+		return
+	}
+	route := matchSNIRoute(sni)
+	if route == nil {
+		// not an open forwarder to any host, only "*" routes the others
+		sniLog.Errorf("[SNI] [ID:%d] [%s] no route matched, rejected", conn.GetID(), sni)
+		conn.Close()
+		return
+	}
+	host, port := sni, sniDefaultPort
+	if len(route.backend) > 0 {
+		host, port, _ = net.SplitHostPort(route.backend)
+	}
+	req := NewHttpRequest(connect.TCP, host, "", port, ProtocolSNI, net.JoinHostPort(sni, port),
+		conn.GetID(), nil, conn.RemoteAddr())
+	if ip := net.ParseIP(host); ip != nil {
+		req.domain, req.ip = "", host
+	}
+	sniLog.Debugf("[SNI] [ID:%d] [%s] -> [%s]", conn.GetID(), sni, req.Host())
+	if self {
+		req.policy = getSelfPolicy()
+	}
+
+	var (
+		r *rule.Rule
+		s *proxy.Server
+	)
+	if len(route.policy) > 0 && !self {
+		r = &rule.Rule{Type: RuleSNI, Value: route.pattern, Policy: route.policy}
+		s, err = proxy.GetServer(route.policy)
+		if err == nil && s.Name == proxy.ProxyReject {
+			err = ErrorReject
+		}
+	} else {
+		r, s, err = FilterByReq(req)
+	}
+	record := &Record{
+		ID:       util.NextID(),
+		Protocol: ProtocolSNI,
+		Created:  time.Now(),
+		Status:   RecordStatusActive,
+		URL:      req.target,
+		Rule:     r,
+		Proxy:    s,
+		Src:      srcString(conn.RemoteAddr()),
+	}
+	if err != nil {
+		sniLog.Errorf("[SNI] [ID:%d] ConnectToServer failed [%s] err: %v", conn.GetID(), req.Host(), err)
+		record.Status = RecordStatusCompleted
+		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+		conn.Close()
+		return
+	}
+	policy := proxy.ProxyDirect
+	if r != nil {
+		policy = r.Policy
+	}
+	sc, err := s.ConnWithDialer(req, proxy.PolicyDialer(policy, s.Name))
+	if err != nil {
+		sniLog.Errorf("[SNI] [ID:%d] ConnectToServer failed [%s] err: %v", conn.GetID(), req.Host(), err)
+		conn.Close()
+		return
+	}
+	sniLog.Debugf("[SNI] [ClientConnID:%d] Bind to [ServerConnID:%d]", conn.GetID(), sc.GetID())
+	sc.SetRecordID(record.ID)
+	boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+	direct := &DirectChannel{}
+	direct.Transport(conn, sc)
+	boxChan <- &Box{record.ID, RecordStatus, RecordStatusCompleted}
+}
@@ -0,0 +1,208 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/sniff"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	SniffingOff      = "off"
+	SniffingOn       = "on"
+	SniffingOverride = "override"
+
+	SniffInboundSocks = "socks"
+	SniffInboundHTTP  = "http" // HTTPS CONNECT to IP
+
+	SniffTimeout = 300 * time.Millisecond
+)
+
+// the server speaks first on these ports (FTP, SSH, SMTP, POP3, IMAP, MySQL...), the client sends nothing
+// to sniff until the greeting arrives, so they are not sniffed unless configured in [ports]
+var serverFirstPorts = map[string]bool{
+	"21": true, "22": true, "23": true, "25": true, "110": true, "143": true, "587": true, "3306": true,
+}
+
+type ISniffConfig interface {
+	GetSniffing() string
+	GetSniff() *config.Sniff
+}
+
+// mode of a request: mode of destination port, then of inbound, then [General] [sniffing] for socks
+type sniffPolicy struct {
+	inbounds  map[string]string
+	ports     map[string]string
+	timeout   time.Duration
+	protocols []string
+}
+
+var (
+	currentSniff = &sniffPolicy{
+		inbounds:  map[string]string{SniffInboundSocks: SniffingOff},
+		timeout:   SniffTimeout,
+		protocols: sniff.Protocols,
+	}
+	sniffLock sync.RWMutex
+)
+
+func ApplySniffConfig(config ISniffConfig) error {
+	if !validSniffMode(config.GetSniffing()) {
+		return fmt.Errorf("resolve config file [General] [sniffing] not support [%s]", config.GetSniffing())
+	}
+	p, err := parseSniff(config.GetSniffing(), config.GetSniff())
+	if err != nil {
+		return fmt.Errorf("resolve config file [Sniffing] %v", err)
+	}
+	sniffLock.Lock()
+	currentSniff = p
+	sniffLock.Unlock()
+	return nil
+}
+
+// [general] is the default mode of socks
+func parseSniff(general string, c *config.Sniff) (*sniffPolicy, error) {
+	if len(general) == 0 {
+		general = SniffingOff
+	}
+	p := &sniffPolicy{
+		inbounds:  map[string]string{SniffInboundSocks: general},
+		ports:     make(map[string]string),
+		timeout:   SniffTimeout,
+		protocols: sniff.Protocols,
+	}
+	if c == nil {
+		return p, nil
+	}
+	for k, v := range c.Inbounds {
+		if k != SniffInboundSocks && k != SniffInboundHTTP {
+			return nil, fmt.Errorf("[inbounds] not support inbound [%s], support: socks, http", k)
+		}
+		if !validSniffMode(v) || len(v) == 0 {
+			return nil, fmt.Errorf("[inbounds] [%s] not support [%s]", k, v)
+		}
+		p.inbounds[k] = v
+	}
+	for k, v := range c.Ports {
+		if port, err := strconv.Atoi(k); err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("[ports] invalid port [%s]", k)
+		}
+		if !validSniffMode(v) || len(v) == 0 {
+			return nil, fmt.Errorf("[ports] [%s] not support [%s]", k, v)
+		}
+		p.ports[k] = v
+	}
+	if len(c.Timeout) > 0 {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("[timeout] invalid [%s]", c.Timeout)
+		}
+		p.timeout = d
+	}
+	if len(c.Protocols) > 0 {
+		for _, v := range c.Protocols {
+			if !sniff.Supported(v) {
+				return nil, fmt.Errorf("[protocols] not support [%s], support: %v", v, sniff.Protocols)
+			}
+		}
+		p.protocols = c.Protocols
+	}
+	return p, nil
+}
+
+func validSniffMode(mode string) bool {
+	switch mode {
+	case "", SniffingOff, SniffingOn, SniffingOverride:
+		return true
+	}
+	return false
+}
+
+func getSniff() *sniffPolicy {
+	sniffLock.RLock()
+	defer sniffLock.RUnlock()
+	return currentSniff
+}
+
+func (p *sniffPolicy) mode(inbound, port string) string {
+	if m, ok := p.ports[port]; ok {
+		return m
+	}
+	if serverFirstPorts[port] {
+		return SniffingOff
+	}
+	if m, ok := p.inbounds[inbound]; ok {
+		return m
+	}
+	return SniffingOff
+}
+
+// peek the first packet of an IP-only request of [inbound] to [port], and recover the domain.
+// mode is off when nothing is sniffed
+func sniffConn(conn connect.IConn, inbound, port string) (connect.IConn, string, string, error) {
+	p := getSniff()
+	mode := p.mode(inbound, port)
+	if mode == SniffingOff {
+		return conn, "", SniffingOff, nil
+	}
+	conn, data, err := connect.PeekDecorate(conn, p.timeout)
+	if err != nil {
+		return conn, "", SniffingOff, err
+	}
+	domain, protocol := sniff.SniffProtocols(data, p.protocols)
+	if len(domain) == 0 {
+		return conn, "", SniffingOff, nil
+	}
+	domain = util.CanonicalHost(domain)
+	sniffLog.Debugf("[Sniff] [ID:%d] [%s] sniffed [%s] [%s] to port [%s]", conn.GetID(), inbound, protocol, domain, port)
+	return conn, domain, mode, nil
+}
+
+// peek the first packet of the IP-only request, and recover the domain
+// from TLS SNI or HTTP Host
+func sniffRequest(conn connect.IConn, req *SocksRequest) (connect.IConn, error) {
+	if len(req.addr) > 0 || req.cmd != CmdTCP {
+		return conn, nil
+	}
+	conn, domain, mode, err := sniffConn(conn, SniffInboundSocks, req.Port())
+	if err != nil || mode == SniffingOff {
+		return conn, err
+	}
+	if mode == SniffingOverride {
+		req.addr = domain
+		req.ip = nil
+	} else {
+		req.sniffed = domain
+	}
+	req.target = net.JoinHostPort(domain, req.Port())
+	return conn, nil
+}
+
+// sniff HTTPS CONNECT to IP, return the sniffed domain as host in override mode,
+// or keep it in the context of connection for rules
+func sniffConnect(conn connect.IConn, host, port string) (connect.IConn, string, error) {
+	if net.ParseIP(host) == nil {
+		return conn, host, nil
+	}
+	conn, domain, mode, err := sniffConn(conn, SniffInboundHTTP, port)
+	if err != nil || mode == SniffingOff {
+		return conn, host, err
+	}
+	if mode == SniffingOverride {
+		return conn, domain, nil
+	}
+	conn.SetContext(context.WithValue(conn.Context(), "sniffed", domain))
+	return conn, host, nil
+}
+
+func connSniffed(c connect.IConn) string {
+	domain, _ := c.Context().Value("sniffed").(string)
+	return domain
+}
@@ -0,0 +1,149 @@
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+const (
+	ProtocolTLS  = "tls"
+	ProtocolHTTP = "http"
+)
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+var sniffers = map[string]func([]byte) string{
+	ProtocolTLS:  TLSServerName,
+	ProtocolHTTP: HTTPHost,
+}
+
+// all protocols in the order of attempts
+var Protocols = []string{ProtocolTLS, ProtocolHTTP}
+
+// sniff domain from the first packet of client, TLS ClientHello SNI or HTTP Host
+func Sniff(data []byte) (domain, protocol string) {
+	return SniffProtocols(data, Protocols)
+}
+
+// sniff domain by [protocols] in order
+func SniffProtocols(data []byte, protocols []string) (domain, protocol string) {
+	for _, p := range protocols {
+		if f, ok := sniffers[p]; ok {
+			if domain = f(data); len(domain) > 0 {
+				return domain, p
+			}
+		}
+	}
+	return "", ""
+}
+
+func Supported(protocol string) bool {
+	_, ok := sniffers[protocol]
+	return ok
+}
+
+//+--------+---------+--------+----------+
+//| type   | version | length | fragment |
+//| 1:0x16 |    2    |   2    |          |
+//+--------+---------+--------+----------+
+func TLSServerName(data []byte) string {
+	if len(data) < 5 || data[0] != 0x16 {
+		return ""
+	}
+	data = data[5:]
+	// handshake: type(1) length(3) version(2) random(32)
+	if len(data) < 38 || data[0] != 0x01 {
+		return ""
+	}
+	data = data[38:]
+	// session id
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return ""
+	}
+	data = data[1+int(data[0]):]
+	// cipher suites
+	if len(data) < 2 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return ""
+	}
+	data = data[2+n:]
+	// compression methods
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return ""
+	}
+	data = data[1+int(data[0]):]
+	// extensions
+	if len(data) < 2 {
+		return ""
+	}
+	n = int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) > n {
+		data = data[:n]
+	}
+	for len(data) >= 4 {
+		extType := binary.BigEndian.Uint16(data)
+		extLen := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < extLen {
+			return ""
+		}
+		if extType == 0x0000 { // server_name
+			ext := data[:extLen]
+			if len(ext) < 2 {
+				return ""
+			}
+			ext = ext[2:]
+			for len(ext) >= 3 {
+				nameType := ext[0]
+				nameLen := int(binary.BigEndian.Uint16(ext[1:]))
+				ext = ext[3:]
+				if len(ext) < nameLen {
+					return ""
+				}
+				if nameType == 0 { // host_name
+					return string(ext[:nameLen])
+				}
+				ext = ext[nameLen:]
+			}
+			return ""
+		}
+		data = data[extLen:]
+	}
+	return ""
+}
+
+func HTTPHost(data []byte) string {
+	isHTTP := false
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(data, []byte(m)) {
+			isHTTP = true
+			break
+		}
+	}
+	if !isHTTP {
+		return ""
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end > 0 {
+		data = data[:end]
+	}
+	lines := strings.Split(string(data), "\r\n")
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(line[:i]), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(line[i+1:])
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host
+	}
+	return ""
+}
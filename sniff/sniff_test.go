@@ -0,0 +1,48 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSServerName(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		c := tls.Client(client, &tls.Config{ServerName: "www.example.com"})
+		c.SetDeadline(time.Now().Add(time.Second))
+		c.Handshake()
+	}()
+	data := make([]byte, 4096)
+	n, err := server.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	domain, protocol := Sniff(data[:n])
+	if domain != "www.example.com" || protocol != ProtocolTLS {
+		t.Errorf("sniff tls failed: [%s] [%s]", domain, protocol)
+	}
+}
+
+func TestHTTPHost(t *testing.T) {
+	domain, protocol := Sniff([]byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\nhost: www.example.com:8080\r\n\r\n"))
+	if domain != "www.example.com" || protocol != ProtocolHTTP {
+		t.Errorf("sniff http failed: [%s] [%s]", domain, protocol)
+	}
+	domain, _ = Sniff([]byte("SSH-2.0-OpenSSH_7.9\r\n"))
+	if domain != "" {
+		t.Errorf("sniff ssh failed: [%s]", domain)
+	}
+}
+
+func TestSniffProtocols(t *testing.T) {
+	data := []byte("GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n")
+	if domain, _ := SniffProtocols(data, []string{ProtocolTLS}); len(domain) > 0 {
+		t.Errorf("sniff http with tls only: [%s]", domain)
+	}
+	if domain, protocol := SniffProtocols(data, []string{ProtocolTLS, ProtocolHTTP}); domain != "www.example.com" || protocol != ProtocolHTTP {
+		t.Errorf("sniff http failed: [%s] [%s]", domain, protocol)
+	}
+}
@@ -0,0 +1,38 @@
+package shuttle
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+)
+
+type ISocketStatsConfig interface {
+	GetSocketStats() string
+}
+
+func ApplySocketStatsConfig(config ISocketStatsConfig) error {
+	interval, err := parseSocketStats(config.GetSocketStats())
+	if err != nil {
+		return fmt.Errorf("resolve config file [General] [socket-stats] failed: %v", err)
+	}
+	if interval > 0 && runtime.GOOS != "linux" {
+		socketLog.Errorf("[Socket] socket-stats is only supported on linux")
+		interval = 0
+	}
+	connect.StartSocketStats(interval)
+	return nil
+}
+
+// sampling interval, empty means off
+func parseSocketStats(v string) (time.Duration, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid interval [%s], at least 1s", v)
+	}
+	return d, nil
+}
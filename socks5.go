@@ -4,9 +4,9 @@ import (
 	"encoding/binary"
 	"errors"
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/pool"
 	"github.com/sipt/shuttle/proxy"
+	rule2 "github.com/sipt/shuttle/rule"
 	"github.com/sipt/shuttle/util"
 	"net"
 	"strconv"
@@ -30,32 +30,47 @@ const (
 )
 
 func SocksHandle(co net.Conn) {
-	log.Logger.Debug("[SOCKS] start shuttle.IConn wrap net.Conn")
+	socksLog.Debug("[SOCKS] start shuttle.IConn wrap net.Conn")
 	conn, err := connect.NewDefaultConn(co, connect.TCP)
 	if err != nil {
-		log.Logger.Errorf("shuttle.IConn wrap net.Conn failed: %v", err)
+		shuttleLog.Errorf("shuttle.IConn wrap net.Conn failed: %v", err)
 		return
 	}
-	log.Logger.Debugf("[SOCKS] [ID:%d] shuttle.IConn wrap net.Conn success ", conn.GetID())
-	log.Logger.Debugf("[SOCKS] [ID:%d] start handShake", conn.GetID())
+	socksLog.Debugf("[SOCKS] [ID:%d] shuttle.IConn wrap net.Conn success ", conn.GetID())
+	markSelfConn(co, conn, "SOCKS")
+	socksLog.Debugf("[SOCKS] [ID:%d] start handShake", conn.GetID())
 	err = handShake(conn)
 	if err != nil {
-		log.Logger.Errorf("[SOCKS] [ID:%d] handShake failed: %s", conn.GetID(), err.Error())
+		socksLog.Errorf("[SOCKS] [ID:%d] handShake failed: %s", conn.GetID(), err.Error())
+		conn.Close()
 		return
 	}
 	req, err := parseRequest(conn)
 	if err != nil {
-		log.Logger.Errorf("[SOCKS] [ID:%d] parseRequest failed: %s", conn.GetID(), err.Error())
+		socksLog.Errorf("[SOCKS] [ID:%d] parseRequest failed: %s", conn.GetID(), err.Error())
+		conn.Close()
 		return
 	}
 	req.protocol = ProtocolSocks
 	req.target = req.Host()
+	if req.cmd == CmdBind {
+		socksBind(conn, req)
+		return
+	}
+	if req.cmd == CmdUDP {
+		socksUDP(conn, req)
+		return
+	}
 	_, err = conn.Write([]byte{socksVer5, 0x00, 0x00, AddrTypeIPv4, 0x00, 0x00, 0x00, 0x00, 0x08, 0x43})
 	if err != nil {
-		log.Logger.Errorf("[SOCKS] [ID:%d] send connection confirmation: %s", conn.GetID(), err.Error())
+		socksLog.Errorf("[SOCKS] [ID:%d] send connection confirmation: %s", conn.GetID(), err.Error())
 		return
 	}
+	socksConnect(conn, req)
+}
 
+// connect [req] by the rules and transport it, after the request is confirmed
+func socksConnect(conn connect.IConn, req *SocksRequest) {
 	//inner controller domain
 	if req.addr == ControllerDomain {
 		port, err := strconv.ParseUint(ControllerPort, 10, 16)
@@ -70,15 +85,46 @@ func SocksHandle(co net.Conn) {
 		s, _ := proxy.GetServer(proxy.ProxyDirect)
 		sc, err := s.Conn(req)
 		if err != nil {
-			log.Logger.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), err.Error())
+			socksLog.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), err.Error())
 		}
 		direct := &DirectChannel{}
 		direct.Transport(conn, sc)
 		return
 	}
 
-	//RuleFilter by Rules and DNS
-	rule, s, err := FilterByReq(req)
+	//sniff domain
+	conn, err := sniffRequest(conn, req)
+	if err != nil {
+		socksLog.Errorf("[SOCKS] [ID:%d] sniff failed: %s", conn.GetID(), err.Error())
+		conn.Close()
+		return
+	}
+
+	//RuleFilter by Rules and DNS, then connect to server
+	wc := connect.NewWatchedConn(conn)
+	conn = wc
+	var (
+		rule      *rule2.Rule
+		s         *proxy.Server
+		filterErr error
+	)
+	sc, err := dialWatched(wc, func() (connect.IConn, error) {
+		rule, s, filterErr = FilterByReqContext(wc.Context(), req)
+		req.sniffed = "" // dial to the origin ip
+		if filterErr != nil {
+			return nil, filterErr
+		}
+		socksLog.Debugf("[SOCKS] [ID:%d] Start connect to Server [%s]", conn.GetID(), s.Name)
+		policy := proxy.ProxyDirect
+		if rule != nil {
+			policy = rule.Policy
+		}
+		return s.ConnWithDialer(req, proxy.PolicyDialerContext(wc.Context(), policy, s.Name))
+	})
+	if err == ErrorCanceled {
+		socksLog.Debugf("[SOCKS] [ID:%d] [%s] %v while connecting", conn.GetID(), req.Host(), err)
+		return
+	}
 	record := &Record{
 		ID:       util.NextID(),
 		Protocol: req.protocol,
@@ -87,24 +133,23 @@ func SocksHandle(co net.Conn) {
 		URL:      req.target,
 		Rule:     rule,
 		Proxy:    s,
+		User:     req.user,
+		Tag:      req.tag,
+		Src:      srcString(req.srcAddr),
 	}
-	if err != nil {
-		if err == ErrorReject {
-			log.Logger.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), err)
+	if filterErr != nil {
+		if filterErr == ErrorReject {
+			socksLog.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), filterErr)
 		}
 		record.Status = RecordStatusCompleted
 		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
 		conn.Close()
+	} else if err != nil {
+		socksLog.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), err.Error())
+		conn.Close()
 	} else {
-		//connnet to server
-		log.Logger.Debugf("[SOCKS] [ID:%d] Start connect to Server [%s]", conn.GetID(), s.Name)
-		sc, err := s.Conn(req)
-		if err != nil {
-			log.Logger.Errorf("[SOCKS] [ID:%d] ConnectToServer failed [%s] err: %s", conn.GetID(), req.Host(), err.Error())
-			return
-		}
-		log.Logger.Debugf("[SOCKS] [ID:%d] Server [%s] Connected success", conn.GetID(), s.Name)
-		log.Logger.Debugf("[HTTP] [ClientConnID:%d] Bind to [ServerConnID:%d]", conn.GetID(), sc.GetID())
+		socksLog.Debugf("[SOCKS] [ID:%d] Server [%s] Connected success", conn.GetID(), s.Name)
+		httpLog.Debugf("[HTTP] [ClientConnID:%d] Bind to [ServerConnID:%d]", conn.GetID(), sc.GetID())
 		sc.SetRecordID(record.ID)
 		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
 		direct := &DirectChannel{}
@@ -114,21 +159,40 @@ func SocksHandle(co net.Conn) {
 }
 
 //socks 握手
-func handShake(conn net.Conn) error {
+func handShake(conn connect.IConn) error {
 	buf := pool.GetBuf()
-	_, err := conn.Read(buf)
+	defer pool.PutBuf(buf)
+	n, err := conn.Read(buf)
 	if err != nil {
 		return err
 	}
+	if n < methodIndex {
+		return errors.New("socks handshake too short")
+	}
 	if buf[verIndex] != socksVer5 {
 		return errors.New("socks version not supported")
 	}
-	//todo get methods
-
-	//return supported methods
-	conn.Write([]byte{0x05, 0x00})
-	pool.PutBuf(buf)
-	return nil
+	nMethods := int(buf[nMethodIndex])
+	if nMethods == 0 || n < methodIndex+nMethods {
+		return errors.New("socks handshake methods malformed")
+	}
+	methods := buf[methodIndex : methodIndex+nMethods]
+	// without authentication, username/password is still accepted for the tag
+	for _, m := range methods {
+		if m == socksMethodUserPass {
+			if _, err = conn.Write([]byte{socksVer5, socksMethodUserPass}); err != nil {
+				return err
+			}
+			return socksUserPassAuth(conn)
+		}
+	}
+	if !AuthRequired() {
+		//return supported methods
+		_, err = conn.Write([]byte{socksVer5, socksMethodNoAuth})
+		return err
+	}
+	conn.Write([]byte{socksVer5, socksMethodNoAcceptable})
+	return ErrorAuthFailed
 }
 
 //获取协议
@@ -157,11 +221,15 @@ func parseRequest(conn connect.IConn) (*SocksRequest, error) {
 		return nil, err
 	}
 	request := &SocksRequest{
-		ver:    uint8(buf[verIndex]),
-		cmd:    uint8(buf[cmdIndex]),
-		rsv:    uint8(buf[rsvIndex]),
-		atyp:   uint8(buf[atypIndex]),
-		connID: conn.GetID(),
+		ver:     uint8(buf[verIndex]),
+		cmd:     uint8(buf[cmdIndex]),
+		rsv:     uint8(buf[rsvIndex]),
+		atyp:    uint8(buf[atypIndex]),
+		connID:  conn.GetID(),
+		srcAddr: conn.RemoteAddr(),
+		user:    connUser(conn),
+		tag:     connTag(conn),
+		policy:  connPolicy(conn),
 	}
 	switch request.atyp {
 	case AddrTypeIPv4:
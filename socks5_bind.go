@@ -0,0 +1,166 @@
+package shuttle
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	ProtocolSocksBind = "SOCKS(BIND)"
+
+	socksRepSucceeded       = 0x00
+	socksRepFailure         = 0x01
+	socksRepNotAllowed      = 0x02
+	socksRepHostUnreachable = 0x04
+	socksRepCmdNotSupported = 0x07
+
+	BindTimeout = 2 * time.Minute
+)
+
+//+----+-----+-------+------+----------+----------+
+//|VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
+//+----+-----+-------+------+----------+----------+
+//| 1  |  1  | X'00' |  1   | Variable |    2     |
+//+----+-----+-------+------+----------+----------+
+func socksReply(rep byte, addr net.Addr) []byte {
+	var (
+		ip   net.IP
+		port int
+	)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+	b := []byte{socksVer5, rep, 0x00}
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		b = append(b, AddrTypeIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, AddrTypeIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return append(b, byte(port>>8), byte(port))
+}
+
+// SOCKS5 BIND: listen for one inbound connection from DST.ADDR (active-mode FTP, P2P),
+// only DIRECT can bind, other servers reply "command not supported"
+func socksBind(conn connect.IConn, req *SocksRequest) {
+	defer conn.Close()
+	rule, s, err := FilterByReq(req)
+	record := &Record{
+		ID:       util.NextID(),
+		Protocol: ProtocolSocksBind,
+		Created:  time.Now(),
+		Status:   RecordStatusActive,
+		URL:      req.target,
+		Rule:     rule,
+		Proxy:    s,
+		User:     req.user,
+		Tag:      req.tag,
+		Src:      srcString(req.srcAddr),
+	}
+	if err != nil {
+		socksLog.Errorf("[SOCKS] [BIND] [ID:%d] [%s] err: %v", conn.GetID(), req.Host(), err)
+		rep := byte(socksRepFailure)
+		if err == ErrorReject {
+			rep = socksRepNotAllowed
+		}
+		conn.Write(socksReply(rep, nil))
+		record.Status = RecordStatusReject
+		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+		return
+	}
+	if s.Name != proxy.ProxyDirect {
+		socksLog.Errorf("[SOCKS] [BIND] [ID:%d] server [%s] not support BIND", conn.GetID(), s.Name)
+		conn.Write(socksReply(socksRepCmdNotSupported, nil))
+		record.Status = RecordStatusFailed
+		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+		return
+	}
+	listener, err := net.ListenTCP(connect.TCP, &net.TCPAddr{IP: bindIP(conn, req)})
+	if err != nil {
+		socksLog.Errorf("[SOCKS] [BIND] [ID:%d] listen failed: %v", conn.GetID(), err)
+		conn.Write(socksReply(socksRepFailure, nil))
+		return
+	}
+	defer listener.Close()
+	socksLog.Debugf("[SOCKS] [BIND] [ID:%d] listen [%s] for [%s]", conn.GetID(), listener.Addr(), req.Host())
+	// first reply: the address to connect
+	if _, err = conn.Write(socksReply(socksRepSucceeded, listener.Addr())); err != nil {
+		return
+	}
+	boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+	defer func() { boxChan <- &Box{record.ID, RecordStatus, RecordStatusCompleted} }()
+
+	listener.SetDeadline(time.Now().Add(BindTimeout))
+	var rc *net.TCPConn
+	for {
+		rc, err = listener.AcceptTCP()
+		if err != nil {
+			socksLog.Errorf("[SOCKS] [BIND] [ID:%d] accept failed: %v", conn.GetID(), err)
+			conn.Write(socksReply(socksRepHostUnreachable, nil))
+			return
+		}
+		if allowBindPeer(req, rc.RemoteAddr().(*net.TCPAddr)) {
+			break
+		}
+		socksLog.Errorf("[SOCKS] [BIND] [ID:%d] reject [%s], expect [%s]", conn.GetID(), rc.RemoteAddr(), req.Host())
+		rc.Close()
+	}
+	// second reply: the address of the connected host
+	if _, err = conn.Write(socksReply(socksRepSucceeded, rc.RemoteAddr())); err != nil {
+		rc.Close()
+		return
+	}
+	sc, err := connect.NewDefaultConn(rc, connect.TCP)
+	if err == nil {
+		sc, err = connect.TrafficDecorate(sc)
+	}
+	if err != nil {
+		rc.Close()
+		return
+	}
+	sc.SetRecordID(record.ID)
+	direct := &DirectChannel{}
+	direct.Transport(conn, sc)
+}
+
+// local ip which routes to DST.ADDR
+func bindIP(conn connect.IConn, req *SocksRequest) net.IP {
+	if a, ok := conn.LocalAddr().(*net.TCPAddr); ok && !a.IP.IsLoopback() && !a.IP.IsUnspecified() {
+		return a.IP
+	}
+	addr := req.IP()
+	if len(addr) == 0 {
+		addr = req.Answer().GetIP()
+	}
+	if len(addr) > 0 {
+		if c, err := net.Dial(connect.UDP, net.JoinHostPort(addr, strconv.Itoa(int(req.port)))); err == nil {
+			defer c.Close()
+			return c.LocalAddr().(*net.UDPAddr).IP
+		}
+	}
+	return nil
+}
+
+// DST.ADDR is used to limit the peer, 0.0.0.0 or empty means any
+func allowBindPeer(req *SocksRequest, peer *net.TCPAddr) bool {
+	ip := req.IP()
+	if len(ip) == 0 {
+		ip = req.Answer().GetIP()
+	}
+	if len(ip) == 0 || net.ParseIP(ip).IsUnspecified() {
+		return true
+	}
+	return net.ParseIP(ip).Equal(peer.IP)
+}
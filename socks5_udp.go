@@ -0,0 +1,411 @@
+package shuttle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/pool"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	ProtocolSocksUDP = "SOCKS(UDP)"
+
+	UDPNATSymmetric = "symmetric"
+	UDPNATFullCone  = "full-cone"
+
+	defaultUDPTimeout = 60 * time.Second
+	udpBufSize        = 64 * 1024
+)
+
+type IUDPConfig interface {
+	GetUDP() *config.UDP
+}
+
+// NAT behavior of UDP sessions by policy:
+// symmetric: a session per flow (client, destination), only datagrams of the destination are relayed back.
+// full-cone: a session per client and server for all destinations, datagrams of any remote are relayed back,
+// so peers learned from a rendezvous server can reach the client (games, P2P).
+// remotes see full-cone only when the server keeps one mapping per session, e.g. DIRECT, most shadowsocks servers
+type udpPolicy struct {
+	timeout time.Duration
+	nat     map[string]string // policy or server -> behavior, "*" for the others
+}
+
+var (
+	currentUDP = &udpPolicy{timeout: defaultUDPTimeout, nat: map[string]string{}}
+	udpLock    sync.RWMutex
+)
+
+func ApplyUDPConfig(config IUDPConfig) error {
+	p, err := parseUDP(config.GetUDP())
+	if err != nil {
+		return fmt.Errorf("resolve config file [UDP] failed: %v", err)
+	}
+	udpLock.Lock()
+	currentUDP = p
+	udpLock.Unlock()
+	return nil
+}
+
+func parseUDP(c *config.UDP) (*udpPolicy, error) {
+	p := &udpPolicy{timeout: defaultUDPTimeout, nat: map[string]string{}}
+	if c == nil {
+		return p, nil
+	}
+	if len(c.Timeout) > 0 {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout [%s] is invalid", c.Timeout)
+		}
+		p.timeout = d
+	}
+	for k, v := range c.NAT {
+		if v != UDPNATSymmetric && v != UDPNATFullCone {
+			return nil, fmt.Errorf("nat [%s] not support [%s], support: symmetric, full-cone", k, v)
+		}
+		p.nat[k] = v
+	}
+	return p, nil
+}
+
+func getUDP() *udpPolicy {
+	udpLock.RLock()
+	defer udpLock.RUnlock()
+	return currentUDP
+}
+
+// behavior of the rule policy, then of the server, then "*", symmetric by default
+func (p *udpPolicy) natOf(policy, server string) string {
+	for _, k := range []string{policy, server, "*"} {
+		if m, ok := p.nat[k]; ok {
+			return m
+		}
+	}
+	return UDPNATSymmetric
+}
+
+// SOCKS5 UDP ASSOCIATE: datagrams of the client are routed by rules per destination,
+// the association ends with the control connection
+func socksUDP(conn connect.IConn, req *SocksRequest) {
+	defer conn.Close()
+	var clientIP, localIP net.IP
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = a.IP
+	}
+	if a, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = a.IP
+	}
+	pc, err := net.ListenUDP(connect.UDP, &net.UDPAddr{IP: localIP})
+	if err != nil {
+		socksLog.Errorf("[SOCKS] [UDP] [ID:%d] listen failed: %v", conn.GetID(), err)
+		conn.Write(socksReply(socksRepFailure, nil))
+		return
+	}
+	if _, err = conn.Write(socksReply(socksRepSucceeded, pc.LocalAddr())); err != nil {
+		pc.Close()
+		return
+	}
+	socksLog.Debugf("[SOCKS] [UDP] [ID:%d] associate [%s] for [%s]", conn.GetID(), pc.LocalAddr(), conn.RemoteAddr())
+	a := &udpAssociation{
+		connID:   conn.GetID(),
+		protocol: ProtocolSocksUDP,
+		user:     req.user,
+		tag:      req.tag,
+		override: req.policy,
+		pc:       pc,
+		clientIP: clientIP,
+		policy:   getUDP(),
+		sessions: make(map[string]*udpSession),
+		routes:   make(map[string]*udpRoute),
+	}
+	a.reply = a.replyClient
+	go a.serve()
+	// nothing is expected on the control connection, it is read until closed
+	buf := pool.GetBuf()
+	for {
+		if _, err = conn.Read(buf); err != nil {
+			break
+		}
+	}
+	pool.PutBuf(buf)
+	a.close()
+}
+
+type udpAssociation struct {
+	connID   int64
+	protocol string
+	user     string
+	tag      string
+	override string       // policy of the requests
+	pc       *net.UDPConn // nil without SOCKS client, e.g. of a packet tunnel
+	clientIP net.IP
+	client   *net.UDPAddr // the latest source of the client
+	reply    func(from *net.UDPAddr, data []byte) error
+	policy   *udpPolicy
+	sessions map[string]*udpSession
+	routes   map[string]*udpRoute // destination -> route
+	sent     time.Time            // of the latest datagram of the client
+	closed   bool
+	sync.Mutex
+}
+
+type udpRoute struct {
+	req    *SocksRequest
+	rule   *rule.Rule
+	server *proxy.Server
+	err    error
+}
+
+type udpSession struct {
+	key    string
+	pc     proxy.PacketConn
+	record *Record
+	peer   *net.UDPAddr // only datagrams of it are relayed back, nil for any remote
+}
+
+func (a *udpAssociation) serve() {
+	buf := make([]byte, udpBufSize)
+	for {
+		n, src, err := a.pc.ReadFromUDP(buf)
+		if err != nil {
+			return // closed
+		}
+		if a.clientIP != nil && !a.clientIP.Equal(src.IP) {
+			continue
+		}
+		req, data, err := parseUDPDatagram(buf[:n])
+		if err != nil {
+			socksLog.Debugf("[SOCKS] [UDP] [ID:%d] drop datagram from [%s]: %v", a.connID, src, err)
+			continue
+		}
+		a.Lock()
+		a.client = src
+		a.Unlock()
+		req.connID, req.srcAddr, req.user, req.tag, req.policy = a.connID, src, a.user, a.tag, a.override
+		if err = a.send(req, data); err != nil {
+			socksLog.Debugf("[SOCKS] [UDP] [ID:%d] send to [%s] failed: %v", a.connID, req.target, err)
+		}
+	}
+}
+
+// +----+------+------+----------+----------+----------+
+// |RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+// +----+------+------+----------+----------+----------+
+// | 2  |  1   |  1   | Variable |    2     | Variable |
+// +----+------+------+----------+----------+----------+
+// fragments are not supported and dropped
+func parseUDPDatagram(b []byte) (*SocksRequest, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, fmt.Errorf("datagram too short")
+	}
+	if b[2] != 0 {
+		return nil, nil, fmt.Errorf("fragment not supported")
+	}
+	req := &SocksRequest{ver: socksVer5, cmd: CmdUDP, atyp: b[3], protocol: ProtocolSocksUDP}
+	b = b[4:]
+	var size int
+	switch req.atyp {
+	case AddrTypeIPv4:
+		size = net.IPv4len
+	case AddrTypeIPv6:
+		size = net.IPv6len
+	case AddrTypeDomain:
+		if len(b) > 0 {
+			size = int(b[0]) + 1
+		}
+	default:
+		return nil, nil, fmt.Errorf("not support address type [%d]", req.atyp)
+	}
+	if size == 0 || len(b) < size+2 {
+		return nil, nil, fmt.Errorf("datagram too short")
+	}
+	if req.atyp == AddrTypeDomain {
+		req.addr = string(b[1:size])
+	} else {
+		req.ip = net.IP(append([]byte{}, b[:size]...))
+	}
+	req.port = binary.BigEndian.Uint16(b[size : size+2])
+	req.target = req.Host()
+	return req, b[size+2:], nil
+}
+
+// datagrams to the same destination follow its first route
+func (a *udpAssociation) route(req *SocksRequest) *udpRoute {
+	a.Lock()
+	r, ok := a.routes[req.target]
+	a.Unlock()
+	if ok {
+		return r
+	}
+	r = &udpRoute{req: req}
+	r.rule, r.server, r.err = FilterByReq(req)
+	if r.err == nil && r.rule != nil {
+		if c := proxy.GetCompositePolicy(r.rule.Policy); c != nil && c.NoUDP {
+			socksLog.Infof("[SOCKS] [UDP] [ID:%d] [%s] UDP is off by policy [%s]", a.connID, req.target, c.Name)
+			r.err = ErrorUDPOff
+		}
+	}
+	a.Lock()
+	a.routes[req.target] = r
+	a.Unlock()
+	if r.err != nil {
+		record := &Record{
+			ID:       util.NextID(),
+			Protocol: a.protocol,
+			Created:  time.Now(),
+			Status:   RecordStatusReject,
+			URL:      req.target,
+			Rule:     r.rule,
+			Proxy:    r.server,
+			User:     a.user,
+			Tag:      a.tag,
+			Src:      srcString(req.srcAddr),
+		}
+		boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
+	}
+	return r
+}
+
+func (a *udpAssociation) send(req *SocksRequest, data []byte) error {
+	r := a.route(req)
+	if r.err != nil {
+		return r.err
+	}
+	policy := proxy.ProxyDirect
+	if r.rule != nil {
+		policy = r.rule.Policy
+	}
+	key := "server:" + r.server.Name
+	nat := a.policy.natOf(policy, r.server.Name)
+	if nat == UDPNATSymmetric {
+		key = "flow:" + req.target
+	}
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return fmt.Errorf("association closed")
+	}
+	a.sent = time.Now()
+	s, ok := a.sessions[key]
+	a.Unlock()
+	if !ok {
+		pc, err := r.server.PacketConn()
+		if err != nil {
+			return err
+		}
+		s = &udpSession{key: key, pc: pc}
+		if nat == UDPNATSymmetric && (len(r.req.addr) == 0 || r.server.Name == proxy.ProxyDirect) {
+			// a domain may be resolved to another ip by the server
+			s.peer = &net.UDPAddr{IP: net.ParseIP(r.req.IP()), Port: int(r.req.port)}
+		}
+		s.record = &Record{
+			ID:       util.NextID(),
+			Protocol: a.protocol,
+			Created:  time.Now(),
+			Status:   RecordStatusActive,
+			URL:      req.target,
+			Rule:     r.rule,
+			Proxy:    r.server,
+			User:     a.user,
+			Tag:      a.tag,
+			Src:      srcString(req.srcAddr),
+		}
+		a.Lock()
+		if a.closed {
+			a.Unlock()
+			pc.Close()
+			return fmt.Errorf("association closed")
+		}
+		a.sessions[key] = s
+		a.Unlock()
+		boxChan <- &Box{Op: RecordAppend, Value: s.record, ID: s.record.ID}
+		socksLog.Debugf("[SOCKS] [UDP] [ID:%d] [%s] session of [%s] through [%s]", a.connID, nat, req.target, r.server.Name)
+		go a.relayReplies(s)
+	}
+	s.pc.SetReadDeadline(time.Now().Add(a.policy.timeout))
+	n, err := s.pc.WriteTo(data, r.req)
+	if n > 0 {
+		boxChan <- &Box{s.record.ID, RecordUp, n}
+	}
+	return err
+}
+
+// relay datagrams back to the client until idle timeout (of both directions) or closed
+func (a *udpAssociation) relayReplies(s *udpSession) {
+	defer func() {
+		a.Lock()
+		if a.sessions[s.key] == s {
+			delete(a.sessions, s.key)
+		}
+		a.Unlock()
+		s.pc.Close()
+		boxChan <- &Box{s.record.ID, RecordStatus, RecordStatusCompleted}
+	}()
+	buf := make([]byte, udpBufSize)
+	for {
+		n, from, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if s.peer != nil && !(s.peer.IP.Equal(from.IP) && s.peer.Port == from.Port) {
+			continue
+		}
+		// a session only receiving (e.g. full-cone to a peer) is not idle
+		s.pc.SetReadDeadline(time.Now().Add(a.policy.timeout))
+		if err = a.reply(from, buf[:n]); err != nil {
+			socksLog.Debugf("[SOCKS] [UDP] [ID:%d] reply of [%s] failed: %v", a.connID, from, err)
+			continue
+		}
+		boxChan <- &Box{s.record.ID, RecordDown, n}
+	}
+}
+
+// to the latest source of the SOCKS client
+func (a *udpAssociation) replyClient(from *net.UDPAddr, data []byte) error {
+	a.Lock()
+	client := a.client
+	a.Unlock()
+	_, err := a.pc.WriteToUDP(udpDatagram(from, data), client)
+	return err
+}
+
+// no session is open and the client sent nothing for the timeout
+func (a *udpAssociation) idle() bool {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.sessions) == 0 && time.Since(a.sent) > a.policy.timeout
+}
+
+func udpDatagram(from *net.UDPAddr, data []byte) []byte {
+	b := []byte{0x00, 0x00, 0x00}
+	if ip4 := from.IP.To4(); ip4 != nil {
+		b = append(append(b, AddrTypeIPv4), ip4...)
+	} else {
+		b = append(append(b, AddrTypeIPv6), from.IP.To16()...)
+	}
+	b = append(b, byte(from.Port>>8), byte(from.Port))
+	return append(b, data...)
+}
+
+func (a *udpAssociation) close() {
+	a.Lock()
+	a.closed = true
+	sessions := a.sessions
+	a.sessions = make(map[string]*udpSession)
+	a.Unlock()
+	if a.pc != nil {
+		a.pc.Close()
+	}
+	for _, s := range sessions {
+		s.pc.Close()
+	}
+	socksLog.Debugf("[SOCKS] [UDP] [ID:%d] association closed", a.connID)
+}
@@ -2,9 +2,9 @@ package shuttle
 
 import (
 	"github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/log"
 	"github.com/sipt/shuttle/proxy"
 	"github.com/sipt/shuttle/rule"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,6 +16,7 @@ const (
 	RecordDown   = 3
 	RecordAppend = 4
 	RecordRemove = 5
+	RecordProxy  = 6
 
 	RecordStatusActive    = "Active"
 	RecordStatusCompleted = "Completed"
@@ -45,8 +46,14 @@ func init() {
 			switch box.Op {
 			case RecordAppend:
 				storage.Append(box.Value.(*Record))
+				mirrorRecord(box.Value.(*Record))
+				exportRecord(box.Value.(*Record))
 			default:
 				storage.Put(box.ID, box.Op, box.Value)
+				if box.Op == RecordStatus {
+					mirrorStatus(box.ID, box.Value.(string))
+					exportStatus(box.ID, box.Value.(string))
+				}
 			}
 			go func(box *Box) {
 				pusher(box)
@@ -80,6 +87,68 @@ func CurrentSpeed() (int, int) {
 	return speed.UpSpeed, speed.DownSpeed
 }
 
+type UserTraffic struct {
+	Up   int `json:"up"`
+	Down int `json:"down"`
+}
+
+// traffic by label: user, tag
+type trafficTable struct {
+	traffic map[string]*UserTraffic
+	max     int // labels over it are counted as TrafficOther, 0: no limit
+	sync.RWMutex
+}
+
+const (
+	TrafficOther = "(other)"
+
+	maxTrafficTags = 1000 // tags are chosen by clients
+)
+
+var (
+	userTraffic = &trafficTable{traffic: make(map[string]*UserTraffic)}
+	tagTraffic  = &trafficTable{traffic: make(map[string]*UserTraffic), max: maxTrafficTags}
+)
+
+// traffic of authenticated users
+func GetUserTraffic() map[string]UserTraffic {
+	return userTraffic.list()
+}
+
+// traffic of tagged connections
+func GetTagTraffic() map[string]UserTraffic {
+	return tagTraffic.list()
+}
+
+func (t *trafficTable) list() map[string]UserTraffic {
+	t.RLock()
+	defer t.RUnlock()
+	m := make(map[string]UserTraffic, len(t.traffic))
+	for k, v := range t.traffic {
+		m[k] = *v
+	}
+	return m
+}
+
+func (t *trafficTable) add(label string, up, down int) {
+	if len(label) == 0 {
+		return
+	}
+	t.Lock()
+	v, ok := t.traffic[label]
+	if !ok && t.max > 0 && len(t.traffic) >= t.max {
+		label = TrafficOther
+		v, ok = t.traffic[label]
+	}
+	if !ok {
+		v = &UserTraffic{}
+		t.traffic[label] = v
+	}
+	v.Up += up
+	v.Down += down
+	t.Unlock()
+}
+
 type Speed struct {
 	UpSpeed   int
 	DownSpeed int
@@ -123,6 +192,17 @@ type Record struct {
 	Down     int
 	URL      string
 	Dumped   bool
+	User     string
+	Tag      string
+	Src      string // client address
+}
+
+// address of the client, empty if unknown
+func srcString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
 }
 
 type LinkedList struct {
@@ -166,9 +246,9 @@ func (l *LinkedList) List() []Record {
 func (l *LinkedList) Append(r *Record) {
 	l.Lock()
 	if r.Proxy == nil || r.Rule == nil {
-		log.Logger.Infof("[Storage] ID:[%d] Policy:[nil] URL:[%s]", r.ID, r.URL)
+		storageLog.Infof("[Storage] ID:[%d] Policy:[nil] URL:[%s]", r.ID, r.URL)
 	} else {
-		log.Logger.Infof("[Storage] ID:[%d] Policy:[%s(%s,%s)] URL:[%s]", r.ID, r.Proxy.Name, r.Rule.Type, r.Rule.Value, r.URL)
+		storageLog.Infof("[Storage] ID:[%d] Policy:[%s(%s,%s)] URL:[%s]", r.ID, r.Proxy.Name, r.Rule.Type, r.Rule.Value, r.URL)
 	}
 	if l.head == nil {
 		l.head = &node{record: r}
@@ -179,7 +259,7 @@ func (l *LinkedList) Append(r *Record) {
 	}
 	l.count ++
 
-	for l.count > maxCount {
+	for l.count > recordLimit() {
 		go func(id int64) {
 			pusher(&Box{
 				Op:    RecordRemove,
@@ -221,12 +301,18 @@ func (n *node) Put(op int, v interface{}) {
 		if speed != nil {
 			speed.UpBytes += s
 		}
+		userTraffic.add(n.record.User, s, 0)
+		tagTraffic.add(n.record.Tag, s, 0)
 	case RecordDown:
 		s := v.(int)
 		n.record.Down += s
 		if speed != nil {
 			speed.DownBytes += s
 		}
+		userTraffic.add(n.record.User, 0, s)
+		tagTraffic.add(n.record.Tag, 0, s)
+	case RecordProxy:
+		n.record.Proxy = v.(*proxy.Server)
 	}
 	n.Unlock()
 }
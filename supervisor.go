@@ -0,0 +1,273 @@
+package shuttle
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	ModuleWaiting  = "waiting" // for its dependencies
+	ModuleStarting = "starting"
+	ModuleReady    = "ready"
+	ModuleFailed   = "failed" // crashed, restarting after backoff
+	ModuleStopped  = "stopped"
+
+	minModuleBackoff = time.Second
+	maxModuleBackoff = 30 * time.Second
+	moduleStableTime = time.Minute // backoff is reset after running for it
+)
+
+var ErrorModuleNotFound = errors.New("module not found")
+
+// a subsystem managed by the supervisor, Run serves until stop is closed and calls ready once it serves.
+// a returned error or a panic is a crash, the module is restarted alone
+type Module struct {
+	Name    string
+	Depends []string
+	Run     func(stop chan struct{}, ready func()) error
+	Check   func() error // health of a ready module, optional
+}
+
+type ModuleStatus struct {
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Depends  []string  `json:"depends,omitempty"`
+	Restarts int       `json:"restarts"`
+	Error    string    `json:"error,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+type moduleState struct {
+	*Module
+	status   string
+	restarts int
+	err      error
+	since    time.Time
+	stop     chan struct{}
+	exited   chan struct{}
+	readyCh  chan struct{} // closed when ready, renewed when restarted
+}
+
+var (
+	modules     []*moduleState // in order of supervision
+	modulesLock sync.Mutex
+)
+
+// supervise [m], it starts when all its dependencies are ready
+func Supervise(m *Module) error {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	for _, s := range modules {
+		if s.Name == m.Name {
+			return fmt.Errorf("module [%s] is already supervised", m.Name)
+		}
+	}
+	for _, d := range m.Depends {
+		if findModule(d) == nil {
+			return fmt.Errorf("module [%s] depends on unknown module [%s]", m.Name, d)
+		}
+	}
+	s := &moduleState{Module: m}
+	modules = append(modules, s)
+	s.start()
+	return nil
+}
+
+// stop and start [name] again, e.g. when its listen address changed.
+// modules depending on it keep running
+func RestartModule(name string) error {
+	modulesLock.Lock()
+	s := findModule(name)
+	modulesLock.Unlock()
+	if s == nil {
+		return ErrorModuleNotFound
+	}
+	s.halt()
+	modulesLock.Lock()
+	s.restarts = 0
+	s.start()
+	modulesLock.Unlock()
+	return nil
+}
+
+// stop all modules, dependents before their dependencies
+func StopModules() {
+	modulesLock.Lock()
+	ms := append([]*moduleState{}, modules...)
+	modulesLock.Unlock()
+	for i := len(ms) - 1; i >= 0; i-- {
+		ms[i].halt()
+	}
+}
+
+func ModuleStatuses() []*ModuleStatus {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	list := make([]*ModuleStatus, 0, len(modules))
+	for _, s := range modules {
+		v := &ModuleStatus{
+			Name:     s.Name,
+			Status:   s.status,
+			Depends:  s.Depends,
+			Restarts: s.restarts,
+			Since:    s.since,
+		}
+		if s.err != nil {
+			v.Error = s.err.Error()
+		} else if s.status == ModuleReady && s.Check != nil {
+			if err := s.Check(); err != nil {
+				v.Error = err.Error()
+			}
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
+// all modules are ready and healthy, and no config is being applied
+func Ready() ([]*ModuleStatus, bool) {
+	list := ModuleStatuses()
+	ok := len(list) > 0 && !reloading()
+	for _, v := range list {
+		ok = ok && v.Status == ModuleReady && len(v.Error) == 0
+	}
+	return list, ok
+}
+
+// no module is crashed or unhealthy, modules waiting or starting are alive
+func Healthy() ([]*ModuleStatus, bool) {
+	list := ModuleStatuses()
+	ok := true
+	for _, v := range list {
+		ok = ok && v.Status != ModuleFailed && len(v.Error) == 0
+	}
+	return list, ok
+}
+
+func findModule(name string) *moduleState {
+	for _, s := range modules {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// with modulesLock
+func (s *moduleState) start() {
+	s.stop = make(chan struct{})
+	s.exited = make(chan struct{})
+	s.readyCh = make(chan struct{})
+	s.setStatus(ModuleWaiting, nil)
+	deps := make([]*moduleState, 0, len(s.Depends))
+	for _, d := range s.Depends {
+		deps = append(deps, findModule(d))
+	}
+	go s.supervise(deps, s.stop, s.exited, s.readyCh)
+}
+
+func (s *moduleState) halt() {
+	modulesLock.Lock()
+	stop, exited := s.stop, s.exited
+	if s.status == ModuleStopped || stop == nil {
+		modulesLock.Unlock()
+		return
+	}
+	close(stop)
+	s.stop = nil
+	modulesLock.Unlock()
+	<-exited
+}
+
+// with modulesLock
+func (s *moduleState) setStatus(status string, err error) {
+	s.status, s.err, s.since = status, err, time.Now()
+}
+
+func (s *moduleState) supervise(deps []*moduleState, stop, exited, readyCh chan struct{}) {
+	defer func() {
+		modulesLock.Lock()
+		s.setStatus(ModuleStopped, nil)
+		modulesLock.Unlock()
+		close(exited)
+	}()
+	for _, d := range deps {
+		modulesLock.Lock()
+		ch := d.readyCh
+		modulesLock.Unlock()
+		select {
+		case <-ch:
+		case <-stop:
+			return
+		}
+	}
+	backoff := minModuleBackoff
+	for {
+		started := time.Now()
+		modulesLock.Lock()
+		s.setStatus(ModuleStarting, nil)
+		modulesLock.Unlock()
+		err := s.run(stop, readyCh)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			err = errors.New("exited")
+		}
+		if time.Since(started) > moduleStableTime {
+			backoff = minModuleBackoff
+		}
+		supervisorLog.Errorf("[Supervisor] module [%s] crashed: %v, restart in %s", s.Name, err, backoff)
+		modulesLock.Lock()
+		s.restarts++
+		s.setStatus(ModuleFailed, err)
+		modulesLock.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxModuleBackoff {
+			backoff = maxModuleBackoff
+		}
+	}
+}
+
+// run once, a panic is returned as an error
+func (s *moduleState) run(stop, readyCh chan struct{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			supervisorLog.Errorf("[Supervisor] module [%s] panic: %v", s.Name, e)
+			supervisorLog.Errorf("[Supervisor] module [%s] stack: %s", s.Name, debug.Stack())
+			err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+	var once sync.Once
+	return s.Run(stop, func() {
+		modulesLock.Lock()
+		s.setStatus(ModuleReady, nil)
+		modulesLock.Unlock()
+		once.Do(func() { close(readyCh) })
+		supervisorLog.Debugf("[Supervisor] module [%s] is ready", s.Name)
+	})
+}
+
+// a module applied by the config, ready as soon as its dependencies are. It has nothing to restart,
+// [check] reports its health
+func StaticModule(name string, check func() error, depends ...string) *Module {
+	return &Module{
+		Name:    name,
+		Depends: depends,
+		Check:   check,
+		Run: func(stop chan struct{}, ready func()) error {
+			ready()
+			<-stop
+			return nil
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package shuttle
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/sipt/shuttle/conn"
+)
+
+const (
+	headerShuttleTag = "X-Shuttle-Tag"
+
+	maxTagLen = 255
+)
+
+type tagKey struct{}
+
+// opaque tag of a client connection, to correlate its requests with records and traffic:
+// SOCKS5 username "user+tag" ("+tag" without [Users]), HTTP header "X-Shuttle-Tag"
+func connTag(c connect.IConn) string {
+	tag, _ := c.Context().Value(tagKey{}).(string)
+	return tag
+}
+
+func setConnTag(c connect.IConn, tag string) {
+	if len(tag) > 0 {
+		c.SetContext(context.WithValue(c.Context(), tagKey{}, tag))
+	}
+}
+
+// tag header of the request, removed before forwarding
+func httpTag(hreq *http.Request) string {
+	tag := hreq.Header.Get(headerShuttleTag)
+	hreq.Header.Del(headerShuttleTag)
+	if len(tag) > maxTagLen {
+		tag = tag[:maxTagLen]
+	}
+	return tag
+}
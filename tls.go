@@ -13,15 +13,15 @@ import (
 	"fmt"
 	"github.com/sipt/shuttle/config"
 	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/grpcview"
 	"math/big"
-	"reflect"
 	"time"
-	"unsafe"
 )
 
 var ca *x509.Certificate
 var caBytes []byte
 var key *rsa.PrivateKey
+var grpcDescriptors *grpcview.Descriptors
 
 type IMITMConfig interface {
 	GetMITM() *config.Mitm
@@ -37,14 +37,26 @@ func ApplyMITMConfig(config IMITMConfig) error {
 	if err != nil {
 		return err
 	}
-	keyBytes, err := base64.RawStdEncoding.DecodeString(mitm.Key)
+	keyBytes, err := base64.RawStdEncoding.DecodeString(mitm.Key.Reveal())
 	if err != nil {
 		return err
 	}
 	ca, key, err = LoadCA(caBytes, keyBytes)
 
 	MitMRules = mitm.Rules
-	return err
+	if err != nil {
+		return err
+	}
+	grpcDescriptors, err = grpcview.LoadDescriptors(mitm.GRPCDescriptors)
+	if err != nil {
+		return fmt.Errorf("resolve config file [MITM] [grpc-descriptors] %v", err)
+	}
+	return nil
+}
+
+// For controller API
+func GetGRPCDescriptors() *grpcview.Descriptors {
+	return grpcDescriptors
 }
 func GetCACert() []byte {
 	l := len(caBytes)
@@ -111,7 +123,7 @@ func GenerateCA() (mitm *config.Mitm, err error) {
 
 	mitm = &config.Mitm{
 		CA:    base64.RawStdEncoding.EncodeToString(certBuffer.Bytes()),
-		Key:   base64.RawStdEncoding.EncodeToString(keyBuffer.Bytes()),
+		Key:   config.Secret(base64.RawStdEncoding.EncodeToString(keyBuffer.Bytes())),
 		Rules: MitMRules,
 	}
 	return
@@ -143,47 +155,74 @@ func makeCert(cert *x509.Certificate) ([]byte, error) {
 	return derBytes, nil
 }
 
-func Mimt(lc, sc connect.IConn) (connect.IConn, connect.IConn, error) {
+// [domain] of the upstream, its verification is configured in [TLS-Verify].
+// the hello of the client is read first, its h2 or http/1.1 are offered upstream,
+// the protocol negotiated is returned
+func Mimt(lc, sc connect.IConn, domain string) (connect.IConn, connect.IConn, string, error) {
 	if ca == nil {
-		return nil, nil, errors.New("please first generate CA")
-	}
-	conf := &tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: true,
+		return nil, nil, "", errors.New("please first generate CA")
 	}
 	lcID, scID := lc.GetID(), sc.GetID()
-	scTls := tls.Client(sc, conf)
-	err := scTls.Handshake()
-	if err != nil {
-		return nil, nil, fmt.Errorf("tls hand shake: %v", err)
-	}
-	//解析sc证书
-	rt := reflect.TypeOf(scTls).Elem()
-	filed, ok := rt.FieldByName("peerCertificates")
-	var cert *x509.Certificate
-	if ok {
-		ptr := (uintptr)(unsafe.Pointer(scTls))
-		cert = (*(*[]*x509.Certificate)(unsafe.Pointer(ptr + filed.Offset)))[0]
-	}
-	sc, err = connect.DefaultDecorateForTls(scTls, connect.TCP, scID)
-	if err != nil {
-		return nil, nil, err
-	}
-	derCert, err := makeCert(cert)
-	if err != nil {
-		return nil, nil, err
-	}
-	conf = &tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: true,
-		Certificates: []tls.Certificate{
-			{
-				Certificate: [][]byte{derCert},
-				PrivateKey:  key,
-			},
+	var (
+		scTls *tls.Conn
+		scErr error
+	)
+	lcTls := tls.Server(lc, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			conf := upstreamTLSConfig(domain)
+			conf.NextProtos = httpProtos(hello.SupportedProtos)
+			scTls = tls.Client(sc, conf)
+			if scErr = scTls.Handshake(); scErr != nil {
+				return nil, scErr
+			}
+			state := scTls.ConnectionState()
+			var derCert []byte
+			if derCert, scErr = makeCert(state.PeerCertificates[0]); scErr != nil {
+				return nil, scErr
+			}
+			conf = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				Certificates: []tls.Certificate{
+					{
+						Certificate: [][]byte{derCert},
+						PrivateKey:  key,
+					},
+				},
+			}
+			if len(state.NegotiatedProtocol) > 0 {
+				conf.NextProtos = []string{state.NegotiatedProtocol}
+			}
+			return conf, nil
 		},
+	})
+	// both handshakes end in time, also if a side stops responding
+	deadline := time.Now().Add(connect.DefaultTimeOut)
+	lc.SetDeadline(deadline)
+	sc.SetDeadline(deadline)
+	if err := lcTls.Handshake(); err != nil {
+		if scErr != nil {
+			err = scErr
+		}
+		return nil, nil, "", fmt.Errorf("tls hand shake: %v", err)
+	}
+	lc.SetDeadline(time.Time{})
+	sc.SetDeadline(time.Time{})
+	proto := lcTls.ConnectionState().NegotiatedProtocol
+	sc, err := connect.DefaultDecorateForTls(scTls, connect.TCP, scID)
+	if err != nil {
+		return nil, nil, "", err
 	}
-	lcTls := tls.Server(lc, conf)
 	lc, err = connect.DefaultDecorateForTls(lcTls, connect.TCP, lcID)
-	return lc, sc, err
+	return lc, sc, proto, err
+}
+
+// h2 and http/1.1 in the order of the client
+func httpProtos(protos []string) []string {
+	var ps []string
+	for _, p := range protos {
+		if p == "h2" || p == "http/1.1" {
+			ps = append(ps, p)
+		}
+	}
+	return ps
 }
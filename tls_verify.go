@@ -0,0 +1,159 @@
+package shuttle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	TLSVerifySkip   = "skip"   // no verification, default
+	TLSVerifySystem = "verify" // system roots
+	TLSVerifyCA     = "ca:"    // private CA file
+	TLSVerifyPin    = "pin:"   // sha256 of public keys, e.g. pin:sha256/base64,sha256/base64
+
+	pinPrefix = "sha256/"
+)
+
+var ErrorPinMismatch = errors.New("certificate pin mismatch")
+
+// verification of upstream TLS that shuttle originates itself (MitM, hedged requests),
+// by destination: exact domain, then "*.suffix", then "*"
+type ITLSVerifyConfig interface {
+	GetTLSVerify() map[string]string
+}
+
+type tlsVerify struct {
+	pattern string
+	mode    string
+	roots   *x509.CertPool
+	pins    [][]byte
+}
+
+var (
+	tlsVerifies      []*tlsVerify
+	tlsVerifyLock    sync.RWMutex
+	defaultTLSVerify = &tlsVerify{pattern: "*", mode: TLSVerifySkip}
+)
+
+func ApplyTLSVerifyConfig(config ITLSVerifyConfig) error {
+	vs, err := parseTLSVerify(config.GetTLSVerify())
+	if err != nil {
+		return fmt.Errorf("resolve config file [TLS-Verify] %v", err)
+	}
+	tlsVerifyLock.Lock()
+	tlsVerifies = vs
+	tlsVerifyLock.Unlock()
+	return nil
+}
+
+func parseTLSVerify(c map[string]string) ([]*tlsVerify, error) {
+	vs := make([]*tlsVerify, 0, len(c))
+	for k, v := range c {
+		t := &tlsVerify{pattern: canonicalSNIPattern(k)}
+		switch {
+		case v == TLSVerifySkip, v == TLSVerifySystem:
+			t.mode = v
+		case strings.HasPrefix(v, TLSVerifyCA):
+			t.mode = TLSVerifyCA
+			data, err := ioutil.ReadFile(v[len(TLSVerifyCA):])
+			if err != nil {
+				return nil, fmt.Errorf("[%s] read CA failed: %v", k, err)
+			}
+			t.roots = x509.NewCertPool()
+			if !t.roots.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("[%s] no certificate in CA file [%s]", k, v[len(TLSVerifyCA):])
+			}
+		case strings.HasPrefix(v, TLSVerifyPin):
+			t.mode = TLSVerifyPin
+			for _, p := range strings.Split(v[len(TLSVerifyPin):], ",") {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(p, pinPrefix) {
+					return nil, fmt.Errorf("[%s] pin [%s] must be sha256/base64", k, p)
+				}
+				pin, err := base64.StdEncoding.DecodeString(p[len(pinPrefix):])
+				if err != nil || len(pin) != sha256.Size {
+					return nil, fmt.Errorf("[%s] invalid pin [%s]", k, p)
+				}
+				t.pins = append(t.pins, pin)
+			}
+		default:
+			return nil, fmt.Errorf("[%s] not support [%s], support: skip, verify, ca:<file>, pin:sha256/<base64>", k, v)
+		}
+		vs = append(vs, t)
+	}
+	return vs, nil
+}
+
+// exact domain first, then the longest "*.suffix", then "*"
+func matchTLSVerify(domain string) *tlsVerify {
+	domain = util.CanonicalHost(domain)
+	tlsVerifyLock.RLock()
+	defer tlsVerifyLock.RUnlock()
+	var matched, def *tlsVerify
+	for _, v := range tlsVerifies {
+		switch {
+		case v.pattern == domain:
+			return v
+		case v.pattern == "*":
+			def = v
+		case strings.HasPrefix(v.pattern, "*.") && strings.HasSuffix(domain, v.pattern[1:]):
+			if matched == nil || len(v.pattern) > len(matched.pattern) {
+				matched = v
+			}
+		}
+	}
+	if matched != nil {
+		return matched
+	}
+	if def != nil {
+		return def
+	}
+	return defaultTLSVerify
+}
+
+// client config of upstream TLS to [domain]
+func upstreamTLSConfig(domain string) *tls.Config {
+	v := matchTLSVerify(domain)
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: domain,
+	}
+	switch v.mode {
+	case TLSVerifySkip:
+		conf.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		conf.RootCAs = v.roots
+	case TLSVerifyPin:
+		// pins replace the chain verification, a self-signed certificate can be pinned.
+		// only the leaf is pinned: it is the one the server proves to own, others of the chain are just sent
+		conf.InsecureSkipVerify = true
+		pins := v.pins
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrorPinMismatch
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, p := range pins {
+				if bytes.Equal(sum[:], p) {
+					return nil
+				}
+			}
+			return ErrorPinMismatch
+		}
+	}
+	return conf
+}
@@ -9,8 +9,6 @@ import (
 	"time"
 
 	connect "github.com/sipt/shuttle/conn"
-	"github.com/sipt/shuttle/log"
-	"github.com/sipt/shuttle/pool"
 	"github.com/sipt/shuttle/proxy"
 	rule2 "github.com/sipt/shuttle/rule"
 	"github.com/sipt/shuttle/util"
@@ -46,35 +44,11 @@ func (d *DirectChannel) Transport(lc, sc connect.IConn) {
 }
 
 func (d *DirectChannel) send(from, to connect.IConn, errChan chan error) {
-	var (
-		buf []byte
-		n   int
-		err error
-	)
-	for {
-		buf = pool.GetBuf()
-		n, err = from.Read(buf)
-		// @fix 空数据返回引发断连
-		//if n == 0 {
-		//errChan <- nil
-		//return
-		//}
-		if err != nil {
-			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
-				log.Logger.Errorf("[ID:%d] [DirectChannel] DirectChannel Transport: %v", from.GetID(), err)
-			}
-			errChan <- err
-			return
-		}
-		n, err = to.Write(buf[:n])
-		if err != nil {
-			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
-				log.Logger.Error("[ID:%d] [DirectChannel] DirectChannel Transport: %v", to.GetID(), err)
-			}
-			errChan <- err
-			return
-		}
+	_, err := connect.Copy(to, from)
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		shuttleLog.Errorf("[ID:%d] [DirectChannel] DirectChannel Transport [ID:%d]: %v", from.GetID(), to.GetID(), err)
 	}
+	errChan <- err
 }
 
 func HttpTransport(lc, sc connect.IConn, allowDump bool, first *http.Request) {
@@ -91,6 +65,8 @@ type HttpChannel struct {
 }
 
 func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err error) {
+	wc := connect.NewWatchedConn(lc)
+	lc = wc
 	var (
 		oldHreq, hreq *http.Request
 		lcBuf         = bufio.NewReader(lc)
@@ -100,6 +76,7 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 		server        *proxy.Server
 		passed        bool // inner request
 		scid          int64
+		lastPolicy    = connPolicy(lc)
 	)
 	if sc != nil {
 		scBuf = bufio.NewReader(sc)
@@ -122,12 +99,34 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 			hreq, err = http.ReadRequest(lcBuf)
 			if err != nil {
 				if err != io.EOF {
-					log.Logger.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport c->[hreq]: %v", sc.GetID(), err)
+					httpLog.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport c->[hreq]: %v", sc.GetID(), err)
 				}
 				return
 			}
 		}
 
+		if !h.isHttps {
+			if AuthRequired() && hreq != first {
+				// every request of a keep-alive connection carries its credentials
+				user, ok := httpProxyAuth(hreq)
+				if !ok {
+					httpLog.Errorf("[ID:%d] [HttpChannel] %s [%s]", lc.GetID(), ErrorAuthFailed.Error(), user)
+					replyProxyAuthRequired(lc)
+					return
+				}
+				setConnUser(lc, user)
+			} else {
+				hreq.Header.Del(headerProxyAuthorization)
+			}
+		}
+		tag := httpTag(hreq)
+		if len(tag) == 0 {
+			tag = connTag(lc)
+		}
+		policy := httpPolicy(hreq)
+		if len(policy) == 0 || connSelf(lc) {
+			policy = connPolicy(lc)
+		}
 		//request update
 		resp = RequestModify(hreq, h.isHttps)
 		passed = IsPass(hreq.URL.Hostname(), hreq.URL.Hostname(), hreq.URL.Port())
@@ -140,6 +139,9 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 			Dumped:  h.allowDump && !passed,
 			Rule:    rule,
 			Proxy:   server,
+			User:    connUser(lc),
+			Tag:     tag,
+			Src:     srcString(lc.RemoteAddr()),
 		}
 		if h.isHttps {
 			record.Protocol = HTTPS
@@ -159,14 +161,15 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 				record.URL = "http:" + record.URL
 			}
 		}
-		log.Logger.Debugf("[ID:%d] [HttpChannel] [reqID:%d] HttpChannel Transport c->[hreq]: %s", lc.GetID(), record.ID, record.URL)
+		httpLog.Debugf("[ID:%d] [HttpChannel] [reqID:%d] HttpChannel Transport c->[hreq]: %s", lc.GetID(), record.ID, record.URL)
 
 		// rule RuleFilter
-		if resp == nil && (sc == nil || (oldHreq != nil && hreq.URL.Host != oldHreq.URL.Host)) {
+		if resp == nil && (sc == nil || (oldHreq != nil && hreq.URL.Host != oldHreq.URL.Host) || policy != lastPolicy) {
 			if sc != nil {
 				sc.Close()
 			}
-			rule, server, sc, err = ConnectFilter(hreq, lc.GetID())
+			lastPolicy = policy
+			rule, server, sc, err = ConnectFilter(hreq, lc, policy)
 			record.Rule = rule
 			record.Proxy = server
 			if err != nil {
@@ -197,7 +200,7 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 			boxChan <- &Box{Op: RecordAppend, Value: record, ID: record.ID}
 		}
 		if sc != nil {
-			log.Logger.Debugf("[ID:%d] [HttpChannel] [reqID:%d] HttpChannel Transport send record to boxChan", scid, record.ID)
+			httpLog.Debugf("[ID:%d] [HttpChannel] [reqID:%d] HttpChannel Transport send record to boxChan", scid, record.ID)
 			sc.SetRecordID(record.ID)
 		}
 
@@ -209,6 +212,12 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 				return dump.WriteRequest(record.ID, b)
 			})
 		}
+		var reqMirror, respMirror *mirrorBuffer
+		if !passed {
+			reqMirror = mirrorWriter(record, MirrorEventRequest)
+			respMirror = mirrorWriter(record, MirrorEventResponse)
+			dumpWriter = mirrorDumpWriter(dumpWriter, reqMirror)
+		}
 		// 分流器
 		var shunt *Shunt
 		if resp != nil {
@@ -219,16 +228,17 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 		}
 
 		err = hreq.Write(shunt)
+		reqMirror.Flush()
 		if err != nil {
 			if err != io.EOF {
-				log.Logger.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport [hreq]->s: %v", scid, err)
+				httpLog.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport [hreq]->s: %v", scid, err)
 				return
 			}
 		}
 		//response mock ?
 		if resp != nil {
 			// write response to client
-			err = h.writeResponse(resp, lc, record.ID, h.allowDump && !passed)
+			err = h.writeResponse(resp, lc, record.ID, h.allowDump && !passed, respMirror)
 			if err != nil {
 				return
 			}
@@ -237,16 +247,37 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 		//==================
 		//Read response
 		//==================
-		resp, err = http.ReadResponse(scBuf, nil)
+		// the request is sent, the upstream is closed if the client closes while waiting
+		cur := sc
+		stop := wc.Watch(func() { cur.Close() })
+		result := hedgeResponse(hreq, lc, sc, scBuf, rule, server, record.ID, h.isHttps)
+		stop()
+		if wc.Context().Err() != nil {
+			if result.conn != nil && result.conn != sc {
+				result.conn.Close()
+			}
+			httpLog.Debugf("[ID:%d] [HttpChannel] [%s] %v while waiting for the response", lc.GetID(), hreq.URL.Host, ErrorCanceled)
+			return ErrorCanceled
+		}
+		if result.conn != nil && result.conn != sc {
+			sc, scBuf, server, scid = result.conn, result.reader, result.server, result.conn.GetID()
+		}
+		resp, err = result.resp, result.err
 		if err != nil {
 			if err != io.EOF {
-				log.Logger.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport s->[b]: %v", scid, err)
+				httpLog.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport s->[b]: %v", scid, err)
 			}
 			return
 		}
-		log.Logger.Debugf("[ID:%d] [HttpChannel] HttpChannel Transport return s->[b]", scid)
+		httpLog.Debugf("[ID:%d] [HttpChannel] HttpChannel Transport return s->[b]", scid)
+		resumed := resumeResponse(hreq, resp, lc, rule, policy, record.ID, h.isHttps)
 		ResponseModify(hreq, resp, h.isHttps)
-		err = h.writeResponse(resp, lc, record.ID, h.allowDump && !passed)
+		err = h.writeResponse(resp, lc, record.ID, h.allowDump && !passed, respMirror)
+		if resumed != nil && resumed.conn != nil {
+			// the broken upstream is replaced by the one of the resume
+			sc.Close()
+			sc, scBuf, server, scid = resumed.conn, resumed.reader, resumed.server, resumed.conn.GetID()
+		}
 		if err != nil {
 			return
 		}
@@ -255,20 +286,22 @@ func (h *HttpChannel) Transport(lc, sc connect.IConn, first *http.Request) (err
 }
 
 // write response in connection
-func (h *HttpChannel) writeResponse(resp *http.Response, to connect.IConn, recordID int64, allowDump bool) (err error) {
+func (h *HttpChannel) writeResponse(resp *http.Response, to connect.IConn, recordID int64, allowDump bool, mirror *mirrorBuffer) (err error) {
 	var dumpWriter io.Writer
 	if allowDump {
 		dumpWriter = ToWriter(func(b []byte) (int, error) {
 			return dump.WriteResponse(recordID, b)
 		})
 	}
+	dumpWriter = mirrorDumpWriter(dumpWriter, mirror)
 	// 分流器
 	shunt := NewShunt(to, dumpWriter)
 	err = resp.Write(shunt)
+	mirror.Flush()
 	if err != nil && err != io.EOF {
-		log.Logger.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport [b]->c: %v", to.GetID(), err)
+		httpLog.Errorf("[ID:%d] [HttpChannel] HttpChannel Transport [b]->c: %v", to.GetID(), err)
 	} else {
-		log.Logger.Debugf("[ID:%d] [HttpChannel] HttpChannel Transport return [b]->c", to.GetID())
+		httpLog.Debugf("[ID:%d] [HttpChannel] HttpChannel Transport return [b]->c", to.GetID())
 	}
 	if allowDump {
 		go func() {
@@ -293,13 +326,19 @@ func HostName(req *http.Request) (host string) {
 	return
 }
 
-func ConnectFilter(hreq *http.Request, connID int64) (rule *rule2.Rule, server *proxy.Server, conn connect.IConn, err error) {
+// [policy] of the request, empty for rules
+func ConnectFilter(hreq *http.Request, lc connect.IConn, policy string) (rule *rule2.Rule, server *proxy.Server, conn connect.IConn, err error) {
+	connID := lc.GetID()
 	req := &HttpRequest{
 		network:  connect.TCP,
 		domain:   HostName(hreq),
 		connID:   connID,
 		port:     hreq.URL.Port(),
 		protocol: hreq.URL.Scheme,
+		srcAddr:  lc.RemoteAddr(),
+		user:     connUser(lc),
+		sniffed:  connSniffed(lc),
+		policy:   policy,
 	}
 	if len(req.protocol) == 0 {
 		req.protocol = HTTPS
@@ -308,24 +347,57 @@ func ConnectFilter(hreq *http.Request, connID int64) (rule *rule2.Rule, server *
 		req.ip = req.domain
 		req.domain = ""
 	}
-	rule, server, err = FilterByReq(req)
+	rule, server, err = FilterByReqContext(lc.Context(), req)
+	req.sniffed = "" // dial to the origin ip
 	if err != nil {
-		log.Logger.Errorf("[HTTP] [ID:%d] ConnectToServer failed [%s] err: %s", connID, req.Host(), err)
+		httpLog.Errorf("[HTTP] [ID:%d] ConnectToServer failed [%s] err: %s", connID, req.Host(), err)
 		return
 	}
 
-	log.Logger.Debugf("[HTTP] [ID:%d] Start connect to Server [%s] [%s]", connID, req.Host(), server.Name)
-	conn, err = server.Conn(req)
+	httpLog.Debugf("[HTTP] [ID:%d] Start connect to Server [%s] [%s]", connID, req.Host(), server.Name)
+	dialPolicy := proxy.ProxyDirect
+	if rule != nil {
+		dialPolicy = rule.Policy
+	}
+	conn, err = server.ConnWithDialer(req, proxy.PolicyDialerContext(lc.Context(), dialPolicy, server.Name))
 	if err != nil {
 		if err == ErrorReject {
-			log.Logger.Debugf("Reject [%s]", req.Host())
+			shuttleLog.Debugf("Reject [%s]", req.Host())
 		} else {
-			log.Logger.Errorf("[HTTP] [ID:%d] Connect to Server [%s] failed [%s] err: %s",
+			httpLog.Errorf("[HTTP] [ID:%d] Connect to Server [%s] failed [%s] err: %s",
 				connID, server.Name, req.Host(), err.Error())
 			return
 		}
 	} else {
-		log.Logger.Infof("[HTTP] [ClientConnID:%d] Bind to Server [ServerConnID:%d]", connID, conn.GetID())
+		httpLog.Infof("[HTTP] [ClientConnID:%d] Bind to Server [ServerConnID:%d]", connID, conn.GetID())
 	}
 	return
 }
+
+type dialResult struct {
+	conn connect.IConn
+	err  error
+}
+
+// [dial] while the client waits for the reply, it returns ErrorCanceled as soon as the client
+// aborts [lc]. [dial] resolves and dials with the context of [lc], so it is given up too
+func dialWatched(lc *connect.WatchedConn, dial func() (connect.IConn, error)) (connect.IConn, error) {
+	result := make(chan dialResult, 1)
+	go func() {
+		c, err := dial()
+		result <- dialResult{c, err}
+	}()
+	stop := lc.Watch(nil)
+	defer stop()
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-lc.Context().Done():
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ErrorCanceled
+	}
+}
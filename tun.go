@@ -0,0 +1,84 @@
+package shuttle
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	connect "github.com/sipt/shuttle/conn"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/util"
+)
+
+const (
+	ProtocolTun    = "TUN"
+	ProtocolTunUDP = "TUN(UDP)"
+)
+
+// a TCP connection of a packet tunnel to [dst], terminated by its userspace stack. it goes through
+// the rules as a SOCKS5 CONNECT to the IP, the domain answered by shuttle for the IP is kept for rules
+func TunHandle(co net.Conn, dst *net.TCPAddr) {
+	conn, err := connect.NewDefaultConn(co, connect.TCP)
+	if err != nil {
+		shuttleLog.Errorf("shuttle.IConn wrap net.Conn failed: %v", err)
+		co.Close()
+		return
+	}
+	req := tunRequest(CmdTCP, dst.IP, dst.Port)
+	req.protocol = ProtocolTun
+	req.connID, req.srcAddr = conn.GetID(), co.RemoteAddr()
+	if len(req.sniffed) > 0 {
+		req.target = net.JoinHostPort(req.sniffed, req.Port())
+	}
+	socksLog.Debugf("[TUN] [ID:%d] [%s] connect to [%s]", conn.GetID(), co.RemoteAddr(), req.target)
+	socksConnect(conn, req)
+}
+
+func tunRequest(cmd uint8, ip net.IP, port int) *SocksRequest {
+	req := &SocksRequest{ver: socksVer5, cmd: cmd, atyp: AddrTypeIPv4, port: uint16(port)}
+	if ip4 := ip.To4(); ip4 != nil {
+		req.ip = append(net.IP{}, ip4...)
+	} else {
+		req.atyp, req.ip = AddrTypeIPv6, append(net.IP{}, ip.To16()...)
+	}
+	req.target = net.JoinHostPort(req.ip.String(), strconv.Itoa(port))
+	req.sniffed = dns.ReverseDNSCache(req.ip.String())
+	return req
+}
+
+// UDP of a packet tunnel from one source (a socket of an app): datagrams are routed by rules per
+// destination as SOCKS5 UDP, replies are passed to [reply] with the remote as source
+type TunUDP struct {
+	a *udpAssociation
+}
+
+func NewTunUDP(src *net.UDPAddr, reply func(from *net.UDPAddr, data []byte) error) *TunUDP {
+	a := &udpAssociation{
+		connID:   util.GetLongID(),
+		protocol: ProtocolTunUDP,
+		client:   src,
+		reply:    reply,
+		policy:   getUDP(),
+		sessions: make(map[string]*udpSession),
+		routes:   make(map[string]*udpRoute),
+		sent:     time.Now(),
+	}
+	return &TunUDP{a: a}
+}
+
+// send [data] to [dst], the domain answered by shuttle for the IP is kept for rules
+func (t *TunUDP) Send(dst *net.UDPAddr, data []byte) error {
+	req := tunRequest(CmdUDP, dst.IP, dst.Port)
+	req.protocol = ProtocolTunUDP
+	req.connID, req.srcAddr = t.a.connID, t.a.client
+	return t.a.send(req, data)
+}
+
+// no session is open and nothing was sent for the UDP timeout
+func (t *TunUDP) Idle() bool {
+	return t.a.idle()
+}
+
+func (t *TunUDP) Close() {
+	t.a.close()
+}
@@ -0,0 +1,6 @@
+package tunnel
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var tunnelLog = log.Named("tunnel")
@@ -0,0 +1,244 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+	dnsPort     = 53
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	tcpHeaderLen  = 20
+	defaultTTL    = 64
+)
+
+var errMalformed = errors.New("malformed packet")
+
+// an IPv4/IPv6 packet, options and extension headers are not supported
+type ipPacket struct {
+	src, dst net.IP
+	protocol byte
+	payload  []byte // header and data of the transport
+}
+
+// a UDP datagram of an IP packet
+type udpPacket struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	payload          []byte
+}
+
+// a TCP segment of an IP packet
+type tcpSegment struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	seq, ack         uint32
+	flags            byte
+	window           uint16
+	mss              uint16 // option of SYN, 0 without
+	payload          []byte
+}
+
+func parseIP(packet []byte) (*ipPacket, error) {
+	if len(packet) == 0 {
+		return nil, errMalformed
+	}
+	p := &ipPacket{}
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0f) * 4
+		if len(packet) < ipv4HeaderLen || ihl < ipv4HeaderLen || len(packet) < ihl {
+			return nil, errMalformed
+		}
+		if binary.BigEndian.Uint16(packet[6:8])&0x3fff != 0 {
+			return nil, errMalformed // fragment
+		}
+		total := int(binary.BigEndian.Uint16(packet[2:4]))
+		if total < ihl || total > len(packet) {
+			return nil, errMalformed
+		}
+		p.protocol = packet[9]
+		p.src, p.dst = net.IP(packet[12:16]), net.IP(packet[16:20])
+		p.payload = packet[ihl:total]
+	case 6:
+		if len(packet) < ipv6HeaderLen {
+			return nil, errMalformed
+		}
+		total := ipv6HeaderLen + int(binary.BigEndian.Uint16(packet[4:6]))
+		if total > len(packet) {
+			return nil, errMalformed
+		}
+		p.protocol = packet[6]
+		p.src, p.dst = net.IP(packet[8:24]), net.IP(packet[24:40])
+		p.payload = packet[ipv6HeaderLen:total]
+	default:
+		return nil, errMalformed
+	}
+	return p, nil
+}
+
+func parseUDP(ip *ipPacket) (*udpPacket, error) {
+	udp := ip.payload
+	if ip.protocol != protocolUDP || len(udp) < udpHeaderLen {
+		return nil, errMalformed
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:6]))
+	if length < udpHeaderLen || length > len(udp) {
+		return nil, errMalformed
+	}
+	return &udpPacket{
+		src:     ip.src,
+		dst:     ip.dst,
+		srcPort: binary.BigEndian.Uint16(udp[0:2]),
+		dstPort: binary.BigEndian.Uint16(udp[2:4]),
+		payload: udp[udpHeaderLen:length],
+	}, nil
+}
+
+func parseTCP(ip *ipPacket) (*tcpSegment, error) {
+	tcp := ip.payload
+	if ip.protocol != protocolTCP || len(tcp) < tcpHeaderLen {
+		return nil, errMalformed
+	}
+	offset := int(tcp[12]>>4) * 4
+	if offset < tcpHeaderLen || offset > len(tcp) {
+		return nil, errMalformed
+	}
+	s := &tcpSegment{
+		src:     ip.src,
+		dst:     ip.dst,
+		srcPort: binary.BigEndian.Uint16(tcp[0:2]),
+		dstPort: binary.BigEndian.Uint16(tcp[2:4]),
+		seq:     binary.BigEndian.Uint32(tcp[4:8]),
+		ack:     binary.BigEndian.Uint32(tcp[8:12]),
+		flags:   tcp[13],
+		window:  binary.BigEndian.Uint16(tcp[14:16]),
+		payload: tcp[offset:],
+	}
+	// options: only MSS is taken, window scale and SACK are not offered back
+	for opts := tcp[tcpHeaderLen:offset]; len(opts) > 0; {
+		switch opts[0] {
+		case 0: // end
+			opts = nil
+		case 1: // nop
+			opts = opts[1:]
+		default:
+			if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+				return nil, errMalformed
+			}
+			if opts[0] == 2 && opts[1] == 4 {
+				s.mss = binary.BigEndian.Uint16(opts[2:4])
+			}
+			opts = opts[opts[1]:]
+		}
+	}
+	return s, nil
+}
+
+// the reply of [q] with [payload], addresses and ports are swapped
+func buildReply(q *udpPacket, payload []byte) []byte {
+	return buildUDP(q.dst, q.src, q.dstPort, q.srcPort, payload)
+}
+
+func buildUDP(src, dst net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	packet, udp := buildIP(src, dst, protocolUDP, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+	binary.BigEndian.PutUint16(udp[6:8], udpChecksum(src, dst, udp))
+	return packet
+}
+
+// a segment with the MSS option if [mss] > 0
+func buildTCP(src, dst net.IP, srcPort, dstPort uint16, seq, ack uint32, flags byte, window, mss uint16,
+	payload []byte) []byte {
+	offset := tcpHeaderLen
+	if mss > 0 {
+		offset += 4
+	}
+	packet, tcp := buildIP(src, dst, protocolTCP, offset+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	tcp[12] = byte(offset/4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], window)
+	if mss > 0 {
+		tcp[20], tcp[21] = 2, 4
+		binary.BigEndian.PutUint16(tcp[22:24], mss)
+	}
+	copy(tcp[offset:], payload)
+	binary.BigEndian.PutUint16(tcp[16:18], transportChecksum(src, dst, protocolTCP, tcp))
+	return packet
+}
+
+// an IPv4 packet if both addresses are IPv4, or an IPv6 one, with the transport of [length] to fill
+func buildIP(src, dst net.IP, protocol byte, length int) (packet, transport []byte) {
+	if ip4 := src.To4(); ip4 != nil && dst.To4() != nil {
+		packet = make([]byte, ipv4HeaderLen+length)
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+		packet[8] = defaultTTL
+		packet[9] = protocol
+		copy(packet[12:16], ip4)
+		copy(packet[16:20], dst.To4())
+		binary.BigEndian.PutUint16(packet[10:12], checksum(0, packet[:ipv4HeaderLen]))
+	} else {
+		packet = make([]byte, ipv6HeaderLen+length)
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:6], uint16(length))
+		packet[6] = protocol
+		packet[7] = defaultTTL
+		copy(packet[8:24], src.To16())
+		copy(packet[24:40], dst.To16())
+	}
+	return packet, packet[len(packet)-length:]
+}
+
+// with the pseudo header, 0 is sent as 0xffff
+func udpChecksum(src, dst net.IP, udp []byte) uint16 {
+	if c := transportChecksum(src, dst, protocolUDP, udp); c != 0 {
+		return c
+	}
+	return 0xffff
+}
+
+// with the pseudo header, the checksum field of [b] is 0 or its value to verify
+func transportChecksum(src, dst net.IP, protocol byte, b []byte) uint16 {
+	var sum uint32
+	if src.To4() != nil && dst.To4() != nil {
+		sum = sumBytes(sum, src.To4())
+		sum = sumBytes(sum, dst.To4())
+	} else {
+		sum = sumBytes(sum, src.To16())
+		sum = sumBytes(sum, dst.To16())
+	}
+	sum += uint32(protocol) + uint32(len(b))
+	return checksum(sum, b)
+}
+
+func checksum(sum uint32, b []byte) uint16 {
+	sum = sumBytes(sum, b)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func sumBytes(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDNSPacket(t *testing.T) {
+	for _, addrs := range [][2]string{{"10.0.0.2", "198.18.0.2"}, {"fd00::2", "fd00::53"}} {
+		client, server := net.ParseIP(addrs[0]), net.ParseIP(addrs[1])
+		// client:50000 -> server:53, as the reply of the other way
+		query := buildReply(&udpPacket{src: server, dst: client, srcPort: 53, dstPort: 50000}, []byte("query"))
+		q, err := parseUDPPacket(query)
+		if err != nil {
+			t.Fatalf("%s: %v", addrs[0], err)
+		}
+		if !q.src.Equal(client) || !q.dst.Equal(server) || q.srcPort != 50000 || q.dstPort != dnsPort ||
+			string(q.payload) != "query" {
+			t.Errorf("%s: got %+v", addrs[0], q)
+		}
+		reply := buildReply(q, []byte("answer"))
+		if r, err := parseUDPPacket(reply); err != nil || r.srcPort != dnsPort || r.dstPort != 50000 {
+			t.Errorf("%s: reply %+v, %v", addrs[0], r, err)
+		}
+		udp := reply[len(reply)-udpHeaderLen-len("answer"):]
+		if c := checksum(0, reply[:ipv4HeaderLen]); client.To4() != nil && c != 0 {
+			t.Errorf("%s: ip checksum %x", addrs[0], c)
+		}
+		if c := udpChecksum(server, client, udp); c != 0xffff {
+			t.Errorf("%s: udp checksum %x", addrs[0], c)
+		}
+		if !bytes.HasSuffix(reply, []byte("answer")) {
+			t.Errorf("%s: payload of reply", addrs[0])
+		}
+	}
+}
+
+func TestTCPPacket(t *testing.T) {
+	for _, addrs := range [][2]string{{"10.0.0.2", "93.184.216.34"}, {"fd00::2", "2606:2800:220:1::"}} {
+		src, dst := net.ParseIP(addrs[0]), net.ParseIP(addrs[1])
+		packet := buildTCP(src, dst, 50000, 443, 100, 200, tcpSYN|tcpACK, 1024, 1400, []byte("data"))
+		seg := parseTCPPacket(t, packet)
+		if !seg.src.Equal(src) || !seg.dst.Equal(dst) || seg.srcPort != 50000 || seg.dstPort != 443 ||
+			seg.seq != 100 || seg.ack != 200 || seg.flags != tcpSYN|tcpACK || seg.window != 1024 ||
+			seg.mss != 1400 || string(seg.payload) != "data" {
+			t.Errorf("%s: got %+v", addrs[0], seg)
+		}
+		ip, _ := parseIP(packet)
+		if c := transportChecksum(src, dst, protocolTCP, ip.payload); c != 0 {
+			t.Errorf("%s: tcp checksum %x", addrs[0], c)
+		}
+	}
+}
+
+func parseUDPPacket(packet []byte) (*udpPacket, error) {
+	ip, err := parseIP(packet)
+	if err != nil {
+		return nil, err
+	}
+	return parseUDP(ip)
+}
+
+func parseTCPPacket(t *testing.T, packet []byte) *tcpSegment {
+	ip, err := parseIP(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, err := parseTCP(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seg
+}
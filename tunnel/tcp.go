@@ -0,0 +1,579 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	tcpWindow     = 65535 // without window scale
+	tcpSendBuffer = 256 * 1024
+	tcpDefaultMSS = 536
+	tcpMaxMSS     = 1460
+
+	tcpMinRTO     = 200 * time.Millisecond
+	tcpMaxRTO     = 10 * time.Second
+	tcpMaxRetries = 8
+	tcpLinger     = 60 * time.Second // after closed, for the FIN of the app
+
+	maxTCPConns = 4096
+)
+
+const (
+	tcpSynReceived = iota
+	tcpEstablished
+	tcpClosed
+)
+
+// userspace TCP of the tunnel: connections of apps are terminated here and handed to [handle],
+// as accepted connections of an inbound. only what a local peer needs is done: data out of order
+// is dropped and retransmitted by the app, no window scale, SACK or congestion control
+type tcpStack struct {
+	write  func(packet []byte)
+	handle func(c net.Conn, dst *net.TCPAddr)
+	conns  map[string]*tcpConn
+	closed bool
+	sync.Mutex
+}
+
+func newTCPStack(write func(packet []byte), handle func(c net.Conn, dst *net.TCPAddr)) *tcpStack {
+	return &tcpStack{
+		write:  write,
+		handle: handle,
+		conns:  make(map[string]*tcpConn),
+	}
+}
+
+func tcpKey(s *tcpSegment) string {
+	return net.JoinHostPort(s.src.String(), strconv.Itoa(int(s.srcPort))) + "-" +
+		net.JoinHostPort(s.dst.String(), strconv.Itoa(int(s.dstPort)))
+}
+
+// a segment of an app, false if it is dropped
+func (s *tcpStack) input(seg *tcpSegment) bool {
+	key := tcpKey(seg)
+	s.Lock()
+	c, ok := s.conns[key]
+	if !ok {
+		if seg.flags&(tcpSYN|tcpACK|tcpRST) != tcpSYN || s.closed || len(s.conns) >= maxTCPConns {
+			s.Unlock()
+			if seg.flags&tcpRST == 0 {
+				s.write(resetOf(seg))
+			}
+			return false
+		}
+		c = newTCPConn(s, key, seg)
+		s.conns[key] = c
+	}
+	s.Unlock()
+	if ok {
+		c.input(seg)
+	}
+	return true
+}
+
+func (s *tcpStack) remove(c *tcpConn) {
+	s.Lock()
+	if s.conns[c.key] == c {
+		delete(s.conns, c.key)
+	}
+	s.Unlock()
+}
+
+// reset every connection, new ones are refused
+func (s *tcpStack) close() {
+	s.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = make(map[string]*tcpConn)
+	s.Unlock()
+	for _, c := range conns {
+		c.abort(syscall.ECONNRESET)
+	}
+}
+
+// RST of a segment without connection
+func resetOf(seg *tcpSegment) []byte {
+	if seg.flags&tcpACK != 0 {
+		return buildTCP(seg.dst, seg.src, seg.dstPort, seg.srcPort, seg.ack, 0, tcpRST, 0, 0, nil)
+	}
+	ack := seg.seq + uint32(len(seg.payload))
+	if seg.flags&(tcpSYN|tcpFIN) != 0 {
+		ack++
+	}
+	return buildTCP(seg.dst, seg.src, seg.dstPort, seg.srcPort, 0, ack, tcpRST|tcpACK, 0, 0, nil)
+}
+
+// a connection of an app, the local address is its destination
+type tcpConn struct {
+	stack         *tcpStack
+	key           string
+	local, remote *net.TCPAddr
+	mss           int // of segments to the app
+
+	mu    sync.Mutex
+	state int
+	err   error // reset or timed out
+
+	rcvNxt  uint32
+	rcvBuf  []byte // data of the app not read yet
+	rcvFin  bool
+	lastWnd int // window of the latest segment to the app
+
+	iss     uint32
+	sndUna  uint32 // the first byte not acked, sndBuf starts here
+	sndNxt  uint32
+	sndWnd  uint32 // of the app
+	sndBuf  []byte
+	finSent bool // FIN after sndBuf
+	finAck  bool
+	closed  bool // by Close
+
+	rto      time.Duration
+	retries  int
+	timer    *time.Timer
+	timerGen int // a timer fired after stopped is ignored
+	linger   *time.Timer
+
+	readDeadline, writeDeadline time.Time
+	readable, writable          chan struct{}
+}
+
+func newTCPConn(s *tcpStack, key string, syn *tcpSegment) *tcpConn {
+	var b [4]byte
+	rand.Read(b[:])
+	c := &tcpConn{
+		stack:    s,
+		key:      key,
+		local:    &net.TCPAddr{IP: append(net.IP{}, syn.dst...), Port: int(syn.dstPort)},
+		remote:   &net.TCPAddr{IP: append(net.IP{}, syn.src...), Port: int(syn.srcPort)},
+		mss:      tcpDefaultMSS,
+		state:    tcpSynReceived,
+		rcvNxt:   syn.seq + 1,
+		iss:      binary.BigEndian.Uint32(b[:]),
+		sndWnd:   uint32(syn.window),
+		rto:      tcpMinRTO,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+	if syn.mss > 0 {
+		c.mss = int(syn.mss)
+	}
+	c.sndUna, c.sndNxt = c.iss, c.iss+1
+	c.mu.Lock()
+	c.sendSynAck()
+	c.startTimer()
+	c.mu.Unlock()
+	return c
+}
+
+// segment of the app
+func (c *tcpConn) input(seg *tcpSegment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == tcpClosed {
+		return
+	}
+	if seg.flags&tcpRST != 0 {
+		if seqIn(seg.seq, c.rcvNxt, uint32(tcpWindow)+1) {
+			c.terminate(syscall.ECONNRESET)
+		}
+		return
+	}
+	if seg.flags&tcpSYN != 0 {
+		if c.state == tcpSynReceived && seg.seq+1 == c.rcvNxt {
+			c.sendSynAck() // SYN-ACK was lost
+		} else {
+			c.sendAck()
+		}
+		return
+	}
+	if seg.flags&tcpACK == 0 {
+		return
+	}
+	if c.state == tcpSynReceived {
+		if seg.ack != c.iss+1 {
+			c.stack.write(resetOf(seg))
+			return
+		}
+		c.state = tcpEstablished
+		c.sndUna = seg.ack
+		c.sndWnd = uint32(seg.window)
+		c.retries, c.rto = 0, tcpMinRTO
+		c.stopTimer()
+		go c.stack.handle(c, c.local)
+	} else {
+		c.acked(seg)
+	}
+	c.receive(seg)
+	if c.finAck && c.rcvFin {
+		c.terminate(nil)
+	}
+}
+
+// ACK of the app for the data and FIN sent
+func (c *tcpConn) acked(seg *tcpSegment) {
+	n := seg.ack - c.sndUna
+	if n > c.sndNxt-c.sndUna {
+		return // old or not sent yet
+	}
+	c.sndWnd = uint32(seg.window)
+	if seg.window == 0 {
+		c.retries = 0 // the app is alive, it does not read
+	}
+	if n > 0 {
+		data := int(n)
+		if data > len(c.sndBuf) {
+			data = len(c.sndBuf)
+			c.finAck = true
+		}
+		c.sndBuf = c.sndBuf[data:]
+		c.sndUna = seg.ack
+		c.retries, c.rto = 0, tcpMinRTO
+		c.stopTimer()
+		if c.sndNxt != c.sndUna {
+			c.startTimer()
+		}
+		notify(c.writable)
+	}
+	c.output()
+}
+
+// data and FIN of the app in order, the others are dropped and acked with the expected one
+func (c *tcpConn) receive(seg *tcpSegment) {
+	data := seg.payload
+	fin := seg.flags&tcpFIN != 0
+	if len(data) == 0 && !fin {
+		return
+	}
+	// trim what was received already
+	if skip := c.rcvNxt - seg.seq; skip != 0 {
+		if int32(skip) < 0 || int(skip) > len(data) {
+			c.sendAck()
+			return
+		}
+		data = data[skip:]
+	}
+	if c.closed && len(data) > 0 {
+		// nobody reads
+		c.sendReset()
+		c.terminate(syscall.ECONNRESET)
+		return
+	}
+	if space := tcpWindow - len(c.rcvBuf); len(data) > space {
+		data, fin = data[:space], false
+	}
+	if !c.rcvFin {
+		c.rcvBuf = append(c.rcvBuf, data...)
+		c.rcvNxt += uint32(len(data))
+		if fin {
+			c.rcvNxt++
+			c.rcvFin = true
+		}
+		notify(c.readable)
+	}
+	c.sendAck()
+}
+
+// data of sndBuf within the window of the app, then FIN after it if closed
+func (c *tcpConn) output() {
+	if c.state != tcpEstablished {
+		return
+	}
+	inFlight := int(c.sndNxt - c.sndUna)
+	if c.finSent {
+		inFlight-- // FIN is counted in sndNxt
+	}
+	wnd := int(c.sndWnd)
+	if wnd == 0 && inFlight == 0 && c.timer == nil && len(c.sndBuf) > 0 {
+		c.startTimer() // probe the zero window
+	}
+	for !c.finSent && inFlight < len(c.sndBuf) && inFlight < wnd {
+		n := len(c.sndBuf) - inFlight
+		if n > c.mss {
+			n = c.mss
+		}
+		if n > wnd-inFlight {
+			n = wnd - inFlight
+		}
+		c.sendData(c.sndUna+uint32(inFlight), c.sndBuf[inFlight:inFlight+n])
+		inFlight += n
+		c.sndNxt = c.sndUna + uint32(inFlight)
+		if c.timer == nil {
+			c.startTimer()
+		}
+	}
+	if c.closed && !c.finSent && inFlight == len(c.sndBuf) {
+		c.finSent = true
+		c.send(c.sndNxt, tcpFIN|tcpACK, nil)
+		c.sndNxt++
+		if c.timer == nil {
+			c.startTimer()
+		}
+	}
+}
+
+// retransmit from sndUna, or probe the zero window of the app, it is reset after retries
+func (c *tcpConn) timeout(gen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.timerGen || c.state == tcpClosed {
+		return
+	}
+	c.timer = nil
+	if c.retries++; c.retries > tcpMaxRetries {
+		c.sendReset()
+		c.terminate(os.ErrDeadlineExceeded)
+		return
+	}
+	if c.rto *= 2; c.rto > tcpMaxRTO {
+		c.rto = tcpMaxRTO
+	}
+	if c.state == tcpSynReceived {
+		c.sendSynAck()
+		c.startTimer()
+		return
+	}
+	// go back to the first byte not acked
+	c.sndNxt, c.finSent = c.sndUna, false
+	if c.sndWnd == 0 && len(c.sndBuf) > 0 {
+		c.sendData(c.sndUna, c.sndBuf[:1])
+		c.sndNxt = c.sndUna + 1
+		c.startTimer()
+		return
+	}
+	c.output()
+	if c.timer == nil && c.sndNxt != c.sndUna {
+		c.startTimer()
+	}
+}
+
+func (c *tcpConn) startTimer() {
+	c.timerGen++
+	gen := c.timerGen
+	c.timer = time.AfterFunc(c.rto, func() { c.timeout(gen) })
+}
+
+func (c *tcpConn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+		c.timerGen++
+	}
+}
+
+func (c *tcpConn) window() uint16 {
+	c.lastWnd = tcpWindow - len(c.rcvBuf)
+	return uint16(c.lastWnd)
+}
+
+func (c *tcpConn) send(seq uint32, flags byte, payload []byte) {
+	c.stack.write(buildTCP(c.local.IP, c.remote.IP, uint16(c.local.Port), uint16(c.remote.Port),
+		seq, c.rcvNxt, flags, c.window(), 0, payload))
+}
+
+func (c *tcpConn) sendData(seq uint32, payload []byte) {
+	c.send(seq, tcpACK|tcpPSH, payload)
+}
+
+func (c *tcpConn) sendAck() {
+	c.send(c.sndNxt, tcpACK, nil)
+}
+
+func (c *tcpConn) sendReset() {
+	c.stack.write(buildTCP(c.local.IP, c.remote.IP, uint16(c.local.Port), uint16(c.remote.Port),
+		c.sndNxt, 0, tcpRST, 0, 0, nil))
+}
+
+func (c *tcpConn) sendSynAck() {
+	mss := tcpMaxMSS
+	if c.mss < mss {
+		mss = c.mss
+	}
+	c.stack.write(buildTCP(c.local.IP, c.remote.IP, uint16(c.local.Port), uint16(c.remote.Port),
+		c.iss, c.rcvNxt, tcpSYN|tcpACK, c.window(), uint16(mss), nil))
+}
+
+// the connection is gone, reads and writes fail with [err] (EOF of reads when nil)
+func (c *tcpConn) terminate(err error) {
+	c.state = tcpClosed
+	if c.err == nil {
+		c.err = err
+	}
+	c.stopTimer()
+	if c.linger != nil {
+		c.linger.Stop()
+	}
+	notify(c.readable)
+	notify(c.writable)
+	c.stack.remove(c)
+}
+
+func (c *tcpConn) abort(err error) {
+	c.mu.Lock()
+	if c.state != tcpClosed {
+		c.sendReset()
+		c.terminate(err)
+	}
+	c.mu.Unlock()
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if len(c.rcvBuf) > 0 {
+			n := copy(b, c.rcvBuf)
+			c.rcvBuf = c.rcvBuf[n:]
+			if len(c.rcvBuf) == 0 {
+				c.rcvBuf = nil
+			}
+			// the app waits for the window to open
+			if c.state == tcpEstablished && c.lastWnd < c.mss && tcpWindow-len(c.rcvBuf) >= c.mss {
+				c.sendAck()
+			}
+			return n, nil
+		}
+		switch {
+		case c.closed:
+			return 0, net.ErrClosed
+		case c.rcvFin:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case c.state == tcpClosed:
+			return 0, io.EOF
+		}
+		if !c.wait(c.readable, c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *tcpConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		switch {
+		case c.closed:
+			return written, net.ErrClosed
+		case c.err != nil:
+			return written, c.err
+		case c.state == tcpClosed:
+			return written, syscall.EPIPE
+		}
+		if space := tcpSendBuffer - len(c.sndBuf); space > 0 {
+			n := len(b) - written
+			if n > space {
+				n = space
+			}
+			c.sndBuf = append(c.sndBuf, b[written:written+n]...)
+			written += n
+			c.output()
+			continue
+		}
+		if !c.wait(c.writable, c.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+	return written, nil
+}
+
+// wait for [ch] without the lock, false when [deadline] passed
+func (c *tcpConn) wait(ch chan struct{}, deadline time.Time) bool {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return false
+	}
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	if deadline.IsZero() {
+		<-ch
+		return true
+	}
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-ch:
+	case <-t.C:
+	}
+	return true // checked again
+}
+
+// FIN after the data written, the connection is kept for the FIN of the app till linger
+func (c *tcpConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	notify(c.readable)
+	notify(c.writable)
+	if c.state == tcpClosed {
+		return nil
+	}
+	if c.state == tcpSynReceived {
+		c.sendReset()
+		c.terminate(net.ErrClosed)
+		return nil
+	}
+	c.rcvBuf = nil
+	c.output()
+	c.linger = time.AfterFunc(tcpLinger, func() { c.abort(os.ErrDeadlineExceeded) })
+	return nil
+}
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	notify(c.readable)
+	return nil
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	notify(c.writable)
+	return nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// [seq] in [start, start+size)
+func seqIn(seq, start, size uint32) bool {
+	return seq-start < size
+}
@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// the app side of a connection through the stack
+type tcpApp struct {
+	t        *testing.T
+	stack    *tcpStack
+	packets  chan []byte
+	src, dst net.IP
+	seq, ack uint32
+}
+
+func (a *tcpApp) send(flags byte, payload []byte) {
+	seg := parseTCPPacket(a.t, buildTCP(a.src, a.dst, 50000, 80, a.seq, a.ack, flags, tcpWindow, 1400, payload))
+	a.stack.input(seg)
+	a.seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		a.seq++
+	}
+}
+
+// the next segment with [flags] of the stack, others are skipped
+func (a *tcpApp) expect(flags byte) *tcpSegment {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case p := <-a.packets:
+			seg := parseTCPPacket(a.t, p)
+			if seg.flags&flags == flags && (flags&tcpPSH == 0 || len(seg.payload) > 0) {
+				return seg
+			}
+		case <-timeout:
+			a.t.Fatalf("no segment of flags %x", flags)
+		}
+	}
+}
+
+func TestTCPStack(t *testing.T) {
+	packets := make(chan []byte, 64)
+	accepted := make(chan *net.TCPAddr, 1)
+	s := newTCPStack(func(packet []byte) { packets <- packet }, func(c net.Conn, dst *net.TCPAddr) {
+		accepted <- dst
+		io.Copy(c, c) // echo until the FIN of the app
+		c.Close()
+	})
+	a := &tcpApp{t: t, stack: s, packets: packets, src: net.ParseIP("10.0.0.2"), dst: net.ParseIP("93.184.216.34"), seq: 1000}
+
+	a.send(tcpSYN, nil)
+	synAck := a.expect(tcpSYN | tcpACK)
+	if synAck.ack != 1001 || synAck.mss == 0 {
+		t.Fatalf("SYN-ACK: %+v", synAck)
+	}
+	a.ack = synAck.seq + 1
+	a.send(tcpACK, []byte("hello"))
+	if dst := <-accepted; !dst.IP.Equal(a.dst) || dst.Port != 80 {
+		t.Errorf("destination: %s", dst)
+	}
+	data := a.expect(tcpPSH | tcpACK)
+	if string(data.payload) != "hello" || data.seq != a.ack || data.ack != a.seq {
+		t.Fatalf("echo: %+v", data)
+	}
+	// not acked, it is sent again
+	if again := a.expect(tcpPSH | tcpACK); again.seq != data.seq || string(again.payload) != "hello" {
+		t.Fatalf("retransmission: %+v", again)
+	}
+	a.ack += uint32(len(data.payload))
+	a.send(tcpACK, nil)
+
+	a.send(tcpFIN|tcpACK, nil)
+	fin := a.expect(tcpFIN | tcpACK)
+	if fin.seq != a.ack || fin.ack != a.seq {
+		t.Fatalf("FIN: %+v", fin)
+	}
+	a.ack++
+	a.send(tcpACK, nil)
+	s.Lock()
+	n := len(s.conns)
+	s.Unlock()
+	if n != 0 {
+		t.Errorf("%d connections after closed", n)
+	}
+
+	// without connection
+	a.send(tcpACK, []byte("late"))
+	if rst := a.expect(tcpRST); rst.seq != a.ack {
+		t.Errorf("RST: %+v", rst)
+	}
+}
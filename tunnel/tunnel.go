@@ -0,0 +1,260 @@
+// Package tunnel runs shuttle embedded in an app with a packet tunnel, e.g. a Network Extension
+// or a VpnService, the app passes the packets read from the system and writes the packets of shuttle.
+// DNS queries (UDP port 53) are answered by shuttle's resolver. TCP is terminated by a userspace stack,
+// connections and other UDP go through the rules as SOCKS5 requests to the IP, with the domain which
+// shuttle answered for it. Other packets (ICMP, fragments, IPv6 extension headers) are dropped.
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sipt/shuttle"
+	"github.com/sipt/shuttle/config"
+	. "github.com/sipt/shuttle/constant"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/engine"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+// max DNS queries being answered, the others are dropped and retried by the system
+const maxPendingQueries = 64
+
+var (
+	ErrStarted    = errors.New("shuttle is started")
+	ErrNotStarted = errors.New("shuttle is not started")
+)
+
+type tunnel struct {
+	write   func(packet []byte)
+	events  chan *EventObj
+	done    chan struct{}
+	pending chan struct{}
+	tcp     *tcpStack
+	udp     *udpFlows
+	dropped int64
+}
+
+var (
+	current     *tunnel
+	currentLock sync.Mutex
+)
+
+// start shuttle with the config file after the logger is initialized, packets to the system are written
+// by [write], it may be called by many goroutines
+func Start(configPath string, write func(packet []byte)) error {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	if current != nil {
+		return ErrStarted
+	}
+	if err := engine.InitRuntime(configPath); err != nil {
+		return err
+	}
+	if _, err := engine.LoadConfig(configPath); err != nil {
+		return err
+	}
+	t := &tunnel{
+		write:   write,
+		events:  make(chan *EventObj, 1),
+		done:    make(chan struct{}),
+		pending: make(chan struct{}, maxPendingQueries),
+		tcp:     newTCPStack(write, shuttle.TunHandle),
+	}
+	go t.handleEvents()
+	if err := engine.SuperviseModules(t.events); err != nil {
+		close(t.done)
+		engine.Shutdown()
+		return err
+	}
+	t.udp = newUDPFlows(write)
+	current = t
+	tunnelLog.Info("[Tunnel] shuttle is started")
+	return nil
+}
+
+// stop shuttle, the logger is closed
+func Stop() {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	if current == nil {
+		return
+	}
+	close(current.done)
+	current.tcp.close()
+	current.udp.close()
+	current = nil
+	tunnelLog.Info("[Tunnel] shuttle is stopped")
+	engine.Shutdown()
+}
+
+func Started() bool {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	return current != nil
+}
+
+// the controller runs in the app, the app owns the process
+func (t *tunnel) handleEvents() {
+	for {
+		select {
+		case <-t.done:
+			return
+		case e := <-t.events:
+			handled, err := engine.HandleEvent(e)
+			if err != nil {
+				tunnelLog.Errorf("[Tunnel] event [%d] failed: %v", e.Type, err)
+			} else if !handled {
+				tunnelLog.Infof("[Tunnel] event [%d] is not supported in the app", e.Type)
+			}
+		}
+	}
+}
+
+// a packet read from the system, it is not kept
+func InputPacket(packet []byte) {
+	currentLock.Lock()
+	t := current
+	currentLock.Unlock()
+	if t == nil {
+		return
+	}
+	if !t.input(packet) {
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+// false if the packet is dropped
+func (t *tunnel) input(packet []byte) bool {
+	ip, err := parseIP(packet)
+	if err != nil {
+		return false
+	}
+	switch ip.protocol {
+	case protocolTCP:
+		seg, err := parseTCP(ip)
+		if err != nil {
+			return false
+		}
+		return t.tcp.input(seg)
+	case protocolUDP:
+		p, err := parseUDP(ip)
+		if err != nil {
+			return false
+		}
+		if p.dstPort == dnsPort {
+			return t.query(p)
+		}
+		return t.udp.input(p)
+	}
+	return false
+}
+
+// answer the DNS query by shuttle's resolver
+func (t *tunnel) query(q *udpPacket) bool {
+	select {
+	case t.pending <- struct{}{}:
+	default:
+		return false
+	}
+	// the packet belongs to the caller
+	q = &udpPacket{
+		src:     append(net.IP{}, q.src...),
+		dst:     append(net.IP{}, q.dst...),
+		srcPort: q.srcPort,
+		dstPort: q.dstPort,
+		payload: append([]byte(nil), q.payload...),
+	}
+	go func() {
+		defer func() { <-t.pending }()
+		client := net.JoinHostPort(q.src.String(), fmt.Sprint(q.srcPort))
+		reply, err := dns.ServeMessage(q.payload, client)
+		if err != nil {
+			tunnelLog.Debugf("[Tunnel] [%s] DNS query failed: %v", client, err)
+			return
+		}
+		t.write(buildReply(q, reply))
+	}()
+	return true
+}
+
+// packets dropped by the tunnel: not supported, too many DNS queries or datagrams waiting,
+// or TCP without connection
+func DroppedPackets() int64 {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	if current == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&current.dropped)
+}
+
+// replace the config file with [data] from the app and reload it, a broken one is refused
+func PushConfig(data []byte) error {
+	if !Started() {
+		return ErrNotStarted
+	}
+	file := config.CurrentConfigFile()
+	if errs := shuttle.ValidateConfigFileData(data, file); len(errs) > 0 {
+		return errs[0]
+	}
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".push")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	_, err := engine.ReloadConfig(file)
+	return err
+}
+
+// outbound mode: RULE, REMOTE, DIRECT or REJECT, kept in the runtime file
+func SetMode(mode string) error {
+	if !Started() {
+		return ErrNotStarted
+	}
+	if err := rule.SetConnMode(mode); err != nil {
+		return err
+	}
+	return config.CurrentRuntime().Set(config.RuntimeKey(config.RuntimeNamespaceGeneral, config.RuntimeKeyMode), mode)
+}
+
+func Mode() string {
+	return rule.GetConnMode()
+}
+
+// select [server] of the select group, kept in the runtime file
+func SelectServer(group, server string) error {
+	if !Started() {
+		return ErrNotStarted
+	}
+	if err := proxy.SelectServer(group, server); err != nil {
+		return err
+	}
+	return config.CurrentRuntime().Set(config.RuntimeKey(config.RuntimeNamespaceGroup, group), server)
+}
+
+// port of the HTTP/HTTPS inbound for the proxy settings, empty if not started
+func HTTPPort() string {
+	if conf := config.CurrentConfig(); conf != nil {
+		return conf.GetHTTPPort()
+	}
+	return ""
+}
+
+// port of the SOCKS5 inbound
+func SOCKSPort() string {
+	if conf := config.CurrentConfig(); conf != nil {
+		return conf.GetSOCKSPort()
+	}
+	return ""
+}
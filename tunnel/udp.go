@@ -0,0 +1,123 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sipt/shuttle"
+)
+
+const (
+	udpFlowQueue    = 64 // datagrams waiting for the rules, the others are dropped
+	udpIdleInterval = 10 * time.Second
+)
+
+// UDP of the tunnel other than DNS: a flow per source (a socket of an app), its datagrams
+// go through the rules as SOCKS5 UDP, replies are written back with the remote as source
+type udpFlows struct {
+	write func(packet []byte)
+	flows map[string]*udpFlow
+	done  chan struct{}
+	sync.Mutex
+}
+
+type udpFlow struct {
+	src   *net.UDPAddr
+	tun   *shuttle.TunUDP
+	queue chan *udpPacket
+}
+
+func newUDPFlows(write func(packet []byte)) *udpFlows {
+	u := &udpFlows{
+		write: write,
+		flows: make(map[string]*udpFlow),
+		done:  make(chan struct{}),
+	}
+	go u.closeIdle()
+	return u
+}
+
+// a datagram of an app, it is not kept. false if it is dropped
+func (u *udpFlows) input(p *udpPacket) bool {
+	key := net.JoinHostPort(p.src.String(), strconv.Itoa(int(p.srcPort)))
+	// the packet belongs to the caller
+	q := &udpPacket{
+		dst:     append(net.IP{}, p.dst...),
+		dstPort: p.dstPort,
+		payload: append([]byte(nil), p.payload...),
+	}
+	u.Lock()
+	defer u.Unlock()
+	f, ok := u.flows[key]
+	if !ok {
+		select {
+		case <-u.done:
+			return false
+		default:
+		}
+		f = u.newFlow(&net.UDPAddr{IP: append(net.IP{}, p.src...), Port: int(p.srcPort)})
+		u.flows[key] = f
+	}
+	select {
+	case f.queue <- q:
+		return true
+	default:
+		return false
+	}
+}
+
+func (u *udpFlows) newFlow(src *net.UDPAddr) *udpFlow {
+	f := &udpFlow{src: src, queue: make(chan *udpPacket, udpFlowQueue)}
+	f.tun = shuttle.NewTunUDP(src, func(from *net.UDPAddr, data []byte) error {
+		u.write(buildUDP(from.IP, src.IP, uint16(from.Port), uint16(src.Port), data))
+		return nil
+	})
+	go func() {
+		for p := range f.queue {
+			dst := &net.UDPAddr{IP: p.dst, Port: int(p.dstPort)}
+			if err := f.tun.Send(dst, p.payload); err != nil {
+				tunnelLog.Debugf("[Tunnel] [UDP] [%s] send to [%s] failed: %v", src, dst, err)
+			}
+		}
+	}()
+	return f
+}
+
+// flows without session and datagram for the UDP timeout are closed
+func (u *udpFlows) closeIdle() {
+	ticker := time.NewTicker(udpIdleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+		}
+		u.Lock()
+		for k, f := range u.flows {
+			if len(f.queue) == 0 && f.tun.Idle() {
+				delete(u.flows, k)
+				f.close()
+			}
+		}
+		u.Unlock()
+	}
+}
+
+func (f *udpFlow) close() {
+	close(f.queue)
+	f.tun.Close()
+}
+
+func (u *udpFlows) close() {
+	u.Lock()
+	close(u.done)
+	flows := u.flows
+	u.flows = make(map[string]*udpFlow)
+	u.Unlock()
+	for _, f := range flows {
+		f.close()
+	}
+}
@@ -1,12 +1,8 @@
 package shuttle
 
-import (
-	"github.com/sipt/shuttle/log"
-)
-
 func Recover(fs ...func()) {
 	if err := recover(); err != nil {
-		log.Logger.Errorf("[PANIC] %v", err)
+		shuttleLog.Errorf("[PANIC] %v", err)
 		for _, f := range fs {
 			f()
 		}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var hostProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.BidiRule())
+
+// canonical form of a hostname for DNS, rules, hosts and SNI: lower case,
+// no trailing dot, IDN in punycode (a "тест.рф" and "xn--e1aybc.xn--p1ai" are the same).
+// invalid IDN is kept in lower case
+func CanonicalHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+	if isASCII(host) {
+		return strings.ToLower(host)
+	}
+	if h, err := hostProfile.ToASCII(host); err == nil {
+		return h
+	}
+	return strings.ToLower(host)
+}
+
+// unicode form of a canonical hostname, for matching keywords
+func UnicodeHost(host string) string {
+	if !strings.Contains(host, "xn--") {
+		return host
+	}
+	if h, err := idna.Punycode.ToUnicode(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,607 @@
+package shuttle
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sipt/shuttle/config"
+	"github.com/sipt/shuttle/dns"
+	"github.com/sipt/shuttle/grpcview"
+	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/proxy"
+	"github.com/sipt/shuttle/rule"
+)
+
+const (
+	SectionGeneral    = "General"
+	SectionProxy      = "Proxy"
+	SectionProxyGroup = "Proxy-Group"
+	SectionComposite  = "Composite-Policy"
+	SectionLocalDNS   = "Local-DNS"
+	SectionHosts      = "Hosts"
+	SectionRule       = "Rule"
+	SectionHttpMap    = "Http-Map"
+	SectionKeepAlive  = "Keep-Alive"
+	SectionChaos      = "Chaos"
+	SectionSNIRouter  = "SNI-Router"
+	SectionMirror     = "Mirror"
+	SectionHedge      = "Hedge"
+	SectionResume     = "Resume"
+	SectionAssertions = "Assertions"
+	SectionMulticast  = "Multicast-Relay"
+	SectionSniffing   = "Sniffing"
+	SectionUDP        = "UDP"
+	SectionDNSListen  = "DNS-Listen"
+	SectionExport     = "Route-Export"
+	SectionTLSVerify  = "TLS-Verify"
+	SectionFlowExport = "Flow-Export"
+	SectionShedding   = "Load-Shedding"
+	SectionMITM       = "MITM"
+	SectionExitCheck  = "Exit-Check"
+)
+
+type ValidateError struct {
+	Section string `json:"section"`
+	Key     string `json:"key,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidateError) Error() string {
+	buffer := bytes.NewBufferString("[" + e.Section + "]")
+	if len(e.Key) > 0 {
+		buffer.WriteString(" [" + e.Key + "]")
+	}
+	if e.Line > 0 {
+		buffer.WriteString(" line " + strconv.Itoa(e.Line))
+	}
+	buffer.WriteString(": " + e.Message)
+	return buffer.String()
+}
+
+// check config file like ApplyConfig does, but never touch the running config
+func ValidateConfigFile(filePath string) ([]*ValidateError, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed: %v", err)
+	}
+	return ValidateConfigFileData(data, filePath), nil
+}
+
+func ValidateConfigData(data []byte) []*ValidateError {
+	conf, err := config.ParseConfig(data)
+	return validateConfig(data, conf, err)
+}
+
+// check config data of [file], e.g. to replace it, see config.ParseConfigFile
+func ValidateConfigFileData(data []byte, file string) []*ValidateError {
+	conf, err := config.ParseConfigFile(data, file)
+	return validateConfig(data, conf, err)
+}
+
+// check config data submitted by others, environment variables and files of the host are not read
+func ValidateUntrustedConfigData(data []byte) []*ValidateError {
+	conf, err := config.ParseUntrustedConfig(data)
+	return validateConfig(data, conf, err)
+}
+
+// countries of the GeoIP database for GEO-IP rules, nil: not checked
+var geoCountries map[string]bool
+
+// load the data of validation once, e.g. by the validate server before it serves
+func WarmValidation() error {
+	countries, err := dns.GeoCountries((&config.Config{}).GetGeoIPDBFile())
+	if err != nil {
+		return err
+	}
+	geoCountries = countries
+	return nil
+}
+
+func validateConfig(data []byte, conf *config.Config, err error) []*ValidateError {
+	if err != nil {
+		return []*ValidateError{{Section: "config", Message: err.Error()}}
+	}
+	v := &validator{lines: strings.Split(string(data), "\n")}
+	v.validate(conf)
+	return v.errs
+}
+
+type validator struct {
+	lines []string
+	errs  []*ValidateError
+}
+
+func (v *validator) add(section, key, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidateError{
+		Section: section,
+		Key:     key,
+		Line:    v.line(section, key),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// find the line of key in section, 0 if not found
+func (v *validator) line(section, key string) int {
+	start := -1
+	for i, l := range v.lines {
+		if start < 0 {
+			if strings.HasPrefix(l, section+":") {
+				start = i
+				if len(key) == 0 {
+					return i + 1
+				}
+			}
+			continue
+		}
+		if len(l) > 0 && l[0] != ' ' && l[0] != '-' && l[0] != '#' {
+			break
+		}
+		if strings.Contains(l, key) {
+			return i + 1
+		}
+	}
+	if start >= 0 {
+		return start + 1
+	}
+	return 0
+}
+
+func (v *validator) validate(conf *config.Config) {
+	names := map[string]bool{
+		proxy.ProxyDirect: true,
+		proxy.ProxyReject: true,
+		proxy.ProxyGlobal: true,
+	}
+	if conf.General == nil {
+		v.add(SectionGeneral, "", "is empty")
+	} else {
+		v.validateGeneral(conf.General)
+	}
+	v.validateLocalDNS(conf.LocalDNSs)
+	v.validateHosts(conf.Hosts)
+	v.validateProxy(conf.Proxy, names)
+	v.validateProxyGroup(conf.ProxyGroup, names)
+	v.validateComposite(conf.Composite, names)
+	v.validateRule(conf.Rule, names, conf.Users)
+	v.validateRule(conf.GetRuleInclude(), names, conf.Users)
+	v.validateKeepAlive(conf.KeepAlive, names)
+	v.validateChaos(conf.Chaos, names, dnsServers(conf))
+	v.validateSNIRouter(conf.SNIRouter, names)
+	if conf.General != nil && len(conf.General.SelfPolicy) > 0 && !names[conf.General.SelfPolicy] {
+		v.add(SectionGeneral, "self-policy", "policy [%s] not found", conf.General.SelfPolicy)
+	}
+	v.validateHttpMap(conf.HttpMap)
+	if _, err := parseMirror(conf.Mirror); err != nil {
+		v.add(SectionMirror, "", "%v", err)
+	}
+	v.validateHedge(conf.Hedge, names)
+	v.validateResume(conf.Resume, names)
+	if _, err := parseMulticastRelay(conf.Multicast); err != nil {
+		v.add(SectionMulticast, "", "%v", err)
+	}
+	if err := dns.ValidateServerConfig(conf); err != nil {
+		v.add(SectionDNSListen, "", "%v", err)
+	}
+	if _, err := parseRouteExport(conf.Export); err != nil {
+		v.add(SectionExport, "", "%v", err)
+	}
+	if _, err := parseTLSVerify(conf.TLSVerify); err != nil {
+		v.add(SectionTLSVerify, "", "%v", err)
+	}
+	if _, err := parseFlowExport(conf.FlowExport); err != nil {
+		v.add(SectionFlowExport, "", "%v", err)
+	}
+	if _, err := parseLoadShedding(conf.Shedding); err != nil {
+		v.add(SectionShedding, "", "%v", err)
+	}
+	if _, err := parseExitCheck(conf.ExitCheck); err != nil {
+		v.add(SectionExitCheck, "", "%v", err)
+	}
+	v.validateAssertions(conf.Assertions, names)
+	if conf.Mitm != nil {
+		if _, err := grpcview.LoadDescriptors(conf.Mitm.GRPCDescriptors); err != nil {
+			v.add(SectionMITM, "grpc-descriptors", "%v", err)
+		}
+	}
+	if _, err := parseSniff("", conf.Sniff); err != nil {
+		v.add(SectionSniffing, "", "%v", err)
+	}
+	v.validateUDP(conf.UDP, names)
+}
+
+func (v *validator) validateGeneral(g *config.General) {
+	if _, ok := log.LevelMap[g.LogLevel]; !ok {
+		v.add(SectionGeneral, "loglevel", "not support LogLevel [%s]", g.LogLevel)
+	}
+	if _, err := log.ParseModuleLevels(g.LogModules); err != nil {
+		v.add(SectionGeneral, "log-modules", "%v", err)
+	}
+	switch g.LogFormat {
+	case "", log.LogFormatConsole, log.LogFormatJSON:
+	default:
+		v.add(SectionGeneral, "log-format", "not support [%s]", g.LogFormat)
+	}
+	if _, _, _, _, err := log.ParseRotation(g.LogMaxSize, g.LogMaxAge, g.LogMaxBackups, g.LogTail); err != nil {
+		v.add(SectionGeneral, "log", "%v", err)
+	}
+	if len(g.DNSServer) == 0 {
+		v.add(SectionGeneral, "dns-server", "is empty")
+	}
+	for _, s := range g.DNSServer {
+		if _, err := dns.ParseUpstream(s); err != nil {
+			v.add(SectionGeneral, "dns-server", "%v", err)
+		}
+	}
+	switch g.DNS0x20 {
+	case "", "true", "false":
+	default:
+		v.add(SectionGeneral, "dns-0x20", "must be true or false")
+	}
+	ports := [][]string{
+		{"http-port", g.HttpPort},
+		{"socks-port", g.SocksPort},
+		{"controller-port", g.ControllerPort},
+	}
+	if len(g.SNIPort) > 0 {
+		ports = append(ports, []string{"sni-port", g.SNIPort})
+	}
+	for _, p := range ports {
+		if n, err := strconv.Atoi(p[1]); err != nil || n <= 0 || n > 65535 {
+			v.add(SectionGeneral, p[0], "invalid port [%s]", p[1])
+		}
+	}
+	limits := [][]string{
+		{"max-connections", g.MaxConnections},
+		{"max-dns-cache-entries", g.MaxDNSCacheEntries},
+		{"max-rtt-tests", g.MaxRttTests},
+	}
+	for _, l := range limits {
+		if _, err := parseLimit(l[1]); err != nil {
+			v.add(SectionGeneral, l[0], "%v", err)
+		}
+	}
+	if _, err := parseReloadQueue(g.ReloadQueue); err != nil {
+		v.add(SectionGeneral, "reload-queue", "%v", err)
+	}
+	if _, err := parseReloadTimeout(g.ReloadTimeout); err != nil {
+		v.add(SectionGeneral, "reload-timeout", "%v", err)
+	}
+	if _, err := parseSocketStats(g.SocketStats); err != nil {
+		v.add(SectionGeneral, "socket-stats", "%v", err)
+	}
+	if _, err := parseIdleUnload(g.IdleUnload); err != nil {
+		v.add(SectionGeneral, "idle-unload", "%v", err)
+	}
+	switch g.Sniffing {
+	case "", SniffingOff, SniffingOn, SniffingOverride:
+	default:
+		v.add(SectionGeneral, "sniffing", "not support [%s]", g.Sniffing)
+	}
+	min, err := dns.ParseTTL(g.DNSMinTTL, 0)
+	if err != nil {
+		v.add(SectionGeneral, "dns-min-ttl", "%v", err)
+	}
+	max, err := dns.ParseTTL(g.DNSMaxTTL, dns.CacheTTL)
+	if err != nil {
+		v.add(SectionGeneral, "dns-max-ttl", "%v", err)
+	} else if min > max {
+		v.add(SectionGeneral, "dns-min-ttl", "[%s] is greater than dns-max-ttl [%s]", min, max)
+	}
+}
+
+func (v *validator) validateLocalDNS(localDNS [][]string) {
+	for _, d := range localDNS {
+		if len(d) != 4 {
+			v.add(SectionLocalDNS, strings.Join(d, ","), "length must be 4")
+			continue
+		}
+		switch d[0] {
+		case dns.MatchTypeDomain, dns.MatchTypeDomainSuffix, dns.MatchTypeDomainKeyword:
+		default:
+			v.add(SectionLocalDNS, d[1], "not support rule type [%s]", d[0])
+		}
+		switch d[2] {
+		case dns.DNSTypeStatic:
+			for _, ip := range strings.Split(d[3], ",") {
+				if net.ParseIP(ip) == nil {
+					v.add(SectionLocalDNS, d[1], "%s is not a IP address", ip)
+				}
+			}
+		case dns.DNSTypeDirect:
+			for _, s := range strings.Split(d[3], ",") {
+				if _, err := dns.ParseUpstream(s); err != nil {
+					v.add(SectionLocalDNS, d[1], "%v", err)
+				}
+			}
+		case dns.DNSTypeRemote:
+		default:
+			v.add(SectionLocalDNS, d[1], "not support DNSType [%s]", d[2])
+		}
+	}
+}
+
+func (v *validator) validateHosts(hosts map[string]string) {
+	for domain, h := range hosts {
+		h = strings.TrimSpace(h)
+		if len(h) == 0 {
+			v.add(SectionHosts, domain, "is empty")
+			continue
+		}
+		ips := strings.Split(h, ",")
+		if net.ParseIP(strings.TrimSpace(ips[0])) == nil {
+			continue // alias
+		}
+		for _, ip := range ips {
+			if net.ParseIP(strings.TrimSpace(ip)) == nil {
+				v.add(SectionHosts, domain, "%s is not a IP address", ip)
+			}
+		}
+	}
+}
+
+func (v *validator) validateProxy(servers map[string][]string, names map[string]bool) {
+	for name, params := range servers {
+		if names[name] {
+			v.add(SectionProxy, name, "name is reserved or duplicate")
+		}
+		names[name] = true
+		if len(params) < 2 {
+			v.add(SectionProxy, name, "invalid format: %v", params)
+			continue
+		}
+		params, _ = proxy.SplitRttUrl(params)
+		if _, err := proxy.NewServer(name, params); err != nil {
+			v.add(SectionProxy, name, "%v", err)
+		}
+	}
+}
+
+func (v *validator) validateProxyGroup(groups map[string][]string, names map[string]bool) {
+	for name := range groups {
+		if names[name] {
+			v.add(SectionProxyGroup, name, "name is reserved or duplicate")
+		}
+		names[name] = true
+	}
+	for name, params := range groups {
+		params, _ = proxy.SplitRttUrl(params)
+		if len(params) < 2 {
+			v.add(SectionProxyGroup, name, "invalid format: %v", params)
+			continue
+		}
+		if !proxy.CheckSelector(params[0]) {
+			v.add(SectionProxyGroup, name, "not support select_type [%s]", params[0])
+		}
+		for _, s := range params[1:] {
+			if name, _ := proxy.SplitWeight(s); !names[s] && names[name] {
+				s = name
+			}
+			if !names[s] || s == proxy.ProxyGlobal {
+				v.add(SectionProxyGroup, name, "[%s] not found", s)
+			} else if s == name {
+				v.add(SectionProxyGroup, name, "contains itself")
+			}
+		}
+	}
+}
+
+// names of the composite policies are added after their targets are checked
+func (v *validator) validateComposite(composites map[string][]string, names map[string]bool) {
+	for name, params := range composites {
+		if names[name] {
+			v.add(SectionComposite, name, "name is reserved or duplicate")
+			continue
+		}
+		c, err := proxy.ParseCompositePolicy(name, params)
+		if err != nil {
+			v.add(SectionComposite, name, "%v", err)
+			continue
+		}
+		if _, ok := composites[c.Target]; ok {
+			v.add(SectionComposite, name, "target [%s] is a composite policy", c.Target)
+		} else if !names[c.Target] || c.Target == proxy.ProxyGlobal {
+			v.add(SectionComposite, name, "target [%s] not found", c.Target)
+		}
+	}
+	for name := range composites {
+		names[name] = true
+	}
+}
+
+func (v *validator) validateRule(rules [][]string, names map[string]bool, users map[string]config.Secret) {
+	for _, r := range rules {
+		if len(r) != 4 {
+			v.add(SectionRule, strings.Join(r, ","), "length must be 4")
+			continue
+		}
+		switch r[0] {
+		case rule.RuleDomainSuffix, rule.RuleDomain, rule.RuleDomainKeyword,
+			rule.RuleFinal, rule.RuleProcessName, rule.RuleProcessPath:
+		case rule.RuleGeoIP:
+			if geoCountries != nil && !geoCountries[r[1]] {
+				v.add(SectionRule, r[1], "country [%s] not found in GeoIP database", r[1])
+			}
+		case rule.RuleUser:
+			if _, ok := users[r[1]]; !ok {
+				v.add(SectionRule, r[1], "user [%s] not found in Users", r[1])
+			}
+		case rule.RuleIPCIDR:
+			if _, _, err := net.ParseCIDR(r[1]); err != nil {
+				v.add(SectionRule, r[1], "malformed CIDR: %v", err)
+			}
+		default:
+			v.add(SectionRule, r[1], "not support rule type [%s]", r[0])
+		}
+		if !names[r[2]] {
+			v.add(SectionRule, r[1], "policy [%s] not found", r[2])
+		}
+	}
+}
+
+func (v *validator) validateKeepAlive(keepAlive map[string]string, names map[string]bool) {
+	for k, d := range keepAlive {
+		if !names[k] {
+			v.add(SectionKeepAlive, k, "policy [%s] not found", k)
+		}
+		if d == proxy.KeepAliveOff {
+			continue
+		}
+		if t, err := time.ParseDuration(d); err != nil || t <= 0 {
+			v.add(SectionKeepAlive, k, "invalid period [%s]", d)
+		}
+	}
+}
+
+// dns-timeout is injected into the exchanges with a DNS server, the other faults into the connections of a policy
+func (v *validator) validateChaos(chaos map[string][]string, names, servers map[string]bool) {
+	if len(chaos) > 0 && !proxy.ChaosEnabled {
+		v.add(SectionChaos, "", "build with \"-tags chaos\" to inject faults")
+	}
+	for k, params := range chaos {
+		if !names[k] && !servers[k] {
+			v.add(SectionChaos, k, "policy or DNS server [%s] not found", k)
+		}
+		c, err := proxy.ParseChaos(params)
+		if err != nil {
+			v.add(SectionChaos, k, "%v", err)
+			continue
+		}
+		if c.DNSTimeout > 0 && !servers[k] {
+			v.add(SectionChaos, k, "%s is for a DNS server of dns-server or Local-DNS", proxy.ChaosDNSTimeout)
+		}
+		if (c.DialFail > 0 || c.Reset > 0) && !names[k] {
+			v.add(SectionChaos, k, "%s and %s are for a policy", proxy.ChaosDialFail, proxy.ChaosReset)
+		}
+	}
+}
+
+// DNS servers of the resolver as written in dns-server and Local-DNS
+func dnsServers(conf *config.Config) map[string]bool {
+	servers := make(map[string]bool)
+	if conf.General != nil {
+		for _, s := range conf.General.DNSServer {
+			servers[s] = true
+		}
+	}
+	for _, d := range conf.LocalDNSs {
+		if len(d) == 4 && d[2] == dns.DNSTypeDirect {
+			for _, s := range strings.Split(d[3], ",") {
+				servers[s] = true
+			}
+		}
+	}
+	return servers
+}
+
+func (v *validator) validateSNIRouter(router map[string][]string, names map[string]bool) {
+	for k, r := range router {
+		if len(r) != 2 {
+			v.add(SectionSNIRouter, k, "length must be 2")
+			continue
+		}
+		if len(r[0]) > 0 {
+			if _, _, err := net.SplitHostPort(r[0]); err != nil {
+				v.add(SectionSNIRouter, k, "backend [%s] %v", r[0], err)
+			}
+		}
+		if len(r[1]) > 0 && !names[r[1]] {
+			v.add(SectionSNIRouter, k, "policy [%s] not found", r[1])
+		}
+	}
+}
+
+func (v *validator) validateHedge(h *config.Hedge, names map[string]bool) {
+	if _, err := parseHedge(h); err != nil {
+		v.add(SectionHedge, "", "%v", err)
+	}
+	if h == nil {
+		return
+	}
+	for _, g := range h.Groups {
+		if g != "*" && !names[g] {
+			v.add(SectionHedge, "groups", "group [%s] not found", g)
+		}
+	}
+}
+
+func (v *validator) validateResume(r *config.Resume, names map[string]bool) {
+	if _, err := parseResume(r); err != nil {
+		v.add(SectionResume, "", "%v", err)
+	}
+	if r == nil {
+		return
+	}
+	for _, g := range r.Groups {
+		if g != "*" && !names[g] {
+			v.add(SectionResume, "groups", "group [%s] not found", g)
+		}
+	}
+}
+
+func (v *validator) validateAssertions(a *config.Assertions, names map[string]bool) {
+	as, err := parseAssertions(a)
+	if err != nil {
+		v.add(SectionAssertions, "", "%v", err)
+		return
+	}
+	if as == nil {
+		return
+	}
+	for _, item := range as.items {
+		if !names[item.expect] {
+			v.add(SectionAssertions, item.raw, "policy [%s] not found", item.expect)
+		}
+	}
+}
+
+func (v *validator) validateUDP(u *config.UDP, names map[string]bool) {
+	if _, err := parseUDP(u); err != nil {
+		v.add(SectionUDP, "", "%v", err)
+	}
+	if u == nil {
+		return
+	}
+	for k := range u.NAT {
+		if k != "*" && !names[k] {
+			v.add(SectionUDP, "nat", "policy [%s] not found", k)
+		}
+	}
+}
+
+func (v *validator) validateHttpMap(httpMap *config.HttpMap) {
+	if httpMap == nil {
+		return
+	}
+	check := func(name string, ms []*config.ModifyMap) {
+		for _, m := range ms {
+			switch m.Type {
+			case ModifyMock, ModifyUpdate:
+			default:
+				v.add(SectionHttpMap, m.UrlRex, "[%s] not support [%s]", name, m.Type)
+			}
+			if _, err := regexp.Compile(m.UrlRex); err != nil {
+				v.add(SectionHttpMap, m.UrlRex, "[%s] %v", name, err)
+			}
+			for _, e := range m.Items {
+				if len(e) != 3 {
+					v.add(SectionHttpMap, m.UrlRex, "[%s] %v item's count must be 3", name, e)
+					continue
+				}
+				switch e[0] {
+				case ModifyTypeURL, ModifyTypeHeader, ModifyTypeStatus, ModifyTypeBody:
+				default:
+					v.add(SectionHttpMap, m.UrlRex, "[%s] not support [%s]", name, e[0])
+				}
+			}
+		}
+	}
+	check("Req-Map", httpMap.ReqMap)
+	check("Resp-Map", httpMap.RespMap)
+}
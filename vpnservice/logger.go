@@ -0,0 +1,6 @@
+package vpnservice
+
+import "github.com/sipt/shuttle/log"
+
+// loggers of the modules, see log-modules
+var vpnLog = log.Named("vpn")
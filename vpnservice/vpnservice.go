@@ -0,0 +1,136 @@
+// +build linux
+
+// Package vpnservice runs shuttle in an Android VpnService, built by gomobile:
+// gomobile bind -target=android github.com/sipt/shuttle/vpnservice
+//
+// Add the default route and the DNS server to VpnService.Builder, and the app itself by
+// addDisallowedApplication so the sockets of shuttle to the servers do not enter the TUN. DNS queries
+// are answered by shuttle's resolver, TCP and UDP of the apps go through the rules. The HTTP proxy of
+// the builder (API 29+) to 127.0.0.1:HTTPPort() is optional, it keeps the domains of the requests for
+// apps resolving names by themselves.
+package vpnservice
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sipt/shuttle/log"
+	"github.com/sipt/shuttle/tunnel"
+)
+
+// MTU of VpnService.Builder is at most this
+const maxPacketSize = 65535
+
+// log lines of shuttle, e.g. to logcat or the UI
+type LogWriter interface {
+	WriteLog(line string)
+}
+
+var (
+	tun     *os.File
+	tunLock sync.Mutex
+)
+
+// start shuttle with the config file in the files dir, packets are read from and written to the TUN [fd]
+// of VpnService.Builder.establish() (detachFd), it is closed by Stop. logs are written to [logger]
+func Start(configPath string, fd int, logger LogWriter) error {
+	tunLock.Lock()
+	defer tunLock.Unlock()
+	if tunnel.Started() {
+		return tunnel.ErrStarted
+	}
+	log.InitLoggerOutput(&logOutput{logger})
+	// by the poller, so Close stops the reading
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), "tun")
+	write := func(packet []byte) {
+		if _, err := f.Write(packet); err != nil {
+			vpnLog.Debugf("[VPN] write packet failed: %v", err)
+		}
+	}
+	if err := tunnel.Start(configPath, write); err != nil {
+		f.Close()
+		return err
+	}
+	tun = f
+	go readPackets(f)
+	return nil
+}
+
+// until the fd is closed
+func readPackets(f *os.File) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			vpnLog.Infof("[VPN] read packets stopped: %v", err)
+			return
+		}
+		tunnel.InputPacket(buf[:n])
+	}
+}
+
+// stop shuttle and close the TUN fd, e.g. in onRevoke and onDestroy
+func Stop() {
+	tunLock.Lock()
+	defer tunLock.Unlock()
+	tunnel.Stop()
+	if tun != nil {
+		tun.Close()
+		tun = nil
+	}
+}
+
+// packets dropped by the tunnel, not supported or too many waiting
+func DroppedPackets() int64 {
+	return tunnel.DroppedPackets()
+}
+
+// replace the config file with [data] from the app and reload it, a broken one is refused
+func PushConfig(data []byte) error {
+	return tunnel.PushConfig(data)
+}
+
+// outbound mode: RULE, REMOTE, DIRECT or REJECT
+func SetMode(mode string) error {
+	return tunnel.SetMode(strings.ToUpper(mode))
+}
+
+func Mode() string {
+	return tunnel.Mode()
+}
+
+// select [server] of the select group
+func SelectServer(group, server string) error {
+	return tunnel.SelectServer(group, server)
+}
+
+// port of the HTTP/HTTPS inbound for the optional HTTP proxy of VpnService.Builder, empty if not started
+func HTTPPort() string {
+	return tunnel.HTTPPort()
+}
+
+// port of the SOCKS5 inbound
+func SOCKSPort() string {
+	return tunnel.SOCKSPort()
+}
+
+// lines of the logger to the app
+type logOutput struct {
+	logger LogWriter
+}
+
+func (o *logOutput) Write(p []byte) (int, error) {
+	if o.logger != nil {
+		o.logger.WriteLog(strings.TrimRight(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+func (o *logOutput) Close() error {
+	return nil
+}